@@ -0,0 +1,300 @@
+package regula
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEarlyDropThreshold 是默认的提前丢弃开始的积压比例
+// DefaultEarlyDropThreshold is the default backlog ratio at which early drop starts
+const DefaultEarlyDropThreshold = 0.5
+
+// ShedPolicy 是积压满时的减载策略
+// ShedPolicy is the load shedding policy when the backlog is full
+type ShedPolicy int8
+
+const (
+	// ShedRejectNewest 拒绝新的消息，Do 返回 ErrBacklogFull
+	// ShedRejectNewest rejects the new message, Do returns ErrBacklogFull
+	ShedRejectNewest ShedPolicy = iota
+
+	// ShedDropOldest 丢弃最早的正在等待的消息，接受新的消息
+	// ShedDropOldest drops the oldest waiting message and accepts the new message
+	ShedDropOldest
+
+	// ShedDropLowestPriority 丢弃优先级最低的正在等待的消息，如果新的消息优先级不高于它，拒绝新的消息
+	// ShedDropLowestPriority drops the waiting message with the lowest priority, if the priority of the new message is not higher than it, the new message is rejected
+	ShedDropLowestPriority
+
+	// ShedEarlyDrop 在积压达到阈值后按概率拒绝新的消息（RED），概率随积压线性增长，积压满时总是拒绝
+	// ShedEarlyDrop rejects new messages with a probability after the backlog reaches the threshold (RED), the probability grows linearly with the backlog, and it always rejects when the backlog is full
+	ShedEarlyDrop
+)
+
+// String 是一个方法，它返回减载策略的名称
+// String is a method that returns the name of the load shedding policy
+func (p ShedPolicy) String() string {
+	switch p {
+	case ShedRejectNewest:
+		return "reject-newest"
+	case ShedDropOldest:
+		return "drop-oldest"
+	case ShedDropLowestPriority:
+		return "drop-lowest-priority"
+	case ShedEarlyDrop:
+		return "early-drop"
+	default:
+		return "unknown"
+	}
+}
+
+// PriorityFunc 是一个函数类型，它返回消息的优先级，值越大越重要
+// PriorityFunc is a function type that returns the priority of the message, the larger the value, the more important
+type PriorityFunc = func(msg any) int
+
+// BacklogConfig 是积压上限的配置，包含最多的消息数量、最长的延迟范围和减载策略
+// BacklogConfig is the configuration of the backlog bound, it contains the maximum number of messages, the maximum delay horizon and the load shedding policy
+type BacklogConfig struct {
+	// maxMessages 是已接受但尚未完成的消息的最多数量，为 0 时不限制
+	// maxMessages is the maximum number of accepted messages that have not completed, no limit if it is 0
+	maxMessages int
+
+	// maxDelay 是速率限制器已排定的最远延迟，为 0 时不限制
+	// maxDelay is the farthest delay scheduled by the rate limiter, no limit if it is 0
+	maxDelay time.Duration
+
+	// policy 是减载策略
+	// policy is the load shedding policy
+	policy ShedPolicy
+
+	// priority 是 ShedDropLowestPriority 策略的优先级函数
+	// priority is the priority function of the ShedDropLowestPriority policy
+	priority PriorityFunc
+
+	// threshold 是 ShedEarlyDrop 策略开始提前丢弃的积压比例
+	// threshold is the backlog ratio at which the ShedEarlyDrop policy starts early drop
+	threshold float64
+}
+
+// NewBacklogConfig 是创建新的积压上限配置的函数，默认策略为 ShedRejectNewest
+// NewBacklogConfig is a function to create a new backlog bound configuration, the default policy is ShedRejectNewest
+func NewBacklogConfig() *BacklogConfig {
+	return &BacklogConfig{policy: ShedRejectNewest, threshold: DefaultEarlyDropThreshold}
+}
+
+// WithMaxMessages 它设置已接受但尚未完成的消息的最多数量
+// WithMaxMessages is a method that sets the maximum number of accepted messages that have not completed
+func (c *BacklogConfig) WithMaxMessages(n int) *BacklogConfig {
+	c.maxMessages = n
+	return c
+}
+
+// WithMaxDelay 它设置速率限制器已排定的最远延迟，超过后总是拒绝新的消息，因为丢弃等待的消息不会让延迟提前
+// WithMaxDelay is a method that sets the farthest delay scheduled by the rate limiter, new messages are always rejected beyond it, because dropping a waiting message does not bring the delay forward
+func (c *BacklogConfig) WithMaxDelay(delay time.Duration) *BacklogConfig {
+	c.maxDelay = delay
+	return c
+}
+
+// WithPolicy 它设置减载策略
+// WithPolicy is a method that sets the load shedding policy
+func (c *BacklogConfig) WithPolicy(policy ShedPolicy) *BacklogConfig {
+	c.policy = policy
+	return c
+}
+
+// WithPriority 它设置优先级函数，并把减载策略设置为 ShedDropLowestPriority
+// WithPriority is a method that sets the priority function and sets the load shedding policy to ShedDropLowestPriority
+func (c *BacklogConfig) WithPriority(fn PriorityFunc) *BacklogConfig {
+	c.priority = fn
+	c.policy = ShedDropLowestPriority
+	return c
+}
+
+// WithEarlyDrop 它设置开始提前丢弃的积压比例，并把减载策略设置为 ShedEarlyDrop
+// WithEarlyDrop is a method that sets the backlog ratio at which early drop starts and sets the load shedding policy to ShedEarlyDrop
+func (c *BacklogConfig) WithEarlyDrop(threshold float64) *BacklogConfig {
+	c.threshold = threshold
+	c.policy = ShedEarlyDrop
+	return c
+}
+
+// Validate 是一个方法，它严格检查积压上限配置是否有效
+// Validate is a method that strictly checks if the backlog bound configuration is valid
+func (c *BacklogConfig) Validate() error {
+	if c.maxMessages < 0 || c.maxDelay < 0 {
+		return fmt.Errorf("%w: limits must not be negative, got %d and %v", ErrInvalidBacklog, c.maxMessages, c.maxDelay)
+	}
+	if c.maxMessages == 0 && c.maxDelay == 0 {
+		return fmt.Errorf("%w: at least one of max messages and max delay must be set", ErrInvalidBacklog)
+	}
+	if c.policy < ShedRejectNewest || c.policy > ShedEarlyDrop {
+		return fmt.Errorf("%w: unknown policy %d", ErrInvalidBacklog, c.policy)
+	}
+	if c.policy == ShedDropLowestPriority && c.priority == nil {
+		return fmt.Errorf("%w: priority function is nil", ErrInvalidBacklog)
+	}
+	if c.threshold < 0 || c.threshold >= 1 {
+		return fmt.Errorf("%w: early drop threshold must be in [0, 1), got %v", ErrInvalidBacklog, c.threshold)
+	}
+	return nil
+}
+
+// isBacklogConfigValid 是一个函数，它检查积压上限配置是否有效，如果无效，它将设置为默认值，没有设置任何上限时关闭积压上限
+// isBacklogConfigValid is a function that checks if the backlog bound configuration is valid, if not, it sets it to the default values, the backlog bound is disabled if no limit is set
+func isBacklogConfigValid(c *BacklogConfig) *BacklogConfig {
+	if c.maxMessages < 0 {
+		c.maxMessages = 0
+	}
+	if c.maxDelay < 0 {
+		c.maxDelay = 0
+	}
+	if c.maxMessages == 0 && c.maxDelay == 0 {
+		return nil
+	}
+	if c.policy < ShedRejectNewest || c.policy > ShedEarlyDrop || (c.policy == ShedDropLowestPriority && c.priority == nil) {
+		c.policy = ShedRejectNewest
+	}
+	if c.threshold < 0 || c.threshold >= 1 {
+		c.threshold = DefaultEarlyDropThreshold
+	}
+	return c
+}
+
+// shedLocked 是一个方法，它在登记新的任务前检查积压，返回需要丢弃的正在等待的任务，或者在拒绝新的任务时返回 ErrBacklogFull，调用者必须持有锁
+// shedLocked is a method that checks the backlog before registering the new task, it returns the waiting task to drop, or ErrBacklogFull if the new task is rejected, the caller must hold the lock
+func (fc *FlowController) shedLocked(t *task) (*task, error) {
+	conf := fc.config.backlog
+	if conf == nil {
+		return nil, nil
+	}
+
+	// 计算积压比例，超过延迟范围时总是拒绝新的任务
+	// Calculate the backlog ratio, the new task is always rejected beyond the delay horizon
+	var ratio float64
+	if conf.maxDelay > 0 {
+		horizon := time.Duration(fc.horizon.Load() - time.Now().UnixNano())
+		if horizon >= conf.maxDelay {
+			return nil, ErrBacklogFull
+		}
+		ratio = float64(horizon) / float64(conf.maxDelay)
+	}
+	if conf.maxMessages > 0 {
+		if r := float64(len(fc.tasks)) / float64(conf.maxMessages); r > ratio {
+			ratio = r
+		}
+	}
+
+	// 提前丢弃，积压越多，拒绝的概率越大
+	// Early drop, the more backlog, the greater the probability of rejection
+	if conf.policy == ShedEarlyDrop && ratio > conf.threshold && ratio < 1 {
+		if rand.Float64() < (ratio-conf.threshold)/(1-conf.threshold) {
+			return nil, ErrBacklogFull
+		}
+		return nil, nil
+	}
+
+	// 积压未满，接受新的任务
+	// The backlog is not full, accept the new task
+	if ratio < 1 {
+		return nil, nil
+	}
+
+	// 积压已满，按策略选择需要丢弃的正在等待的任务
+	// The backlog is full, choose the waiting task to drop according to the policy
+	var victim *task
+	switch conf.policy {
+	case ShedDropOldest:
+		for w := range fc.tasks {
+			if atomic.LoadInt32(&w.state) == taskPending && (victim == nil || w.id < victim.id) {
+				victim = w
+			}
+		}
+	case ShedDropLowestPriority:
+		for w := range fc.tasks {
+			if atomic.LoadInt32(&w.state) != taskPending {
+				continue
+			}
+			if victim == nil || w.priority < victim.priority || (w.priority == victim.priority && w.id < victim.id) {
+				victim = w
+			}
+		}
+		if victim != nil && victim.priority >= t.priority {
+			victim = nil
+		}
+	}
+
+	if victim == nil {
+		return nil, ErrBacklogFull
+	}
+	return victim, nil
+}
+
+// prioritize 是一个方法，它在登记任务前计算任务的优先级
+// prioritize is a method that calculates the priority of the task before registering it
+func (fc *FlowController) prioritize(t *task) {
+	if conf := fc.config.backlog; conf != nil && conf.priority != nil {
+		t.priority = conf.priority(t.msg)
+	}
+}
+
+// shed 是一个方法，它报告因积压而被丢弃或者被拒绝的任务
+// shed is a method that reports the task dropped or rejected due to the backlog
+func (fc *FlowController) shed(t *task, accepted bool) {
+	fc.metrics.shed.Add(1)
+	if accepted {
+		fc.drop(t, ErrBacklogFull)
+		return
+	}
+	fc.onExecDropped(t.msg, ErrBacklogFull)
+}
+
+// extendHorizon 是一个方法，它在任务被排定延迟后更新速率限制器已排定的最远时间
+// extendHorizon is a method that updates the farthest time scheduled by the rate limiter after the task is scheduled with a delay
+func (fc *FlowController) extendHorizon(delay time.Duration) {
+	if fc.config.backlog == nil || fc.config.backlog.maxDelay == 0 {
+		return
+	}
+
+	end := time.Now().Add(delay).UnixNano()
+	for {
+		current := fc.horizon.Load()
+		if end <= current || fc.horizon.CompareAndSwap(current, end) {
+			return
+		}
+	}
+}
+
+// inherit 是一个方法，如果被丢弃的任务在管道中排定了尚未到来的时间槽，它让新的任务继承该时间槽并返回 true
+// inherit is a method that lets the new task inherit the slot and returns true if the dropped task has a slot in the pipeline that has not arrived yet
+func (fc *FlowController) inherit(victim, t *task) bool {
+	if victim == nil || !victim.slotted.Load() {
+		return false
+	}
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	// 时间槽已经到来，新的任务需要自己预留令牌
+	// The slot has already arrived, the new task needs to reserve a token by itself
+	if victim.fired || victim.heir != nil {
+		return false
+	}
+	victim.heir = t
+	t.slotted.Store(true)
+	return true
+}
+
+// succeed 是一个方法，它在被丢弃的任务的时间槽到来时返回继承该时间槽的任务，没有继承者时返回 nil
+// succeed is a method that returns the task that inherits the slot when the slot of the dropped task arrives, it returns nil if there is no heir
+func (fc *FlowController) succeed(t *task) *task {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	t.fired = true
+	heir := t.heir
+	t.heir = nil
+	return heir
+}
@@ -0,0 +1,181 @@
+package regula
+
+import (
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+// DoBatch 是一个方法，它一次提交一批消息。与循环调用 Do 不同，它只进行一次速率限制器预留（速率限制器实现 BatchRateLimiter 时），
+// 为每个消息计算错开的延迟，一次性提交到管道中。如果回调实现了 BatchCallback，对被延迟的消息只调用一次 OnExecBatchLimited，否则对每条被延迟的消息调用 OnExecLimited。
+// 返回值与 msgs 一一对应，为 nil 表示该消息提交成功
+// DoBatch is a method that submits a batch of messages at once. Unlike calling Do in a loop, it makes only one rate limiter reservation (when the rate limiter implements BatchRateLimiter),
+// computes the staggered delay of each message, and submits them to the pipeline in one pass. If the callback implements BatchCallback, OnExecBatchLimited is called only once for the delayed messages, otherwise OnExecLimited is called for each delayed message.
+// The return value corresponds to msgs one by one, nil means the message was submitted successfully
+func (fc *FlowController) DoBatch(fn MessageHandleFunc, msgs []any) []error {
+	errs := make([]error, len(msgs))
+	handle := withoutContext(fn)
+
+	// 批处理、防抖和节流模式下，消息逐个提交，由这些模式决定何时消耗令牌
+	// In the batching, debounce and throttle modes, messages are submitted one by one, and these modes decide when tokens are consumed
+	if fc.aggregator != nil || fc.config.debounce != nil || fc.config.throttle != nil {
+		for i, msg := range msgs {
+			errs[i] = fc.do(newTask(handle, msg))
+		}
+		return errs
+	}
+
+	// ready 是需要预留令牌的任务，index 是它们在 msgs 中的位置
+	// ready are the tasks that need to reserve tokens, index are their positions in msgs
+	ready := make([]*task, 0, len(msgs))
+	index := make([]int, 0, len(msgs))
+
+	for i, msg := range msgs {
+		t := newTask(handle, msg)
+
+		// 如果相同键的消息正在等待或者执行，共享它的结果
+		// If a message with the same key is waiting or executing, share its result
+		if fc.coalesce(t) || fc.dedupe(t) {
+			fc.metrics.count(nil)
+			continue
+		}
+
+		// 登记任务、检查暂停状态和熔断器，与 Do 相同
+		// Register the task, check the pause state and the circuit breaker, the same as Do
+		held, deferred, err := fc.prepare(t)
+		if err != nil {
+			errs[i] = err
+			fc.metrics.count(err)
+			fc.reject(t, err)
+			continue
+		}
+		fc.metrics.count(nil)
+
+		if !held && !deferred {
+			ready = append(ready, t)
+			index = append(index, i)
+		}
+	}
+
+	if len(ready) == 0 {
+		return errs
+	}
+
+	// 一次为整批任务预留令牌，得到每个任务的延迟时间
+	// Reserve tokens for the whole batch at once, and get the delay of each task
+	delays, reserveErrs := fc.reserve(ready)
+
+	// 收集被延迟的消息，一起通知回调函数
+	// Collect the delayed messages and notify the callback function together
+	var limited []any
+	var limitedDelays []time.Duration
+	var maxDelay time.Duration
+	for j := range ready {
+		if reserveErrs[j] != nil {
+			continue
+		}
+		if fc.shaper == nil {
+			delays[j] = delays[j].Round(rl.DefaultEffectiveTimeSliceInterval)
+		}
+		if delays[j] > 0 {
+			limited = append(limited, ready[j].msg)
+			limitedDelays = append(limitedDelays, delays[j])
+			if delays[j] > maxDelay {
+				maxDelay = delays[j]
+			}
+		}
+	}
+	if len(limited) > 0 {
+		fc.metrics.limited.Add(uint64(len(limited)))
+		fc.onExecBatchLimited(limited, limitedDelays, maxDelay)
+	}
+
+	// 一次性把所有任务提交到管道中
+	// Submit all tasks to the pipeline in one pass
+	for j, t := range ready {
+		err := reserveErrs[j]
+		if err == nil {
+			err = fc.submitAfter(t, delays[j])
+		}
+		if err != nil {
+			fc.unschedule(t)
+			fc.release(t)
+			fc.reject(t, err)
+			errs[index[j]] = err
+		}
+	}
+
+	return errs
+}
+
+// prepare 是一个方法，它登记任务并检查暂停状态和熔断器，返回任务是否被保留或者被推迟，继承了被丢弃的任务的时间槽的任务也视为被推迟，失败时任务已被注销。
+// 任务在可能开始执行之前按去重键登记为正在等待
+// prepare is a method that registers the task and checks the pause state and the circuit breaker, it returns whether the task is kept or deferred, a task that inherits the slot of a dropped task is also regarded as deferred, the task has been unregistered when it fails.
+// The task is registered as waiting by the deduplication key before it can start
+func (fc *FlowController) prepare(t *task) (held, deferred bool, err error) {
+	// 登记任务，如果流控制器已停止，返回 ErrStopped
+	// Register the task, if the flow controller has been stopped, return ErrStopped
+	victim, err := fc.admit(t)
+	if err != nil {
+		return false, false, err
+	}
+
+	// 保存被接受的任务，被保留或者被推迟的任务在重启后立即重放
+	// Save the accepted task, kept or deferred tasks are replayed immediately after a restart
+	if err = fc.persist(t, 0); err != nil {
+		fc.release(t)
+		return false, false, err
+	}
+
+	// 登记正在等待的任务，之后相同键的消息可以与它去重。必须在任务被保留、推迟或者提交之前登记，否则任务可能已经开始执行
+	// Register the waiting task, messages with the same key can be deduplicated with it afterwards. It must be registered before the task is kept, deferred or submitted, otherwise the task may have started already
+	fc.enqueue(t)
+
+	// 如果流控制器处于暂停状态，保留任务，不消耗令牌
+	// If the flow controller is paused, keep the task without consuming tokens
+	if held, err = fc.hold(t); held || err != nil {
+		if err != nil {
+			fc.release(t)
+		}
+		return held, false, err
+	}
+
+	// 检查熔断器
+	// Check the circuit breaker
+	if deferred, err = fc.guard(t); deferred || err != nil {
+		if err != nil {
+			fc.release(t)
+		}
+		return false, deferred, err
+	}
+
+	// 暂停和熔断器都放行后，新的任务接替被丢弃的任务在管道中的时间槽，不再预留令牌，也不会延长延迟范围
+	// After both the pause and the circuit breaker let it through, the new task takes over the slot of the dropped task in the pipeline, it reserves no token and does not extend the delay horizon
+	return false, fc.inherit(victim, t), nil
+}
+
+// reserve 是一个方法，它为一批任务预留令牌，如果速率限制器实现了 BatchRateLimiter，只进行一次预留，否则逐个预留，
+// 逐个预留时无法放行的任务得到对应的错误。漏桶整形模式下，每个任务逐个排在下一个空闲的时间槽上，整形器已满时得到 ErrShaperFull
+// reserve is a method that reserves tokens for a batch of tasks, if the rate limiter implements BatchRateLimiter, only one reservation is made, otherwise they are reserved one by one,
+// tasks that cannot be admitted get the corresponding error when reserved one by one. In the leaky-bucket shaping mode, each task is placed on the next free slot one by one, and gets ErrShaperFull when the shaper is full
+func (fc *FlowController) reserve(tasks []*task) ([]time.Duration, []error) {
+	n := len(tasks)
+	errs := make([]error, n)
+	limiter := fc.RateLimiter()
+	if fc.shaper != nil {
+		delays := make([]time.Duration, n)
+		for i, t := range tasks {
+			delays[i], errs[i] = fc.schedule(t, limiter)
+		}
+		return delays, errs
+	}
+	if batch, ok := limiter.(BatchRateLimiter); ok {
+		return batch.WhenN(n), errs
+	}
+
+	delays := make([]time.Duration, n)
+	for i := range delays {
+		delays[i], errs[i] = fc.when(limiter)
+	}
+	return delays, errs
+}
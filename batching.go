@@ -0,0 +1,233 @@
+package regula
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBatchSize 是默认的每批最多包含的消息数量
+	// DefaultMaxBatchSize is the default maximum number of messages in a batch
+	DefaultMaxBatchSize = 100
+
+	// DefaultMaxLinger 是默认的第一条消息进入批次后最长的等待时间
+	// DefaultMaxLinger is the default maximum waiting time after the first message enters the batch
+	DefaultMaxLinger = time.Millisecond * 10
+)
+
+// BatchHandleFunc 是批处理函数的类型，它接受一批消息，返回与消息一一对应的结果
+// BatchHandleFunc is the type of the batch handle function, it accepts a batch of messages and returns the results corresponding to the messages one by one
+type BatchHandleFunc = func(msgs []any) ([]any, error)
+
+// BatchingConfig 是批处理模式的配置，包含批处理函数、每批最多的消息数量和最长等待时间
+// BatchingConfig is the configuration of the batching mode, it contains the batch handle function, the maximum number of messages in a batch and the maximum waiting time
+type BatchingConfig struct {
+	// handler 是批处理函数
+	// handler is the batch handle function
+	handler BatchHandleFunc
+
+	// maxBatchSize 是每批最多包含的消息数量，达到后立即提交批次
+	// maxBatchSize is the maximum number of messages in a batch, the batch is submitted immediately when it is reached
+	maxBatchSize int
+
+	// maxLinger 是第一条消息进入批次后最长的等待时间，超过后提交未满的批次
+	// maxLinger is the maximum waiting time after the first message enters the batch, the batch that is not full is submitted after it
+	maxLinger time.Duration
+}
+
+// NewBatchingConfig 是创建新的批处理模式配置的函数，它接受批处理函数
+// NewBatchingConfig is a function to create a new batching mode configuration, it accepts the batch handle function
+func NewBatchingConfig(fn BatchHandleFunc) *BatchingConfig {
+	return &BatchingConfig{
+		handler:      fn,
+		maxBatchSize: DefaultMaxBatchSize,
+		maxLinger:    DefaultMaxLinger,
+	}
+}
+
+// WithMaxBatchSize 它设置每批最多包含的消息数量
+// WithMaxBatchSize is a method that sets the maximum number of messages in a batch
+func (c *BatchingConfig) WithMaxBatchSize(size int) *BatchingConfig {
+	c.maxBatchSize = size
+	return c
+}
+
+// WithMaxLinger 它设置第一条消息进入批次后最长的等待时间
+// WithMaxLinger is a method that sets the maximum waiting time after the first message enters the batch
+func (c *BatchingConfig) WithMaxLinger(linger time.Duration) *BatchingConfig {
+	c.maxLinger = linger
+	return c
+}
+
+// Validate 是一个方法，它严格检查批处理模式配置是否有效
+// Validate is a method that strictly checks if the batching mode configuration is valid
+func (c *BatchingConfig) Validate() error {
+	if c.handler == nil {
+		return fmt.Errorf("%w: batch handler is nil", ErrInvalidBatching)
+	}
+	if c.maxBatchSize <= 0 {
+		return fmt.Errorf("%w: max batch size must be greater than 0, got %d", ErrInvalidBatching, c.maxBatchSize)
+	}
+	if c.maxLinger <= 0 {
+		return fmt.Errorf("%w: max linger must be greater than 0, got %v", ErrInvalidBatching, c.maxLinger)
+	}
+	return nil
+}
+
+// isBatchingConfigValid 是一个函数，它检查批处理模式配置是否有效，如果无效，它将设置为默认值，批处理函数为空时关闭批处理模式
+// isBatchingConfigValid is a function that checks if the batching mode configuration is valid, if not, it sets it to the default values, the batching mode is disabled if the batch handle function is nil
+func isBatchingConfigValid(c *BatchingConfig) *BatchingConfig {
+	if c.handler == nil {
+		return nil
+	}
+	if c.maxBatchSize <= 0 {
+		c.maxBatchSize = DefaultMaxBatchSize
+	}
+	if c.maxLinger <= 0 {
+		c.maxLinger = DefaultMaxLinger
+	}
+	return c
+}
+
+// invoke 是一个方法，它把批处理函数转换为批次任务的消息处理函数
+// invoke is a method that converts the batch handle function to the message handle function of the batch task
+func (c *BatchingConfig) invoke(_ context.Context, msg any) (any, error) {
+	return c.handler(msg.([]any))
+}
+
+// aggregator 是批处理模式的聚合器，它收集通过 Do 提交的任务，批次满或者等待超时后把它们交给 flush
+// aggregator is the aggregator of the batching mode, it collects the tasks submitted through Do, and hands them over to flush when the batch is full or the waiting times out
+type aggregator struct {
+	// conf 是批处理模式的配置
+	// conf is the configuration of the batching mode
+	conf *BatchingConfig
+
+	// lock 保护正在收集的批次和定时器
+	// lock protects the batch being collected and the timer
+	lock sync.Mutex
+
+	// pending 是正在收集的批次
+	// pending is the batch being collected
+	pending []*task
+
+	// timer 在最长等待时间后提交未满的批次
+	// timer submits the batch that is not full after the maximum waiting time
+	timer *time.Timer
+
+	// flush 是提交批次的函数
+	// flush is the function that submits the batch
+	flush func([]*task)
+}
+
+// newAggregator 是创建新的聚合器的函数
+// newAggregator is a function to create a new aggregator
+func newAggregator(conf *BatchingConfig, flush func([]*task)) *aggregator {
+	return &aggregator{conf: conf, flush: flush}
+}
+
+// add 是一个方法，它把任务加入正在收集的批次，如果批次已满，立即提交批次，否则在第一条消息进入时启动定时器
+// add is a method that adds the task to the batch being collected, if the batch is full, it is submitted immediately, otherwise the timer is started when the first message enters
+func (a *aggregator) add(t *task) {
+	a.lock.Lock()
+	a.pending = append(a.pending, t)
+
+	// 批次已满，立即提交
+	// The batch is full, submit it immediately
+	if len(a.pending) >= a.conf.maxBatchSize {
+		batch := a.take()
+		a.lock.Unlock()
+		a.flush(batch)
+		return
+	}
+
+	// 第一条消息进入批次，启动定时器
+	// The first message enters the batch, start the timer
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.conf.maxLinger, a.expire)
+	}
+	a.lock.Unlock()
+}
+
+// take 是一个方法，它取出正在收集的批次并停止定时器，调用者必须持有锁
+// take is a method that takes out the batch being collected and stops the timer, the caller must hold the lock
+func (a *aggregator) take() []*task {
+	batch := a.pending
+	a.pending = nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	return batch
+}
+
+// expire 是一个方法，它在最长等待时间后或者关闭时提交未满的批次
+// expire is a method that submits the batch that is not full after the maximum waiting time or during shutdown
+func (a *aggregator) expire() {
+	a.lock.Lock()
+	batch := a.take()
+	a.lock.Unlock()
+
+	if len(batch) > 0 {
+		a.flush(batch)
+	}
+}
+
+// aggregate 是一个方法，它在批处理模式下登记任务并把它加入正在收集的批次，任务自己的处理函数和超时不会被使用
+// aggregate is a method that registers the task and adds it to the batch being collected in the batching mode, the handle function and timeout of the task itself are not used
+func (fc *FlowController) aggregate(t *task) error {
+	if _, err := fc.admit(t); err != nil {
+		return err
+	}
+	fc.aggregator.add(t)
+	return nil
+}
+
+// flush 是一个方法，它把一批任务合并为一个批次任务，批次任务与普通任务一样经过暂停、熔断器和速率限制器，每个批次只消耗一个令牌
+// flush is a method that merges a batch of tasks into one batch task, the batch task goes through the pause, the circuit breaker and the rate limiter like a normal task, and each batch consumes only one token
+func (fc *FlowController) flush(members []*task) {
+	bt := newTask(fc.config.batching.invoke, nil)
+
+	// 用批次任务替换登记表中的成员任务，跳过在关闭时已被放弃的成员
+	// Replace the member tasks in the registry with the batch task, skip the members that have been abandoned during shutdown
+	fc.lock.Lock()
+	msgs := make([]any, 0, len(members))
+	for _, m := range members {
+		if _, ok := fc.tasks[m]; !ok {
+			continue
+		}
+		delete(fc.tasks, m)
+		bt.members = append(bt.members, m)
+		msgs = append(msgs, m.msg)
+	}
+	if len(bt.members) == 0 {
+		fc.lock.Unlock()
+		return
+	}
+	bt.id = bt.members[0].id
+	bt.msg = msgs
+	fc.tasks[bt] = struct{}{}
+	fc.lock.Unlock()
+
+	// 批次任务与普通任务一样继续处理，如果失败，所有成员以失败结束
+	// The batch task continues to be processed like a normal task, if it fails, all members finish with failure
+	fc.proceed(bt)
+}
+
+// finishBatch 是一个方法，它把批处理函数的结果按顺序分发给每个成员，批处理函数失败时所有成员得到同一个错误
+// finishBatch is a method that distributes the results of the batch handle function to each member in order, all members get the same error if the batch handle function fails
+func (fc *FlowController) finishBatch(bt *task, result any, err error) {
+	results, _ := result.([]any)
+	if err == nil && len(results) != len(bt.members) {
+		err = fmt.Errorf("%w: %d results for %d messages", ErrBatchResultMismatch, len(results), len(bt.members))
+	}
+
+	for i, m := range bt.members {
+		if err != nil {
+			fc.complete(m, nil, err)
+		} else {
+			fc.complete(m, results[i], nil)
+		}
+	}
+}
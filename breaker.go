@@ -0,0 +1,450 @@
+package regula
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState 是熔断器的状态
+// CircuitState is the state of the circuit breaker
+type CircuitState int32
+
+const (
+	// CircuitClosed 表示熔断器关闭，消息正常执行
+	// CircuitClosed indicates that the circuit breaker is closed and messages are executed normally
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen 表示熔断器打开，消息被拒绝或推迟
+	// CircuitOpen indicates that the circuit breaker is open and messages are rejected or deferred
+	CircuitOpen
+
+	// CircuitHalfOpen 表示熔断器半开，只允许少量探测消息执行
+	// CircuitHalfOpen indicates that the circuit breaker is half-open and only a few probe messages are allowed to execute
+	CircuitHalfOpen
+)
+
+// String 是一个方法，它返回熔断器状态的名称
+// String is a method that returns the name of the circuit breaker state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(s))
+	}
+}
+
+const (
+	// DefaultBreakerConsecutiveFailures 是默认的打开熔断器的连续失败次数
+	// DefaultBreakerConsecutiveFailures is the default number of consecutive failures to open the circuit breaker
+	DefaultBreakerConsecutiveFailures = 5
+
+	// DefaultBreakerWindow 是默认的错误率统计的滚动窗口
+	// DefaultBreakerWindow is the default rolling window for error ratio statistics
+	DefaultBreakerWindow = time.Second * 10
+
+	// DefaultBreakerBuckets 是默认的滚动窗口的桶数量
+	// DefaultBreakerBuckets is the default number of buckets of the rolling window
+	DefaultBreakerBuckets = 10
+
+	// DefaultBreakerOpenDuration 是默认的熔断器打开持续时间
+	// DefaultBreakerOpenDuration is the default open duration of the circuit breaker
+	DefaultBreakerOpenDuration = time.Second * 5
+
+	// DefaultBreakerHalfOpenRequests 是默认的半开状态允许的探测消息数量
+	// DefaultBreakerHalfOpenRequests is the default number of probe messages allowed in the half-open state
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+// CircuitBreakerConfig 是熔断器的配置
+// CircuitBreakerConfig is the configuration of the circuit breaker
+type CircuitBreakerConfig struct {
+	// consecutiveFailures 是打开熔断器的连续失败次数，为 0 时不使用
+	// consecutiveFailures is the number of consecutive failures to open the circuit breaker, not used when it is 0
+	consecutiveFailures int
+
+	// errorRatio 是打开熔断器的滚动窗口内的错误率，为 0 时不使用
+	// errorRatio is the error ratio in the rolling window to open the circuit breaker, not used when it is 0
+	errorRatio float64
+
+	// minRequests 是使用错误率之前滚动窗口内至少需要的请求数量
+	// minRequests is the minimum number of requests in the rolling window before the error ratio is used
+	minRequests int
+
+	// window 是滚动窗口的长度
+	// window is the length of the rolling window
+	window time.Duration
+
+	// buckets 是滚动窗口的桶数量
+	// buckets is the number of buckets of the rolling window
+	buckets int
+
+	// openDuration 是熔断器打开的持续时间，之后进入半开状态
+	// openDuration is the duration the circuit breaker stays open, after which it enters the half-open state
+	openDuration time.Duration
+
+	// halfOpenRequests 是半开状态允许的探测消息数量，全部成功后熔断器关闭
+	// halfOpenRequests is the number of probe messages allowed in the half-open state, the circuit breaker closes after all of them succeed
+	halfOpenRequests int
+
+	// deferWhenOpen 表示熔断器打开时推迟消息而不是拒绝
+	// deferWhenOpen indicates that messages are deferred instead of rejected when the circuit breaker is open
+	deferWhenOpen bool
+}
+
+// NewCircuitBreakerConfig 是创建新的熔断器配置的函数
+// NewCircuitBreakerConfig is a function to create a new circuit breaker configuration
+func NewCircuitBreakerConfig() *CircuitBreakerConfig {
+	return &CircuitBreakerConfig{
+		consecutiveFailures: DefaultBreakerConsecutiveFailures,
+		window:              DefaultBreakerWindow,
+		buckets:             DefaultBreakerBuckets,
+		openDuration:        DefaultBreakerOpenDuration,
+		halfOpenRequests:    DefaultBreakerHalfOpenRequests,
+	}
+}
+
+// DefaultCircuitBreakerConfig 是获取默认熔断器配置的函数
+// DefaultCircuitBreakerConfig is a function to get the default circuit breaker configuration
+func DefaultCircuitBreakerConfig() *CircuitBreakerConfig {
+	return NewCircuitBreakerConfig()
+}
+
+// WithConsecutiveFailures 它设置打开熔断器的连续失败次数，为 0 时不使用
+// WithConsecutiveFailures is a method that sets the number of consecutive failures to open the circuit breaker, not used when it is 0
+func (c *CircuitBreakerConfig) WithConsecutiveFailures(failures int) *CircuitBreakerConfig {
+	c.consecutiveFailures = failures
+	return c
+}
+
+// WithErrorRatio 它设置打开熔断器的错误率，以及使用错误率之前滚动窗口内至少需要的请求数量，错误率为 0 时不使用
+// WithErrorRatio is a method that sets the error ratio to open the circuit breaker, and the minimum number of requests in the rolling window before the error ratio is used, not used when the ratio is 0
+func (c *CircuitBreakerConfig) WithErrorRatio(ratio float64, minRequests int) *CircuitBreakerConfig {
+	c.errorRatio = ratio
+	c.minRequests = minRequests
+	return c
+}
+
+// WithWindow 它设置滚动窗口的长度和桶数量
+// WithWindow is a method that sets the length and the number of buckets of the rolling window
+func (c *CircuitBreakerConfig) WithWindow(window time.Duration, buckets int) *CircuitBreakerConfig {
+	c.window = window
+	c.buckets = buckets
+	return c
+}
+
+// WithOpenDuration 它设置熔断器打开的持续时间
+// WithOpenDuration is a method that sets the duration the circuit breaker stays open
+func (c *CircuitBreakerConfig) WithOpenDuration(duration time.Duration) *CircuitBreakerConfig {
+	c.openDuration = duration
+	return c
+}
+
+// WithHalfOpenRequests 它设置半开状态允许的探测消息数量
+// WithHalfOpenRequests is a method that sets the number of probe messages allowed in the half-open state
+func (c *CircuitBreakerConfig) WithHalfOpenRequests(requests int) *CircuitBreakerConfig {
+	c.halfOpenRequests = requests
+	return c
+}
+
+// WithDeferWhenOpen 它设置熔断器打开时是否推迟消息，默认拒绝消息并返回 ErrCircuitOpen
+// WithDeferWhenOpen is a method that sets whether to defer messages when the circuit breaker is open, by default messages are rejected with ErrCircuitOpen
+func (c *CircuitBreakerConfig) WithDeferWhenOpen(deferred bool) *CircuitBreakerConfig {
+	c.deferWhenOpen = deferred
+	return c
+}
+
+// Validate 是一个方法，它严格检查熔断器配置是否有效
+// Validate is a method that strictly checks if the circuit breaker configuration is valid
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.consecutiveFailures < 0 {
+		return fmt.Errorf("%w: consecutive failures must not be negative, got %d", ErrInvalidCircuitBreaker, c.consecutiveFailures)
+	}
+	if c.errorRatio < 0 || c.errorRatio > 1 {
+		return fmt.Errorf("%w: error ratio must be in [0, 1], got %v", ErrInvalidCircuitBreaker, c.errorRatio)
+	}
+	if c.consecutiveFailures == 0 && c.errorRatio == 0 {
+		return fmt.Errorf("%w: at least one of consecutive failures and error ratio must be set", ErrInvalidCircuitBreaker)
+	}
+	if c.window <= 0 || c.buckets <= 0 {
+		return fmt.Errorf("%w: window and buckets must be greater than 0, got %v and %d", ErrInvalidCircuitBreaker, c.window, c.buckets)
+	}
+	if c.openDuration <= 0 {
+		return fmt.Errorf("%w: open duration must be greater than 0, got %v", ErrInvalidCircuitBreaker, c.openDuration)
+	}
+	if c.halfOpenRequests <= 0 {
+		return fmt.Errorf("%w: half-open requests must be greater than 0, got %d", ErrInvalidCircuitBreaker, c.halfOpenRequests)
+	}
+	return nil
+}
+
+// isCircuitBreakerConfigValid 是一个函数，它检查熔断器配置是否有效，如果无效，它将设置为默认值
+// isCircuitBreakerConfigValid is a function that checks if the circuit breaker configuration is valid, if not, it sets it to the default values
+func isCircuitBreakerConfigValid(c *CircuitBreakerConfig) *CircuitBreakerConfig {
+	if c.consecutiveFailures < 0 {
+		c.consecutiveFailures = DefaultBreakerConsecutiveFailures
+	}
+	if c.errorRatio < 0 || c.errorRatio > 1 {
+		c.errorRatio = 0
+	}
+	if c.consecutiveFailures == 0 && c.errorRatio == 0 {
+		c.consecutiveFailures = DefaultBreakerConsecutiveFailures
+	}
+	if c.window <= 0 {
+		c.window = DefaultBreakerWindow
+	}
+	if c.buckets <= 0 {
+		c.buckets = DefaultBreakerBuckets
+	}
+	if c.openDuration <= 0 {
+		c.openDuration = DefaultBreakerOpenDuration
+	}
+	if c.halfOpenRequests <= 0 {
+		c.halfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return c
+}
+
+// breakerBucket 是滚动窗口中的一个桶
+// breakerBucket is a bucket in the rolling window
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// circuitBreaker 是流控制器内部的熔断器
+// circuitBreaker is the circuit breaker inside the flow controller
+type circuitBreaker struct {
+	// config 是熔断器的配置
+	// config is the configuration of the circuit breaker
+	config *CircuitBreakerConfig
+
+	// onChange 是熔断器状态变化时的通知函数，它在锁外被调用
+	// onChange is the notification function when the state of the circuit breaker changes, it is called outside the lock
+	onChange func(from, to CircuitState)
+
+	// lock 保护熔断器的状态
+	// lock protects the state of the circuit breaker
+	lock sync.Mutex
+
+	// state 是熔断器的当前状态
+	// state is the current state of the circuit breaker
+	state CircuitState
+
+	// openedAt 是熔断器最近一次打开的时间
+	// openedAt is the time when the circuit breaker was last opened
+	openedAt time.Time
+
+	// consecutive 是当前的连续失败次数
+	// consecutive is the current number of consecutive failures
+	consecutive int
+
+	// probes 和 probeSuccesses 是半开状态下已放行的探测消息数量和成功数量
+	// probes and probeSuccesses are the number of probe messages allowed and succeeded in the half-open state
+	probes         int
+	probeSuccesses int
+
+	// generation 是当前半开状态的编号，每次进入半开状态时加一，探测消息持有它作为凭证
+	// generation is the number of the current half-open state, it is incremented each time the half-open state is entered, probe messages hold it as the ticket
+	generation uint64
+
+	// buckets 是滚动窗口的桶
+	// buckets are the buckets of the rolling window
+	buckets []breakerBucket
+}
+
+// newCircuitBreaker 是创建新的熔断器的函数
+// newCircuitBreaker is a function to create a new circuit breaker
+func newCircuitBreaker(conf *CircuitBreakerConfig, onChange func(from, to CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		config:   conf,
+		onChange: onChange,
+		buckets:  make([]breakerBucket, conf.buckets),
+	}
+}
+
+// State 是一个方法，它返回熔断器的当前状态
+// State is a method that returns the current state of the circuit breaker
+func (b *circuitBreaker) State() CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+// allow 是一个方法，它判断是否允许一个消息执行，如果不允许，返回 ErrCircuitOpen 和建议的推迟时间。
+// 半开状态下被放行的消息是探测消息，返回非 0 的探测凭证，消息执行后通过 record 报告结果，没有执行时必须通过 cancel 归还
+// allow is a method that determines whether a message is allowed to execute, if not, it returns ErrCircuitOpen and the suggested defer time.
+// A message allowed in the half-open state is a probe and gets a non-zero probe ticket, its result is reported through record after execution, and it must be returned through cancel if it is not executed
+func (b *circuitBreaker) allow() (uint64, time.Duration, error) {
+	b.lock.Lock()
+
+	now := time.Now()
+	from := b.state
+
+	// 如果熔断器打开并且持续时间已经结束，进入半开状态
+	// If the circuit breaker is open and the duration has ended, enter the half-open state
+	if b.state == CircuitOpen {
+		if wait := b.openedAt.Add(b.config.openDuration).Sub(now); wait > 0 {
+			b.lock.Unlock()
+			return 0, wait, ErrCircuitOpen
+		}
+		b.state, b.probes, b.probeSuccesses = CircuitHalfOpen, 0, 0
+		b.generation++
+	}
+
+	// 半开状态下只放行有限数量的探测消息
+	// Only a limited number of probe messages are allowed in the half-open state
+	var probe uint64
+	var err error
+	if b.state == CircuitHalfOpen {
+		if b.probes < b.config.halfOpenRequests {
+			b.probes++
+			probe = b.generation
+		} else {
+			err = ErrCircuitOpen
+		}
+	}
+
+	to := b.state
+	b.lock.Unlock()
+
+	b.notify(from, to)
+	if err != nil {
+		return 0, b.config.openDuration, err
+	}
+	return probe, 0, nil
+}
+
+// cancel 是一个方法，它归还一个没有执行的探测消息的名额，使半开状态可以放行新的探测消息
+// cancel is a method that returns the slot of a probe message that was not executed, so the half-open state can allow a new probe message
+func (b *circuitBreaker) cancel(probe uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitHalfOpen && probe == b.generation && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// record 是一个方法，它记录一个消息的执行结果，并根据阈值转换熔断器的状态，probe 是消息被放行时得到的探测凭证
+// record is a method that records the execution result of a message, and transitions the state of the circuit breaker according to the thresholds, probe is the probe ticket the message got when it was allowed
+func (b *circuitBreaker) record(err error, probe uint64) {
+	b.lock.Lock()
+
+	now := time.Now()
+	from := b.state
+
+	switch b.state {
+	case CircuitHalfOpen:
+		// 半开状态下只统计当前探测消息的结果，熔断器打开前放行的消息的结果被忽略。
+		// 任何探测失败都会重新打开熔断器，全部探测成功后关闭熔断器
+		// Only the results of the current probe messages are counted in the half-open state, the results of messages allowed before the circuit breaker opened are ignored.
+		// Any probe failure reopens the circuit breaker, and it closes after all probes succeed
+		if probe != b.generation {
+			break
+		}
+		if err != nil {
+			b.open(now)
+		} else if b.probeSuccesses++; b.probeSuccesses >= b.config.halfOpenRequests {
+			b.reset()
+		}
+
+	case CircuitClosed:
+		// 记录到滚动窗口中
+		// Record into the rolling window
+		bucket := b.bucket(now)
+		if err != nil {
+			bucket.failures++
+			b.consecutive++
+		} else {
+			bucket.successes++
+			b.consecutive = 0
+		}
+
+		// 检查是否达到打开熔断器的阈值
+		// Check whether the threshold to open the circuit breaker is reached
+		if err != nil && b.tripped(now) {
+			b.open(now)
+		}
+	}
+
+	to := b.state
+	b.lock.Unlock()
+
+	b.notify(from, to)
+}
+
+// tripped 是一个方法，它判断是否达到打开熔断器的阈值，调用者必须持有锁
+// tripped is a method that determines whether the threshold to open the circuit breaker is reached, the caller must hold the lock
+func (b *circuitBreaker) tripped(now time.Time) bool {
+	// 连续失败次数阈值
+	// Consecutive failures threshold
+	if b.config.consecutiveFailures > 0 && b.consecutive >= b.config.consecutiveFailures {
+		return true
+	}
+
+	// 滚动窗口内的错误率阈值
+	// Error ratio threshold in the rolling window
+	if b.config.errorRatio > 0 {
+		successes, failures := 0, 0
+		for i := range b.buckets {
+			if now.Sub(b.buckets[i].start) < b.config.window {
+				successes += b.buckets[i].successes
+				failures += b.buckets[i].failures
+			}
+		}
+		total := successes + failures
+		if total > 0 && total >= b.config.minRequests && float64(failures)/float64(total) >= b.config.errorRatio {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bucket 是一个方法，它返回当前时间所在的桶，过期的桶会被重置，调用者必须持有锁
+// bucket is a method that returns the bucket of the current time, expired buckets are reset, the caller must hold the lock
+func (b *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := b.config.window / time.Duration(b.config.buckets)
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	bucket := &b.buckets[int((start.UnixNano()/int64(width))%int64(len(b.buckets)))]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// open 是一个方法，它打开熔断器，调用者必须持有锁
+// open is a method that opens the circuit breaker, the caller must hold the lock
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = CircuitOpen
+	b.openedAt = now
+}
+
+// reset 是一个方法，它关闭熔断器并清空统计，调用者必须持有锁
+// reset is a method that closes the circuit breaker and clears the statistics, the caller must hold the lock
+func (b *circuitBreaker) reset() {
+	b.state = CircuitClosed
+	b.consecutive = 0
+	for i := range b.buckets {
+		b.buckets[i] = breakerBucket{}
+	}
+}
+
+// notify 是一个方法，如果状态发生了变化，它调用通知函数
+// notify is a method that calls the notification function if the state has changed
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
@@ -15,3 +15,85 @@ func (emptyCallback) OnExecLimited(msg any, delay time.Duration) {}
 func NewEmptyCallback() Callback {
 	return &emptyCallback{}
 }
+
+// emptyReloadCallback 是一个空结构体，用于实现重新加载回调接口
+// emptyReloadCallback is an empty structure used to implement the reload callback interface
+type emptyReloadCallback struct{}
+
+// OnReloaded 是一个方法，当配置被重新加载时，它不执行任何操作
+// OnReloaded is a method that does nothing when the configuration is reloaded
+func (emptyReloadCallback) OnReloaded(event *ReloadEvent) {}
+
+// NewEmptyReloadCallback 是一个函数，它创建并返回一个新的emptyReloadCallback
+// NewEmptyReloadCallback is a function that creates and returns a new emptyReloadCallback
+func NewEmptyReloadCallback() ReloadCallback {
+	return &emptyReloadCallback{}
+}
+
+// onPaused 是一个方法，如果回调实现了 PauseCallback，它通知流控制器被暂停
+// onPaused is a method that notifies that the flow controller is paused if the callback implements PauseCallback
+func (fc *FlowController) onPaused() {
+	if cb, ok := fc.config.callback.(PauseCallback); ok {
+		cb.OnPaused()
+	}
+}
+
+// onResumed 是一个方法，如果回调实现了 PauseCallback，它通知流控制器被恢复
+// onResumed is a method that notifies that the flow controller is resumed if the callback implements PauseCallback
+func (fc *FlowController) onResumed(held int) {
+	if cb, ok := fc.config.callback.(PauseCallback); ok {
+		cb.OnResumed(held)
+	}
+}
+
+// onExecRetry 是一个方法，如果回调实现了 RetryCallback，它通知消息将要重试
+// onExecRetry is a method that notifies that the message will be retried if the callback implements RetryCallback
+func (fc *FlowController) onExecRetry(msg any, attempt int, delay time.Duration, err error) {
+	if cb, ok := fc.config.callback.(RetryCallback); ok {
+		cb.OnExecRetry(msg, attempt, delay, err)
+	}
+}
+
+// onExecFailed 是一个方法，如果回调实现了 RetryCallback，它通知消息最终失败
+// onExecFailed is a method that notifies that the message finally failed if the callback implements RetryCallback
+func (fc *FlowController) onExecFailed(msg any, err error) {
+	if cb, ok := fc.config.callback.(RetryCallback); ok {
+		cb.OnExecFailed(msg, err)
+	}
+}
+
+// onExecPanic 是一个方法，如果回调实现了 PanicCallback，它通知处理函数发生了 panic
+// onExecPanic is a method that notifies that the handle function panicked if the callback implements PanicCallback
+func (fc *FlowController) onExecPanic(msg any, err *PanicError) {
+	if cb, ok := fc.config.callback.(PanicCallback); ok {
+		cb.OnExecPanic(msg, err)
+	}
+}
+
+// onExecDropped 是一个方法，如果回调实现了 DropCallback，它通知消息在执行前被丢弃
+// onExecDropped is a method that notifies that the message was dropped before execution if the callback implements DropCallback
+func (fc *FlowController) onExecDropped(msg any, reason error) {
+	if cb, ok := fc.config.callback.(DropCallback); ok {
+		cb.OnExecDropped(msg, reason)
+	}
+}
+
+// onExecDeduplicated 是一个方法，如果回调实现了 DedupeCallback，它通知消息因去重被合并
+// onExecDeduplicated is a method that notifies that the message was combined by deduplication if the callback implements DedupeCallback
+func (fc *FlowController) onExecDeduplicated(msg any, kept any) {
+	if cb, ok := fc.config.callback.(DedupeCallback); ok {
+		cb.OnExecDeduplicated(msg, kept)
+	}
+}
+
+// onExecBatchLimited 是一个方法，如果回调实现了 BatchCallback，它对一批被延迟的消息只通知一次，否则对每条消息调用 OnExecLimited
+// onExecBatchLimited is a method that notifies only once for a batch of delayed messages if the callback implements BatchCallback, otherwise it calls OnExecLimited for each message
+func (fc *FlowController) onExecBatchLimited(msgs []any, delays []time.Duration, maxDelay time.Duration) {
+	if cb, ok := fc.config.callback.(BatchCallback); ok {
+		cb.OnExecBatchLimited(msgs, maxDelay)
+		return
+	}
+	for i, msg := range msgs {
+		fc.config.callback.OnExecLimited(msg, delays[i])
+	}
+}
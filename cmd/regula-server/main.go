@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/shengyanli1982/regula/lease"
+)
+
+// logCallback 是一个结构体，它把重新加载的结果写入日志
+// logCallback is a structure that writes the result of reloading to the log
+type logCallback struct{}
+
+// OnReloaded 是一个方法，它在配置文件被重新加载后写入日志
+// OnReloaded is a method that writes to the log after the configuration file is reloaded
+func (logCallback) OnReloaded(event *regula.ReloadEvent) {
+	if event.Err != nil {
+		log.Printf("reload %s: %v", event.Path, event.Err)
+		return
+	}
+	if len(event.Applied) > 0 {
+		log.Printf("reload %s: applied %v", event.Path, event.Applied)
+	}
+}
+
+// loadLimits 是一个函数，它读取限制文件，并在协调器上设置每个限制，文件格式与重新加载器相同
+// loadLimits is a function that reads the limits file and sets each limit on the coordinator, the file format is the same as the reloader
+func loadLimits(path string, server *lease.Server) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file regula.ReloadFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(file.Limiters))
+	for name, spec := range file.Limiters {
+		if spec == nil {
+			return nil, fmt.Errorf("limiter %q: %w", name, regula.ErrConfigIsNil)
+		}
+		if spec.Type != "" && spec.Type != regula.LimiterTypeToken {
+			return nil, fmt.Errorf("limiter %q: %w: %q", name, regula.ErrUnknownLimiterType, spec.Type)
+		}
+		if err = spec.Validate(); err != nil {
+			return nil, fmt.Errorf("limiter %q: %w", name, err)
+		}
+		server.SetLimit(name, spec.Rate, spec.Burst)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	path := flag.String("config", "limits.json", "limits file, in the same format as the regula reloader")
+	maxBatch := flag.Int("max-batch", lease.DefaultMaxBatch, "maximum number of tokens in a single lease")
+	ttl := flag.Duration("ttl", lease.DefaultTTL, "time a token stays valid after it is available")
+	interval := flag.Duration("reload-interval", regula.DefaultReloadInterval, "polling interval of the limits file")
+	flag.Parse()
+
+	server := lease.NewServer(lease.NewServerConfig().WithMaxBatch(*maxBatch).WithTTL(*ttl))
+
+	names, err := loadLimits(*path, server)
+	if err != nil {
+		log.Fatalf("load %s: %v", *path, err)
+	}
+
+	// 限制文件的修改在运行时生效，新增的名称需要重启
+	// Changes to the limits file take effect at runtime, new names require a restart
+	reloader := regula.NewReloader(*path, regula.NewReloaderConfig().WithInterval(*interval).WithCallback(logCallback{}))
+	defer reloader.Stop()
+	for _, name := range names {
+		limiter, _ := server.Limiter(name)
+		if err = reloader.RegisterLimiter(name, limiter); err != nil {
+			log.Fatalf("register %s: %v", name, err)
+		}
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: server, ReadHeaderTimeout: time.Second * 5}
+
+	// 收到信号后优雅地停止
+	// Stop gracefully after receiving a signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdown)
+	}()
+
+	log.Printf("regula-server listening on %s with limits %v", *addr, names)
+	if err = httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("serve: %v", err)
+	}
+}
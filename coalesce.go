@@ -0,0 +1,66 @@
+package regula
+
+// coalesce 是一个方法，如果已有相同键的任务在等待或者执行，它把任务作为跟随者挂到该任务上并返回 true，
+// 跟随者不消耗令牌也不执行处理函数，而是得到与领导者相同的结果。否则任务成为该键的领导者
+// coalesce is a method that attaches the task as a follower to the task with the same key and returns true if such a task is waiting or executing,
+// the follower does not consume tokens or execute the handle function, but gets the same result as the leader. Otherwise the task becomes the leader of the key
+func (fc *FlowController) coalesce(t *task) bool {
+	if fc.config.coalesce == nil {
+		return false
+	}
+	key := fc.config.coalesce(t.msg)
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	// 如果流控制器已停止，任务会在登记时被拒绝
+	// If the flow controller has been stopped, the task is rejected when it is registered
+	if fc.stopped {
+		return false
+	}
+
+	// 相同键的任务还没有完成，作为跟随者等待它的结果
+	// The task with the same key has not completed yet, wait for its result as a follower
+	if leader, ok := fc.inflight[key]; ok {
+		fc.seq++
+		t.id = fc.seq
+		leader.followers = append(leader.followers, t)
+		fc.metrics.coalesced.Add(1)
+		return true
+	}
+
+	// 成为该键的领导者
+	// Become the leader of the key
+	t.key = key
+	t.keyed = true
+	fc.inflight[key] = t
+	return false
+}
+
+// detach 是一个方法，它注销任务的合并键，并取出任务的跟随者，之后相同键的消息会成为新的领导者
+// detach is a method that unregisters the coalescing key of the task and takes out the followers of the task, messages with the same key become a new leader afterwards
+func (fc *FlowController) detach(t *task) []*task {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	return fc.detachLocked(t)
+}
+
+// detachLocked 是一个方法，它与 detach 相同，调用者必须持有锁
+// detachLocked is a method that is the same as detach, the caller must hold the lock
+func (fc *FlowController) detachLocked(t *task) []*task {
+	if t.keyed && fc.inflight[t.key] == t {
+		delete(fc.inflight, t.key)
+	}
+	followers := t.followers
+	t.followers = nil
+	return followers
+}
+
+// reject 是一个方法，它在领导者提交失败时以相同的错误结束它的跟随者
+// reject is a method that finishes the followers of the leader with the same error when the submission of the leader fails
+func (fc *FlowController) reject(t *task, err error) {
+	for _, f := range fc.detach(t) {
+		fc.complete(f, nil, err)
+	}
+}
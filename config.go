@@ -1,22 +1,43 @@
 package regula
 
 import (
+	"fmt"
+	"time"
+
 	rl "github.com/shengyanli1982/regula/ratelimiter"
 )
 
+// DefaultPauseCapacity 是默认的暂停期间最多保留的消息数量
+// DefaultPauseCapacity is the default maximum number of messages kept during the pause
+const DefaultPauseCapacity = 1024
+
 // Config 是配置的结构体，包含一个速率限制器接口
 // Config is the structure for configuration, containing a rate limiter interface
 type Config struct {
-	ratelimiter RateLimiter
-	callback    Callback
+	ratelimiter   RateLimiter
+	callback      Callback
+	pauseCapacity int
+	retryPolicy   *RetryPolicy
+	breaker       *CircuitBreakerConfig
+	timeout       time.Duration
+	batching      *BatchingConfig
+	coalesce      KeyFunc
+	debounce      *DebounceConfig
+	throttle      *ThrottleConfig
+	dedupe        *DedupeConfig
+	backlog       *BacklogConfig
+	shaper        *ShaperConfig
+	store         MessageStore
+	codec         Codec
 }
 
 // NewConfig 是创建新配置的函数，它返回一个包含默认无操作限制器的配置
 // NewConfig is a function to create a new configuration, it returns a configuration with a default no-operation limiter
 func NewConfig() *Config {
 	return &Config{
-		ratelimiter: rl.NewNopLimiter(),
-		callback:    NewEmptyCallback(),
+		ratelimiter:   rl.NewNopLimiter(),
+		callback:      NewEmptyCallback(),
+		pauseCapacity: DefaultPauseCapacity,
 	}
 }
 
@@ -40,6 +61,211 @@ func (c *Config) WithCallback(cb Callback) *Config {
 	return c
 }
 
+// WithPauseCapacity 它设置暂停期间最多保留的消息数量，超过后 Do 返回 ErrPauseCapacityExceeded
+// WithPauseCapacity is a method that sets the maximum number of messages kept during the pause, Do returns ErrPauseCapacityExceeded when it is exceeded
+func (c *Config) WithPauseCapacity(capacity int) *Config {
+	c.pauseCapacity = capacity
+	return c
+}
+
+// WithRetryPolicy 它设置处理函数返回错误时的重试策略，为 nil 时不重试
+// WithRetryPolicy is a method that sets the retry policy when the handle function returns an error, no retry if it is nil
+func (c *Config) WithRetryPolicy(policy *RetryPolicy) *Config {
+	c.retryPolicy = policy
+	return c
+}
+
+// WithCircuitBreaker 它设置由处理函数返回的错误驱动的熔断器，为 nil 时不使用熔断器
+// WithCircuitBreaker is a method that sets the circuit breaker driven by the errors returned by the handle function, no circuit breaker is used if it is nil
+func (c *Config) WithCircuitBreaker(breaker *CircuitBreakerConfig) *Config {
+	c.breaker = breaker
+	return c
+}
+
+// WithHandlerTimeout 它设置消息处理函数的超时，超时后调用者得到 ErrHandlerTimeout，为 0 时不限制。处理函数必须响应上下文，否则超时后它们会在后台继续运行
+// WithHandlerTimeout is a method that sets the timeout of the message handle function, the caller gets ErrHandlerTimeout after the timeout, no limit if it is 0. Handle functions must honour the context, otherwise they keep running in the background after the timeout
+func (c *Config) WithHandlerTimeout(timeout time.Duration) *Config {
+	c.timeout = timeout
+	return c
+}
+
+// WithBatching 它设置批处理模式，通过 Do 提交的消息被聚合后交给批处理函数，每个批次消耗一个令牌，为 nil 时不使用批处理模式
+// WithBatching is a method that sets the batching mode, the messages submitted through Do are aggregated and handed over to the batch handle function, each batch consumes one token, the batching mode is not used if it is nil
+func (c *Config) WithBatching(batching *BatchingConfig) *Config {
+	c.batching = batching
+	return c
+}
+
+// WithCoalescing 它设置合并键函数，相同键的消息在等待或者执行期间共享一个令牌和一次处理函数执行，所有提交者得到相同的结果，为 nil 时不合并
+// WithCoalescing is a method that sets the coalescing key function, messages with the same key share one token and one execution of the handle function while waiting or executing, and all submitters get the same result, no coalescing if it is nil
+func (c *Config) WithCoalescing(fn KeyFunc) *Config {
+	c.coalesce = fn
+	return c
+}
+
+// WithDebounce 它设置防抖模式，同一个键在静默时间内只有最后一条消息会执行，为 nil 时不使用防抖模式
+// WithDebounce is a method that sets the debounce mode, only the last message of a key in the quiet period is executed, the debounce mode is not used if it is nil
+func (c *Config) WithDebounce(debounce *DebounceConfig) *Config {
+	c.debounce = debounce
+	return c
+}
+
+// WithThrottle 它设置节流模式，同一个键每个间隔内最多执行一次，为 nil 时不使用节流模式。节流模式不能与防抖模式同时使用，
+// Validate 会拒绝这样的配置，NewFlowController 则忽略节流模式
+// WithThrottle is a method that sets the throttle mode, a key is executed at most once per interval, the throttle mode is not used if it is nil. The throttle mode cannot be used together with the debounce mode,
+// Validate rejects such a configuration, and NewFlowController ignores the throttle mode
+func (c *Config) WithThrottle(throttle *ThrottleConfig) *Config {
+	c.throttle = throttle
+	return c
+}
+
+// WithDedupe 它设置去重，在速率限制器延迟中等待的相同键的消息按去重策略合并为一次执行，为 nil 时不去重。
+// 去重不能与批处理、防抖和节流模式同时使用，Validate 会拒绝这样的配置，NewFlowController 则忽略去重
+// WithDedupe is a method that sets deduplication, messages with the same key waiting in the delay of the rate limiter are combined into one execution according to the deduplication policy, no deduplication if it is nil.
+// Deduplication cannot be used together with the batching, debounce and throttle modes, Validate rejects such a configuration, and NewFlowController ignores deduplication
+func (c *Config) WithDedupe(dedupe *DedupeConfig) *Config {
+	c.dedupe = dedupe
+	return c
+}
+
+// WithBacklog 它设置积压上限和减载策略，积压满时新的或者正在等待的消息被丢弃，为 nil 时不限制积压
+// WithBacklog is a method that sets the backlog bound and the load shedding policy, new or waiting messages are dropped when the backlog is full, no limit on the backlog if it is nil
+func (c *Config) WithBacklog(backlog *BacklogConfig) *Config {
+	c.backlog = backlog
+	return c
+}
+
+// WithShaper 它设置漏桶整形模式，消息按固定的间隔排在下一个空闲的时间槽上，不会突发，延迟也不再按时间片取整，为 nil 时不使用整形模式。
+// 消息在时间槽到来时才立即提交到管道中，所以管道必须立即执行提交的消息，间隔才是精确的
+// WithShaper is a method that sets the leaky-bucket shaping mode, messages are placed on the next free slot at a fixed interval without bursts, and the delays are no longer rounded to the time slice, the shaping mode is not used if it is nil.
+// Messages are submitted to the pipeline immediately only when their slots arrive, so the pipeline must execute submitted messages at once for the spacing to be exact
+func (c *Config) WithShaper(shaper *ShaperConfig) *Config {
+	c.shaper = shaper
+	return c
+}
+
+// WithStore 它设置持久化存储和消息编解码器，通过 DoNamed 提交的消息在完成前都保存在存储中，重启后可以通过 Replay 重放，为 nil 时不持久化
+// WithStore is a method that sets the durable store and the message codec, messages submitted through DoNamed are kept in the store until they complete, and can be replayed through Replay after a restart, no persistence if it is nil
+func (c *Config) WithStore(store MessageStore, codec Codec) *Config {
+	c.store = store
+	c.codec = codec
+	return c
+}
+
+// Validate 是一个方法，它严格检查配置是否有效，如果无效，它返回描述性错误而不是设置为默认值
+// Validate is a method that strictly checks if the configuration is valid, if not, it returns a descriptive error instead of setting default values
+func (c *Config) Validate() error {
+	// 如果配置为空，返回错误
+	// If the configuration is null, return an error
+	if c == nil {
+		return ErrConfigIsNil
+	}
+
+	// 如果配置中的速率限制器为空，返回错误
+	// If the rate limiter in the configuration is null, return an error
+	if c.ratelimiter == nil {
+		return ErrRateLimiterIsNil
+	}
+
+	// 如果配置中的回调函数为空，返回错误
+	// If the callback function in the configuration is null, return an error
+	if c.callback == nil {
+		return ErrCallbackIsNil
+	}
+
+	// 如果配置中的暂停容量小于等于0，返回错误
+	// If the pause capacity in the configuration is less than or equal to 0, return an error
+	if c.pauseCapacity <= 0 {
+		return fmt.Errorf("%w, got %d", ErrInvalidPauseCapacity, c.pauseCapacity)
+	}
+
+	// 如果配置了重试策略，检查重试策略是否有效
+	// If the retry policy is configured, check if the retry policy is valid
+	if c.retryPolicy != nil {
+		if err := c.retryPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置中的超时小于0，返回错误
+	// If the timeout in the configuration is less than 0, return an error
+	if c.timeout < 0 {
+		return fmt.Errorf("%w, got %v", ErrInvalidHandlerTimeout, c.timeout)
+	}
+
+	// 如果配置了熔断器，检查熔断器配置是否有效
+	// If the circuit breaker is configured, check if the circuit breaker configuration is valid
+	if c.breaker != nil {
+		if err := c.breaker.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了批处理模式，检查批处理模式配置是否有效
+	// If the batching mode is configured, check if the batching mode configuration is valid
+	if c.batching != nil {
+		if err := c.batching.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了防抖模式，检查防抖模式配置是否有效
+	// If the debounce mode is configured, check if the debounce mode configuration is valid
+	if c.debounce != nil {
+		if err := c.debounce.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了节流模式，检查节流模式配置是否有效，节流模式不能与防抖模式同时使用
+	// If the throttle mode is configured, check if the throttle mode configuration is valid, the throttle mode cannot be used together with the debounce mode
+	if c.throttle != nil {
+		if c.debounce != nil {
+			return fmt.Errorf("%w: throttle cannot be used together with debounce", ErrInvalidThrottle)
+		}
+		if err := c.throttle.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了去重，检查去重配置是否有效，去重不能与批处理、防抖和节流模式同时使用
+	// If deduplication is configured, check if the deduplication configuration is valid, deduplication cannot be used together with the batching, debounce and throttle modes
+	if c.dedupe != nil {
+		if c.batching != nil || c.debounce != nil || c.throttle != nil {
+			return fmt.Errorf("%w: dedupe cannot be used together with batching, debounce or throttle", ErrInvalidDedupe)
+		}
+		if err := c.dedupe.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了积压上限，检查积压上限配置是否有效
+	// If the backlog bound is configured, check if the backlog bound configuration is valid
+	if c.backlog != nil {
+		if err := c.backlog.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了漏桶整形模式，检查漏桶整形模式配置是否有效
+	// If the leaky-bucket shaping mode is configured, check if the leaky-bucket shaping mode configuration is valid
+	if c.shaper != nil {
+		if err := c.shaper.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// 如果配置了持久化存储，必须同时配置消息编解码器
+	// If the durable store is configured, the message codec must be configured as well
+	if c.store != nil && c.codec == nil {
+		return ErrCodecIsNil
+	}
+
+	// 配置有效
+	// The configuration is valid
+	return nil
+}
+
 // isConfigValid 是一个函数，它检查配置是否有效，如果无效，它将设置为默认值
 // isConfigValid is a function that checks if the configuration is valid, if not, it sets it to the default values
 func isConfigValid(conf *Config) *Config {
@@ -57,6 +283,76 @@ func isConfigValid(conf *Config) *Config {
 		if conf.callback == nil {
 			conf.callback = NewEmptyCallback()
 		}
+
+		// 如果配置中的暂停容量小于等于0，则设置为默认值
+		// If the pause capacity in the configuration is less than or equal to 0, set it to the default value
+		if conf.pauseCapacity <= 0 {
+			conf.pauseCapacity = DefaultPauseCapacity
+		}
+
+		// 如果配置了重试策略，检查重试策略是否有效
+		// If the retry policy is configured, check if the retry policy is valid
+		if conf.retryPolicy != nil {
+			conf.retryPolicy = isRetryPolicyValid(conf.retryPolicy)
+		}
+
+		// 如果配置中的超时小于0，则不限制
+		// If the timeout in the configuration is less than 0, there is no limit
+		if conf.timeout < 0 {
+			conf.timeout = 0
+		}
+
+		// 如果配置了熔断器，检查熔断器配置是否有效
+		// If the circuit breaker is configured, check if the circuit breaker configuration is valid
+		if conf.breaker != nil {
+			conf.breaker = isCircuitBreakerConfigValid(conf.breaker)
+		}
+
+		// 如果配置了批处理模式，检查批处理模式配置是否有效
+		// If the batching mode is configured, check if the batching mode configuration is valid
+		if conf.batching != nil {
+			conf.batching = isBatchingConfigValid(conf.batching)
+		}
+
+		// 如果配置了防抖模式，检查防抖模式配置是否有效
+		// If the debounce mode is configured, check if the debounce mode configuration is valid
+		if conf.debounce != nil {
+			conf.debounce = isDebounceConfigValid(conf.debounce)
+		}
+
+		// 如果配置了节流模式，检查节流模式配置是否有效，同时配置了防抖模式时忽略节流模式
+		// If the throttle mode is configured, check if the throttle mode configuration is valid, the throttle mode is ignored when the debounce mode is also configured
+		if conf.debounce != nil {
+			conf.throttle = nil
+		} else if conf.throttle != nil {
+			conf.throttle = isThrottleConfigValid(conf.throttle)
+		}
+
+		// 如果配置了去重，检查去重配置是否有效，与批处理、防抖或节流模式同时配置时忽略去重
+		// If deduplication is configured, check if the deduplication configuration is valid, deduplication is ignored when the batching, debounce or throttle mode is also configured
+		if conf.batching != nil || conf.debounce != nil || conf.throttle != nil {
+			conf.dedupe = nil
+		} else if conf.dedupe != nil {
+			conf.dedupe = isDedupeConfigValid(conf.dedupe)
+		}
+
+		// 如果配置了积压上限，检查积压上限配置是否有效
+		// If the backlog bound is configured, check if the backlog bound configuration is valid
+		if conf.backlog != nil {
+			conf.backlog = isBacklogConfigValid(conf.backlog)
+		}
+
+		// 如果配置了漏桶整形模式，检查漏桶整形模式配置是否有效
+		// If the leaky-bucket shaping mode is configured, check if the leaky-bucket shaping mode configuration is valid
+		if conf.shaper != nil {
+			conf.shaper = isShaperConfigValid(conf.shaper)
+		}
+
+		// 如果配置了持久化存储但没有配置消息编解码器，则不持久化
+		// If the durable store is configured but the message codec is not, there is no persistence
+		if conf.store != nil && conf.codec == nil {
+			conf.store = nil
+		}
 	} else {
 		// 如果配置为空，则设置为默认配置
 		// If the configuration is null, set it to the default configuration
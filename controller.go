@@ -1,7 +1,10 @@
 package regula
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	rl "github.com/shengyanli1982/regula/ratelimiter"
 )
@@ -20,8 +23,84 @@ type FlowController struct {
 	// once 是用于确保某个操作只执行一次的同步原语
 	// once is a synchronization primitive used to ensure that an operation is performed only once
 	once sync.Once
+
+	// limiter 是当前生效的速率限制器，可以在运行时被原子地替换
+	// limiter is the rate limiter currently in effect, it can be replaced atomically at runtime
+	limiter atomic.Value
+
+	// lock 保护停止状态和任务登记表
+	// lock protects the stopped state and the task registry
+	lock sync.Mutex
+
+	// stopped 表示流控制器已停止接受新的消息
+	// stopped indicates that the flow controller has stopped accepting new messages
+	stopped bool
+
+	// horizon 是速率限制器已排定的最远时间（UnixNano），用于积压的延迟范围
+	// horizon is the farthest time scheduled by the rate limiter (UnixNano), used for the delay horizon of the backlog
+	horizon atomic.Int64
+
+	// seq 是任务的提交序号
+	// seq is the submission sequence number of the tasks
+	seq uint64
+
+	// tasks 是已被接受但尚未完成的任务
+	// tasks are the tasks that have been accepted but not yet completed
+	tasks map[*task]struct{}
+
+	// inflight 是按合并键登记的正在等待或者执行的领导者任务
+	// inflight are the leader tasks waiting or executing registered by the coalescing key
+	inflight map[any]*task
+
+	// queued 是按去重键登记的正在等待的任务
+	// queued are the waiting tasks registered by the deduplication key
+	queued map[any]*task
+
+	// debounced 是防抖模式下每个键正在等待静默时间结束的任务
+	// debounced are the tasks of each key waiting for the end of the quiet period in the debounce mode
+	debounced map[any]*task
+
+	// windows 是节流模式下每个键正在进行的间隔
+	// windows are the intervals in progress of each key in the throttle mode
+	windows map[any]*throttleWindow
+
+	// drained 在停止后所有任务完成时被关闭
+	// drained is closed when all tasks are completed after stopping
+	drained chan struct{}
+
+	// paused 表示流控制器处于暂停状态
+	// paused indicates that the flow controller is paused
+	paused bool
+
+	// handlers 是按名称注册的消息处理函数
+	// handlers are the message handle functions registered by name
+	handlers map[string]MessageHandleFunc
+
+	// held 是暂停期间保留的任务
+	// held are the tasks kept during the pause
+	held []*task
+
+	// aggregator 是批处理模式的聚合器，未配置批处理模式时为 nil
+	// aggregator is the aggregator of the batching mode, it is nil if the batching mode is not configured
+	aggregator *aggregator
+
+	// breaker 是熔断器，未配置时为 nil
+	// breaker is the circuit breaker, it is nil if not configured
+	breaker *circuitBreaker
+
+	// shaper 是漏桶整形器，未配置漏桶整形模式时为 nil
+	// shaper is the leaky-bucket shaper, it is nil if the leaky-bucket shaping mode is not configured
+	shaper *shaper
+
+	// metrics 是流控制器的计数器
+	// metrics are the counters of the flow controller
+	metrics metrics
 }
 
+// limiterHolder 是一个包装结构体，用于在 atomic.Value 中保存不同具体类型的速率限制器
+// limiterHolder is a wrapper structure used to store rate limiters of different concrete types in atomic.Value
+type limiterHolder struct{ RateLimiter }
+
 // NewFlowController 是创建新的流控制器的函数，它接受一个管道接口和配置
 // NewFlowController is a function to create a new flow controller, it accepts a pipeline interface and configuration
 func NewFlowController(pipline Pipeline, conf *Config) *FlowController {
@@ -35,9 +114,9 @@ func NewFlowController(pipline Pipeline, conf *Config) *FlowController {
 	// Check if the configuration is valid, if not, use the default configuration
 	conf = isConfigValid(conf)
 
-	// 返回一个新的流控制器，包含配置、管道接口和一次性同步
-	// Return a new flow controller, including configuration, pipeline interface and once sync
-	return &FlowController{
+	// 创建一个新的流控制器，包含配置、管道接口和一次性同步
+	// Create a new flow controller, including configuration, pipeline interface and once sync
+	fc := &FlowController{
 		// config 是流控制器的配置
 		// config is the configuration of the flow controller
 		config: conf,
@@ -49,12 +128,94 @@ func NewFlowController(pipline Pipeline, conf *Config) *FlowController {
 		// once 是用于确保某个操作只执行一次的同步原语
 		// once is a synchronization primitive used to ensure that an operation is performed only once
 		once: sync.Once{},
+
+		// tasks 是已被接受但尚未完成的任务
+		// tasks are the tasks that have been accepted but not yet completed
+		tasks: make(map[*task]struct{}),
+
+		// inflight 是按合并键登记的正在等待或者执行的领导者任务
+		// inflight are the leader tasks waiting or executing registered by the coalescing key
+		inflight: make(map[any]*task),
+
+		// queued 是按去重键登记的正在等待的任务
+		// queued are the waiting tasks registered by the deduplication key
+		queued: make(map[any]*task),
+
+		// debounced 是防抖模式下每个键正在等待静默时间结束的任务
+		// debounced are the tasks of each key waiting for the end of the quiet period in the debounce mode
+		debounced: make(map[any]*task),
+
+		// windows 是节流模式下每个键正在进行的间隔
+		// windows are the intervals in progress of each key in the throttle mode
+		windows: make(map[any]*throttleWindow),
+
+		// handlers 是按名称注册的消息处理函数
+		// handlers are the message handle functions registered by name
+		handlers: make(map[string]MessageHandleFunc),
+
+		// drained 在停止后所有任务完成时被关闭
+		// drained is closed when all tasks are completed after stopping
+		drained: make(chan struct{}),
+	}
+
+	// 保存配置中的速率限制器作为当前生效的速率限制器
+	// Store the rate limiter in the configuration as the rate limiter currently in effect
+	fc.limiter.Store(limiterHolder{conf.ratelimiter})
+
+	// 如果配置了熔断器，创建熔断器，回调函数实现了 CircuitCallback 时状态变化通过它通知
+	// If the circuit breaker is configured, create it, and state transitions are notified through the callback function if it implements CircuitCallback
+	if conf.breaker != nil {
+		var onChange func(from, to CircuitState)
+		if cb, ok := conf.callback.(CircuitCallback); ok {
+			onChange = cb.OnCircuitStateChanged
+		}
+		fc.breaker = newCircuitBreaker(conf.breaker, onChange)
+	}
+
+	// 如果配置了批处理模式，创建聚合器
+	// If the batching mode is configured, create the aggregator
+	if conf.batching != nil {
+		fc.aggregator = newAggregator(conf.batching, fc.flush)
 	}
+
+	// 如果配置了漏桶整形模式，创建整形器
+	// If the leaky-bucket shaping mode is configured, create the shaper
+	if conf.shaper != nil {
+		fc.shaper = newShaper(conf.shaper)
+	}
+
+	// 返回流控制器
+	// Return the flow controller
+	return fc
+}
+
+// NewStrictFlowController 是创建新的流控制器的函数，与 NewFlowController 不同，它不会静默地返回 nil 或使用默认配置，而是返回描述性错误
+// NewStrictFlowController is a function to create a new flow controller, unlike NewFlowController, it does not silently return nil or use the default configuration, but returns a descriptive error
+func NewStrictFlowController(pipline Pipeline, conf *Config) (*FlowController, error) {
+	// 如果管道接口为空，返回错误
+	// If the pipeline interface is nil, return an error
+	if pipline == nil {
+		return nil, ErrPipelineIsNil
+	}
+
+	// 严格检查配置是否有效
+	// Strictly check if the configuration is valid
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 配置有效，创建流控制器
+	// The configuration is valid, create the flow controller
+	return NewFlowController(pipline, conf), nil
 }
 
-// Stop 是一个方法，它停止流控制器的管道
-// Stop is a method that stops the pipeline of the flow controller
+// Stop 是一个方法，它停止流控制器的管道，尚未执行的消息会被直接丢弃，如果需要等待它们，请使用 Shutdown
+// Stop is a method that stops the pipeline of the flow controller, messages that have not been executed are discarded directly, use Shutdown if you need to wait for them
 func (fc *FlowController) Stop() {
+	// 停止接受新的消息
+	// Stop accepting new messages
+	fc.markStopped()
+
 	// 使用 sync.Once 确保管道只被停止一次
 	// Use sync.Once to ensure the pipeline is stopped only once
 	fc.once.Do(func() {
@@ -64,26 +225,315 @@ func (fc *FlowController) Stop() {
 	})
 }
 
+// Shutdown 是一个方法，它优雅地停止流控制器：立即拒绝新的消息（返回 ErrStopped），等待已排队和延迟的消息执行完成，直到上下文结束。
+// 如果上下文先结束，尚未开始执行的消息会被放弃，并按提交顺序返回，调用者可以持久化或重试它们，同时返回上下文的错误。
+// 已保存在持久化存储中的消息不会返回，它们保留在存储中，由重启后的 Replay 重放
+// Shutdown is a method that gracefully stops the flow controller: it immediately rejects new messages (returns ErrStopped), and waits for the queued and delayed messages to be executed until the context ends.
+// If the context ends first, the messages that have not started are abandoned and returned in the order of submission, so the caller can persist or retry them, and the error of the context is returned.
+// Messages already saved in the durable store are not returned, they stay in the store and are replayed by Replay after a restart
+func (fc *FlowController) Shutdown(ctx context.Context) ([]any, error) {
+	// 停止接受新的消息
+	// Stop accepting new messages
+	fc.markStopped()
+
+	// 在函数结束时停止管道
+	// Stop the pipeline when the function ends
+	defer fc.Stop()
+
+	// 不再有新的消息加入正在收集的批次，立即提交它，不等待最长等待时间
+	// No new messages join the batch being collected, submit it immediately without waiting for the maximum waiting time
+	if fc.aggregator != nil {
+		fc.aggregator.expire()
+	}
+
+	// 等待所有任务完成或者上下文结束
+	// Wait for all tasks to complete or the context to end
+	select {
+	case <-fc.drained:
+		return nil, nil
+	case <-ctx.Done():
+		return fc.abandon(), ctx.Err()
+	}
+}
+
+// markStopped 是一个方法，它标记流控制器已停止接受新的消息
+// markStopped is a method that marks that the flow controller has stopped accepting new messages
+func (fc *FlowController) markStopped() {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.stopped = true
+	fc.notifyDrained()
+}
+
+// RateLimiter 是一个方法，它返回流控制器当前生效的速率限制器
+// RateLimiter is a method that returns the rate limiter currently in effect of the flow controller
+func (fc *FlowController) RateLimiter() RateLimiter {
+	return fc.limiter.Load().(limiterHolder).RateLimiter
+}
+
+// SetRateLimiter 是一个方法，它在运行时原子地替换流控制器的速率限制器，如果速率限制器为空，则使用无操作限制器
+// SetRateLimiter is a method that atomically replaces the rate limiter of the flow controller at runtime, if the rate limiter is nil, a no-operation limiter is used
+func (fc *FlowController) SetRateLimiter(limiter RateLimiter) {
+	if limiter == nil {
+		limiter = rl.NewNopLimiter()
+	}
+	fc.limiter.Store(limiterHolder{limiter})
+}
+
 // Do 是一个方法，它执行一个消息处理函数，如果有延迟，它会在延迟后提交函数，否则直接提交
 // Do is a method that executes a message handle function, if there is a delay, it submits the function after the delay, otherwise it submits directly
+// 如果配置了批处理模式，消息会被批处理函数处理，fn 必须为 nil，否则返回 ErrBatchingHandler
+// If the batching mode is configured, the message is handled by the batch handle function, fn must be nil, otherwise ErrBatchingHandler is returned
+// 如果流控制器已停止，返回 ErrStopped
+// If the flow controller has been stopped, return ErrStopped
 func (fc *FlowController) Do(fn MessageHandleFunc, msg any) error {
-	// 通过速率限制器获取下一个事件的延迟时间
-	// Get the delay time of the next event through the rate limiter
-	delay := fc.config.ratelimiter.When().Round(rl.DefaultEffectiveTimeSliceInterval)
+	return fc.do(newTask(withoutContext(fn), msg))
+}
+
+// DoWithFuture 是一个方法，它与 Do 相同，但返回一个异步结果，可以在消息处理函数最终完成后获取结果和错误
+// DoWithFuture is a method that is the same as Do, but returns an asynchronous result, from which the result and error can be obtained after the message handle function finally completes
+func (fc *FlowController) DoWithFuture(fn MessageHandleFunc, msg any) (*Future, error) {
+	t := newTask(withoutContext(fn), msg)
+	t.future = newFuture()
+
+	if err := fc.do(t); err != nil {
+		return nil, err
+	}
+	return t.future, nil
+}
+
+// DoWithTimeout 是一个方法，它使用单次提交的超时执行带上下文的消息处理函数，超时为 0 时使用流控制器的超时。
+// 处理函数在带有截止时间的上下文中运行，超时后异步结果和 OnExecFailed 回调得到 ErrHandlerTimeout
+// DoWithTimeout is a method that executes a message handle function with context using the timeout of a single submission, the timeout of the flow controller is used when it is 0.
+// The handle function runs with a deadline context, after the timeout, the asynchronous result and the OnExecFailed callback get ErrHandlerTimeout
+func (fc *FlowController) DoWithTimeout(fn ContextHandleFunc, msg any, timeout time.Duration) (*Future, error) {
+	t := newTask(fn, msg)
+	t.timeout = timeout
+	t.future = newFuture()
 
-	// 如果有延迟
-	// If there is a delay
+	if err := fc.do(t); err != nil {
+		return nil, err
+	}
+	return t.future, nil
+}
+
+// do 是一个方法，它登记、保留或分发一个任务，被拒绝的任务计入计数器
+// do is a method that registers, keeps or dispatches a task, rejected tasks are counted in the counters
+func (fc *FlowController) do(t *task) (err error) {
+	// 统计被接受和被拒绝的任务
+	// Count accepted and rejected tasks
+	defer func() { fc.metrics.count(err) }()
+
+	// 批处理模式下处理函数不会被调用，拒绝带有处理函数的提交，避免它被静默地忽略
+	// The handle function is never called in the batching mode, reject submissions with a handle function so that it is not silently ignored
+	if fc.aggregator != nil && t.fn != nil {
+		return ErrBatchingHandler
+	}
+
+	// 如果相同键的消息正在等待或者执行，共享它的结果
+	// If a message with the same key is waiting or executing, share its result
+	if fc.coalesce(t) {
+		return nil
+	}
+
+	// 如果相同键的消息还在等待，按去重策略与它合并
+	// If a message with the same key is still waiting, combine with it according to the deduplication policy
+	if fc.dedupe(t) {
+		return nil
+	}
+
+	// 如果提交失败，跟随者得到相同的错误
+	// If the submission fails, the followers get the same error
+	defer func() {
+		if err != nil {
+			fc.reject(t, err)
+		}
+	}()
+
+	// 防抖和节流模式下，任务先按键等待，之后才进入速率限制器
+	// In the debounce and throttle modes, the task waits by key first before entering the rate limiter
+	if fc.config.debounce != nil {
+		return fc.debounce(t)
+	}
+	if fc.config.throttle != nil {
+		return fc.throttle(t)
+	}
+
+	// 批处理模式下，任务被加入正在收集的批次
+	// In the batching mode, the task is added to the batch being collected
+	if fc.aggregator != nil {
+		return fc.aggregate(t)
+	}
+
+	// 登记任务并检查暂停状态和熔断器，被保留或者被推迟的任务不需要立即提交
+	// Register the task and check the pause state and the circuit breaker, kept or deferred tasks do not need to be submitted immediately
+	held, deferred, err := fc.prepare(t)
+	if err != nil {
+		return err
+	}
+
+	// 提交任务，如果提交失败，注销任务
+	// Submit the task, if the submission fails, unregister the task
+	if !held && !deferred {
+		if err = fc.submit(t); err != nil {
+			fc.release(t)
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch 是一个方法，它检查熔断器是否允许任务执行，然后提交任务。如果熔断器打开，根据配置拒绝任务或者推迟任务
+// dispatch is a method that checks whether the circuit breaker allows the task to execute, and then submits the task. If the circuit breaker is open, the task is rejected or deferred according to the configuration
+func (fc *FlowController) dispatch(t *task) error {
+	// 检查熔断器，如果任务被推迟或者被拒绝，直接返回
+	// Check the circuit breaker, if the task is deferred or rejected, return directly
+	if deferred, err := fc.guard(t); deferred || err != nil {
+		return err
+	}
+
+	// 提交任务
+	// Submit the task
+	return fc.submit(t)
+}
+
+// guard 是一个方法，它检查熔断器是否允许任务执行。如果熔断器打开，根据配置返回 ErrCircuitOpen，或者推迟任务并返回 true
+// guard is a method that checks whether the circuit breaker allows the task to execute. If the circuit breaker is open, it returns ErrCircuitOpen or defers the task and returns true according to the configuration
+func (fc *FlowController) guard(t *task) (bool, error) {
+	if fc.breaker == nil {
+		return false, nil
+	}
+
+	probe, wait, err := fc.breaker.allow()
+	if err == nil {
+		t.probe.Store(probe)
+		return false, nil
+	}
+
+	// 如果没有配置推迟，拒绝任务
+	// If deferring is not configured, reject the task
+	if !fc.config.breaker.deferWhenOpen {
+		return false, err
+	}
+
+	// 推迟任务，在熔断器打开的持续时间结束后重新分发，推迟期间不消耗令牌
+	// Defer the task, dispatch it again after the open duration of the circuit breaker ends, no token is consumed during the deferral
+	return true, fc.pipline.SubmitAfterWithFunc(t.readmit(fc), t.msg, wait)
+}
+
+// CircuitState 是一个方法，它返回熔断器的当前状态，未配置熔断器时总是返回 CircuitClosed
+// CircuitState is a method that returns the current state of the circuit breaker, it always returns CircuitClosed if the circuit breaker is not configured
+func (fc *FlowController) CircuitState() CircuitState {
+	if fc.breaker == nil {
+		return CircuitClosed
+	}
+	return fc.breaker.State()
+}
+
+// submit 是一个方法，它通过速率限制器计算任务的延迟时间，并把任务提交到管道中
+// submit is a method that calculates the delay time of the task through the rate limiter and submits the task to the pipeline
+func (fc *FlowController) submit(t *task) error {
+	// 通过速率限制器获取下一个事件的延迟时间，无法放行的事件被拒绝
+	// Get the delay time of the next event through the rate limiter, events that cannot be admitted are rejected
+	delay, err := fc.schedule(t, fc.RateLimiter())
+	if err != nil {
+		return err
+	}
+
+	// 如果有延迟，调用回调函数，通知有延迟
+	// If there is a delay, call the callback function to notify that there is a delay
+	if delay > 0 {
+		fc.metrics.limited.Add(1)
+		fc.config.callback.OnExecLimited(t.msg, delay)
+	}
+
+	// 更新任务计划执行的时间，重启后可以按剩余的延迟重放
+	// Update the scheduled time of the task, so it can be replayed with the remaining delay after a restart
 	if delay > 0 {
-		// 调用回调函数，通知有延迟
-		// Call the callback function to notify that there is a delay
-		fc.config.callback.OnExecLimited(msg, delay)
+		if err = fc.persist(t, delay); err != nil {
+			fc.unschedule(t)
+			return err
+		}
+	}
 
-		// 在延迟后提交函数
-		// Submit the function after the delay
-		return fc.pipline.SubmitAfterWithFunc(fn, msg, delay)
+	// 提交任务，失败时归还整形器的时间槽
+	// Submit the task, give back the slot of the shaper when it fails
+	if err = fc.submitAfter(t, delay); err != nil {
+		fc.unschedule(t)
+	}
+	return err
+}
+
+// when 是一个方法，它通过速率限制器预留一个事件，如果速率限制器实现了 AdmissionRateLimiter，使用 Reserve 并返回它的错误
+// when is a method that reserves an event through the rate limiter, if the rate limiter implements AdmissionRateLimiter, Reserve is used and its error is returned
+func (fc *FlowController) when(limiter RateLimiter) (time.Duration, error) {
+	if admission, ok := limiter.(AdmissionRateLimiter); ok {
+		return admission.Reserve()
+	}
+	return limiter.When(), nil
+}
+
+// schedule 是一个方法，它为任务通过速率限制器预留一个事件并返回延迟时间。漏桶整形模式下，任务被排在下一个空闲的时间槽上，延迟是精确的，
+// 否则延迟按有效时间片取整
+// schedule is a method that reserves an event through the rate limiter for the task and returns the delay. In the leaky-bucket shaping mode, the task is placed on the next free slot and the delay is exact,
+// otherwise the delay is rounded to the effective time slice
+func (fc *FlowController) schedule(t *task, limiter RateLimiter) (time.Duration, error) {
+	if fc.shaper != nil {
+		slot, delay, err := fc.shaper.schedule(func() (time.Duration, error) { return fc.when(limiter) })
+		t.slot = slot
+		return delay, err
+	}
+	delay, err := fc.when(limiter)
+	return delay.Round(rl.DefaultEffectiveTimeSliceInterval), err
+}
+
+// unschedule 是一个方法，它在任务提交失败时把它在整形器中的时间槽归还
+// unschedule is a method that gives back the slot of the task in the shaper when the submission of the task fails
+func (fc *FlowController) unschedule(t *task) {
+	if fc.shaper != nil && !t.slot.IsZero() {
+		fc.shaper.cancel(t.slot)
+		t.slot = time.Time{}
+	}
+}
+
+// submitAfter 是一个方法，如果有延迟，它在延迟后把任务提交到管道中，否则直接提交
+// submitAfter is a method that submits the task to the pipeline after the delay if there is a delay, otherwise it submits directly
+func (fc *FlowController) submitAfter(t *task, delay time.Duration) error {
+	// 任务在管道中排定了时间槽，它被丢弃时时间槽可以交给新的任务
+	// The task has a slot in the pipeline, the slot can be handed over to a new task when it is dropped
+	t.slotted.Store(true)
+
+	// 如果有延迟，在延迟后提交函数
+	// If there is a delay, submit the function after the delay
+	if delay > 0 {
+		fc.extendHorizon(delay)
+
+		// 管道的延迟队列可能按固定的心跳检查到期的消息，会让时间槽聚在一起，漏桶整形模式使用自己的定时器，在时间槽到来时直接提交
+		// The delaying queue of the pipeline may check due messages on a fixed heartbeat, which clumps the slots together, so the leaky-bucket shaping mode uses its own timer and submits directly when the slot arrives
+		if fc.shaper != nil {
+			time.AfterFunc(delay, func() { fc.fire(t) })
+			return nil
+		}
+		return fc.pipline.SubmitAfterWithFunc(t.handle(fc), t.msg, delay)
 	}
 
 	// 如果没有延返，直接提交函数
 	// If there is no delay, submit the function directly
-	return fc.pipline.SubmitWithFunc(fn, msg)
+	return fc.pipline.SubmitWithFunc(t.handle(fc), t.msg)
+}
+
+// fire 是一个方法，它在漏桶整形器的时间槽到来时把任务提交到管道中。管道拒绝时，以它的错误结束任务，
+// 任务已被丢弃时结束继承它的时间槽的任务，已被放弃的任务不再处理
+// fire is a method that submits the task to the pipeline when its slot of the leaky-bucket shaper arrives. When the pipeline rejects it, the task is finished with its error,
+// the task that inherits the slot is finished when the task has been dropped, and an abandoned task is not handled any more
+func (fc *FlowController) fire(t *task) {
+	err := fc.pipline.SubmitWithFunc(t.handle(fc), t.msg)
+	for err != nil && t != nil {
+		if atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning) {
+			fc.finish(t, nil, err)
+			return
+		}
+		t = fc.succeed(t)
+	}
 }
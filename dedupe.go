@@ -0,0 +1,215 @@
+package regula
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// DedupePolicy 是去重策略，决定重复的消息如何与正在等待的消息合并
+// DedupePolicy is the deduplication policy, it decides how a duplicate message is combined with the waiting message
+type DedupePolicy int8
+
+const (
+	// DedupeKeepFirst 保留正在等待的消息，丢弃新的重复消息
+	// DedupeKeepFirst keeps the waiting message and discards the new duplicate message
+	DedupeKeepFirst DedupePolicy = iota
+
+	// DedupeReplaceLatest 用新的重复消息和它的处理函数替换正在等待的消息
+	// DedupeReplaceLatest replaces the waiting message with the new duplicate message and its handle function
+	DedupeReplaceLatest
+
+	// DedupeMerge 使用合并函数把新的重复消息合并到正在等待的消息中
+	// DedupeMerge merges the new duplicate message into the waiting message with the merge function
+	DedupeMerge
+)
+
+// String 是一个方法，它返回去重策略的名称
+// String is a method that returns the name of the deduplication policy
+func (p DedupePolicy) String() string {
+	switch p {
+	case DedupeKeepFirst:
+		return "keep-first"
+	case DedupeReplaceLatest:
+		return "replace-latest"
+	case DedupeMerge:
+		return "merge"
+	default:
+		return "unknown"
+	}
+}
+
+// MergeFunc 是一个函数类型，它把新的重复消息合并到正在等待的消息中，返回合并后的消息
+// MergeFunc is a function type that merges the new duplicate message into the waiting message and returns the merged message
+type MergeFunc = func(waiting, incoming any) any
+
+// DedupeConfig 是去重的配置，在速率限制器延迟中等待的消息按键去重
+// DedupeConfig is the configuration of deduplication, messages waiting in the delay of the rate limiter are deduplicated by key
+type DedupeConfig struct {
+	// key 是消息的键函数
+	// key is the key function of the message
+	key KeyFunc
+
+	// policy 是去重策略
+	// policy is the deduplication policy
+	policy DedupePolicy
+
+	// merge 是 DedupeMerge 策略的合并函数
+	// merge is the merge function of the DedupeMerge policy
+	merge MergeFunc
+}
+
+// NewDedupeConfig 是创建新的去重配置的函数，它接受键函数，默认策略为 DedupeKeepFirst
+// NewDedupeConfig is a function to create a new deduplication configuration, it accepts the key function, the default policy is DedupeKeepFirst
+func NewDedupeConfig(fn KeyFunc) *DedupeConfig {
+	return &DedupeConfig{key: fn, policy: DedupeKeepFirst}
+}
+
+// WithPolicy 它设置去重策略
+// WithPolicy is a method that sets the deduplication policy
+func (c *DedupeConfig) WithPolicy(policy DedupePolicy) *DedupeConfig {
+	c.policy = policy
+	return c
+}
+
+// WithMerge 它设置合并函数，并把去重策略设置为 DedupeMerge
+// WithMerge is a method that sets the merge function and sets the deduplication policy to DedupeMerge
+func (c *DedupeConfig) WithMerge(fn MergeFunc) *DedupeConfig {
+	c.merge = fn
+	c.policy = DedupeMerge
+	return c
+}
+
+// Validate 是一个方法，它严格检查去重配置是否有效
+// Validate is a method that strictly checks if the deduplication configuration is valid
+func (c *DedupeConfig) Validate() error {
+	if c.key == nil {
+		return fmt.Errorf("%w: key function is nil", ErrInvalidDedupe)
+	}
+	if c.policy < DedupeKeepFirst || c.policy > DedupeMerge {
+		return fmt.Errorf("%w: unknown policy %d", ErrInvalidDedupe, c.policy)
+	}
+	if c.policy == DedupeMerge && c.merge == nil {
+		return fmt.Errorf("%w: merge function is nil", ErrInvalidDedupe)
+	}
+	return nil
+}
+
+// isDedupeConfigValid 是一个函数，它检查去重配置是否有效，如果无效，它将设置为默认值，键函数为空时关闭去重
+// isDedupeConfigValid is a function that checks if the deduplication configuration is valid, if not, it sets it to the default values, deduplication is disabled if the key function is nil
+func isDedupeConfigValid(c *DedupeConfig) *DedupeConfig {
+	if c.key == nil {
+		return nil
+	}
+	if c.policy < DedupeKeepFirst || c.policy > DedupeMerge || (c.policy == DedupeMerge && c.merge == nil) {
+		c.policy = DedupeKeepFirst
+	}
+	return c
+}
+
+// dedupe 是一个方法，如果相同键的消息还在等待，它按去重策略把新的任务合并到正在等待的任务中并返回 true。
+// 新的任务作为跟随者得到正在等待的任务最终执行的结果
+// dedupe is a method that combines the new task into the waiting task according to the deduplication policy and returns true if a message with the same key is still waiting.
+// The new task gets the result of the final execution of the waiting task as a follower
+func (fc *FlowController) dedupe(t *task) bool {
+	conf := fc.config.dedupe
+	if conf == nil {
+		return false
+	}
+	key := conf.key(t.msg)
+
+	fc.lock.Lock()
+
+	// 只有仍在等待的任务可以被合并，已经开始执行的任务不受影响
+	// Only tasks that are still waiting can be combined, tasks that have started are not affected
+	waiting, ok := fc.queued[key]
+	if fc.stopped || !ok || atomic.LoadInt32(&waiting.state) != taskPending {
+		fc.lock.Unlock()
+		return false
+	}
+
+	// 按去重策略计算将要执行的消息，替换在任务开始执行时生效
+	// Calculate the message that will be executed according to the deduplication policy, the replacement takes effect when the task starts
+	current := waiting.latest()
+	removed, kept := t.msg, current.msg
+	switch conf.policy {
+	case DedupeReplaceLatest:
+		waiting.replacement = &task{fn: t.fn, msg: t.msg}
+		removed, kept = current.msg, t.msg
+	case DedupeMerge:
+		kept = conf.merge(current.msg, t.msg)
+		waiting.replacement = &task{fn: current.fn, msg: kept}
+	}
+
+	fc.seq++
+	t.id = fc.seq
+	waiting.followers = append(waiting.followers, t)
+	fc.lock.Unlock()
+
+	fc.metrics.deduplicated.Add(1)
+	fc.onExecDeduplicated(removed, kept)
+	return true
+}
+
+// enqueue 是一个方法，它按去重键登记正在等待的任务，之后相同键的消息会与它合并
+// enqueue is a method that registers the waiting task by the deduplication key, messages with the same key are combined with it afterwards
+func (fc *FlowController) enqueue(t *task) {
+	conf := fc.config.dedupe
+	if conf == nil {
+		return
+	}
+	key := conf.key(t.msg)
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	// 任务可能已经开始执行
+	// The task may have started already
+	if atomic.LoadInt32(&t.state) != taskPending {
+		return
+	}
+
+	// 保留仍在等待的任务，它先于当前任务提交
+	// Keep the task that is still waiting, it was submitted before the current task
+	if waiting, ok := fc.queued[key]; ok && atomic.LoadInt32(&waiting.state) == taskPending {
+		return
+	}
+
+	t.dedupeKey = key
+	t.queued = true
+	fc.queued[key] = t
+}
+
+// dequeue 是一个方法，它在任务开始执行时注销它的去重键，并应用合并后的消息
+// dequeue is a method that unregisters the deduplication key of the task when it starts, and applies the combined message
+func (fc *FlowController) dequeue(t *task) {
+	if fc.config.dedupe == nil {
+		return
+	}
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.dequeueLocked(t)
+	if t.replacement != nil {
+		t.fn, t.msg = t.replacement.fn, t.replacement.msg
+		t.replacement = nil
+	}
+}
+
+// dequeueLocked 是一个方法，它注销任务的去重键，调用者必须持有锁
+// dequeueLocked is a method that unregisters the deduplication key of the task, the caller must hold the lock
+func (fc *FlowController) dequeueLocked(t *task) {
+	if t.queued && fc.queued[t.dedupeKey] == t {
+		delete(fc.queued, t.dedupeKey)
+	}
+	t.queued = false
+}
+
+// latest 是一个方法，它返回合并后将要执行的处理函数和消息，调用者必须持有锁
+// latest is a method that returns the handle function and message that will be executed after combining, the caller must hold the lock
+func (t *task) latest() *task {
+	if t.replacement != nil {
+		return t.replacement
+	}
+	return t
+}
@@ -0,0 +1,133 @@
+package regula
+
+import (
+	"errors"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+var (
+	// ErrStopped 表示流控制器已停止，不再接受新的消息
+	// ErrStopped indicates that the flow controller has been stopped and no longer accepts new messages
+	ErrStopped = errors.New("flow controller is stopped")
+
+	// ErrPipelineIsNil 表示管道接口为空
+	// ErrPipelineIsNil indicates that the pipeline interface is nil
+	ErrPipelineIsNil = errors.New("pipeline is nil")
+
+	// ErrConfigIsNil 表示配置为空
+	// ErrConfigIsNil indicates that the configuration is nil
+	ErrConfigIsNil = errors.New("config is nil")
+
+	// ErrRateLimiterIsNil 表示配置中的速率限制器为空
+	// ErrRateLimiterIsNil indicates that the rate limiter in the configuration is nil
+	ErrRateLimiterIsNil = errors.New("rate limiter is nil")
+
+	// ErrCallbackIsNil 表示配置中的回调函数为空
+	// ErrCallbackIsNil indicates that the callback in the configuration is nil
+	ErrCallbackIsNil = errors.New("callback is nil")
+
+	// ErrUnknownLimiterType 表示速率限制器的类型未知
+	// ErrUnknownLimiterType indicates that the type of the rate limiter is unknown
+	ErrUnknownLimiterType = errors.New("unknown limiter type")
+
+	// ErrLimiterTypeMismatch 表示速率限制器的类型不能被修改
+	// ErrLimiterTypeMismatch indicates that the type of the rate limiter cannot be changed
+	ErrLimiterTypeMismatch = errors.New("limiter type mismatch")
+
+	// ErrInvalidPauseCapacity 表示暂停容量无效，暂停容量必须大于 0
+	// ErrInvalidPauseCapacity indicates that the pause capacity is invalid, the pause capacity must be greater than 0
+	ErrInvalidPauseCapacity = errors.New("pause capacity must be greater than 0")
+
+	// ErrPauseCapacityExceeded 表示流控制器已暂停，并且保留的消息数量已达到上限
+	// ErrPauseCapacityExceeded indicates that the flow controller is paused and the number of kept messages has reached the limit
+	ErrPauseCapacityExceeded = errors.New("flow controller is paused and the pause capacity is exceeded")
+
+	// ErrInvalidRetryPolicy 表示重试策略无效
+	// ErrInvalidRetryPolicy indicates that the retry policy is invalid
+	ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+	// ErrInvalidCircuitBreaker 表示熔断器配置无效
+	// ErrInvalidCircuitBreaker indicates that the circuit breaker configuration is invalid
+	ErrInvalidCircuitBreaker = errors.New("invalid circuit breaker config")
+
+	// ErrCircuitOpen 表示熔断器打开，消息被拒绝
+	// ErrCircuitOpen indicates that the circuit breaker is open and the message is rejected
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrInvalidHandlerTimeout 表示处理函数的超时无效，超时不能小于 0
+	// ErrInvalidHandlerTimeout indicates that the timeout of the handle function is invalid, the timeout must not be less than 0
+	ErrInvalidHandlerTimeout = errors.New("handler timeout must not be negative")
+
+	// ErrHandlerTimeout 表示消息处理函数执行超时
+	// ErrHandlerTimeout indicates that the execution of the message handle function timed out
+	ErrHandlerTimeout = errors.New("message handler timed out")
+
+	// ErrInvalidBatching 表示批处理模式配置无效
+	// ErrInvalidBatching indicates that the batching mode configuration is invalid
+	ErrInvalidBatching = errors.New("invalid batching config")
+
+	// ErrBatchResultMismatch 表示批处理函数返回的结果数量与消息数量不一致
+	// ErrBatchResultMismatch indicates that the number of results returned by the batch handle function does not match the number of messages
+	ErrBatchResultMismatch = errors.New("batch result count mismatch")
+
+	// ErrInvalidDebounce 表示防抖模式配置无效
+	// ErrInvalidDebounce indicates that the debounce mode configuration is invalid
+	ErrInvalidDebounce = errors.New("invalid debounce config")
+
+	// ErrInvalidThrottle 表示节流模式配置无效
+	// ErrInvalidThrottle indicates that the throttle mode configuration is invalid
+	ErrInvalidThrottle = errors.New("invalid throttle config")
+
+	// ErrMessageSuperseded 表示消息在执行前被相同键的更新的消息取代
+	// ErrMessageSuperseded indicates that the message was superseded by a newer message with the same key before execution
+	ErrMessageSuperseded = errors.New("message superseded by a newer one")
+
+	// ErrMessageThrottled 表示消息在节流间隔内到达并被丢弃
+	// ErrMessageThrottled indicates that the message arrived within the throttle interval and was dropped
+	ErrMessageThrottled = errors.New("message dropped by throttle")
+
+	// ErrInvalidDedupe 表示去重配置无效
+	// ErrInvalidDedupe indicates that the deduplication configuration is invalid
+	ErrInvalidDedupe = errors.New("invalid dedupe config")
+
+	// ErrInvalidBacklog 表示积压上限配置无效
+	// ErrInvalidBacklog indicates that the backlog bound configuration is invalid
+	ErrInvalidBacklog = errors.New("invalid backlog config")
+
+	// ErrBacklogFull 表示积压已满，消息被减载
+	// ErrBacklogFull indicates that the backlog is full and the message is shed
+	ErrBacklogFull = errors.New("backlog is full")
+
+	// ErrHandlerNotRegistered 表示没有按该名称注册的消息处理函数
+	// ErrHandlerNotRegistered indicates that no message handle function is registered by the name
+	ErrHandlerNotRegistered = errors.New("handler not registered")
+
+	// ErrStoreIsNil 表示没有配置持久化存储
+	// ErrStoreIsNil indicates that the durable store is not configured
+	ErrStoreIsNil = errors.New("store is nil")
+
+	// ErrCodecIsNil 表示配置了持久化存储但没有配置消息编解码器
+	// ErrCodecIsNil indicates that the durable store is configured but the message codec is not
+	ErrCodecIsNil = errors.New("codec is nil")
+
+	// ErrStoreClosed 表示持久化存储已关闭
+	// ErrStoreClosed indicates that the durable store has been closed
+	ErrStoreClosed = errors.New("store is closed")
+
+	// ErrQuotaExhausted 表示速率限制器的配额已用尽，消息被拒绝，具体的错误是包含重置时间的 ratelimiter.QuotaError
+	// ErrQuotaExhausted indicates that the quota of the rate limiter is exhausted and the message is rejected, the concrete error is ratelimiter.QuotaError which contains the reset time
+	ErrQuotaExhausted = rl.ErrQuotaExhausted
+
+	// ErrInvalidShaper 表示漏桶整形模式配置无效
+	// ErrInvalidShaper indicates that the leaky-bucket shaping mode configuration is invalid
+	ErrInvalidShaper = errors.New("invalid shaper config")
+
+	// ErrShaperFull 表示等待时间槽的消息已达到整形器的容量，消息被拒绝
+	// ErrShaperFull indicates that the messages waiting for their slots have reached the capacity of the shaper and the message is rejected
+	ErrShaperFull = errors.New("shaper is full")
+
+	// ErrBatchingHandler 表示批处理模式下提交了消息处理函数，批处理模式只使用批处理函数，处理函数必须为 nil
+	// ErrBatchingHandler indicates that a message handle function was submitted in the batching mode, the batching mode only uses the batch handle function, the handle function must be nil
+	ErrBatchingHandler = errors.New("message handler is not used in batching mode")
+)
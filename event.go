@@ -0,0 +1,326 @@
+package regula
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDebounceQuietPeriod 是默认的防抖静默时间
+	// DefaultDebounceQuietPeriod is the default quiet period of debounce
+	DefaultDebounceQuietPeriod = time.Millisecond * 100
+
+	// DefaultThrottleInterval 是默认的节流间隔
+	// DefaultThrottleInterval is the default interval of throttle
+	DefaultThrottleInterval = time.Millisecond * 100
+)
+
+// DebounceConfig 是防抖模式的配置，同一个键在静默时间内只有最后一条消息会执行，之前的消息被取代
+// DebounceConfig is the configuration of the debounce mode, only the last message of a key in the quiet period is executed, the previous messages are superseded
+type DebounceConfig struct {
+	// key 是消息的键函数，为 nil 时所有消息使用同一个键
+	// key is the key function of the message, all messages use the same key if it is nil
+	key KeyFunc
+
+	// quiet 是静默时间，最后一条消息在静默时间内没有新的消息时才会执行
+	// quiet is the quiet period, the last message is executed only when there is no new message during the quiet period
+	quiet time.Duration
+}
+
+// NewDebounceConfig 是创建新的防抖模式配置的函数，它接受键函数和静默时间
+// NewDebounceConfig is a function to create a new debounce mode configuration, it accepts the key function and the quiet period
+func NewDebounceConfig(fn KeyFunc, quiet time.Duration) *DebounceConfig {
+	return &DebounceConfig{key: fn, quiet: quiet}
+}
+
+// Validate 是一个方法，它严格检查防抖模式配置是否有效
+// Validate is a method that strictly checks if the debounce mode configuration is valid
+func (c *DebounceConfig) Validate() error {
+	if c.quiet <= 0 {
+		return fmt.Errorf("%w: quiet period must be greater than 0, got %v", ErrInvalidDebounce, c.quiet)
+	}
+	return nil
+}
+
+// isDebounceConfigValid 是一个函数，它检查防抖模式配置是否有效，如果无效，它将设置为默认值
+// isDebounceConfigValid is a function that checks if the debounce mode configuration is valid, if not, it sets it to the default values
+func isDebounceConfigValid(c *DebounceConfig) *DebounceConfig {
+	if c.key == nil {
+		c.key = sameKey
+	}
+	if c.quiet <= 0 {
+		c.quiet = DefaultDebounceQuietPeriod
+	}
+	return c
+}
+
+// ThrottleConfig 是节流模式的配置，同一个键每个间隔内最多执行一次，可以在间隔的开始（前沿）和结束（后沿）执行
+// ThrottleConfig is the configuration of the throttle mode, a key is executed at most once per interval, on the leading edge and the trailing edge of the interval
+type ThrottleConfig struct {
+	// key 是消息的键函数，为 nil 时所有消息使用同一个键
+	// key is the key function of the message, all messages use the same key if it is nil
+	key KeyFunc
+
+	// interval 是节流间隔
+	// interval is the interval of throttle
+	interval time.Duration
+
+	// leading 表示间隔开始时的第一条消息立即执行
+	// leading indicates that the first message at the beginning of the interval is executed immediately
+	leading bool
+
+	// trailing 表示间隔内的最后一条消息在间隔结束时执行
+	// trailing indicates that the last message in the interval is executed at the end of the interval
+	trailing bool
+}
+
+// NewThrottleConfig 是创建新的节流模式配置的函数，它接受键函数和节流间隔，默认前沿和后沿都执行
+// NewThrottleConfig is a function to create a new throttle mode configuration, it accepts the key function and the interval, both the leading edge and the trailing edge are executed by default
+func NewThrottleConfig(fn KeyFunc, interval time.Duration) *ThrottleConfig {
+	return &ThrottleConfig{key: fn, interval: interval, leading: true, trailing: true}
+}
+
+// WithLeading 它设置是否在间隔开始时立即执行第一条消息
+// WithLeading is a method that sets whether the first message is executed immediately at the beginning of the interval
+func (c *ThrottleConfig) WithLeading(leading bool) *ThrottleConfig {
+	c.leading = leading
+	return c
+}
+
+// WithTrailing 它设置是否在间隔结束时执行间隔内的最后一条消息
+// WithTrailing is a method that sets whether the last message in the interval is executed at the end of the interval
+func (c *ThrottleConfig) WithTrailing(trailing bool) *ThrottleConfig {
+	c.trailing = trailing
+	return c
+}
+
+// Validate 是一个方法，它严格检查节流模式配置是否有效
+// Validate is a method that strictly checks if the throttle mode configuration is valid
+func (c *ThrottleConfig) Validate() error {
+	if c.interval <= 0 {
+		return fmt.Errorf("%w: interval must be greater than 0, got %v", ErrInvalidThrottle, c.interval)
+	}
+	if !c.leading && !c.trailing {
+		return fmt.Errorf("%w: at least one of leading and trailing must be enabled", ErrInvalidThrottle)
+	}
+	return nil
+}
+
+// isThrottleConfigValid 是一个函数，它检查节流模式配置是否有效，如果无效，它将设置为默认值
+// isThrottleConfigValid is a function that checks if the throttle mode configuration is valid, if not, it sets it to the default values
+func isThrottleConfigValid(c *ThrottleConfig) *ThrottleConfig {
+	if c.key == nil {
+		c.key = sameKey
+	}
+	if c.interval <= 0 {
+		c.interval = DefaultThrottleInterval
+	}
+	if !c.leading && !c.trailing {
+		c.leading, c.trailing = true, true
+	}
+	return c
+}
+
+// sameKey 是默认的键函数，所有消息使用同一个键
+// sameKey is the default key function, all messages use the same key
+func sameKey(any) any { return struct{}{} }
+
+// throttleWindow 是一个键的节流间隔
+// throttleWindow is the throttle interval of a key
+type throttleWindow struct {
+	// trailing 是等待在间隔结束时执行的消息
+	// trailing is the message waiting to be executed at the end of the interval
+	trailing *task
+}
+
+// debounce 是一个方法，它登记任务并在静默时间后执行，如果同一个键在此期间有新的消息，之前的任务被取代
+// debounce is a method that registers the task and executes it after the quiet period, if there is a new message of the same key during this period, the previous task is superseded
+func (fc *FlowController) debounce(t *task) error {
+	if _, err := fc.admit(t); err != nil {
+		return err
+	}
+	key := fc.config.debounce.key(t.msg)
+
+	// 用新的任务替换同一个键正在等待的任务
+	// Replace the waiting task of the same key with the new task
+	fc.lock.Lock()
+	prev := fc.debounced[key]
+	fc.debounced[key] = t
+	fc.lock.Unlock()
+
+	if prev != nil {
+		fc.drop(prev, ErrMessageSuperseded)
+	}
+
+	// 通过管道延迟提交，静默时间结束后任务才进入速率限制器
+	// Submit with the delay of the pipeline, the task enters the rate limiter only after the quiet period
+	err := fc.pipline.SubmitAfterWithFunc(fc.settle(key, t), t.msg, fc.config.debounce.quiet)
+	if err != nil {
+		fc.lock.Lock()
+		if fc.debounced[key] == t {
+			delete(fc.debounced, key)
+		}
+		fc.lock.Unlock()
+		fc.release(t)
+	}
+	return err
+}
+
+// settle 是一个方法，它返回静默时间结束后继续处理任务的函数，被取代的任务不会执行
+// settle is a method that returns the function that continues to process the task after the quiet period, superseded tasks are not executed
+func (fc *FlowController) settle(key any, t *task) MessageHandleFunc {
+	return func(_ any) (any, error) {
+		fc.lock.Lock()
+		if fc.debounced[key] == t {
+			delete(fc.debounced, key)
+		}
+		fc.lock.Unlock()
+
+		fc.advance(t)
+		return nil, nil
+	}
+}
+
+// throttle 是一个方法，它登记任务并按节流间隔执行。间隔开始时的消息在前沿执行，间隔内的最后一条消息在后沿执行，其他消息被丢弃或者被取代
+// throttle is a method that registers the task and executes it by the throttle interval. The message at the beginning of the interval is executed on the leading edge, the last message in the interval is executed on the trailing edge, and other messages are dropped or superseded
+func (fc *FlowController) throttle(t *task) error {
+	if _, err := fc.admit(t); err != nil {
+		return err
+	}
+	conf := fc.config.throttle
+	key := conf.key(t.msg)
+
+	fc.lock.Lock()
+	w, ok := fc.windows[key]
+
+	// 没有正在进行的间隔，开始新的间隔
+	// There is no interval in progress, start a new interval
+	if !ok {
+		w = &throttleWindow{}
+		if !conf.leading {
+			w.trailing = t
+		}
+		fc.windows[key] = w
+		fc.lock.Unlock()
+
+		// 通过管道延迟提交间隔的结束
+		// Submit the end of the interval with the delay of the pipeline
+		if err := fc.pipline.SubmitAfterWithFunc(fc.closeWindow(key, w), t.msg, conf.interval); err != nil {
+			fc.lock.Lock()
+			delete(fc.windows, key)
+			fc.lock.Unlock()
+			fc.release(t)
+			return err
+		}
+
+		if conf.leading {
+			fc.advance(t)
+		}
+		return nil
+	}
+
+	// 间隔正在进行，新的消息取代等待后沿执行的消息，或者在没有后沿时被丢弃
+	// The interval is in progress, the new message supersedes the message waiting for the trailing edge, or it is dropped if there is no trailing edge
+	var dropped *task
+	reason := ErrMessageSuperseded
+	if conf.trailing {
+		dropped, w.trailing = w.trailing, t
+	} else {
+		dropped, reason = t, ErrMessageThrottled
+	}
+	fc.lock.Unlock()
+
+	if dropped != nil {
+		fc.drop(dropped, reason)
+	}
+	return nil
+}
+
+// closeWindow 是一个方法，它返回在间隔结束时执行后沿消息的函数。执行后沿消息会开始新的间隔，没有后沿消息时间隔结束
+// closeWindow is a method that returns the function that executes the trailing message at the end of the interval. Executing the trailing message starts a new interval, the interval ends if there is no trailing message
+func (fc *FlowController) closeWindow(key any, w *throttleWindow) MessageHandleFunc {
+	return func(_ any) (any, error) {
+		fc.lock.Lock()
+		t := w.trailing
+		w.trailing = nil
+		if t == nil {
+			delete(fc.windows, key)
+			fc.lock.Unlock()
+			return nil, nil
+		}
+		fc.lock.Unlock()
+
+		// 后沿消息的执行开始新的间隔
+		// The execution of the trailing message starts a new interval
+		if err := fc.pipline.SubmitAfterWithFunc(fc.closeWindow(key, w), t.msg, fc.config.throttle.interval); err != nil {
+			fc.lock.Lock()
+			delete(fc.windows, key)
+			fc.lock.Unlock()
+		}
+
+		fc.advance(t)
+		return nil, nil
+	}
+}
+
+// advance 是一个方法，它让通过防抖或者节流的任务继续处理，批处理模式下加入正在收集的批次
+// advance is a method that lets the task passing debounce or throttle continue to be processed, it is added to the batch being collected in the batching mode
+func (fc *FlowController) advance(t *task) {
+	if fc.aggregator != nil {
+		fc.aggregator.add(t)
+		return
+	}
+	fc.proceed(t)
+}
+
+// proceed 是一个方法，它让已登记的任务经过暂停、熔断器和速率限制器，如果失败并且任务没有被放弃，以失败结束任务
+// proceed is a method that lets the registered task go through the pause, the circuit breaker and the rate limiter, if it fails and the task has not been abandoned, finish the task with failure
+func (fc *FlowController) proceed(t *task) {
+	// 跳过已被放弃或者被取代的任务
+	// Skip the tasks that have been abandoned or superseded
+	if atomic.LoadInt32(&t.state) != taskPending {
+		return
+	}
+
+	// 如果流控制器处于暂停状态，保留任务
+	// If the flow controller is paused, keep the task
+	held, err := fc.hold(t)
+	if held {
+		return
+	}
+
+	if err == nil {
+		err = fc.dispatch(t)
+	}
+	if err != nil && atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning) {
+		fc.finish(t, nil, err)
+	}
+}
+
+// drop 是一个方法，它在执行前丢弃一个任务，任务和它的跟随者通过回调函数报告，异步结果得到丢弃的原因
+// drop is a method that drops a task before execution, the task and its followers are reported through the callback function, and the asynchronous result gets the reason for dropping
+func (fc *FlowController) drop(t *task, reason error) {
+	// 只有仍在等待的任务可以被丢弃
+	// Only tasks that are still waiting can be dropped
+	if !atomic.CompareAndSwapInt32(&t.state, taskPending, taskAbandoned) {
+		return
+	}
+	fc.release(t)
+
+	// 批次任务按成员报告，跟随者也一起报告
+	// The batch task is reported by member, and the followers are reported as well
+	members := []*task{t}
+	if t.members != nil {
+		members = t.members
+	}
+	for _, m := range members {
+		for _, d := range append([]*task{m}, fc.detach(m)...) {
+			fc.metrics.dropped.Add(1)
+			fc.onExecDropped(d.msg, reason)
+			if d.future != nil {
+				d.future.complete(nil, reason)
+			}
+		}
+	}
+}
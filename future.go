@@ -0,0 +1,43 @@
+package regula
+
+// Future 是一个异步结果，它在消息处理函数最终完成（包括重试）后保存结果和错误
+// Future is an asynchronous result, it holds the result and error after the message handle function finally completes (including retries)
+type Future struct {
+	// done 在结果可用时被关闭
+	// done is closed when the result is available
+	done chan struct{}
+
+	// result 是消息处理函数的结果
+	// result is the result of the message handle function
+	result any
+
+	// err 是消息处理函数的错误
+	// err is the error of the message handle function
+	err error
+}
+
+// newFuture 是创建新的异步结果的函数
+// newFuture is a function to create a new asynchronous result
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// complete 是一个方法，它设置结果并通知等待者，只能被调用一次
+// complete is a method that sets the result and notifies the waiters, it can only be called once
+func (f *Future) complete(result any, err error) {
+	f.result, f.err = result, err
+	close(f.done)
+}
+
+// Done 是一个方法，它返回一个在结果可用时被关闭的通道
+// Done is a method that returns a channel that is closed when the result is available
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result 是一个方法，它阻塞直到结果可用，然后返回结果和错误
+// Result is a method that blocks until the result is available, and then returns the result and error
+func (f *Future) Result() (any, error) {
+	<-f.done
+	return f.result, f.err
+}
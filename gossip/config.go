@@ -0,0 +1,178 @@
+package gossip
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultBindAddr 是默认的本地监听地址，端口由系统分配
+	// DefaultBindAddr is the default local listen address, the port is assigned by the system
+	DefaultBindAddr = "127.0.0.1:0"
+
+	// DefaultMulticastBindAddr 是使用组播时默认的本地监听地址，组播消息不能从回环地址发出
+	// DefaultMulticastBindAddr is the default local listen address when multicast is used, multicast messages cannot be sent from the loopback address
+	DefaultMulticastBindAddr = ":0"
+
+	// DefaultInterval 是默认的广播间隔
+	// DefaultInterval is the default broadcast interval
+	DefaultInterval = time.Millisecond * 200
+
+	// DefaultPeerTimeout 是默认的节点超时时间，超过它没有收到消息的节点被视为离开
+	// DefaultPeerTimeout is the default peer timeout, a peer without messages for longer than it is considered to have left
+	DefaultPeerTimeout = time.Second
+
+	// DefaultMinShare 是默认的最低份额，每个节点至少得到平均份额的这个比例，使需求突然增加的节点不必等待下一次调整
+	// DefaultMinShare is the default minimum share, every node gets at least this ratio of the equal share, so a node whose demand suddenly grows does not wait for the next adjustment
+	DefaultMinShare = 0.2
+)
+
+// Config 是节点的配置
+// Config is the configuration of the node
+type Config struct {
+	// id 是节点的唯一标识，为空时随机生成
+	// id is the unique identifier of the node, randomly generated if empty
+	id string
+
+	// bind 是本地监听地址
+	// bind is the local listen address
+	bind string
+
+	// peers 是静态的节点地址
+	// peers are the static peer addresses
+	peers []string
+
+	// multicast 是组播地址，为空时不使用组播
+	// multicast is the multicast address, multicast is not used if it is empty
+	multicast string
+
+	// rate 和 burst 是所有节点共享的全局速率和突发值
+	// rate and burst are the global rate and burst shared by all nodes
+	rate  float64
+	burst int64
+
+	// interval 是广播间隔
+	// interval is the broadcast interval
+	interval time.Duration
+
+	// peerTimeout 是节点超时时间
+	// peerTimeout is the peer timeout
+	peerTimeout time.Duration
+
+	// minShare 是最低份额
+	// minShare is the minimum share
+	minShare float64
+
+	// onError 是收发消息失败时调用的函数
+	// onError is the function called when sending or receiving a message fails
+	onError func(err error)
+}
+
+// NewConfig 是创建新的节点配置的函数，它接受全局速率和突发值
+// NewConfig is a function to create a new node configuration, it accepts the global rate and burst
+func NewConfig(rate float64, burst int64) *Config {
+	return &Config{
+		rate:        rate,
+		burst:       burst,
+		interval:    DefaultInterval,
+		peerTimeout: DefaultPeerTimeout,
+		minShare:    DefaultMinShare,
+		onError:     func(error) {},
+	}
+}
+
+// WithID 它设置节点的唯一标识
+// WithID is a method that sets the unique identifier of the node
+func (c *Config) WithID(id string) *Config {
+	c.id = id
+	return c
+}
+
+// WithBind 它设置本地监听地址，默认为 DefaultBindAddr，使用组播时默认为 DefaultMulticastBindAddr
+// WithBind is a method that sets the local listen address, the default is DefaultBindAddr, or DefaultMulticastBindAddr when multicast is used
+func (c *Config) WithBind(addr string) *Config {
+	c.bind = addr
+	return c
+}
+
+// WithPeers 它设置静态的节点地址
+// WithPeers is a method that sets the static peer addresses
+func (c *Config) WithPeers(addrs ...string) *Config {
+	c.peers = append(c.peers, addrs...)
+	return c
+}
+
+// WithMulticast 它设置组播地址，节点通过组播发现彼此
+// WithMulticast is a method that sets the multicast address, nodes discover each other through multicast
+func (c *Config) WithMulticast(group string) *Config {
+	c.multicast = group
+	return c
+}
+
+// WithInterval 它设置广播间隔
+// WithInterval is a method that sets the broadcast interval
+func (c *Config) WithInterval(interval time.Duration) *Config {
+	c.interval = interval
+	return c
+}
+
+// WithPeerTimeout 它设置节点超时时间
+// WithPeerTimeout is a method that sets the peer timeout
+func (c *Config) WithPeerTimeout(timeout time.Duration) *Config {
+	c.peerTimeout = timeout
+	return c
+}
+
+// WithMinShare 它设置最低份额，必须大于 0，使没有需求的节点仍然可以立即执行新的事件，1 表示平均分配
+// WithMinShare is a method that sets the minimum share, it must be greater than 0 so that a node without demand can still execute new events immediately, 1 means allocating equally
+func (c *Config) WithMinShare(share float64) *Config {
+	c.minShare = share
+	return c
+}
+
+// WithErrorHandler 它设置收发消息失败时调用的函数
+// WithErrorHandler is a method that sets the function called when sending or receiving a message fails
+func (c *Config) WithErrorHandler(fn func(err error)) *Config {
+	c.onError = fn
+	return c
+}
+
+// Validate 是一个方法，它严格检查节点配置是否有效
+// Validate is a method that strictly checks if the node configuration is valid
+func (c *Config) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if c.rate <= 0 || math.IsNaN(c.rate) || math.IsInf(c.rate, 0) || c.burst <= 0 {
+		return fmt.Errorf("%w, got %v and %d", ErrInvalidRate, c.rate, c.burst)
+	}
+	if c.minShare <= 0 || c.minShare > 1 {
+		return fmt.Errorf("%w, got %v", ErrInvalidShare, c.minShare)
+	}
+	return nil
+}
+
+// isConfigValid 是一个函数，它检查节点配置是否有效，如果无效，它将设置为默认值，全局限制没有默认值，必须已经通过检查
+// isConfigValid is a function that checks if the node configuration is valid, if not, it sets it to the default values, the global limit has no default value and must have been validated
+func isConfigValid(conf *Config) *Config {
+	if conf.bind == "" {
+		conf.bind = DefaultBindAddr
+		if conf.multicast != "" {
+			conf.bind = DefaultMulticastBindAddr
+		}
+	}
+	if conf.interval <= 0 {
+		conf.interval = DefaultInterval
+	}
+	if conf.peerTimeout <= conf.interval {
+		conf.peerTimeout = conf.interval * 5
+	}
+	if conf.minShare <= 0 || conf.minShare > 1 {
+		conf.minShare = DefaultMinShare
+	}
+	if conf.onError == nil {
+		conf.onError = func(error) {}
+	}
+	return conf
+}
@@ -0,0 +1,21 @@
+package gossip
+
+import "errors"
+
+var (
+	// ErrConfigIsNil 表示配置为空
+	// ErrConfigIsNil indicates that the configuration is nil
+	ErrConfigIsNil = errors.New("gossip config is nil")
+
+	// ErrLimiterIsNil 表示本地限流器为空
+	// ErrLimiterIsNil indicates that the local limiter is nil
+	ErrLimiterIsNil = errors.New("gossip limiter is nil")
+
+	// ErrInvalidRate 表示全局速率或者突发值无效，它们必须大于 0
+	// ErrInvalidRate indicates that the global rate or burst is invalid, they must be greater than 0
+	ErrInvalidRate = errors.New("gossip global rate and burst must be greater than 0")
+
+	// ErrInvalidShare 表示最低份额无效，它必须在 (0, 1] 之间
+	// ErrInvalidShare indicates that the minimum share is invalid, it must be in (0, 1]
+	ErrInvalidShare = errors.New("gossip min share must be in (0, 1]")
+)
@@ -0,0 +1,495 @@
+package gossip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+// maxPacketSize 是单个消息的最大字节数
+// maxPacketSize is the maximum number of bytes of a single message
+const maxPacketSize = 8192
+
+// message 是节点之间交换的消息
+// message is the message exchanged between nodes
+type message struct {
+	// ID 是发送节点的唯一标识
+	// ID is the unique identifier of the sending node
+	ID string `json:"id"`
+
+	// Demand 是发送节点观察到的需求，单位为每秒事件数
+	// Demand is the demand observed by the sending node in events per second
+	Demand float64 `json:"demand"`
+
+	// Peers 是发送节点已知的存活节点地址，使节点列表在节点之间传播
+	// Peers are the addresses of the alive nodes known to the sending node, so that the peer list propagates between nodes
+	Peers []string `json:"peers,omitempty"`
+
+	// Leave 表示发送节点正在离开
+	// Leave indicates that the sending node is leaving
+	Leave bool `json:"leave,omitempty"`
+}
+
+// peer 是已知的其他节点
+// peer is another known node
+type peer struct {
+	// addr 是节点的地址
+	// addr is the address of the node
+	addr *net.UDPAddr
+
+	// demand 是节点最近报告的需求
+	// demand is the demand most recently reported by the node
+	demand float64
+
+	// seen 是最近收到节点消息的时间
+	// seen is the time when a message from the node was most recently received
+	seen time.Time
+}
+
+// Node 是参与限制分配的节点，它实现了 RateLimiter。节点通过 UDP 交换观察到的需求，
+// 并按需求加权调整本地限流器的速率和突发值，使所有节点的速率之和等于全局速率
+// Node is a node that takes part in splitting the limit, it implements RateLimiter. Nodes exchange their observed demand over UDP,
+// and adjust the rate and burst of the local limiter weighted by demand, so that the sum of the rates of all nodes equals the global rate
+type Node struct {
+	// config 是节点的配置
+	// config is the configuration of the node
+	config *Config
+
+	// limiter 是本地限流器
+	// limiter is the local limiter
+	limiter *rl.Limiter
+
+	// id 是节点的唯一标识
+	// id is the unique identifier of the node
+	id string
+
+	// conn 是单播连接，group 和 mconn 是组播地址和组播接收连接
+	// conn is the unicast connection, group and mconn are the multicast address and the multicast receiving connection
+	conn  *net.UDPConn
+	group *net.UDPAddr
+	mconn *net.UDPConn
+
+	// count 是当前间隔内的事件数量
+	// count is the number of events in the current interval
+	count atomic.Int64
+
+	// lock 保护节点列表、需求和份额
+	// lock protects the peer list, the demand and the share
+	lock sync.Mutex
+
+	// static 是静态的节点地址
+	// static are the static peer addresses
+	static []*net.UDPAddr
+
+	// peers 是按唯一标识索引的已知节点
+	// peers are the known nodes indexed by unique identifier
+	peers map[string]*peer
+
+	// discovered 是从其他节点得知但还没有收到消息的地址，以及得知的时间
+	// discovered are the addresses learned from other nodes that have not sent a message yet, and the time they were learned
+	discovered map[string]time.Time
+
+	// demand 是本节点平滑后的需求
+	// demand is the smoothed demand of this node
+	demand float64
+
+	// share 是本节点当前得到的全局限制的比例
+	// share is the ratio of the global limit that this node currently gets
+	share float64
+
+	// startup 是启动时的份额，使用组播时在 warmup 之前份额不会超过它
+	// startup is the share at startup, when multicast is used, the share does not exceed it before warmup
+	startup float64
+	warmup  time.Time
+
+	// ctx 和 cancel 用于管理后台协程的生命周期
+	// ctx and cancel are used to manage the lifecycle of the background goroutines
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg 用于等待后台协程退出
+	// wg is used to wait for the background goroutines to exit
+	wg sync.WaitGroup
+
+	// once 用于确保节点只被停止一次
+	// once is used to ensure that the node is stopped only once
+	once sync.Once
+}
+
+// NewNode 是创建新的节点的函数，它监听本地地址并开始交换需求。在收到其他节点的消息之前，
+// 节点按静态节点的数量平均分配全局限制，避免启动时超过全局限制。使用组播时无法预先知道节点的数量，
+// 所以节点只取平均份额的最低比例，并在一个节点超时时间内不会提高份额，这段时间内所有存活的节点都已经广播过
+// NewNode is a function to create a new node, it listens on the local address and starts exchanging demand. Before receiving messages from other nodes,
+// the node splits the global limit equally by the number of static peers to avoid exceeding the global limit at startup. The number of nodes is not known in advance when multicast is used,
+// so the node only takes the minimum ratio of the equal share, and does not raise its share for one peer timeout, during which every live node has broadcast
+func NewNode(limiter *rl.Limiter, conf *Config) (*Node, error) {
+	if limiter == nil {
+		return nil, ErrLimiterIsNil
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf = isConfigValid(conf)
+
+	n := &Node{config: conf, limiter: limiter, id: conf.id, peers: make(map[string]*peer), discovered: make(map[string]time.Time)}
+	if n.id == "" {
+		n.id = newID()
+	}
+
+	for _, addr := range conf.peers {
+		udp, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		n.static = append(n.static, udp)
+	}
+
+	bind, err := net.ResolveUDPAddr("udp", conf.bind)
+	if err != nil {
+		return nil, err
+	}
+	if n.conn, err = net.ListenUDP("udp", bind); err != nil {
+		return nil, err
+	}
+
+	if conf.multicast != "" {
+		if n.group, err = net.ResolveUDPAddr("udp4", conf.multicast); err == nil {
+			n.mconn, err = net.ListenMulticastUDP("udp4", nil, n.group)
+		}
+		if err != nil {
+			_ = n.conn.Close()
+			return nil, err
+		}
+	}
+
+	n.startup = 1 / float64(len(n.static)+1)
+	if n.mconn != nil {
+		n.startup *= conf.minShare
+		n.warmup = time.Now().Add(conf.peerTimeout)
+	}
+	n.rescale(n.startup, floorBurst(conf.burst, n.startup))
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.wg.Add(1)
+	go n.receiver(n.conn)
+	if n.mconn != nil {
+		n.wg.Add(1)
+		go n.receiver(n.mconn)
+	}
+	n.wg.Add(1)
+	go n.gossiper()
+
+	return n, nil
+}
+
+// When 是一个方法，它记录一次需求并返回本地限流器的延迟时间
+// When is a method that records a demand and returns the delay of the local limiter
+func (n *Node) When() time.Duration {
+	n.count.Add(1)
+	return n.limiter.When()
+}
+
+// ID 是一个方法，它返回节点的唯一标识
+// ID is a method that returns the unique identifier of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Addr 是一个方法，它返回节点的单播监听地址
+// Addr is a method that returns the unicast listen address of the node
+func (n *Node) Addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+// AddPeer 是一个方法，它在运行时添加一个静态的节点地址
+// AddPeer is a method that adds a static peer address at runtime
+func (n *Node) AddPeer(addr string) error {
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.static = append(n.static, udp)
+	return nil
+}
+
+// Peers 是一个方法，它返回当前存活的其他节点数量
+// Peers is a method that returns the number of other nodes currently alive
+func (n *Node) Peers() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.peers)
+}
+
+// Share 是一个方法，它返回本节点当前得到的全局限制的比例
+// Share is a method that returns the ratio of the global limit that this node currently gets
+func (n *Node) Share() float64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.share
+}
+
+// Stop 是一个方法，它通知其他节点本节点离开，并停止交换需求，本地限流器保持最后的速率
+// Stop is a method that notifies other nodes that this node is leaving and stops exchanging demand, the local limiter keeps the last rate
+func (n *Node) Stop() {
+	n.once.Do(func() {
+		n.cancel()
+		n.broadcast(&message{ID: n.id, Leave: true})
+		_ = n.conn.Close()
+		if n.mconn != nil {
+			_ = n.mconn.Close()
+		}
+		n.wg.Wait()
+	})
+}
+
+// gossiper 是一个方法，它按间隔测量需求、广播给其他节点、移除超时的节点并调整本地限流器
+// gossiper is a method that measures the demand, broadcasts it to other nodes, removes timed out nodes and adjusts the local limiter at intervals
+func (n *Node) gossiper() {
+	ticker := time.NewTicker(n.config.interval)
+
+	defer func() {
+		ticker.Stop()
+		n.wg.Done()
+	}()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// 用指数加权平均平滑需求
+		// Smooth the demand with an exponentially weighted average
+		current := float64(n.count.Swap(0)) / n.config.interval.Seconds()
+
+		n.lock.Lock()
+		n.demand = (n.demand + current) / 2
+		msg := &message{ID: n.id, Demand: n.demand}
+		for _, p := range n.peers {
+			msg.Peers = append(msg.Peers, p.addr.String())
+		}
+		n.lock.Unlock()
+
+		n.broadcast(msg)
+		n.adjust()
+	}
+}
+
+// receiver 是一个方法，它接收其他节点的消息并更新节点列表
+// receiver is a method that receives messages from other nodes and updates the peer list
+func (n *Node) receiver(conn *net.UDPConn) {
+	defer n.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			n.config.onError(err)
+			continue
+		}
+
+		var msg message
+		if err = json.Unmarshal(buf[:size], &msg); err != nil || msg.ID == "" || msg.ID == n.id {
+			continue
+		}
+
+		n.lock.Lock()
+		if msg.Leave {
+			delete(n.peers, msg.ID)
+		} else if p, ok := n.peers[msg.ID]; ok {
+			p.addr, p.demand, p.seen = addr, msg.Demand, time.Now()
+		} else {
+			n.peers[msg.ID] = &peer{addr: addr, demand: msg.Demand, seen: time.Now()}
+		}
+		delete(n.discovered, addr.String())
+		n.discoverLocked(msg.Peers)
+		n.lock.Unlock()
+
+		// 节点加入或者离开时立即调整
+		// Adjust immediately when a node joins or leaves
+		if msg.Leave {
+			n.adjust()
+		}
+	}
+}
+
+// discoverLocked 是一个方法，它记录从其他节点得知的、还不是已知节点的地址，调用者必须持有锁
+// discoverLocked is a method that records the addresses learned from other nodes that are not known nodes yet, the caller must hold the lock
+func (n *Node) discoverLocked(addrs []string) {
+	self := n.conn.LocalAddr().String()
+
+	known := make(map[string]struct{}, len(n.peers))
+	for _, p := range n.peers {
+		known[p.addr.String()] = struct{}{}
+	}
+
+	for _, addr := range addrs {
+		if _, ok := known[addr]; ok || addr == self {
+			continue
+		}
+		n.discovered[addr] = time.Now()
+	}
+}
+
+// broadcast 是一个方法，它把消息发送给静态节点、已知节点、得知的地址和组播地址
+// broadcast is a method that sends the message to the static peers, the known nodes, the learned addresses and the multicast address
+func (n *Node) broadcast(msg *message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	n.lock.Lock()
+	targets := make(map[string]*net.UDPAddr, len(n.static)+len(n.peers)+1)
+	for _, addr := range n.static {
+		targets[addr.String()] = addr
+	}
+	for _, p := range n.peers {
+		targets[p.addr.String()] = p.addr
+	}
+	for addr := range n.discovered {
+		if udp, err := net.ResolveUDPAddr("udp", addr); err == nil {
+			targets[addr] = udp
+		}
+	}
+	if n.group != nil {
+		targets[n.group.String()] = n.group
+	}
+	n.lock.Unlock()
+
+	for _, addr := range targets {
+		if _, err = n.conn.WriteToUDP(data, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+			n.config.onError(err)
+		}
+	}
+}
+
+// adjust 是一个方法，它移除超时的节点和得知的地址，并按需求加权计算本节点的份额
+// adjust is a method that removes timed out nodes and learned addresses, and calculates the share of this node weighted by demand
+func (n *Node) adjust() {
+	now := time.Now()
+
+	n.lock.Lock()
+	total := n.demand
+	for id, p := range n.peers {
+		if now.Sub(p.seen) > n.config.peerTimeout {
+			delete(n.peers, id)
+			continue
+		}
+		total += p.demand
+	}
+	for addr, learned := range n.discovered {
+		if now.Sub(learned) > n.config.peerTimeout {
+			delete(n.discovered, addr)
+		}
+	}
+
+	// 每个节点先得到平均份额的最低比例，剩下的按需求分配，没有需求时平均分配。所有节点的份额都要计算，用于分配突发值
+	// Every node first gets the minimum ratio of the equal share, the rest is allocated by demand, and it is split equally when there is no demand. The shares of all nodes are calculated to split the burst
+	equal := 1 / float64(len(n.peers)+1)
+	shareOf := func(demand float64) float64 {
+		if total > 0 {
+			return n.config.minShare*equal + (1-n.config.minShare)*demand/total
+		}
+		return equal
+	}
+	shares := make(map[string]float64, len(n.peers)+1)
+	shares[n.id] = shareOf(n.demand)
+	for id, p := range n.peers {
+		shares[id] = shareOf(p.demand)
+	}
+	n.lock.Unlock()
+
+	// 使用组播时，在所有存活的节点都广播过之前不提高份额
+	// When multicast is used, do not raise the share before every live node has broadcast
+	if shares[n.id] > n.startup && now.Before(n.warmup) {
+		n.rescale(n.startup, floorBurst(n.config.burst, n.startup))
+		return
+	}
+	n.rescale(shares[n.id], splitBurst(n.config.burst, n.id, shares))
+}
+
+// rescale 是一个方法，它把本地限流器的速率设置为全局速率的指定比例，并设置突发值
+// rescale is a method that sets the rate of the local limiter to the specified ratio of the global rate, and sets the burst
+func (n *Node) rescale(share float64, burst int64) {
+	n.lock.Lock()
+	n.share = share
+	n.lock.Unlock()
+
+	n.limiter.SetRate(n.config.rate * share)
+	n.limiter.SetBurst(burst)
+}
+
+// floorBurst 是一个函数，它返回全局突发值的指定比例向下取整的结果，至少为 1
+// floorBurst is a function that returns the specified ratio of the global burst rounded down, it is at least 1
+func floorBurst(burst int64, share float64) int64 {
+	// 加上一个很小的值，避免 1/3 这样的比例因为浮点误差少算一个
+	// Add a tiny value so that a ratio such as 1/3 does not lose one to the floating point error
+	return int64(math.Max(1, math.Floor(float64(burst)*share+1e-9)))
+}
+
+// splitBurst 是一个函数，它用最大余数法把全局突发值分配给所有节点，返回节点 self 得到的突发值。
+// 每个节点先得到向下取整的部分，剩下的按小数部分从大到小逐个分配，小数部分相同时唯一标识较小的节点优先。
+// 所有节点看到相同的份额时，突发值之和等于全局突发值。每个节点至少得到 1，所以节点数量超过全局突发值时之和会超过全局突发值
+// splitBurst is a function that splits the global burst across all nodes by the largest remainder method, and returns the burst that the node self gets.
+// Every node first gets the part rounded down, and the rest is handed out one by one by the fractional part from large to small, the node with the smaller unique identifier goes first when the fractional parts are equal.
+// When all nodes see the same shares, the bursts add up to the global burst. Every node gets at least 1, so the sum exceeds the global burst when there are more nodes than the global burst
+func splitBurst(burst int64, self string, shares map[string]float64) int64 {
+	type part struct {
+		id   string
+		frac float64
+	}
+	parts := make([]part, 0, len(shares))
+	rest := burst
+	for id, share := range shares {
+		exact := float64(burst) * share
+		whole := math.Floor(exact + 1e-9)
+		rest -= int64(whole)
+		parts = append(parts, part{id: id, frac: exact - whole})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].frac != parts[j].frac {
+			return parts[i].frac > parts[j].frac
+		}
+		return parts[i].id < parts[j].id
+	})
+
+	own := int64(math.Floor(float64(burst)*shares[self] + 1e-9))
+	for i := int64(0); i < rest && i < int64(len(parts)); i++ {
+		if parts[i].id == self {
+			own++
+		}
+	}
+	if own < 1 {
+		own = 1
+	}
+	return own
+}
+
+// newID 是一个函数，它生成随机的节点唯一标识
+// newID is a function that generates a random node unique identifier
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -1,6 +1,9 @@
 package regula
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // MessageHandleFunc 是一个消息处理函数类型，接收任意类型的消息并返回任意类型的结果和错误。
 // MessageHandleFunc is a message processing function type that receives messages of any type and returns results and errors of any type.
@@ -30,6 +33,28 @@ type RateLimiter = interface {
 	When() time.Duration
 }
 
+// BatchRateLimiter 是一个可选的接口，速率限制器可以实现它，在一次操作中为一批事件预留令牌并返回每个事件的延迟时间
+// BatchRateLimiter is an optional interface, a rate limiter can implement it to reserve tokens for a batch of events in one operation and return the delay of each event
+type BatchRateLimiter = interface {
+	RateLimiter
+
+	// WhenN 返回 n 个事件各自的延迟时间
+	// WhenN returns the delay of each of the n events
+	WhenN(n int) []time.Duration
+}
+
+// AdmissionRateLimiter 是一个可选的接口，速率限制器可以实现它来拒绝无法放行的事件，例如配额用尽时，
+// 流控制器通过 Reserve 而不是 When 预留事件，并以返回的错误拒绝消息
+// AdmissionRateLimiter is an optional interface, a rate limiter can implement it to reject events that cannot be admitted, such as when the quota is exhausted,
+// the flow controller reserves events through Reserve instead of When, and rejects the message with the returned error
+type AdmissionRateLimiter = interface {
+	RateLimiter
+
+	// Reserve 返回下一个事件的延迟时间，事件无法放行时返回错误并且不消耗任何东西
+	// Reserve returns the delay time of the next event, it returns an error without consuming anything when the event cannot be admitted
+	Reserve() (time.Duration, error)
+}
+
 // Callback 是一个接口，定义了一个方法，该方法是达到速率限制时的回调函数
 // Callback is an interface that defines a method that is the callback function when the rate limit is reached
 type Callback = interface {
@@ -37,3 +62,119 @@ type Callback = interface {
 	// OnExecLimited is the callback function when the rate limit is reached
 	OnExecLimited(msg any, delay time.Duration)
 }
+
+// PauseCallback 是一个可选的接口，回调可以实现它来接收暂停和恢复的通知
+// PauseCallback is an optional interface, a callback can implement it to be notified of pauses and resumes
+type PauseCallback = interface {
+	// OnPaused 当流控制器被暂停时的回调函数
+	// OnPaused is the callback function when the flow controller is paused
+	OnPaused()
+
+	// OnResumed 当流控制器被恢复时的回调函数，held 是暂停期间保留并开始按速率释放的消息数量
+	// OnResumed is the callback function when the flow controller is resumed, held is the number of messages kept during the pause that start to be released at the configured rate
+	OnResumed(held int)
+}
+
+// RetryCallback 是一个可选的接口，回调可以实现它来接收重试和最终失败的通知
+// RetryCallback is an optional interface, a callback can implement it to be notified of retries and final failures
+type RetryCallback = interface {
+	// OnExecRetry 当处理函数返回错误并将要重试时的回调函数，attempt 是失败的执行次数，delay 是重试前的退避时间
+	// OnExecRetry is the callback function when the handle function returns an error and will be retried, attempt is the number of the failed execution, delay is the backoff time before the retry
+	OnExecRetry(msg any, attempt int, delay time.Duration, err error)
+
+	// OnExecFailed 当处理函数最终失败（不再重试）时的回调函数
+	// OnExecFailed is the callback function when the handle function finally fails (no more retries)
+	OnExecFailed(msg any, err error)
+}
+
+// CircuitCallback 是一个可选的接口，回调可以实现它来接收熔断器状态变化的通知
+// CircuitCallback is an optional interface, a callback can implement it to be notified of state changes of the circuit breaker
+type CircuitCallback = interface {
+	// OnCircuitStateChanged 当熔断器状态变化时的回调函数
+	// OnCircuitStateChanged is the callback function when the state of the circuit breaker changes
+	OnCircuitStateChanged(from, to CircuitState)
+}
+
+// PanicCallback 是一个可选的接口，回调可以实现它来接收处理函数 panic 的通知
+// PanicCallback is an optional interface, a callback can implement it to be notified of panics of the handle function
+type PanicCallback = interface {
+	// OnExecPanic 当消息处理函数发生 panic 时的回调函数，panic 被恢复为带有堆栈的 PanicError
+	// OnExecPanic is the callback function when the message handle function panics, the panic is recovered as PanicError with the stack
+	OnExecPanic(msg any, err *PanicError)
+}
+
+// DropCallback 是一个可选的接口，回调可以实现它来接收消息在执行前被丢弃的通知
+// DropCallback is an optional interface, a callback can implement it to be notified of messages dropped before execution
+type DropCallback = interface {
+	// OnExecDropped 当消息在执行前被丢弃或者被更新的消息取代时的回调函数，reason 说明原因
+	// OnExecDropped is the callback function when the message is dropped or superseded by a newer message before execution, reason explains why
+	OnExecDropped(msg any, reason error)
+}
+
+// DedupeCallback 是一个可选的接口，回调可以实现它来接收去重的通知
+// DedupeCallback is an optional interface, a callback can implement it to be notified of deduplication
+type DedupeCallback = interface {
+	// OnExecDeduplicated 当等待中的消息因去重被合并时的回调函数，msg 是被移除的消息，kept 是将要执行的消息
+	// OnExecDeduplicated is the callback function when a waiting message is combined by deduplication, msg is the removed message, and kept is the message that will be executed
+	OnExecDeduplicated(msg any, kept any)
+}
+
+// BatchCallback 是一个可选的接口，回调可以实现它，对 DoBatch 中被延迟的消息只接收一次通知
+// BatchCallback is an optional interface, a callback can implement it to be notified only once of the delayed messages of DoBatch
+type BatchCallback = interface {
+	// OnExecBatchLimited 当 DoBatch 中的消息被限制时的回调函数，msgs 是被延迟的消息，delay 是最大延迟
+	// OnExecBatchLimited is the callback function when messages of DoBatch are limited, msgs are the delayed messages, and delay is the largest delay
+	OnExecBatchLimited(msgs []any, delay time.Duration)
+}
+
+// ReloadCallback 是一个接口，定义了一个方法，该方法是配置重新加载时的回调函数
+// ReloadCallback is an interface that defines a method that is the callback function when the configuration is reloaded
+type ReloadCallback = interface {
+	// OnReloaded 当配置文件被重新加载（无论成功与否）时的回调函数
+	// OnReloaded is the callback function when the configuration file is reloaded (whether successful or not)
+	OnReloaded(event *ReloadEvent)
+}
+
+// ContextHandleFunc 是一个带有上下文的消息处理函数类型，当设置了超时时，上下文带有截止时间
+// ContextHandleFunc is a message processing function type with a context, the context has a deadline when a timeout is set
+type ContextHandleFunc = func(ctx context.Context, msg any) (any, error)
+
+// KeyFunc 是一个函数类型，它返回消息的键，用于合并、防抖和节流等按键处理的功能，键必须是可比较的
+// KeyFunc is a function type that returns the key of the message, it is used by per-key features such as coalescing, debounce and throttle, the key must be comparable
+type KeyFunc = func(msg any) any
+
+// Codec 是消息编解码器的接口，用于把消息保存到持久化存储中
+// Codec is the interface of the message codec, used to save messages into the durable store
+type Codec = interface {
+	// Encode 把消息编码为字节
+	// Encode encodes the message into bytes
+	Encode(msg any) ([]byte, error)
+
+	// Decode 把字节解码为消息
+	// Decode decodes the bytes into a message
+	Decode(data []byte) (any, error)
+}
+
+// MessageStore 是持久化存储的接口，它保存已接受但尚未完成的消息，重启后可以重放
+// MessageStore is the interface of the durable store, it keeps the messages that have been accepted but not completed, so they can be replayed after a restart
+type MessageStore = interface {
+	// Save 保存或者更新一条记录，编号为 0 时分配新的编号并写回记录
+	// Save saves or updates a record, a new number is assigned and written back to the record if the number is 0
+	Save(record *StoreRecord) error
+
+	// Delete 删除一条记录
+	// Delete deletes a record
+	Delete(id uint64) error
+
+	// Load 按编号顺序返回所有记录
+	// Load returns all records in the order of number
+	Load() ([]*StoreRecord, error)
+}
+
+// ExecFeedback 是一个可选的接口，速率限制器可以实现它来接收每次执行的结果（包括 ErrHandlerTimeout）和耗时，用于自适应地调整速率
+// ExecFeedback is an optional interface, a rate limiter can implement it to receive the result (including ErrHandlerTimeout) and elapsed time of every execution, used to adjust the rate adaptively
+type ExecFeedback = interface {
+	// OnExecResult 在每次执行消息处理函数后被调用
+	// OnExecResult is called after every execution of the message handle function
+	OnExecResult(err error, elapsed time.Duration)
+}
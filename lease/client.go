@@ -0,0 +1,460 @@
+package lease
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+const (
+	// DefaultBatchSize 是默认的每次申请的令牌数量
+	// DefaultBatchSize is the default number of tokens requested each time
+	DefaultBatchSize = 10
+
+	// DefaultRequestTimeout 是默认的申请超时时间
+	// DefaultRequestTimeout is the default timeout of a request
+	DefaultRequestTimeout = time.Millisecond * 200
+
+	// DefaultRenewInterval 是默认的后台续约检查间隔
+	// DefaultRenewInterval is the default interval of checking renewal in the background
+	DefaultRenewInterval = time.Millisecond * 100
+
+	// DefaultRetryInterval 是默认的降级后重新尝试协调器的间隔
+	// DefaultRetryInterval is the default interval to try the coordinator again after degrading
+	DefaultRetryInterval = time.Second
+
+	// DefaultFallbackRate 是默认的降级后的保守速率
+	// DefaultFallbackRate is the default conservative rate after degrading
+	DefaultFallbackRate = 1.0
+)
+
+// ClientConfig 是租约客户端的配置
+// ClientConfig is the configuration of the lease client
+type ClientConfig struct {
+	// url 是协调器的地址
+	// url is the URL of the coordinator
+	url string
+
+	// name 是限制的名称
+	// name is the name of the limit
+	name string
+
+	// batchSize 是每次申请的令牌数量
+	// batchSize is the number of tokens requested each time
+	batchSize int
+
+	// lowWatermark 是剩余令牌少于它时在后台预取下一批的数量
+	// lowWatermark is the number of remaining tokens below which the next batch is prefetched in the background
+	lowWatermark int
+
+	// timeout 是申请超时时间
+	// timeout is the timeout of a request
+	timeout time.Duration
+
+	// renewInterval 是后台续约检查间隔
+	// renewInterval is the interval of checking renewal in the background
+	renewInterval time.Duration
+
+	// retryInterval 是降级后重新尝试协调器的间隔
+	// retryInterval is the interval to try the coordinator again after degrading
+	retryInterval time.Duration
+
+	// fallback 是协调器不可用时使用的本地限流器
+	// fallback is the local limiter used when the coordinator is unavailable
+	fallback rl.RateLimiter
+
+	// onError 是访问协调器失败时调用的函数
+	// onError is the function called when accessing the coordinator fails
+	onError func(err error)
+}
+
+// NewClientConfig 是创建新的租约客户端配置的函数，它接受协调器地址和限制名称
+// NewClientConfig is a function to create a new lease client configuration, it accepts the coordinator URL and the limit name
+func NewClientConfig(url, name string) *ClientConfig {
+	return &ClientConfig{
+		url:           url,
+		name:          name,
+		batchSize:     DefaultBatchSize,
+		lowWatermark:  DefaultBatchSize / 2,
+		timeout:       DefaultRequestTimeout,
+		renewInterval: DefaultRenewInterval,
+		retryInterval: DefaultRetryInterval,
+		onError:       func(error) {},
+	}
+}
+
+// WithBatchSize 它设置每次申请的令牌数量，剩余令牌少于它的一半时在后台预取下一批
+// WithBatchSize is a method that sets the number of tokens requested each time, the next batch is prefetched in the background when less than half of it remains
+func (c *ClientConfig) WithBatchSize(n int) *ClientConfig {
+	c.batchSize = n
+	c.lowWatermark = n / 2
+	return c
+}
+
+// WithTimeout 它设置申请超时时间，它限制了协调器不可用时 When 的最长阻塞时间
+// WithTimeout is a method that sets the timeout of a request, it bounds how long When blocks when the coordinator is unavailable
+func (c *ClientConfig) WithTimeout(timeout time.Duration) *ClientConfig {
+	c.timeout = timeout
+	return c
+}
+
+// WithRenewInterval 它设置后台续约检查间隔
+// WithRenewInterval is a method that sets the interval of checking renewal in the background
+func (c *ClientConfig) WithRenewInterval(interval time.Duration) *ClientConfig {
+	c.renewInterval = interval
+	return c
+}
+
+// WithRetryInterval 它设置降级后重新尝试协调器的间隔，在此期间直接使用本地限流器
+// WithRetryInterval is a method that sets the interval to try the coordinator again after degrading, the local limiter is used directly in the meantime
+func (c *ClientConfig) WithRetryInterval(interval time.Duration) *ClientConfig {
+	c.retryInterval = interval
+	return c
+}
+
+// WithFallback 它设置协调器不可用时使用的本地限流器，默认是速率为 DefaultFallbackRate 的保守令牌桶
+// WithFallback is a method that sets the local limiter used when the coordinator is unavailable, the default is a conservative token bucket at DefaultFallbackRate
+func (c *ClientConfig) WithFallback(limiter rl.RateLimiter) *ClientConfig {
+	c.fallback = limiter
+	return c
+}
+
+// WithErrorHandler 它设置访问协调器失败时调用的函数
+// WithErrorHandler is a method that sets the function called when accessing the coordinator fails
+func (c *ClientConfig) WithErrorHandler(fn func(err error)) *ClientConfig {
+	c.onError = fn
+	return c
+}
+
+// Validate 是一个方法，它严格检查租约客户端配置是否有效
+// Validate is a method that strictly checks if the lease client configuration is valid
+func (c *ClientConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if c.url == "" {
+		return ErrInvalidURL
+	}
+	if c.name == "" {
+		return ErrInvalidName
+	}
+	if c.batchSize <= 0 {
+		return fmt.Errorf("%w, got %d", ErrInvalidBatchSize, c.batchSize)
+	}
+	return nil
+}
+
+// isClientConfigValid 是一个函数，它检查租约客户端配置是否有效，如果无效，它将设置为默认值
+// isClientConfigValid is a function that checks if the lease client configuration is valid, if not, it sets it to the default values
+func isClientConfigValid(conf *ClientConfig) *ClientConfig {
+	if conf == nil {
+		conf = NewClientConfig("", "")
+	}
+	if conf.batchSize <= 0 {
+		conf.batchSize = DefaultBatchSize
+		conf.lowWatermark = DefaultBatchSize / 2
+	}
+	if conf.lowWatermark < 0 || conf.lowWatermark > conf.batchSize {
+		conf.lowWatermark = conf.batchSize / 2
+	}
+	if conf.timeout <= 0 {
+		conf.timeout = DefaultRequestTimeout
+	}
+	if conf.renewInterval <= 0 {
+		conf.renewInterval = DefaultRenewInterval
+	}
+	if conf.retryInterval <= 0 {
+		conf.retryInterval = DefaultRetryInterval
+	}
+	if conf.fallback == nil {
+		conf.fallback = rl.NewRateLimiter(rl.NewConfig().WithRate(DefaultFallbackRate).WithBurst(1))
+	}
+	if conf.onError == nil {
+		conf.onError = func(error) {}
+	}
+	return conf
+}
+
+// token 是租约中的一个令牌
+// token is a token in a lease
+type token struct {
+	// at 是令牌可用的时间
+	// at is the time when the token is available
+	at time.Time
+
+	// expires 是令牌过期的时间
+	// expires is the time when the token expires
+	expires time.Time
+}
+
+// Client 是租约客户端，它实现了 RateLimiter。它从协调器按批预取令牌，在后台续约，
+// 协调器不可用时降级到保守的本地限流器
+// Client is the lease client, it implements RateLimiter. It prefetches tokens from the coordinator in batches and renews them in the background,
+// and degrades to a conservative local limiter when the coordinator is unavailable
+type Client struct {
+	// config 是租约客户端的配置
+	// config is the configuration of the lease client
+	config *ClientConfig
+
+	// http 是 HTTP 客户端
+	// http is the HTTP client
+	http *http.Client
+
+	// lock 保护预取的令牌
+	// lock protects the prefetched tokens
+	lock sync.Mutex
+
+	// tokens 是预取的令牌，按可用时间排序
+	// tokens are the prefetched tokens, sorted by available time
+	tokens []token
+
+	// fetching 保证同一时间只有一个申请
+	// fetching ensures that there is only one request at a time
+	fetching sync.Mutex
+
+	// retryAt 是降级后重新尝试协调器的时间，单位为纳秒，为 0 时没有降级
+	// retryAt is the time to try the coordinator again after degrading in nanoseconds, 0 means not degraded
+	retryAt atomic.Int64
+
+	// wake 用于唤醒后台续约
+	// wake is used to wake up the background renewal
+	wake chan struct{}
+
+	// ctx 和 cancel 用于管理续约协程的生命周期
+	// ctx and cancel are used to manage the lifecycle of the renewal goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg 用于等待续约协程退出
+	// wg is used to wait for the renewal goroutine to exit
+	wg sync.WaitGroup
+
+	// once 用于确保租约客户端只被停止一次
+	// once is used to ensure that the lease client is stopped only once
+	once sync.Once
+}
+
+// NewClient 是创建新的租约客户端的函数，它在后台开始续约
+// NewClient is a function to create a new lease client, it starts renewing in the background
+func NewClient(conf *ClientConfig) *Client {
+	conf = isClientConfigValid(conf)
+
+	c := &Client{
+		config: conf,
+		http:   &http.Client{Timeout: conf.timeout},
+		wake:   make(chan struct{}, 1),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	c.wg.Add(1)
+	go c.renewer()
+
+	return c
+}
+
+// NewStrictClient 是创建新的租约客户端的函数，它在配置无效时返回错误
+// NewStrictClient is a function to create a new lease client, it returns an error when the configuration is invalid
+func NewStrictClient(conf *ClientConfig) (*Client, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewClient(conf), nil
+}
+
+// When 是一个方法，它取出一个预取的令牌并返回它可用前的延迟，没有令牌时同步申请一批，协调器不可用时使用本地限流器
+// When is a method that takes a prefetched token and returns the delay before it is available, a batch is requested synchronously if there is no token, the local limiter is used when the coordinator is unavailable
+func (c *Client) When() time.Duration {
+	if delay, ok := c.take(); ok {
+		return delay
+	}
+	if c.Degraded() && time.Now().UnixNano() < c.retryAt.Load() {
+		return c.config.fallback.When()
+	}
+
+	// 同一时间只有一个申请，等待中的调用者可能已经得到了令牌
+	// There is only one request at a time, the waiting caller may have got tokens already
+	c.fetching.Lock()
+	delay, ok := c.take()
+	if !ok {
+		if err := c.refill(); err == nil {
+			delay, ok = c.take()
+		}
+	}
+	c.fetching.Unlock()
+
+	if !ok {
+		return c.config.fallback.When()
+	}
+	return delay
+}
+
+// Degraded 是一个方法，它返回客户端当前是否降级到本地限流器
+// Degraded is a method that returns whether the client is currently degraded to the local limiter
+func (c *Client) Degraded() bool {
+	return c.retryAt.Load() != 0
+}
+
+// Available 是一个方法，它返回预取的未过期令牌数量
+// Available is a method that returns the number of prefetched tokens that have not expired
+func (c *Client) Available() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.expireLocked(time.Now())
+	return len(c.tokens)
+}
+
+// Stop 是一个方法，它停止后台续约，未使用的令牌被丢弃
+// Stop is a method that stops the background renewal, unused tokens are discarded
+func (c *Client) Stop() {
+	c.once.Do(func() {
+		c.cancel()
+		c.wg.Wait()
+	})
+}
+
+// take 是一个方法，它取出最早的未过期令牌，剩余令牌少于下限时唤醒后台预取
+// take is a method that takes the earliest token that has not expired, and wakes up the background prefetch when the remaining tokens are below the low watermark
+func (c *Client) take() (time.Duration, bool) {
+	now := time.Now()
+
+	c.lock.Lock()
+	c.expireLocked(now)
+	if len(c.tokens) == 0 {
+		c.lock.Unlock()
+		return 0, false
+	}
+	t := c.tokens[0]
+	c.tokens = c.tokens[1:]
+	remaining := len(c.tokens)
+	c.lock.Unlock()
+
+	if remaining < c.config.lowWatermark {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	if delay := t.at.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// expireLocked 是一个方法，它丢弃已经过期的令牌，调用者必须持有锁
+// expireLocked is a method that discards the expired tokens, the caller must hold the lock
+func (c *Client) expireLocked(now time.Time) {
+	i := 0
+	for i < len(c.tokens) && c.tokens[i].expires.Before(now) {
+		i++
+	}
+	c.tokens = c.tokens[i:]
+}
+
+// refill 是一个方法，它申请一批令牌，失败时降级并报告错误，调用者必须持有 fetching
+// refill is a method that requests a batch of tokens, it degrades and reports the error on failure, the caller must hold fetching
+func (c *Client) refill() error {
+	lease, err := c.request()
+	if err != nil {
+		c.retryAt.Store(time.Now().Add(c.config.retryInterval).UnixNano())
+		c.config.onError(err)
+		return err
+	}
+	c.retryAt.Store(0)
+
+	now := time.Now()
+	ttl := time.Duration(lease.TTL) * time.Millisecond
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, delay := range lease.Delays {
+		at := now.Add(time.Duration(delay) * time.Microsecond)
+		c.tokens = append(c.tokens, token{at: at, expires: at.Add(ttl)})
+	}
+	return nil
+}
+
+// request 是一个方法，它向协调器发送一次租约申请
+// request is a method that sends a lease request to the coordinator
+func (c *Client) request() (*Lease, error) {
+	body, err := json.Marshal(&Request{Limit: c.config.name, Tokens: c.config.batchSize})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, strings.TrimRight(c.config.url, "/")+LeasePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var reply errorReply
+		_ = json.NewDecoder(resp.Body).Decode(&reply)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownLimit, c.config.name)
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrCoordinator, resp.Status, reply.Error)
+	}
+
+	var lease Lease
+	if err = json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCoordinator, err)
+	}
+	return &lease, nil
+}
+
+// renewer 是一个方法，它在剩余令牌少于下限时在后台预取下一批，并在降级后重新尝试协调器
+// renewer is a method that prefetches the next batch in the background when the remaining tokens are below the low watermark, and tries the coordinator again after degrading
+func (c *Client) renewer() {
+	ticker := time.NewTicker(c.config.renewInterval)
+
+	defer func() {
+		ticker.Stop()
+		c.wg.Done()
+	}()
+
+	for {
+		// 只在令牌被使用后预取，空闲的客户端不会占用全局的限制。降级后按间隔探测协调器
+		// Prefetch only after tokens are used, so an idle client does not hold the global limit. After degrading, probe the coordinator at intervals
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if !c.Degraded() {
+				continue
+			}
+		case <-c.wake:
+			if c.Available() >= c.config.lowWatermark {
+				continue
+			}
+		}
+
+		if c.Degraded() && time.Now().UnixNano() < c.retryAt.Load() {
+			continue
+		}
+
+		// 调用者正在同步申请时跳过
+		// Skip when a caller is requesting synchronously
+		if c.fetching.TryLock() {
+			_ = c.refill()
+			c.fetching.Unlock()
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package lease
+
+import "errors"
+
+var (
+	// ErrConfigIsNil 表示配置为空
+	// ErrConfigIsNil indicates that the configuration is nil
+	ErrConfigIsNil = errors.New("lease config is nil")
+
+	// ErrInvalidURL 表示协调器地址为空
+	// ErrInvalidURL indicates that the coordinator URL is empty
+	ErrInvalidURL = errors.New("lease coordinator url is empty")
+
+	// ErrInvalidName 表示限制名称为空
+	// ErrInvalidName indicates that the limit name is empty
+	ErrInvalidName = errors.New("lease limit name is empty")
+
+	// ErrInvalidBatchSize 表示批量大小无效，它必须大于 0
+	// ErrInvalidBatchSize indicates that the batch size is invalid, it must be greater than 0
+	ErrInvalidBatchSize = errors.New("lease batch size must be greater than 0")
+
+	// ErrUnknownLimit 表示协调器上没有配置该名称的限制
+	// ErrUnknownLimit indicates that no limit of the name is configured on the coordinator
+	ErrUnknownLimit = errors.New("lease limit is not configured")
+
+	// ErrCoordinator 表示协调器返回了错误
+	// ErrCoordinator indicates that the coordinator returned an error
+	ErrCoordinator = errors.New("lease coordinator error")
+)
@@ -0,0 +1,229 @@
+package lease
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+const (
+	// DefaultMaxBatch 是默认的单次租约最多令牌数量
+	// DefaultMaxBatch is the default maximum number of tokens in a single lease
+	DefaultMaxBatch = 100
+
+	// DefaultTTL 是默认的令牌可用后的有效时间
+	// DefaultTTL is the default time a token stays valid after it is available
+	DefaultTTL = time.Second
+)
+
+// ServerConfig 是协调器的配置
+// ServerConfig is the configuration of the coordinator
+type ServerConfig struct {
+	// maxBatch 是单次租约最多的令牌数量
+	// maxBatch is the maximum number of tokens in a single lease
+	maxBatch int
+
+	// ttl 是令牌可用后的有效时间，过期的令牌被客户端丢弃，避免空闲后集中使用
+	// ttl is the time a token stays valid after it is available, expired tokens are discarded by the client to avoid using them all at once after being idle
+	ttl time.Duration
+}
+
+// NewServerConfig 是创建新的协调器配置的函数
+// NewServerConfig is a function to create a new coordinator configuration
+func NewServerConfig() *ServerConfig {
+	return &ServerConfig{maxBatch: DefaultMaxBatch, ttl: DefaultTTL}
+}
+
+// DefaultServerConfig 是获取默认协调器配置的函数
+// DefaultServerConfig is a function to get the default coordinator configuration
+func DefaultServerConfig() *ServerConfig {
+	return NewServerConfig()
+}
+
+// WithMaxBatch 它设置单次租约最多的令牌数量
+// WithMaxBatch is a method that sets the maximum number of tokens in a single lease
+func (c *ServerConfig) WithMaxBatch(n int) *ServerConfig {
+	c.maxBatch = n
+	return c
+}
+
+// WithTTL 它设置令牌可用后的有效时间
+// WithTTL is a method that sets the time a token stays valid after it is available
+func (c *ServerConfig) WithTTL(ttl time.Duration) *ServerConfig {
+	c.ttl = ttl
+	return c
+}
+
+// isServerConfigValid 是一个函数，它检查协调器配置是否有效，如果无效，它将设置为默认值
+// isServerConfigValid is a function that checks if the coordinator configuration is valid, if not, it sets it to the default values
+func isServerConfigValid(conf *ServerConfig) *ServerConfig {
+	if conf != nil {
+		if conf.maxBatch <= 0 {
+			conf.maxBatch = DefaultMaxBatch
+		}
+		if conf.ttl <= 0 {
+			conf.ttl = DefaultTTL
+		}
+	} else {
+		conf = DefaultServerConfig()
+	}
+	return conf
+}
+
+// Server 是令牌租约协调器，它持有全局的限制，并通过 HTTP/JSON 按批发放令牌租约
+// Server is the token lease coordinator, it owns the global limits and issues token leases in batches over HTTP/JSON
+type Server struct {
+	// config 是协调器的配置
+	// config is the configuration of the coordinator
+	config *ServerConfig
+
+	// lock 保护限制
+	// lock protects the limits
+	lock sync.RWMutex
+
+	// limiters 是按名称索引的全局限流器
+	// limiters are the global limiters indexed by name
+	limiters map[string]*rl.Limiter
+
+	// mux 是请求路由
+	// mux is the request router
+	mux *http.ServeMux
+}
+
+// NewServer 是创建新的协调器的函数
+// NewServer is a function to create a new coordinator
+func NewServer(conf *ServerConfig) *Server {
+	s := &Server{
+		config:   isServerConfigValid(conf),
+		limiters: make(map[string]*rl.Limiter),
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc(LeasePath, s.handleLease)
+	s.mux.HandleFunc(LimitsPath, s.handleLimits)
+	s.mux.HandleFunc(HealthPath, func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	return s
+}
+
+// SetLimit 是一个方法，它设置指定名称的全局限制，已有的限制原地修改并保留桶中的令牌，返回该限制的限流器
+// SetLimit is a method that sets the global limit of the specified name, an existing limit is modified in place and keeps the tokens in the bucket, it returns the limiter of the limit
+func (s *Server) SetLimit(name string, rate float64, burst int64) *rl.Limiter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if limiter, ok := s.limiters[name]; ok {
+		limiter.SetRate(rate)
+		limiter.SetBurst(burst)
+		return limiter
+	}
+
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(rate).WithBurst(burst))
+	s.limiters[name] = limiter
+	return limiter
+}
+
+// Limiter 是一个方法，它返回指定名称的限制的限流器
+// Limiter is a method that returns the limiter of the limit of the specified name
+func (s *Server) Limiter(name string) (*rl.Limiter, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	limiter, ok := s.limiters[name]
+	return limiter, ok
+}
+
+// RemoveLimit 是一个方法，它删除指定名称的全局限制
+// RemoveLimit is a method that removes the global limit of the specified name
+func (s *Server) RemoveLimit(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.limiters, name)
+}
+
+// Limits 是一个方法，它返回所有限制的描述
+// Limits is a method that returns the descriptions of all limits
+func (s *Server) Limits() map[string]Limit {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	limits := make(map[string]Limit, len(s.limiters))
+	for name, limiter := range s.limiters {
+		limits[name] = Limit{Rate: limiter.Rate(), Burst: limiter.Burst()}
+	}
+	return limits
+}
+
+// Grant 是一个方法，它从指定名称的全局限制中预留最多 n 个令牌并返回租约，单次租约的令牌数量不超过配置的上限
+// Grant is a method that reserves at most n tokens from the global limit of the specified name and returns the lease, the number of tokens in a single lease does not exceed the configured maximum
+func (s *Server) Grant(name string, n int) (*Lease, error) {
+	s.lock.RLock()
+	limiter, ok := s.limiters[name]
+	s.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLimit, name)
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("%w, got %d", ErrInvalidBatchSize, n)
+	}
+	if n > s.config.maxBatch {
+		n = s.config.maxBatch
+	}
+
+	delays := limiter.WhenN(n)
+	lease := &Lease{Limit: name, Delays: make([]int64, n), TTL: s.config.ttl.Milliseconds()}
+	for i, delay := range delays {
+		lease.Delays[i] = delay.Microseconds()
+	}
+	return lease, nil
+}
+
+// ServeHTTP 是一个方法，它处理协调器的 HTTP 请求
+// ServeHTTP is a method that handles the HTTP requests of the coordinator
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleLease 是一个方法，它处理令牌租约的申请
+// handleLease is a method that handles a request for a token lease
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, &errorReply{Error: "method not allowed"})
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: err.Error()})
+		return
+	}
+
+	lease, err := s.Grant(req.Limit, req.Tokens)
+	switch {
+	case errors.Is(err, ErrUnknownLimit):
+		writeJSON(w, http.StatusNotFound, &errorReply{Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, &errorReply{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, lease)
+	}
+}
+
+// handleLimits 是一个方法，它返回所有限制的描述
+// handleLimits is a method that returns the descriptions of all limits
+func (s *Server) handleLimits(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.Limits())
+}
+
+// writeJSON 是一个函数，它把值编码为 JSON 并写入响应
+// writeJSON is a function that encodes the value as JSON and writes it to the response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
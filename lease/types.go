@@ -0,0 +1,61 @@
+package lease
+
+const (
+	// LeasePath 是申请令牌租约的路径
+	// LeasePath is the path to request a token lease
+	LeasePath = "/v1/lease"
+
+	// LimitsPath 是查看已配置限制的路径
+	// LimitsPath is the path to view the configured limits
+	LimitsPath = "/v1/limits"
+
+	// HealthPath 是健康检查的路径
+	// HealthPath is the path of the health check
+	HealthPath = "/healthz"
+)
+
+// Request 是令牌租约的申请
+// Request is a request for a token lease
+type Request struct {
+	// Limit 是限制的名称
+	// Limit is the name of the limit
+	Limit string `json:"limit"`
+
+	// Tokens 是申请的令牌数量
+	// Tokens is the number of tokens requested
+	Tokens int `json:"tokens"`
+}
+
+// Lease 是协调器发放的令牌租约，第 i 个令牌在 Delays[i] 微秒后可用，并在可用后 TTL 毫秒内有效
+// Lease is a token lease issued by the coordinator, the i-th token is available after Delays[i] microseconds and is valid within TTL milliseconds after it is available
+type Lease struct {
+	// Limit 是限制的名称
+	// Limit is the name of the limit
+	Limit string `json:"limit"`
+
+	// Delays 是每个令牌可用前的延迟，单位为微秒
+	// Delays are the delays before each token is available in microseconds
+	Delays []int64 `json:"delays_us"`
+
+	// TTL 是令牌可用后的有效时间，单位为毫秒
+	// TTL is the time a token stays valid after it is available in milliseconds
+	TTL int64 `json:"ttl_ms"`
+}
+
+// Limit 是协调器上一个限制的描述
+// Limit is the description of a limit on the coordinator
+type Limit struct {
+	// Rate 是全局的速率
+	// Rate is the global rate
+	Rate float64 `json:"rate"`
+
+	// Burst 是全局的突发值
+	// Burst is the global burst
+	Burst int64 `json:"burst"`
+}
+
+// errorReply 是协调器返回的错误
+// errorReply is an error returned by the coordinator
+type errorReply struct {
+	Error string `json:"error"`
+}
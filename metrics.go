@@ -0,0 +1,107 @@
+package regula
+
+import "sync/atomic"
+
+// Metrics 是流控制器的计数器快照
+// Metrics is a snapshot of the counters of the flow controller
+type Metrics struct {
+	// Submitted 是被接受的消息数量
+	// Submitted is the number of accepted messages
+	Submitted uint64
+
+	// Rejected 是被拒绝的消息数量
+	// Rejected is the number of rejected messages
+	Rejected uint64
+
+	// Limited 是被速率限制器延迟的提交次数
+	// Limited is the number of submissions delayed by the rate limiter
+	Limited uint64
+
+	// Succeeded 是最终成功的消息数量
+	// Succeeded is the number of messages that finally succeeded
+	Succeeded uint64
+
+	// Failed 是最终失败的消息数量
+	// Failed is the number of messages that finally failed
+	Failed uint64
+
+	// Retried 是安排的重试次数
+	// Retried is the number of scheduled retries
+	Retried uint64
+
+	// TimedOut 是超时的执行次数
+	// TimedOut is the number of executions that timed out
+	TimedOut uint64
+
+	// Panicked 是发生 panic 的执行次数
+	// Panicked is the number of executions that panicked
+	Panicked uint64
+
+	// Coalesced 是与相同键的消息共享结果的消息数量
+	// Coalesced is the number of messages that share the result of a message with the same key
+	Coalesced uint64
+
+	// Dropped 是在执行前被丢弃或者被取代的消息数量
+	// Dropped is the number of messages dropped or superseded before execution
+	Dropped uint64
+
+	// Deduplicated 是与正在等待的相同键的消息去重合并的消息数量
+	// Deduplicated is the number of messages combined with a waiting message with the same key by deduplication
+	Deduplicated uint64
+
+	// Shed 是因积压而被拒绝或者被丢弃的消息数量
+	// Shed is the number of messages rejected or dropped due to the backlog
+	Shed uint64
+
+	// Stragglers 是超时后仍在后台运行的处理函数数量，它是当前值而不是累计值，持续增长说明处理函数没有响应上下文
+	// Stragglers is the number of handle functions still running in the background after the timeout, it is a current value rather than a cumulative one, a steady growth means that the handle functions do not honour the context
+	Stragglers int64
+}
+
+// metrics 是流控制器内部的原子计数器
+// metrics are the atomic counters inside the flow controller
+type metrics struct {
+	submitted    atomic.Uint64
+	rejected     atomic.Uint64
+	limited      atomic.Uint64
+	succeeded    atomic.Uint64
+	failed       atomic.Uint64
+	retried      atomic.Uint64
+	timedOut     atomic.Uint64
+	panicked     atomic.Uint64
+	coalesced    atomic.Uint64
+	dropped      atomic.Uint64
+	deduplicated atomic.Uint64
+	shed         atomic.Uint64
+	stragglers   atomic.Int64
+}
+
+// count 是一个方法，它根据提交的错误统计被接受或被拒绝的消息
+// count is a method that counts the accepted or rejected messages according to the error of the submission
+func (m *metrics) count(err error) {
+	if err != nil {
+		m.rejected.Add(1)
+	} else {
+		m.submitted.Add(1)
+	}
+}
+
+// Metrics 是一个方法，它返回流控制器的计数器快照
+// Metrics is a method that returns a snapshot of the counters of the flow controller
+func (fc *FlowController) Metrics() Metrics {
+	return Metrics{
+		Submitted:    fc.metrics.submitted.Load(),
+		Rejected:     fc.metrics.rejected.Load(),
+		Limited:      fc.metrics.limited.Load(),
+		Succeeded:    fc.metrics.succeeded.Load(),
+		Failed:       fc.metrics.failed.Load(),
+		Retried:      fc.metrics.retried.Load(),
+		TimedOut:     fc.metrics.timedOut.Load(),
+		Panicked:     fc.metrics.panicked.Load(),
+		Coalesced:    fc.metrics.coalesced.Load(),
+		Dropped:      fc.metrics.dropped.Load(),
+		Deduplicated: fc.metrics.deduplicated.Load(),
+		Shed:         fc.metrics.shed.Load(),
+		Stragglers:   fc.metrics.stragglers.Load(),
+	}
+}
@@ -0,0 +1,46 @@
+package regula
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError 是消息处理函数发生 panic 时返回的错误，它包含 panic 的值和堆栈
+// PanicError is the error returned when the message handle function panics, it contains the value and stack of the panic
+type PanicError struct {
+	// Value 是 panic 的值
+	// Value is the value of the panic
+	Value any
+
+	// Stack 是发生 panic 时的堆栈
+	// Stack is the stack when the panic occurred
+	Stack []byte
+}
+
+// Error 是一个方法，它返回错误信息
+// Error is a method that returns the error message
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("message handler panic: %v", e.Value)
+}
+
+// Unwrap 是一个方法，如果 panic 的值是一个错误，它返回该错误
+// Unwrap is a method that returns the error if the value of the panic is an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// safeCall 是一个函数，它执行消息处理函数，并把 panic 恢复为 PanicError，这样一个有问题的消息不会终止管道的工作者
+// safeCall is a function that executes the message handle function and recovers the panic as PanicError, so that one bad message cannot kill the pipeline worker
+func safeCall(fn ContextHandleFunc, ctx context.Context, msg any) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return fn(ctx, msg)
+}
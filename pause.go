@@ -0,0 +1,97 @@
+package regula
+
+import "sync/atomic"
+
+// Pause 是一个方法，它暂停流控制器。暂停期间 Do 仍然接受消息，但只把它们保留在有界的暂存区中，不会消耗速率限制器的令牌
+// Pause is a method that pauses the flow controller. During the pause, Do still accepts messages, but only keeps them in a bounded holding area without consuming tokens of the rate limiter
+// 如果在暂停期间调用 Shutdown，保留的消息会在上下文结束时作为被放弃的消息返回
+// If Shutdown is called during the pause, the kept messages are returned as abandoned messages when the context ends
+func (fc *FlowController) Pause() {
+	fc.lock.Lock()
+
+	// 如果已经暂停，不执行任何操作
+	// If it is already paused, do nothing
+	if fc.paused {
+		fc.lock.Unlock()
+		return
+	}
+	fc.paused = true
+	fc.lock.Unlock()
+
+	// 调用回调函数，通知流控制器已暂停
+	// Call the callback function to notify that the flow controller is paused
+	fc.onPaused()
+}
+
+// Resume 是一个方法，它恢复流控制器，暂停期间保留的消息会按顺序重新通过速率限制器，以配置的速率释放，而不是一次性全部执行
+// Resume is a method that resumes the flow controller, the messages kept during the pause go through the rate limiter again in order and are released at the configured rate instead of all at once
+func (fc *FlowController) Resume() {
+	fc.lock.Lock()
+
+	// 如果没有暂停，不执行任何操作
+	// If it is not paused, do nothing
+	if !fc.paused {
+		fc.lock.Unlock()
+		return
+	}
+	fc.paused = false
+
+	// 取出暂停期间保留的任务
+	// Take out the tasks kept during the pause
+	held := fc.held
+	fc.held = nil
+	fc.lock.Unlock()
+
+	// 调用回调函数，通知流控制器已恢复
+	// Call the callback function to notify that the flow controller is resumed
+	fc.onResumed(len(held))
+
+	// 按顺序提交保留的任务，每个任务都会消耗一个令牌，从而按配置的速率释放
+	// Submit the kept tasks in order, each task consumes a token, so they are released at the configured rate
+	for _, t := range held {
+		// 跳过在关闭时已被放弃的任务
+		// Skip the tasks that have been abandoned during shutdown
+		if atomic.LoadInt32(&t.state) != taskPending {
+			continue
+		}
+
+		// 如果分发失败并且任务没有被放弃，以失败结束任务
+		// If the dispatch fails and the task has not been abandoned, finish the task with failure
+		if err := fc.dispatch(t); err != nil && atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning) {
+			fc.finish(t, nil, err)
+		}
+	}
+}
+
+// Paused 是一个方法，它返回流控制器是否处于暂停状态
+// Paused is a method that returns whether the flow controller is paused
+func (fc *FlowController) Paused() bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	return fc.paused
+}
+
+// hold 是一个方法，如果流控制器处于暂停状态，它把任务保留在暂存区中并返回 true，如果暂存区已满，返回 ErrPauseCapacityExceeded
+// hold is a method that keeps the task in the holding area and returns true if the flow controller is paused, if the holding area is full, it returns ErrPauseCapacityExceeded
+func (fc *FlowController) hold(t *task) (bool, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	// 如果没有暂停，任务需要正常提交
+	// If it is not paused, the task needs to be submitted normally
+	if !fc.paused {
+		return false, nil
+	}
+
+	// 如果暂存区已满，拒绝任务
+	// If the holding area is full, reject the task
+	if len(fc.held) >= fc.config.pauseCapacity {
+		return false, ErrPauseCapacityExceeded
+	}
+
+	// 保留任务
+	// Keep the task
+	fc.held = append(fc.held, t)
+	return true, nil
+}
@@ -0,0 +1,185 @@
+package regula
+
+import (
+	"fmt"
+	"time"
+)
+
+// RegisterHandler 是一个方法，它按名称注册消息处理函数，DoNamed 和 Replay 通过名称查找处理函数，而不是保存闭包
+// RegisterHandler is a method that registers the message handle function by name, DoNamed and Replay look up the handle function by name instead of keeping closures
+func (fc *FlowController) RegisterHandler(name string, fn MessageHandleFunc) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.handlers[name] = fn
+}
+
+// handler 是一个方法，它按名称查找已注册的消息处理函数
+// handler is a method that looks up the registered message handle function by name
+func (fc *FlowController) handler(name string) (MessageHandleFunc, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fn, ok := fc.handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrHandlerNotRegistered, name)
+	}
+	return fn, nil
+}
+
+// DoNamed 是一个方法，它使用按名称注册的处理函数执行消息。如果配置了持久化存储，消息在被接受时编码保存，
+// 直到最终完成或者被丢弃才删除，进程重启后可以通过 Replay 重放
+// DoNamed is a method that executes the message with the handle function registered by name. If the durable store is configured, the message is encoded and saved when it is accepted,
+// and deleted only when it finally completes or is dropped, so it can be replayed through Replay after the process restarts
+func (fc *FlowController) DoNamed(name string, msg any) error {
+	_, err := fc.doNamed(name, msg)
+	return err
+}
+
+// DoNamedWithFuture 是一个方法，它与 DoNamed 相同，但返回一个异步结果
+// DoNamedWithFuture is a method that is the same as DoNamed, but returns an asynchronous result
+func (fc *FlowController) DoNamedWithFuture(name string, msg any) (*Future, error) {
+	return fc.doNamed(name, msg)
+}
+
+// doNamed 是一个方法，它创建使用已注册处理函数的任务，编码消息并提交任务
+// doNamed is a method that creates the task using the registered handle function, encodes the message and submits the task
+func (fc *FlowController) doNamed(name string, msg any) (*Future, error) {
+	fn, err := fc.handler(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTask(withoutContext(fn), msg)
+	t.future = newFuture()
+
+	// 编码消息，提交时保存到持久化存储中
+	// Encode the message, it is saved into the durable store when submitted
+	if fc.config.store != nil {
+		payload, err := fc.config.codec.Encode(msg)
+		if err != nil {
+			return nil, err
+		}
+		t.record = &StoreRecord{Handler: name, Payload: payload}
+	}
+
+	if err := fc.do(t); err != nil {
+		return nil, err
+	}
+	return t.future, nil
+}
+
+// persist 是一个方法，它把任务的记录和计划执行的时间保存到持久化存储中，任务被延迟时更新计划执行的时间
+// persist is a method that saves the record of the task and the scheduled time into the durable store, the scheduled time is updated when the task is delayed
+func (fc *FlowController) persist(t *task, delay time.Duration) error {
+	if t.record == nil {
+		return nil
+	}
+	t.record.Due = time.Now().Add(delay)
+	return fc.config.store.Save(t.record)
+}
+
+// unpersist 是一个方法，它在任务最终完成或者被丢弃后从持久化存储中删除它的记录，删除失败的记录会在重启后重放
+// unpersist is a method that deletes the record of the task from the durable store after the task finally completes or is dropped, records that fail to be deleted are replayed after a restart
+func (fc *FlowController) unpersist(t *task) {
+	if t.record == nil || t.record.ID == 0 {
+		return
+	}
+	_ = fc.config.store.Delete(t.record.ID)
+	t.record = nil
+}
+
+// Replay 是一个方法，它在启动时重放持久化存储中的消息，应在注册处理函数之后调用。消息在剩余的延迟结束后重新经过熔断器和速率限制器，
+// 因此重启后的速率仍然受到限制。暂停和去重与新的消息一样作用于重放的消息。处理函数未注册或者无法解码的记录会被保留，并返回第一个错误。返回值是重放的消息数量
+// Replay is a method that replays the messages in the durable store on startup, it should be called after the handle functions are registered. Messages go through the circuit breaker and the rate limiter again after their remaining delay,
+// so the rate is still limited after a restart. The pause and deduplication apply to replayed messages like new ones. Records whose handle function is not registered or that cannot be decoded are kept, and the first error is returned. The return value is the number of replayed messages
+func (fc *FlowController) Replay() (int, error) {
+	if fc.config.store == nil {
+		return 0, ErrStoreIsNil
+	}
+
+	records, err := fc.config.store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	var first error
+	replayed := 0
+	for _, record := range records {
+		if err := fc.replay(record); err != nil {
+			if first == nil {
+				first = fmt.Errorf("replay record %d: %w", record.ID, err)
+			}
+			continue
+		}
+		replayed++
+	}
+	return replayed, first
+}
+
+// replay 是一个方法，它重放一条记录。记录与新的消息一样登记、按去重键登记为正在等待并检查暂停状态，然后在剩余的延迟结束后重新分发。
+// 已经到期的记录立即经过熔断器，可以继承因积压而被丢弃的任务的时间槽
+// replay is a method that replays a record. The record is registered, registered as waiting by the deduplication key and checked against the pause like a new message, then it is dispatched again after its remaining delay.
+// A record that is already due goes through the circuit breaker at once, and it can inherit the slot of the task dropped due to the backlog
+func (fc *FlowController) replay(record *StoreRecord) error {
+	fn, err := fc.handler(record.Handler)
+	if err != nil {
+		return err
+	}
+	msg, err := fc.config.codec.Decode(record.Payload)
+	if err != nil {
+		return err
+	}
+
+	t := newTask(withoutContext(fn), msg)
+	t.record = record
+	victim, err := fc.admit(t)
+	if err != nil {
+		return err
+	}
+	fc.enqueue(t)
+
+	if err = fc.redispatch(t, victim); err != nil {
+		fc.forget(t)
+	}
+	return err
+}
+
+// redispatch 是一个方法，它把重放的任务保留在暂停的暂存区中，或者在剩余的延迟结束后重新分发，已经到期的任务立即经过熔断器和速率限制器
+// redispatch is a method that keeps the replayed task in the holding area of the pause, or dispatches it again after its remaining delay, a task that is already due goes through the circuit breaker and the rate limiter at once
+func (fc *FlowController) redispatch(t *task, victim *task) error {
+	// 如果流控制器处于暂停状态，保留任务，恢复后按速率释放
+	// If the flow controller is paused, keep the task, it is released at the rate after resuming
+	if held, err := fc.hold(t); held || err != nil {
+		return err
+	}
+
+	// 剩余的延迟结束后重新经过熔断器和速率限制器
+	// Go through the circuit breaker and the rate limiter again after the remaining delay
+	if delay := time.Until(t.record.Due); delay > 0 {
+		return fc.pipline.SubmitAfterWithFunc(t.readmit(fc), t.msg, delay)
+	}
+
+	// 已经到期，熔断器放行后继承被丢弃的任务的时间槽，或者自己预留令牌
+	// It is already due, after the circuit breaker lets it through, it inherits the slot of the dropped task or reserves a token by itself
+	if deferred, err := fc.guard(t); deferred || err != nil {
+		return err
+	}
+	if fc.inherit(victim, t) {
+		return nil
+	}
+	return fc.submit(t)
+}
+
+// forget 是一个方法，它注销重放失败的任务但保留它的记录，以便下次重放
+// forget is a method that unregisters a task that failed to be replayed but keeps its record, so it can be replayed next time
+func (fc *FlowController) forget(t *task) {
+	fc.unprobe(t)
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	delete(fc.tasks, t)
+	fc.dequeueLocked(t)
+	fc.notifyDrained()
+}
@@ -0,0 +1,253 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultCheckpointInterval 是默认的快照保存间隔
+// DefaultCheckpointInterval is the default interval of saving snapshots
+const DefaultCheckpointInterval = time.Second * 5
+
+// CheckpointFile 是快照文件的结构，快照按限流器名称索引
+// CheckpointFile is the structure of the snapshot file, the snapshots are indexed by limiter name
+type CheckpointFile struct {
+	// Version 是文件的编码版本
+	// Version is the encoding version of the file
+	Version int `json:"version"`
+
+	// Limiters 是按名称索引的限流器快照
+	// Limiters are the limiter snapshots indexed by name
+	Limiters map[string]*Snapshot `json:"limiters"`
+}
+
+// CheckpointConfig 是快照保存器的配置
+// CheckpointConfig is the configuration of the checkpointer
+type CheckpointConfig struct {
+	// interval 是快照保存间隔
+	// interval is the interval of saving snapshots
+	interval time.Duration
+
+	// onError 是后台保存失败时调用的函数
+	// onError is the function called when saving in the background fails
+	onError func(err error)
+}
+
+// NewCheckpointConfig 是创建新的快照保存器配置的函数
+// NewCheckpointConfig is a function to create a new checkpointer configuration
+func NewCheckpointConfig() *CheckpointConfig {
+	return &CheckpointConfig{
+		interval: DefaultCheckpointInterval,
+		onError:  func(error) {},
+	}
+}
+
+// DefaultCheckpointConfig 是获取默认快照保存器配置的函数
+// DefaultCheckpointConfig is a function to get the default checkpointer configuration
+func DefaultCheckpointConfig() *CheckpointConfig {
+	return NewCheckpointConfig()
+}
+
+// WithInterval 它设置快照保存间隔
+// WithInterval is a method that sets the interval of saving snapshots
+func (c *CheckpointConfig) WithInterval(interval time.Duration) *CheckpointConfig {
+	c.interval = interval
+	return c
+}
+
+// WithErrorHandler 它设置后台保存失败时调用的函数
+// WithErrorHandler is a method that sets the function called when saving in the background fails
+func (c *CheckpointConfig) WithErrorHandler(fn func(err error)) *CheckpointConfig {
+	c.onError = fn
+	return c
+}
+
+// isCheckpointConfigValid 是一个函数，它检查快照保存器配置是否有效，如果无效，它将设置为默认值
+// isCheckpointConfigValid is a function that checks if the checkpointer configuration is valid, if not, it sets it to the default values
+func isCheckpointConfigValid(conf *CheckpointConfig) *CheckpointConfig {
+	if conf != nil {
+		if conf.interval <= 0 {
+			conf.interval = DefaultCheckpointInterval
+		}
+		if conf.onError == nil {
+			conf.onError = func(error) {}
+		}
+	} else {
+		conf = DefaultCheckpointConfig()
+	}
+	return conf
+}
+
+// Checkpointer 是快照保存器，它按间隔把一组命名限流器的快照保存到文件，并在注册时从文件恢复它们，
+// 使进程重启后限流器不会以装满的桶开始
+// Checkpointer is the checkpointer, it saves the snapshots of a set of named limiters to a file at intervals, and restores them from the file when they are registered,
+// so that the limiters do not start with a full bucket after the process restarts
+type Checkpointer struct {
+	// path 是快照文件的路径
+	// path is the path of the snapshot file
+	path string
+
+	// config 是快照保存器的配置
+	// config is the configuration of the checkpointer
+	config *CheckpointConfig
+
+	// lock 保护已注册的限流器和已加载的快照
+	// lock protects the registered limiters and the loaded snapshots
+	lock sync.Mutex
+
+	// limiters 是按名称索引的已注册限流器
+	// limiters are the registered limiters indexed by name
+	limiters map[string]Snapshotter
+
+	// saved 是从文件加载的快照，没有注册的名称在保存时被保留
+	// saved are the snapshots loaded from the file, names that are not registered are kept when saving
+	saved map[string]*Snapshot
+
+	// ctx 和 cancel 用于管理保存协程的生命周期
+	// ctx and cancel are used to manage the lifecycle of the saving goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg 用于等待保存协程退出
+	// wg is used to wait for the saving goroutine to exit
+	wg sync.WaitGroup
+
+	// once 用于确保快照保存器只被停止一次
+	// once is used to ensure that the checkpointer is stopped only once
+	once sync.Once
+}
+
+// NewCheckpointer 是创建新的快照保存器的函数，它立即加载快照文件，文件不存在时从空开始，然后在后台按间隔保存
+// NewCheckpointer is a function to create a new checkpointer, it loads the snapshot file immediately, starts empty if the file does not exist, and then saves in the background at intervals
+func NewCheckpointer(path string, conf *CheckpointConfig) (*Checkpointer, error) {
+	c := &Checkpointer{
+		path:     path,
+		config:   isCheckpointConfigValid(conf),
+		limiters: make(map[string]Snapshotter),
+		saved:    make(map[string]*Snapshot),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var file CheckpointFile
+		if err = json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		if file.Version < 1 || file.Version > SnapshotVersion {
+			return nil, fmt.Errorf("%w, got %d", ErrSnapshotVersion, file.Version)
+		}
+		for name, s := range file.Limiters {
+			if s != nil {
+				c.saved[name] = s
+			}
+		}
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.saver()
+
+	return c, nil
+}
+
+// Register 是一个方法，它注册一个命名限流器，如果文件中有该名称的快照，会立即恢复
+// Register is a method that registers a named limiter, if there is a snapshot of the name in the file, it is restored immediately
+func (c *Checkpointer) Register(name string, limiter Snapshotter) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limiters[name] = limiter
+
+	if s, ok := c.saved[name]; ok {
+		if err := limiter.Restore(s); err != nil {
+			return fmt.Errorf("limiter %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Save 是一个方法，它立即把所有已注册限流器的快照写入文件，写入临时文件后再替换，不会留下写了一半的文件
+// Save is a method that immediately writes the snapshots of all registered limiters to the file, it writes a temporary file and then replaces it, so no half written file is left
+func (c *Checkpointer) Save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	file := CheckpointFile{Version: SnapshotVersion, Limiters: make(map[string]*Snapshot, len(c.saved)+len(c.limiters))}
+	for name, s := range c.saved {
+		file.Limiters[name] = s
+	}
+	for name, limiter := range c.limiters {
+		file.Limiters[name] = limiter.Snapshot()
+	}
+
+	data, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// writeFileAtomic 是一个函数，它把数据写入临时文件后再替换目标文件，不会留下写了一半的文件
+// writeFileAtomic is a function that writes the data to a temporary file and then replaces the target file, so no half written file is left
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stop 是一个方法，它停止后台保存，并最后保存一次，返回最后一次保存的错误
+// Stop is a method that stops saving in the background and saves one last time, it returns the error of the last save
+func (c *Checkpointer) Stop() error {
+	var err error
+	c.once.Do(func() {
+		c.cancel()
+		c.wg.Wait()
+		err = c.Save()
+	})
+	return err
+}
+
+// saver 是一个方法，它按间隔保存快照，失败时调用错误处理函数并在下一个间隔重试
+// saver is a method that saves the snapshots at intervals, it calls the error handler on failure and retries at the next interval
+func (c *Checkpointer) saver() {
+	ticker := time.NewTicker(c.config.interval)
+
+	defer func() {
+		ticker.Stop()
+		c.wg.Done()
+	}()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				c.config.onError(err)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package ratelimiter
 
 import (
+	"fmt"
 	"math"
 
 	"golang.org/x/time/rate"
@@ -74,6 +75,32 @@ func (c *Config) WithBurst(burst int64) *Config {
 	return c
 }
 
+// Validate 是一个方法，它严格检查配置是否有效，如果无效，它返回描述性错误而不是设置为默认值
+// Validate is a method that strictly checks if the configuration is valid, if not, it returns a descriptive error instead of setting default values
+func (c *Config) Validate() error {
+	// 如果配置为空，返回错误
+	// If the configuration is null, return an error
+	if c == nil {
+		return ErrConfigIsNil
+	}
+
+	// 如果配置的速率小于等于0，或者不是有限数，返回错误
+	// If the rate of the configuration is less than or equal to 0, or is not a finite number, return an error
+	if c.rate <= 0 || math.IsNaN(c.rate) || math.IsInf(c.rate, 0) {
+		return fmt.Errorf("%w, got %v", ErrInvalidRate, c.rate)
+	}
+
+	// 如果配置的突发小于等于0，返回错误
+	// If the burst of the configuration is less than or equal to 0, return an error
+	if c.burst <= 0 {
+		return fmt.Errorf("%w, got %d", ErrInvalidBurst, c.burst)
+	}
+
+	// 配置有效
+	// The configuration is valid
+	return nil
+}
+
 // isConfigValid 是一个函数，它检查配置是否有效，如果无效，它将设置为默认值
 // isConfigValid is a function that checks if the configuration is valid, if not, it sets it to the default values
 func isConfigValid(conf *Config) *Config {
@@ -0,0 +1,69 @@
+package ratelimiter
+
+import "errors"
+
+var (
+	// ErrConfigIsNil 表示配置为空
+	// ErrConfigIsNil indicates that the configuration is nil
+	ErrConfigIsNil = errors.New("ratelimiter config is nil")
+
+	// ErrInvalidRate 表示配置的速率无效，速率必须是大于 0 的有限数
+	// ErrInvalidRate indicates that the rate of the configuration is invalid, the rate must be a finite number greater than 0
+	ErrInvalidRate = errors.New("ratelimiter rate must be a finite number greater than 0")
+
+	// ErrInvalidBurst 表示配置的突发无效，突发必须大于 0
+	// ErrInvalidBurst indicates that the burst of the configuration is invalid, the burst must be greater than 0
+	ErrInvalidBurst = errors.New("ratelimiter burst must be greater than 0")
+
+	// ErrInvalidSnapshot 表示快照为空或者无法解码
+	// ErrInvalidSnapshot indicates that the snapshot is nil or cannot be decoded
+	ErrInvalidSnapshot = errors.New("ratelimiter snapshot is invalid")
+
+	// ErrSnapshotVersion 表示快照的编码版本不受支持
+	// ErrSnapshotVersion indicates that the encoding version of the snapshot is not supported
+	ErrSnapshotVersion = errors.New("ratelimiter snapshot version is not supported")
+
+	// ErrSnapshotKind 表示快照的限流器类型与目标限流器不一致
+	// ErrSnapshotKind indicates that the limiter type of the snapshot does not match the target limiter
+	ErrSnapshotKind = errors.New("ratelimiter snapshot kind does not match the limiter")
+
+	// ErrInvalidAddress 表示服务器地址为空
+	// ErrInvalidAddress indicates that the server address is empty
+	ErrInvalidAddress = errors.New("ratelimiter server address is empty")
+
+	// ErrInvalidWindow 表示滑动窗口的上限或者长度无效，它们必须大于 0
+	// ErrInvalidWindow indicates that the limit or length of the sliding window is invalid, they must be greater than 0
+	ErrInvalidWindow = errors.New("ratelimiter window limit and length must be greater than 0")
+
+	// ErrRedisProtocol 表示服务器的回复不符合 RESP 协议或者不是预期的类型
+	// ErrRedisProtocol indicates that the reply of the server does not follow the RESP protocol or is not the expected type
+	ErrRedisProtocol = errors.New("ratelimiter redis protocol error")
+
+	// ErrRedisPoolExhausted 表示连接数已达到上限，并且在连接超时时间内没有连接被释放
+	// ErrRedisPoolExhausted indicates that the number of connections has reached the limit and no connection was released within the dial timeout
+	ErrRedisPoolExhausted = errors.New("ratelimiter redis connection pool exhausted")
+
+	// ErrInvalidPath 表示共享内存文件的路径为空
+	// ErrInvalidPath indicates that the path of the shared memory file is empty
+	ErrInvalidPath = errors.New("ratelimiter shared file path is empty")
+
+	// ErrSharedUnsupported 表示当前平台不支持共享内存文件
+	// ErrSharedUnsupported indicates that the shared memory file is not supported on the current platform
+	ErrSharedUnsupported = errors.New("ratelimiter shared file is not supported on this platform")
+
+	// ErrInvalidQuota 表示配额无效，配额必须大于 0，并且重置周期必须是日历单位
+	// ErrInvalidQuota indicates that the quota is invalid, the quota must be greater than 0 and the reset period must be a calendar unit
+	ErrInvalidQuota = errors.New("ratelimiter quota must be greater than 0 with a calendar reset period")
+
+	// ErrQuotaExhausted 表示当前周期的配额已用尽，具体的错误是包含重置时间的 QuotaError
+	// ErrQuotaExhausted indicates that the quota of the current period is exhausted, the concrete error is QuotaError which contains the reset time
+	ErrQuotaExhausted = errors.New("ratelimiter quota is exhausted")
+
+	// ErrInvalidSchedule 表示定时限流器的时间窗口无效
+	// ErrInvalidSchedule indicates that the time window of the scheduled limiter is invalid
+	ErrInvalidSchedule = errors.New("ratelimiter schedule time window is invalid")
+
+	// ErrInvalidWarmUp 表示预热配置无效，预热时间和回到冷状态的时间必须大于 0，冷启动系数必须不小于 1
+	// ErrInvalidWarmUp indicates that the warm-up configuration is invalid, the warm-up time and the time to become cold again must be greater than 0, and the cold factor must not be less than 1
+	ErrInvalidWarmUp = errors.New("ratelimiter warm-up time and cold after must be greater than 0 and cold factor must not be less than 1")
+)
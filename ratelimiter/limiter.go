@@ -1,6 +1,7 @@
 package ratelimiter
 
 import (
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -10,9 +11,21 @@ import (
 // DefaultEffectiveTimeSliceInterval is the default effective time slice interval
 const DefaultEffectiveTimeSliceInterval = time.Millisecond * 100
 
+// RateLimiter 是一个接口，定义了一个方法，该方法返回下一个事件的延迟时间，它与 regula.RateLimiter 相同
+// RateLimiter is an interface that defines a method that returns the delay time of the next event, it is the same as regula.RateLimiter
+type RateLimiter = interface {
+	// When 返回下一个事件的延迟时间
+	// When returns the delay time of the next event
+	When() time.Duration
+}
+
 // Limiter 是一个限流器结构体，包含了一个 rate.Limiter
 // Limiter is a structure for rate limiter, it includes a rate.Limiter
 type Limiter struct {
+	// lock 让速率和突发值的修改对预留是原子的，预留之间共享读锁
+	// lock makes changes of the rate and the burst atomic for reservations, reservations share the read lock
+	lock sync.RWMutex
+
 	// limiter 是 rate.Limiter 的实例
 	// limiter is an instance of rate.Limiter
 	limiter *rate.Limiter
@@ -34,12 +47,116 @@ func NewRateLimiter(conf *Config) *Limiter {
 	}
 }
 
+// NewStrictRateLimiter 是创建新的限流器的函数，与 NewRateLimiter 不同，它不会静默地使用默认值，而是在配置无效时返回错误
+// NewStrictRateLimiter is a function to create a new rate limiter, unlike NewRateLimiter, it does not silently use default values, but returns an error when the configuration is invalid
+func NewStrictRateLimiter(conf *Config) (*Limiter, error) {
+	// 严格检查配置是否有效
+	// Strictly check if the configuration is valid
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 配置有效，创建限流器
+	// The configuration is valid, create the rate limiter
+	return NewRateLimiter(conf), nil
+}
+
 // When 是一个方法，它返回下一个事件发生的延迟时间
 // When is a method that returns the delay for the next event to occur
 func (l *Limiter) When() time.Duration {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
 	return l.limiter.Reserve().Delay()
 }
 
+// WhenN 是一个方法，它为 n 个事件预留令牌，并返回每个事件错开的延迟时间。每次限流器操作最多预留突发值个令牌，
+// 所以 n 不超过突发值时只有一次操作，否则按突发值分块，每块一次操作。无法预留的事件（例如速率为 0 时）得到 rate.InfDuration
+// WhenN is a method that reserves tokens for n events and returns the staggered delay of each event. Each limiter operation reserves at most burst tokens,
+// so there is only one operation when n does not exceed the burst, otherwise the tokens are reserved in chunks of the burst, one operation per chunk. Events that cannot be reserved (for example when the rate is 0) get rate.InfDuration
+func (l *Limiter) WhenN(n int) []time.Duration {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	delays := make([]time.Duration, n)
+
+	// 速率为 0 时没有令牌会被补充，只有桶中现有的令牌可以预留，间隔为 0 避免除以 0
+	// When the rate is 0, no tokens are refilled and only the tokens in the bucket can be reserved, the interval is 0 to avoid dividing by 0
+	var interval float64
+	if limit := l.limiter.Limit(); limit > 0 && limit != rate.Inf {
+		interval = float64(time.Second) / float64(limit)
+	}
+
+	for start := 0; start < n; {
+		// 每块最多预留突发值个令牌
+		// Reserve at most burst tokens per chunk
+		size := n - start
+		if burst := l.limiter.Burst(); burst > 0 && size > burst {
+			size = burst
+		}
+
+		// 一次预留整块令牌，得到最后一个令牌的延迟时间，预留失败时没有令牌被预留，剩余的事件都无法放行
+		// Reserve the whole chunk at once and get the delay of the last token, no tokens are reserved when it fails, so none of the remaining events can be admitted
+		r := l.limiter.ReserveN(time.Now(), size)
+		if !r.OK() {
+			for i := start; i < n; i++ {
+				delays[i] = rate.InfDuration
+			}
+			break
+		}
+		last := r.Delay()
+
+		// 第 i 个令牌比最后一个令牌早 (size-1-i) 个间隔可用
+		// The i-th token is available (size-1-i) intervals earlier than the last token
+		for i := 0; i < size; i++ {
+			if delay := last - time.Duration(float64(size-1-i)*interval); delay > 0 {
+				delays[start+i] = delay
+			}
+		}
+
+		start += size
+	}
+
+	return delays
+}
+
+// SetRate 是一个方法，它在运行时原子地修改限流器的速率，已有的令牌会被保留
+// SetRate is a method that atomically changes the rate of the limiter at runtime, existing tokens are preserved
+func (l *Limiter) SetRate(limit float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limiter.SetLimit(rate.Limit(limit))
+}
+
+// SetBurst 是一个方法，它在运行时原子地修改限流器的突发值
+// SetBurst is a method that atomically changes the burst of the limiter at runtime
+func (l *Limiter) SetBurst(burst int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.limiter.SetBurst(int(burst))
+}
+
+// SetLimit 是一个方法，它在运行时一次修改限流器的速率和突发值，并发的预留不会看到新的速率和旧的突发值，已有的令牌会被保留
+// SetLimit is a method that changes the rate and the burst of the limiter at once at runtime, a concurrent reservation never sees the new rate with the old burst, existing tokens are preserved
+func (l *Limiter) SetLimit(limit float64, burst int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.limiter.SetLimitAt(now, rate.Limit(limit))
+	l.limiter.SetBurstAt(now, int(burst))
+}
+
+// Rate 是一个方法，它返回限流器当前的速率
+// Rate is a method that returns the current rate of the limiter
+func (l *Limiter) Rate() float64 {
+	return float64(l.limiter.Limit())
+}
+
+// Burst 是一个方法，它返回限流器当前的突发值
+// Burst is a method that returns the current burst of the limiter
+func (l *Limiter) Burst() int64 {
+	return int64(l.limiter.Burst())
+}
+
 // NopLimiter 是一个不执行任何操作的限流器结构体
 // NopLimiter is a structure for a limiter that does not perform any operations
 type NopLimiter struct{}
@@ -48,6 +165,10 @@ type NopLimiter struct{}
 // When is a method that always returns 0, indicating no delay
 func (l *NopLimiter) When() time.Duration { return 0 }
 
+// WhenN 是一个方法，它总是返回 n 个 0，表示没有延迟
+// WhenN is a method that always returns n zeros, indicating no delay
+func (l *NopLimiter) WhenN(n int) []time.Duration { return make([]time.Duration, n) }
+
 // NewNopLimiter 是创建新的不执行任何操作的限流器的函数
 // NewNopLimiter is a function to create a new limiter that does not perform any operations
 func NewNopLimiter() *NopLimiter {
@@ -0,0 +1,501 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQuotaName 是默认的配额名称，它是配额在存储中的键
+	// DefaultQuotaName is the default name of the quota, it is the key of the quota in the store
+	DefaultQuotaName = "default"
+
+	// DefaultQuotaSaveInterval 是默认的配额保存间隔
+	// DefaultQuotaSaveInterval is the default interval of saving the quota
+	DefaultQuotaSaveInterval = time.Second
+)
+
+// QuotaError 是配额用尽时返回的错误，它包含配额重置的时间，errors.Is(err, ErrQuotaExhausted) 为真
+// QuotaError is the error returned when the quota is exhausted, it contains the time the quota resets, errors.Is(err, ErrQuotaExhausted) is true
+type QuotaError struct {
+	// Name 是配额的名称
+	// Name is the name of the quota
+	Name string
+
+	// Limit 是每个周期的配额
+	// Limit is the quota of each period
+	Limit int64
+
+	// ResetAt 是配额重置的时间
+	// ResetAt is the time the quota resets
+	ResetAt time.Time
+}
+
+// Error 是一个方法，它返回错误信息
+// Error is a method that returns the error message
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("ratelimiter quota %q of %d is exhausted until %s", e.Name, e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+// Unwrap 是一个方法，它返回 ErrQuotaExhausted
+// Unwrap is a method that returns ErrQuotaExhausted
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExhausted
+}
+
+// QuotaStore 是配额的存储，它按名称保存当前周期的开始时间和已使用的数量，使配额在重启后保留
+// QuotaStore is the store of quotas, it saves the start of the current period and the used amount by name, so that the quota survives restarts
+type QuotaStore interface {
+	// Load 返回指定名称的配额状态，没有保存过时返回 false
+	// Load returns the quota state of the specified name, it returns false if it has never been saved
+	Load(name string) (WindowState, bool, error)
+
+	// Save 保存指定名称的配额状态
+	// Save saves the quota state of the specified name
+	Save(name string, state WindowState) error
+}
+
+// quotaFile 是配额文件的结构，配额按名称索引
+// quotaFile is the structure of the quota file, the quotas are indexed by name
+type quotaFile struct {
+	Version int                    `json:"version"`
+	Quotas  map[string]WindowState `json:"quotas"`
+}
+
+// FileQuotaStore 是基于文件的配额存储，所有配额保存在一个 JSON 文件中，每次保存都原子地替换文件
+// FileQuotaStore is the file based quota store, all quotas are kept in one JSON file, and every save replaces the file atomically
+type FileQuotaStore struct {
+	// path 是配额文件的路径
+	// path is the path of the quota file
+	path string
+
+	// lock 串行化对文件的读写
+	// lock serializes the reads and writes of the file
+	lock sync.Mutex
+}
+
+// NewFileQuotaStore 是创建新的基于文件的配额存储的函数，文件在第一次保存时创建，同一个文件中的配额必须共享一个存储
+// NewFileQuotaStore is a function to create a new file based quota store, the file is created on the first save, quotas in the same file must share one store
+func NewFileQuotaStore(path string) *FileQuotaStore {
+	return &FileQuotaStore{path: path}
+}
+
+// Load 是一个方法，它从文件中读取指定名称的配额状态
+// Load is a method that reads the quota state of the specified name from the file
+func (s *FileQuotaStore) Load(name string) (WindowState, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := s.read()
+	if err != nil {
+		return WindowState{}, false, err
+	}
+	state, ok := file.Quotas[name]
+	return state, ok, nil
+}
+
+// Save 是一个方法，它把指定名称的配额状态写入文件，文件中的其他配额被保留
+// Save is a method that writes the quota state of the specified name to the file, the other quotas in the file are kept
+func (s *FileQuotaStore) Save(name string, state WindowState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := s.read()
+	if err != nil {
+		return err
+	}
+	file.Quotas[name] = state
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// read 是一个方法，它读取配额文件，文件不存在时返回空的配额
+// read is a method that reads the quota file, it returns empty quotas if the file does not exist
+func (s *FileQuotaStore) read() (*quotaFile, error) {
+	file := &quotaFile{Version: SnapshotVersion, Quotas: make(map[string]WindowState)}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if file.Version < 1 || file.Version > SnapshotVersion {
+		return nil, fmt.Errorf("%w, got %d", ErrSnapshotVersion, file.Version)
+	}
+	if file.Quotas == nil {
+		file.Quotas = make(map[string]WindowState)
+	}
+	return file, nil
+}
+
+// QuotaConfig 是配额限流器的配置
+// QuotaConfig is the configuration of the quota limiter
+type QuotaConfig struct {
+	// name 是配额的名称
+	// name is the name of the quota
+	name string
+
+	// limit 是每个周期的配额
+	// limit is the quota of each period
+	limit int64
+
+	// period 是配额的重置周期，配额在日历边界重置
+	// period is the reset period of the quota, the quota resets on the calendar boundary
+	period CalendarUnit
+
+	// location 是日历边界使用的时区
+	// location is the time zone used by the calendar boundaries
+	location *time.Location
+
+	// store 是配额的存储，为空时配额不会在重启后保留
+	// store is the store of the quota, the quota does not survive restarts if it is nil
+	store QuotaStore
+
+	// saveInterval 是把已使用的数量保存到存储的间隔
+	// saveInterval is the interval of saving the used amount to the store
+	saveInterval time.Duration
+
+	// limiter 是配额之内使用的速率限制器
+	// limiter is the rate limiter used within the quota
+	limiter RateLimiter
+
+	// onError 是后台保存失败时调用的函数
+	// onError is the function called when saving in the background fails
+	onError func(err error)
+}
+
+// NewQuotaConfig 是创建新的配额限流器配置的函数，它接受每个周期的配额和重置周期，默认使用本地时区
+// NewQuotaConfig is a function to create a new quota limiter configuration, it accepts the quota of each period and the reset period, the local time zone is used by default
+func NewQuotaConfig(limit int64, period CalendarUnit) *QuotaConfig {
+	return &QuotaConfig{
+		name:         DefaultQuotaName,
+		limit:        limit,
+		period:       period,
+		location:     time.Local,
+		saveInterval: DefaultQuotaSaveInterval,
+		onError:      func(error) {},
+	}
+}
+
+// WithName 它设置配额的名称，多个配额可以使用不同的名称共享一个存储
+// WithName is a method that sets the name of the quota, several quotas can share one store with different names
+func (c *QuotaConfig) WithName(name string) *QuotaConfig {
+	c.name = name
+	return c
+}
+
+// WithLocation 它设置日历边界使用的时区
+// WithLocation is a method that sets the time zone used by the calendar boundaries
+func (c *QuotaConfig) WithLocation(loc *time.Location) *QuotaConfig {
+	c.location = loc
+	return c
+}
+
+// WithStore 它设置配额的存储
+// WithStore is a method that sets the store of the quota
+func (c *QuotaConfig) WithStore(store QuotaStore) *QuotaConfig {
+	c.store = store
+	return c
+}
+
+// WithSaveInterval 它设置把已使用的数量保存到存储的间隔，进程崩溃时最后一个间隔内的使用会丢失
+// WithSaveInterval is a method that sets the interval of saving the used amount to the store, the usage within the last interval is lost when the process crashes
+func (c *QuotaConfig) WithSaveInterval(interval time.Duration) *QuotaConfig {
+	c.saveInterval = interval
+	return c
+}
+
+// WithLimiter 它设置配额之内使用的速率限制器，默认不限制速率
+// WithLimiter is a method that sets the rate limiter used within the quota, the rate is not limited by default
+func (c *QuotaConfig) WithLimiter(limiter RateLimiter) *QuotaConfig {
+	c.limiter = limiter
+	return c
+}
+
+// WithErrorHandler 它设置后台保存失败时调用的函数
+// WithErrorHandler is a method that sets the function called when saving in the background fails
+func (c *QuotaConfig) WithErrorHandler(fn func(err error)) *QuotaConfig {
+	c.onError = fn
+	return c
+}
+
+// Validate 是一个方法，它严格检查配额限流器配置是否有效
+// Validate is a method that strictly checks if the quota limiter configuration is valid
+func (c *QuotaConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if c.limit <= 0 {
+		return fmt.Errorf("%w, got limit %d", ErrInvalidQuota, c.limit)
+	}
+	if c.period <= CalendarNone || c.period > CalendarMonth {
+		return fmt.Errorf("%w, got period %v", ErrInvalidQuota, c.period)
+	}
+	return nil
+}
+
+// isQuotaConfigValid 是一个函数，它检查配额限流器配置是否有效，如果无效，它将设置为默认值
+// isQuotaConfigValid is a function that checks if the quota limiter configuration is valid, if not, it sets it to the default values
+func isQuotaConfigValid(conf *QuotaConfig) *QuotaConfig {
+	if conf == nil {
+		conf = NewQuotaConfig(DefaultLimitRate, CalendarDay)
+	}
+	if conf.name == "" {
+		conf.name = DefaultQuotaName
+	}
+	if conf.limit <= 0 {
+		conf.limit = DefaultLimitRate
+	}
+	if conf.period <= CalendarNone || conf.period > CalendarMonth {
+		conf.period = CalendarDay
+	}
+	if conf.location == nil {
+		conf.location = time.Local
+	}
+	if conf.saveInterval <= 0 {
+		conf.saveInterval = DefaultQuotaSaveInterval
+	}
+	if conf.limiter == nil {
+		conf.limiter = NewNopLimiter()
+	}
+	if conf.onError == nil {
+		conf.onError = func(error) {}
+	}
+	return conf
+}
+
+// QuotaLimiter 是长周期的配额限流器，例如每个日历月 100 万次调用。配额在日历边界重置，已使用的数量按间隔保存到存储中。
+// 配额用尽时 Reserve 返回 QuotaError，流控制器据此拒绝消息，而不是计算一个很长的延迟
+// QuotaLimiter is a long horizon quota limiter, such as 1 million calls per calendar month. The quota resets on the calendar boundary, and the used amount is saved to the store at intervals.
+// When the quota is exhausted, Reserve returns QuotaError, and the flow controller rejects the message accordingly instead of computing a very long delay
+type QuotaLimiter struct {
+	// config 是配额限流器的配置
+	// config is the configuration of the quota limiter
+	config *QuotaConfig
+
+	// lock 保护当前周期和已使用的数量
+	// lock protects the current period and the used amount
+	lock sync.Mutex
+
+	// start 和 end 是当前周期的边界
+	// start and end are the boundaries of the current period
+	start, end time.Time
+
+	// used 是当前周期已使用的数量
+	// used is the amount used in the current period
+	used int64
+
+	// dirty 表示已使用的数量在上次保存后有变化
+	// dirty indicates that the used amount has changed since the last save
+	dirty bool
+
+	// saveLock 串行化保存，使较旧的状态不会覆盖较新的状态
+	// saveLock serializes the saves, so that an older state does not overwrite a newer one
+	saveLock sync.Mutex
+
+	// ctx 和 cancel 用于管理保存协程的生命周期
+	// ctx and cancel are used to manage the lifecycle of the saving goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg 用于等待保存协程退出
+	// wg is used to wait for the saving goroutine to exit
+	wg sync.WaitGroup
+
+	// once 用于确保配额限流器只被关闭一次
+	// once is used to ensure that the quota limiter is closed only once
+	once sync.Once
+}
+
+// NewQuotaLimiter 是创建新的配额限流器的函数，如果配置了存储，它立即加载当前周期已使用的数量，然后在后台按间隔保存
+// NewQuotaLimiter is a function to create a new quota limiter, if the store is configured, it loads the amount used in the current period immediately, and then saves in the background at intervals
+func NewQuotaLimiter(conf *QuotaConfig) (*QuotaLimiter, error) {
+	conf = isQuotaConfigValid(conf)
+
+	l := &QuotaLimiter{config: conf}
+	l.start, l.end = conf.period.bounds(time.Now(), conf.location)
+
+	if conf.store != nil {
+		state, ok, err := conf.store.Load(conf.name)
+		if err != nil {
+			return nil, err
+		}
+
+		// 保存的状态属于当前周期时才恢复，否则配额已经重置
+		// The saved state is restored only when it belongs to the current period, otherwise the quota has been reset
+		if ok && state.Start.Equal(l.start) {
+			l.used = state.Count
+		}
+
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+		l.wg.Add(1)
+		go l.saver()
+	}
+
+	return l, nil
+}
+
+// NewStrictQuotaLimiter 是创建新的配额限流器的函数，它在配置无效时返回错误
+// NewStrictQuotaLimiter is a function to create a new quota limiter, it returns an error when the configuration is invalid
+func NewStrictQuotaLimiter(conf *QuotaConfig) (*QuotaLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewQuotaLimiter(conf)
+}
+
+// roll 是一个方法，如果当前周期已经结束，它进入新的周期并重置已使用的数量，调用者必须持有锁
+// roll is a method that enters the new period and resets the used amount if the current period has ended, the caller must hold the lock
+func (l *QuotaLimiter) roll(now time.Time) {
+	if now.Before(l.end) {
+		return
+	}
+	l.start, l.end = l.config.period.bounds(now, l.config.location)
+	l.used = 0
+	l.dirty = true
+}
+
+// Reserve 是一个方法，它消耗一个配额并返回配额之内的速率限制器给出的延迟时间。配额用尽时不消耗配额，返回 QuotaError
+// Reserve is a method that consumes one unit of the quota and returns the delay given by the rate limiter within the quota. When the quota is exhausted, nothing is consumed and QuotaError is returned
+func (l *QuotaLimiter) Reserve() (time.Duration, error) {
+	l.lock.Lock()
+	l.roll(time.Now())
+	if l.used >= l.config.limit {
+		err := &QuotaError{Name: l.config.name, Limit: l.config.limit, ResetAt: l.end}
+		l.lock.Unlock()
+		return 0, err
+	}
+	l.used++
+	l.dirty = true
+	l.lock.Unlock()
+
+	return l.config.limiter.When(), nil
+}
+
+// When 是一个方法，它消耗一个配额并返回延迟时间，配额用尽时不消耗配额，返回到配额重置的时间
+// When is a method that consumes one unit of the quota and returns the delay, when the quota is exhausted, nothing is consumed and the time until the quota resets is returned
+func (l *QuotaLimiter) When() time.Duration {
+	delay, err := l.Reserve()
+	if e, ok := err.(*QuotaError); ok {
+		return time.Until(e.ResetAt)
+	}
+	return delay
+}
+
+// Remaining 是一个方法，它返回当前周期剩余的配额
+// Remaining is a method that returns the remaining quota of the current period
+func (l *QuotaLimiter) Remaining() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.roll(time.Now())
+	if l.used >= l.config.limit {
+		return 0
+	}
+	return l.config.limit - l.used
+}
+
+// Used 是一个方法，它返回当前周期已使用的配额
+// Used is a method that returns the used quota of the current period
+func (l *QuotaLimiter) Used() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.roll(time.Now())
+	return l.used
+}
+
+// ResetAt 是一个方法，它返回配额下一次重置的时间
+// ResetAt is a method that returns the time the quota resets next
+func (l *QuotaLimiter) ResetAt() time.Time {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.roll(time.Now())
+	return l.end
+}
+
+// Limit 是一个方法，它返回每个周期的配额
+// Limit is a method that returns the quota of each period
+func (l *QuotaLimiter) Limit() int64 {
+	return l.config.limit
+}
+
+// Flush 是一个方法，它立即把已使用的数量保存到存储，没有配置存储时什么也不做
+// Flush is a method that immediately saves the used amount to the store, it does nothing if the store is not configured
+func (l *QuotaLimiter) Flush() error {
+	if l.config.store == nil {
+		return nil
+	}
+
+	l.saveLock.Lock()
+	defer l.saveLock.Unlock()
+
+	l.lock.Lock()
+	state := WindowState{Start: l.start, Count: l.used}
+	dirty := l.dirty
+	l.dirty = false
+	l.lock.Unlock()
+
+	if !dirty {
+		return nil
+	}
+	if err := l.config.store.Save(l.config.name, state); err != nil {
+		// 保存失败，下一次继续尝试
+		// The save failed, try again next time
+		l.lock.Lock()
+		l.dirty = true
+		l.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close 是一个方法，它停止后台保存，并最后保存一次，返回最后一次保存的错误
+// Close is a method that stops saving in the background and saves one last time, it returns the error of the last save
+func (l *QuotaLimiter) Close() error {
+	var err error
+	l.once.Do(func() {
+		if l.cancel != nil {
+			l.cancel()
+			l.wg.Wait()
+		}
+		err = l.Flush()
+	})
+	return err
+}
+
+// saver 是一个方法，它按间隔保存已使用的数量，失败时调用错误处理函数并在下一个间隔重试
+// saver is a method that saves the used amount at intervals, it calls the error handler on failure and retries at the next interval
+func (l *QuotaLimiter) saver() {
+	ticker := time.NewTicker(l.config.saveInterval)
+
+	defer func() {
+		ticker.Stop()
+		l.wg.Done()
+	}()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				l.config.onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package ratelimiter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRedisKey 是默认的限流器状态键
+	// DefaultRedisKey is the default key of the limiter state
+	DefaultRedisKey = "regula:ratelimiter"
+
+	// DefaultRedisPoolSize 是默认的最多连接数量
+	// DefaultRedisPoolSize is the default maximum number of connections
+	DefaultRedisPoolSize = 8
+
+	// DefaultRedisDialTimeout 是默认的连接超时时间
+	// DefaultRedisDialTimeout is the default dial timeout
+	DefaultRedisDialTimeout = time.Millisecond * 100
+
+	// DefaultRedisIOTimeout 是默认的命令读写超时时间
+	// DefaultRedisIOTimeout is the default read and write timeout of a command
+	DefaultRedisIOTimeout = time.Millisecond * 100
+
+	// DefaultRedisRetryInterval 是默认的降级后重新尝试服务器的间隔
+	// DefaultRedisRetryInterval is the default interval to try the server again after degrading
+	DefaultRedisRetryInterval = time.Second
+)
+
+// RedisAlgorithm 是 Redis 限流器使用的算法
+// RedisAlgorithm is the algorithm used by the Redis limiter
+type RedisAlgorithm int8
+
+const (
+	// RedisTokenBucket 是令牌桶算法，按 rate 补充令牌，最多保留 burst 个
+	// RedisTokenBucket is the token bucket algorithm, tokens are refilled at rate and at most burst of them are kept
+	RedisTokenBucket RedisAlgorithm = iota
+
+	// RedisSlidingWindow 是滑动窗口日志算法，任意 window 长度的时间内最多允许 limit 个事件
+	// RedisSlidingWindow is the sliding window log algorithm, at most limit events are admitted within any time of window length
+	RedisSlidingWindow
+)
+
+// String 是一个方法，它返回算法的名称
+// String is a method that returns the name of the algorithm
+func (a RedisAlgorithm) String() string {
+	switch a {
+	case RedisTokenBucket:
+		return "token-bucket"
+	case RedisSlidingWindow:
+		return "sliding-window"
+	default:
+		return "unknown"
+	}
+}
+
+// redisScript 是一个 Lua 脚本和它的 SHA1 摘要
+// redisScript is a Lua script and its SHA1 digest
+type redisScript struct {
+	src string
+	sha string
+}
+
+// newRedisScript 是创建新的 Lua 脚本的函数
+// newRedisScript is a function to create a new Lua script
+func newRedisScript(src string) *redisScript {
+	sum := sha1.Sum([]byte(src))
+	return &redisScript{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// tokenBucketScript 原子地预留一个令牌，返回等待的微秒数，时间取自服务器，避免副本之间的时钟偏差。
+// KEYS[1] 是状态哈希，ARGV 是速率和突发值
+// tokenBucketScript atomically reserves a token and returns the wait in microseconds, the time is taken from the server to avoid clock skew between replicas.
+// KEYS[1] is the state hash, ARGV are the rate and burst
+var tokenBucketScript = newRedisScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+else
+  if now < ts then now = ts end
+  tokens = math.min(burst, tokens + (now - ts) * rate / 1000000)
+end
+tokens = tokens - 1
+local wait = 0
+if tokens < 0 then wait = math.ceil(-tokens * 1000000 / rate) end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return wait
+`)
+
+// slidingWindowScript 原子地在滑动窗口中为一个事件排定时间，返回等待的微秒数。
+// KEYS[1] 是事件时间的有序集合，KEYS[2] 是成员序号，它们使用相同的哈希标签，在集群中位于同一个槽，ARGV 是上限和窗口的微秒数
+// slidingWindowScript atomically schedules a time for an event in the sliding window and returns the wait in microseconds.
+// KEYS[1] is the sorted set of event times, KEYS[2] is the member sequence, they use the same hash tag so they are in the same slot of a cluster, ARGV are the limit and the window in microseconds
+var slidingWindowScript = newRedisScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local at = now
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #last > 0 then at = math.max(at, tonumber(last[2])) end
+if redis.call('ZCARD', KEYS[1]) >= limit then
+  local nth = redis.call('ZRANGE', KEYS[1], -limit, -limit, 'WITHSCORES')
+  at = math.max(at, tonumber(nth[2]) + window)
+end
+local seq = redis.call('INCR', KEYS[2])
+redis.call('ZADD', KEYS[1], at, string.format('%d:%d', at, seq))
+local ttl = math.ceil((at - now + window) / 1000) + 1000
+redis.call('PEXPIRE', KEYS[1], ttl)
+redis.call('PEXPIRE', KEYS[2], ttl)
+return at - now
+`)
+
+// RedisConfig 是 Redis 限流器的配置
+// RedisConfig is the configuration of the Redis limiter
+type RedisConfig struct {
+	// addr 是服务器的地址
+	// addr is the address of the server
+	addr string
+
+	// password 和 db 是认证密码和数据库编号
+	// password and db are the authentication password and the database number
+	password string
+	db       int
+
+	// key 是限流器状态的键，使用相同键的限流器共享同一个限制
+	// key is the key of the limiter state, limiters using the same key share the same limit
+	key string
+
+	// algorithm 是限流算法
+	// algorithm is the rate limiting algorithm
+	algorithm RedisAlgorithm
+
+	// rate 和 burst 是令牌桶的速率和突发值
+	// rate and burst are the rate and burst of the token bucket
+	rate  float64
+	burst int64
+
+	// limit 和 window 是滑动窗口的上限和长度
+	// limit and window are the limit and length of the sliding window
+	limit  int64
+	window time.Duration
+
+	// poolSize 是最多同时打开的连接数量，空闲的连接也保留在池中
+	// poolSize is the maximum number of connections open at the same time, idle connections are kept in the pool as well
+	poolSize int
+
+	// dialTimeout 和 ioTimeout 是连接超时时间和命令读写超时时间
+	// dialTimeout and ioTimeout are the dial timeout and the read and write timeout of a command
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+
+	// retryInterval 是降级后重新尝试服务器的间隔
+	// retryInterval is the interval to try the server again after degrading
+	retryInterval time.Duration
+
+	// fallback 是服务器不可用时使用的本地限流器
+	// fallback is the local limiter used when the server is unavailable
+	fallback RateLimiter
+
+	// onError 是访问服务器失败时调用的函数
+	// onError is the function called when accessing the server fails
+	onError func(err error)
+}
+
+// NewRedisConfig 是创建新的 Redis 限流器配置的函数，它接受服务器地址，默认使用令牌桶算法
+// NewRedisConfig is a function to create a new Redis limiter configuration, it accepts the server address, the token bucket algorithm is used by default
+func NewRedisConfig(addr string) *RedisConfig {
+	return &RedisConfig{
+		addr:          addr,
+		key:           DefaultRedisKey,
+		algorithm:     RedisTokenBucket,
+		rate:          DefaultLimitRate,
+		burst:         DefaultLimitBurst,
+		poolSize:      DefaultRedisPoolSize,
+		dialTimeout:   DefaultRedisDialTimeout,
+		ioTimeout:     DefaultRedisIOTimeout,
+		retryInterval: DefaultRedisRetryInterval,
+		onError:       func(error) {},
+	}
+}
+
+// WithPassword 它设置认证密码
+// WithPassword is a method that sets the authentication password
+func (c *RedisConfig) WithPassword(password string) *RedisConfig {
+	c.password = password
+	return c
+}
+
+// WithDB 它设置数据库编号
+// WithDB is a method that sets the database number
+func (c *RedisConfig) WithDB(db int) *RedisConfig {
+	c.db = db
+	return c
+}
+
+// WithKey 它设置限流器状态的键
+// WithKey is a method that sets the key of the limiter state
+func (c *RedisConfig) WithKey(key string) *RedisConfig {
+	c.key = key
+	return c
+}
+
+// WithRate 它设置令牌桶的速率
+// WithRate is a method that sets the rate of the token bucket
+func (c *RedisConfig) WithRate(rate float64) *RedisConfig {
+	c.rate = rate
+	return c
+}
+
+// WithBurst 它设置令牌桶的突发值
+// WithBurst is a method that sets the burst of the token bucket
+func (c *RedisConfig) WithBurst(burst int64) *RedisConfig {
+	c.burst = burst
+	return c
+}
+
+// WithSlidingWindow 它设置滑动窗口的上限和长度，并把算法设置为 RedisSlidingWindow
+// WithSlidingWindow is a method that sets the limit and length of the sliding window and sets the algorithm to RedisSlidingWindow
+func (c *RedisConfig) WithSlidingWindow(limit int64, window time.Duration) *RedisConfig {
+	c.limit = limit
+	c.window = window
+	c.algorithm = RedisSlidingWindow
+	return c
+}
+
+// WithPoolSize 它设置最多同时打开的连接数量，连接都在使用中时 When 最多等待连接超时时间，之后降级到本地限流器
+// WithPoolSize is a method that sets the maximum number of connections open at the same time, when all connections are in use, When waits at most the dial timeout and then degrades to the local limiter
+func (c *RedisConfig) WithPoolSize(size int) *RedisConfig {
+	c.poolSize = size
+	return c
+}
+
+// WithTimeout 它设置连接超时时间和命令读写超时时间，它们限制了服务器不可用时 When 的最长阻塞时间
+// WithTimeout is a method that sets the dial timeout and the read and write timeout of a command, they bound how long When blocks when the server is unavailable
+func (c *RedisConfig) WithTimeout(dial, io time.Duration) *RedisConfig {
+	c.dialTimeout = dial
+	c.ioTimeout = io
+	return c
+}
+
+// WithRetryInterval 它设置降级后重新尝试服务器的间隔，在此期间直接使用本地限流器
+// WithRetryInterval is a method that sets the interval to try the server again after degrading, the local limiter is used directly in the meantime
+func (c *RedisConfig) WithRetryInterval(interval time.Duration) *RedisConfig {
+	c.retryInterval = interval
+	return c
+}
+
+// WithFallback 它设置服务器不可用时使用的本地限流器，默认是与配置的限制相同的令牌桶
+// WithFallback is a method that sets the local limiter used when the server is unavailable, the default is a token bucket with the same limit as configured
+func (c *RedisConfig) WithFallback(limiter RateLimiter) *RedisConfig {
+	c.fallback = limiter
+	return c
+}
+
+// WithErrorHandler 它设置访问服务器失败时调用的函数
+// WithErrorHandler is a method that sets the function called when accessing the server fails
+func (c *RedisConfig) WithErrorHandler(fn func(err error)) *RedisConfig {
+	c.onError = fn
+	return c
+}
+
+// Validate 是一个方法，它严格检查 Redis 限流器配置是否有效
+// Validate is a method that strictly checks if the Redis limiter configuration is valid
+func (c *RedisConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if c.addr == "" {
+		return ErrInvalidAddress
+	}
+	if c.algorithm == RedisSlidingWindow {
+		if c.limit <= 0 || c.window < time.Microsecond {
+			return fmt.Errorf("%w, got %d and %v", ErrInvalidWindow, c.limit, c.window)
+		}
+		return nil
+	}
+	return NewConfig().WithRate(c.rate).WithBurst(c.burst).Validate()
+}
+
+// isRedisConfigValid 是一个函数，它检查 Redis 限流器配置是否有效，如果无效，它将设置为默认值
+// isRedisConfigValid is a function that checks if the Redis limiter configuration is valid, if not, it sets it to the default values
+func isRedisConfigValid(conf *RedisConfig) *RedisConfig {
+	if conf == nil {
+		conf = NewRedisConfig("")
+	}
+	if conf.key == "" {
+		conf.key = DefaultRedisKey
+	}
+	if conf.rate <= 0 || math.IsNaN(conf.rate) || math.IsInf(conf.rate, 0) {
+		conf.rate = DefaultLimitRate
+	}
+	if conf.burst <= 0 {
+		conf.burst = DefaultLimitBurst
+	}
+	if conf.algorithm == RedisSlidingWindow && (conf.limit <= 0 || conf.window < time.Microsecond) {
+		conf.algorithm = RedisTokenBucket
+	}
+	if conf.poolSize <= 0 {
+		conf.poolSize = DefaultRedisPoolSize
+	}
+	if conf.dialTimeout <= 0 {
+		conf.dialTimeout = DefaultRedisDialTimeout
+	}
+	if conf.ioTimeout <= 0 {
+		conf.ioTimeout = DefaultRedisIOTimeout
+	}
+	if conf.retryInterval <= 0 {
+		conf.retryInterval = DefaultRedisRetryInterval
+	}
+	if conf.onError == nil {
+		conf.onError = func(error) {}
+	}
+
+	// 默认的本地限流器使用与配置相同的限制
+	// The default local limiter uses the same limit as configured
+	if conf.fallback == nil {
+		if conf.algorithm == RedisSlidingWindow {
+			conf.fallback = NewRateLimiter(NewConfig().WithRate(float64(conf.limit) / conf.window.Seconds()).WithBurst(conf.limit))
+		} else {
+			conf.fallback = NewRateLimiter(NewConfig().WithRate(conf.rate).WithBurst(conf.burst))
+		}
+	}
+	return conf
+}
+
+// RedisLimiter 是通过 RESP 协议使用 Redis 共享状态的分布式限流器，所有使用相同键的副本共享同一个限制。
+// 服务器不可用时它降级到本地限流器，并在重试间隔后重新尝试服务器
+// RedisLimiter is a distributed limiter that shares its state in Redis through the RESP protocol, all replicas using the same key share the same limit.
+// It degrades to the local limiter when the server is unavailable, and tries the server again after the retry interval
+type RedisLimiter struct {
+	// config 是 Redis 限流器的配置
+	// config is the configuration of the Redis limiter
+	config *RedisConfig
+
+	// pool 是连接池
+	// pool is the connection pool
+	pool *respPool
+
+	// script 是限流算法的 Lua 脚本
+	// script is the Lua script of the rate limiting algorithm
+	script *redisScript
+
+	// args 是脚本的键和参数
+	// args are the keys and arguments of the script
+	args []string
+
+	// retryAt 是降级后重新尝试服务器的时间，单位为纳秒，为 0 时没有降级
+	// retryAt is the time to try the server again after degrading in nanoseconds, 0 means not degraded
+	retryAt atomic.Int64
+}
+
+// NewRedisLimiter 是创建新的 Redis 限流器的函数，连接在第一次使用时建立
+// NewRedisLimiter is a function to create a new Redis limiter, connections are established on first use
+func NewRedisLimiter(conf *RedisConfig) *RedisLimiter {
+	conf = isRedisConfigValid(conf)
+
+	l := &RedisLimiter{config: conf, pool: newRespPool(conf)}
+	if conf.algorithm == RedisSlidingWindow {
+		l.script = slidingWindowScript
+		// 两个键使用相同的哈希标签，避免集群中的 CROSSSLOT 错误
+		// Both keys use the same hash tag to avoid the CROSSSLOT error in a cluster
+		tag := "{" + conf.key + "}"
+		l.args = []string{"2", tag, tag + ":seq", strconv.FormatInt(conf.limit, 10), strconv.FormatInt(conf.window.Microseconds(), 10)}
+	} else {
+		l.script = tokenBucketScript
+		l.args = []string{"1", conf.key, strconv.FormatFloat(conf.rate, 'g', -1, 64), strconv.FormatInt(conf.burst, 10)}
+	}
+	return l
+}
+
+// NewStrictRedisLimiter 是创建新的 Redis 限流器的函数，它在配置无效时返回错误
+// NewStrictRedisLimiter is a function to create a new Redis limiter, it returns an error when the configuration is invalid
+func NewStrictRedisLimiter(conf *RedisConfig) (*RedisLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewRedisLimiter(conf), nil
+}
+
+// When 是一个方法，它在服务器上原子地预留一个事件并返回延迟时间，服务器不可用时使用本地限流器
+// When is a method that atomically reserves an event on the server and returns the delay, the local limiter is used when the server is unavailable
+func (l *RedisLimiter) When() time.Duration {
+	if at := l.retryAt.Load(); at != 0 && time.Now().UnixNano() < at {
+		return l.config.fallback.When()
+	}
+
+	delay, err := l.eval()
+	if err != nil {
+		l.retryAt.Store(time.Now().Add(l.config.retryInterval).UnixNano())
+		l.config.onError(err)
+		return l.config.fallback.When()
+	}
+
+	l.retryAt.Store(0)
+	return delay
+}
+
+// Degraded 是一个方法，它返回限流器当前是否降级到本地限流器
+// Degraded is a method that returns whether the limiter is currently degraded to the local limiter
+func (l *RedisLimiter) Degraded() bool {
+	return l.retryAt.Load() != 0
+}
+
+// Close 是一个方法，它关闭所有空闲的连接
+// Close is a method that closes all idle connections
+func (l *RedisLimiter) Close() {
+	l.pool.close()
+}
+
+// eval 是一个方法，它通过 EVALSHA 执行脚本，服务器没有缓存脚本时改用 EVAL
+// eval is a method that executes the script through EVALSHA, and uses EVAL instead when the server has not cached the script
+func (l *RedisLimiter) eval() (time.Duration, error) {
+	reply, err := l.pool.do(append([]string{"EVALSHA", l.script.sha}, l.args...)...)
+	if e, ok := err.(*RedisError); ok && strings.HasPrefix(e.Message, "NOSCRIPT") {
+		reply, err = l.pool.do(append([]string{"EVAL", l.script.src}, l.args...)...)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	wait, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: unexpected script reply %v", ErrRedisProtocol, reply)
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return time.Duration(wait) * time.Microsecond, nil
+}
@@ -0,0 +1,241 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respConn 是一个使用 RESP 协议的连接
+// respConn is a connection that speaks the RESP protocol
+type respConn struct {
+	// conn 是底层的网络连接
+	// conn is the underlying network connection
+	conn net.Conn
+
+	// reader 和 writer 是连接的缓冲读写器
+	// reader and writer are the buffered reader and writer of the connection
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	// timeout 是每个命令的读写超时时间
+	// timeout is the read and write timeout of each command
+	timeout time.Duration
+}
+
+// dialResp 是一个函数，它建立一个新的连接，并按需要认证和选择数据库
+// dialResp is a function that establishes a new connection, and authenticates and selects the database as needed
+func dialResp(conf *RedisConfig) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", conf.addr, conf.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		timeout: conf.ioTimeout,
+	}
+
+	if conf.password != "" {
+		if _, err = c.do("AUTH", conf.password); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	if conf.db != 0 {
+		if _, err = c.do("SELECT", strconv.Itoa(conf.db)); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do 是一个方法，它发送一个命令并读取回复，服务器返回的错误回复作为 ErrRedisReply 返回
+// do is a method that sends a command and reads the reply, the error reply returned by the server is returned as ErrRedisReply
+func (c *respConn) do(args ...string) (any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	// 命令是由批量字符串组成的数组
+	// A command is an array of bulk strings
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// read 是一个方法，它读取一个回复，简单字符串和批量字符串返回 string，整数返回 int64，数组返回 []any，空值返回 nil
+// read is a method that reads a reply, simple and bulk strings are returned as string, integers as int64, arrays as []any and nulls as nil
+func (c *respConn) read() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: malformed line %q", ErrRedisProtocol, line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, &RedisError{Message: body}
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad bulk length %q", ErrRedisProtocol, body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad array length %q", ErrRedisProtocol, body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]any, size)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown reply type %q", ErrRedisProtocol, kind)
+	}
+}
+
+// close 是一个方法，它关闭连接
+// close is a method that closes the connection
+func (c *respConn) close() {
+	_ = c.conn.Close()
+}
+
+// respPool 是连接池，它最多同时打开 size 个连接，并保留空闲的连接
+// respPool is the connection pool, it opens at most size connections at the same time and keeps the idle ones
+type respPool struct {
+	// config 是 Redis 限流器的配置
+	// config is the configuration of the Redis limiter
+	config *RedisConfig
+
+	// idle 是空闲的连接
+	// idle are the idle connections
+	idle chan *respConn
+
+	// slots 限制打开的连接数量，每个打开的连接占用一个位置
+	// slots limits the number of open connections, each open connection takes one slot
+	slots chan struct{}
+}
+
+// newRespPool 是创建新的连接池的函数
+// newRespPool is a function to create a new connection pool
+func newRespPool(conf *RedisConfig) *respPool {
+	return &respPool{config: conf, idle: make(chan *respConn, conf.poolSize), slots: make(chan struct{}, conf.poolSize)}
+}
+
+// get 是一个方法，它取出一个空闲的连接，或者在连接数未达到上限时建立新的连接。连接数已达到上限时最多等待连接超时时间，之后返回 ErrRedisPoolExhausted
+// get is a method that takes an idle connection, or establishes a new connection when the number of connections has not reached the limit. When the limit is reached, it waits at most the dial timeout and then returns ErrRedisPoolExhausted
+func (p *respPool) get() (*respConn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	timer := time.NewTimer(p.config.dialTimeout)
+	defer timer.Stop()
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	case p.slots <- struct{}{}:
+		c, err := dialResp(p.config)
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		return c, nil
+	case <-timer.C:
+		return nil, ErrRedisPoolExhausted
+	}
+}
+
+// discard 是一个方法，它关闭连接并释放它占用的位置
+// discard is a method that closes the connection and releases the slot it takes
+func (p *respPool) discard(c *respConn) {
+	c.close()
+	<-p.slots
+}
+
+// do 是一个方法，它从连接池取出一个连接执行命令，网络错误和协议错误后连接被关闭，其他情况下放回连接池
+// do is a method that takes a connection from the pool to execute the command, the connection is closed after a network or protocol error, otherwise it is put back into the pool
+func (p *respPool) do(args ...string) (any, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(args...)
+	if _, ok := err.(*RedisError); err != nil && !ok {
+		p.discard(c)
+		return nil, err
+	}
+
+	select {
+	case p.idle <- c:
+	default:
+		p.discard(c)
+	}
+	return reply, err
+}
+
+// close 是一个方法，它关闭所有空闲的连接
+// close is a method that closes all idle connections
+func (p *respPool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			p.discard(c)
+		default:
+			return
+		}
+	}
+}
+
+// RedisError 是 Redis 服务器返回的错误回复
+// RedisError is an error reply returned by the Redis server
+type RedisError struct {
+	// Message 是错误回复的内容
+	// Message is the content of the error reply
+	Message string
+}
+
+// Error 是一个方法，它返回错误回复的内容
+// Error is a method that returns the content of the error reply
+func (e *RedisError) Error() string {
+	return "redis: " + e.Message
+}
@@ -0,0 +1,442 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProfileName 是默认配置档的名称，没有时间窗口匹配时使用默认配置档
+// DefaultProfileName is the name of the default profile, the default profile is used when no time window matches
+const DefaultProfileName = "default"
+
+// weekdays 是星期名称的缩写
+// weekdays are the abbreviations of the weekday names
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// TimeWindow 是每周重复的时间窗口，例如 "Mon-Fri 22:00-06:00"，跨过午夜的时间范围属于它开始的那一天
+// TimeWindow is a time window repeated every week, such as "Mon-Fri 22:00-06:00", a time range crossing midnight belongs to the day it starts
+type TimeWindow struct {
+	// days 是窗口开始的星期
+	// days are the weekdays on which the window starts
+	days [7]bool
+
+	// start 和 end 是从午夜开始的偏移，end 不大于 start 时跨过午夜
+	// start and end are the offsets from midnight, the window crosses midnight when end is not greater than start
+	start, end time.Duration
+}
+
+// ParseTimeWindow 是解析时间窗口的函数，格式为 "[星期] [开始-结束]"。
+// 星期是 "*"，或者逗号分隔的星期缩写和范围，例如 "Mon-Fri" 和 "Sat,Sun"，省略时为每天。
+// 开始和结束是 "HH:MM" 或者 "HH:MM:SS"，结束可以是 "24:00"，省略时为全天
+// ParseTimeWindow is a function to parse a time window, the format is "[weekdays] [start-end]".
+// The weekdays are "*", or comma separated weekday abbreviations and ranges, such as "Mon-Fri" and "Sat,Sun", every day when omitted.
+// The start and end are "HH:MM" or "HH:MM:SS", the end can be "24:00", the whole day when omitted
+func ParseTimeWindow(spec string) (*TimeWindow, error) {
+	w := &TimeWindow{end: time.Hour * 24}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+	}
+
+	days, clock := fields[0], ""
+	if len(fields) == 2 {
+		clock = fields[1]
+	} else if strings.Contains(days, ":") {
+		days, clock = "*", days
+	}
+
+	if err := w.parseDays(days); err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSchedule, spec, err)
+	}
+	if clock != "" {
+		if err := w.parseClock(clock); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSchedule, spec, err)
+		}
+	}
+	return w, nil
+}
+
+// parseDays 是一个方法，它解析星期
+// parseDays is a method that parses the weekdays
+func (w *TimeWindow) parseDays(spec string) error {
+	if spec == "*" {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+		return nil
+	}
+
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("unknown weekday %q", to)
+			}
+		}
+
+		// 范围可以跨过周末，例如 "Fri-Mon"
+		// A range can wrap around the weekend, such as "Fri-Mon"
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock 是一个方法，它解析开始和结束时间
+// parseClock is a method that parses the start and end times
+func (w *TimeWindow) parseClock(spec string) error {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return fmt.Errorf("missing end time in %q", spec)
+	}
+
+	var err error
+	if w.start, err = parseClockTime(from); err != nil {
+		return err
+	}
+	if w.end, err = parseClockTime(to); err != nil {
+		return err
+	}
+	if w.start == time.Hour*24 {
+		return fmt.Errorf("start time %q is out of range", from)
+	}
+	return nil
+}
+
+// parseClockTime 是一个函数，它把 "HH:MM" 或者 "HH:MM:SS" 解析为从午夜开始的偏移
+// parseClockTime is a function that parses "HH:MM" or "HH:MM:SS" as the offset from midnight
+func parseClockTime(spec string) (time.Duration, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", spec)
+	}
+
+	var offset time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 || (i > 0 && v > 59) {
+			return 0, fmt.Errorf("invalid time %q", spec)
+		}
+		offset += time.Duration(v) * units[i]
+	}
+	if offset > time.Hour*24 {
+		return 0, fmt.Errorf("time %q is out of range", spec)
+	}
+	return offset, nil
+}
+
+// Contains 是一个方法，它返回时间 t 是否在窗口之内，t 按它自身的时区解释
+// Contains is a method that returns whether the time t is within the window, t is interpreted in its own time zone
+func (w *TimeWindow) Contains(t time.Time) bool {
+	day := t.Weekday()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.start < w.end {
+		return w.days[day] && offset >= w.start && offset < w.end
+	}
+
+	// 跨过午夜的窗口，午夜之后的部分属于前一天
+	// A window crossing midnight, the part after midnight belongs to the previous day
+	return (w.days[day] && offset >= w.start) || (w.days[(day+6)%7] && offset < w.end)
+}
+
+// Profile 是一个速率配置档
+// Profile is a rate profile
+type Profile struct {
+	// Name 是配置档的名称
+	// Name is the name of the profile
+	Name string
+
+	// Rate 和 Burst 是配置档的速率和突发值
+	// Rate and Burst are the rate and burst of the profile
+	Rate  float64
+	Burst int64
+}
+
+// scheduleRule 是配置档和它生效的时间窗口
+// scheduleRule is a profile and the time windows in which it is in effect
+type scheduleRule struct {
+	profile Profile
+	specs   []string
+	windows []*TimeWindow
+}
+
+// ScheduleConfig 是定时限流器的配置
+// ScheduleConfig is the configuration of the scheduled limiter
+type ScheduleConfig struct {
+	// fallback 是没有时间窗口匹配时使用的默认配置档
+	// fallback is the default profile used when no time window matches
+	fallback Profile
+
+	// rules 是按添加顺序匹配的配置档，第一个匹配的生效
+	// rules are the profiles matched in the order they are added, the first match takes effect
+	rules []*scheduleRule
+
+	// location 是时间窗口使用的时区
+	// location is the time zone used by the time windows
+	location *time.Location
+
+	// transition 是切换配置档时速率线性过渡的时间，为 0 时立即切换
+	// transition is the time the rate changes linearly over when switching profiles, it switches immediately if it is 0
+	transition time.Duration
+
+	// onChange 是生效的配置档变化时调用的函数
+	// onChange is the function called when the profile in effect changes
+	onChange func(from, to Profile)
+}
+
+// NewScheduleConfig 是创建新的定时限流器配置的函数，它接受默认配置档的速率和突发值，默认使用本地时区
+// NewScheduleConfig is a function to create a new scheduled limiter configuration, it accepts the rate and burst of the default profile, the local time zone is used by default
+func NewScheduleConfig(rate float64, burst int64) *ScheduleConfig {
+	return &ScheduleConfig{
+		fallback: Profile{Name: DefaultProfileName, Rate: rate, Burst: burst},
+		location: time.Local,
+		onChange: func(Profile, Profile) {},
+	}
+}
+
+// WithProfile 它添加一个配置档，它在任意一个时间窗口之内生效，时间窗口的格式见 ParseTimeWindow
+// WithProfile is a method that adds a profile, it is in effect within any of the time windows, see ParseTimeWindow for the format of the time windows
+func (c *ScheduleConfig) WithProfile(name string, rate float64, burst int64, windows ...string) *ScheduleConfig {
+	c.rules = append(c.rules, &scheduleRule{profile: Profile{Name: name, Rate: rate, Burst: burst}, specs: windows})
+	return c
+}
+
+// WithLocation 它设置时间窗口使用的时区
+// WithLocation is a method that sets the time zone used by the time windows
+func (c *ScheduleConfig) WithLocation(loc *time.Location) *ScheduleConfig {
+	c.location = loc
+	return c
+}
+
+// WithTransition 它设置切换配置档时速率线性过渡的时间
+// WithTransition is a method that sets the time the rate changes linearly over when switching profiles
+func (c *ScheduleConfig) WithTransition(d time.Duration) *ScheduleConfig {
+	c.transition = d
+	return c
+}
+
+// WithChangeHandler 它设置生效的配置档变化时调用的函数
+// WithChangeHandler is a method that sets the function called when the profile in effect changes
+func (c *ScheduleConfig) WithChangeHandler(fn func(from, to Profile)) *ScheduleConfig {
+	c.onChange = fn
+	return c
+}
+
+// Validate 是一个方法，它严格检查定时限流器配置是否有效
+// Validate is a method that strictly checks if the scheduled limiter configuration is valid
+func (c *ScheduleConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if err := NewConfig().WithRate(c.fallback.Rate).WithBurst(c.fallback.Burst).Validate(); err != nil {
+		return fmt.Errorf("profile %q: %w", c.fallback.Name, err)
+	}
+	for _, rule := range c.rules {
+		if err := NewConfig().WithRate(rule.profile.Rate).WithBurst(rule.profile.Burst).Validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", rule.profile.Name, err)
+		}
+		if len(rule.specs) == 0 {
+			return fmt.Errorf("profile %q: %w: no time window", rule.profile.Name, ErrInvalidSchedule)
+		}
+		for _, spec := range rule.specs {
+			if _, err := ParseTimeWindow(spec); err != nil {
+				return fmt.Errorf("profile %q: %w", rule.profile.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isScheduleConfigValid 是一个函数，它检查定时限流器配置是否有效，无效的默认配置档使用默认值，无效的时间窗口和配置档被丢弃
+// isScheduleConfigValid is a function that checks if the scheduled limiter configuration is valid, an invalid default profile uses the default values, and invalid time windows and profiles are dropped
+func isScheduleConfigValid(conf *ScheduleConfig) *ScheduleConfig {
+	if conf == nil {
+		conf = NewScheduleConfig(DefaultLimitRate, DefaultLimitBurst)
+	}
+	if NewConfig().WithRate(conf.fallback.Rate).Validate() != nil {
+		conf.fallback.Rate = DefaultLimitRate
+	}
+	if conf.fallback.Burst <= 0 {
+		conf.fallback.Burst = DefaultLimitBurst
+	}
+
+	rules := make([]*scheduleRule, 0, len(conf.rules))
+	for _, rule := range conf.rules {
+		if NewConfig().WithRate(rule.profile.Rate).WithBurst(rule.profile.Burst).Validate() != nil {
+			continue
+		}
+		rule.windows = rule.windows[:0]
+		for _, spec := range rule.specs {
+			if w, err := ParseTimeWindow(spec); err == nil {
+				rule.windows = append(rule.windows, w)
+			}
+		}
+		if len(rule.windows) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	conf.rules = rules
+
+	if conf.location == nil {
+		conf.location = time.Local
+	}
+	if conf.transition < 0 {
+		conf.transition = 0
+	}
+	if conf.onChange == nil {
+		conf.onChange = func(Profile, Profile) {}
+	}
+	return conf
+}
+
+// ScheduledLimiter 是按时间切换速率配置档的限流器，例如夜间允许更高的吞吐量。
+// 所有配置档共用一个令牌桶，切换时只修改速率和突发值，桶中的令牌被保留，不会因为切换而突发
+// ScheduledLimiter is a limiter that switches rate profiles by time, such as allowing a higher throughput at night.
+// All profiles share one token bucket, switching only changes the rate and burst, the tokens in the bucket are kept and switching does not cause a burst
+type ScheduledLimiter struct {
+	// config 是定时限流器的配置
+	// config is the configuration of the scheduled limiter
+	config *ScheduleConfig
+
+	// limiter 是共用的令牌桶
+	// limiter is the shared token bucket
+	limiter *Limiter
+
+	// lock 保护生效的配置档和过渡状态
+	// lock protects the profile in effect and the transition state
+	lock sync.Mutex
+
+	// active 是生效的配置档
+	// active is the profile in effect
+	active Profile
+
+	// from 和 since 是过渡开始时的速率和时间
+	// from and since are the rate and time when the transition started
+	from  float64
+	since time.Time
+
+	// rate 是最后设置到令牌桶的速率
+	// rate is the rate last set to the token bucket
+	rate float64
+}
+
+// NewScheduledLimiter 是创建新的定时限流器的函数，它立即使用当前时间的配置档
+// NewScheduledLimiter is a function to create a new scheduled limiter, it uses the profile of the current time immediately
+func NewScheduledLimiter(conf *ScheduleConfig) *ScheduledLimiter {
+	conf = isScheduleConfigValid(conf)
+
+	active := conf.match(time.Now())
+	return &ScheduledLimiter{
+		config:  conf,
+		limiter: NewRateLimiter(NewConfig().WithRate(active.Rate).WithBurst(active.Burst)),
+		active:  active,
+		rate:    active.Rate,
+	}
+}
+
+// NewStrictScheduledLimiter 是创建新的定时限流器的函数，它在配置无效时返回错误
+// NewStrictScheduledLimiter is a function to create a new scheduled limiter, it returns an error when the configuration is invalid
+func NewStrictScheduledLimiter(conf *ScheduleConfig) (*ScheduledLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewScheduledLimiter(conf), nil
+}
+
+// match 是一个方法，它返回时间 t 生效的配置档
+// match is a method that returns the profile in effect at the time t
+func (c *ScheduleConfig) match(t time.Time) Profile {
+	t = t.In(c.location)
+	for _, rule := range c.rules {
+		for _, w := range rule.windows {
+			if w.Contains(t) {
+				return rule.profile
+			}
+		}
+	}
+	return c.fallback
+}
+
+// update 是一个方法，它切换到当前时间的配置档，并在过渡期间逐步调整令牌桶的速率
+// update is a method that switches to the profile of the current time, and adjusts the rate of the token bucket gradually during the transition
+func (l *ScheduledLimiter) update() {
+	now := time.Now()
+	profile := l.config.match(now)
+
+	l.lock.Lock()
+	from := l.active
+	changed := profile != from
+	if changed {
+		l.active = profile
+		l.from = l.rate
+		l.since = now
+		l.limiter.SetBurst(profile.Burst)
+	}
+
+	rate := l.active.Rate
+	if elapsed := now.Sub(l.since); elapsed < l.config.transition {
+		rate = l.from + (rate-l.from)*float64(elapsed)/float64(l.config.transition)
+	}
+	if rate != l.rate {
+		l.rate = rate
+		l.limiter.SetRate(rate)
+	}
+	l.lock.Unlock()
+
+	if changed {
+		l.config.onChange(from, profile)
+	}
+}
+
+// When 是一个方法，它按当前时间的配置档返回下一个事件的延迟时间
+// When is a method that returns the delay of the next event according to the profile of the current time
+func (l *ScheduledLimiter) When() time.Duration {
+	l.update()
+	return l.limiter.When()
+}
+
+// WhenN 是一个方法，它按当前时间的配置档为 n 个事件预留令牌，并返回每个事件的延迟时间
+// WhenN is a method that reserves tokens for n events according to the profile of the current time and returns the delay of each event
+func (l *ScheduledLimiter) WhenN(n int) []time.Duration {
+	l.update()
+	return l.limiter.WhenN(n)
+}
+
+// Active 是一个方法，它返回当前生效的配置档
+// Active is a method that returns the profile currently in effect
+func (l *ScheduledLimiter) Active() Profile {
+	l.update()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.active
+}
+
+// Rate 是一个方法，它返回令牌桶当前的速率，过渡期间它在两个配置档的速率之间
+// Rate is a method that returns the current rate of the token bucket, it is between the rates of the two profiles during a transition
+func (l *ScheduledLimiter) Rate() float64 {
+	l.update()
+	return l.limiter.Rate()
+}
+
+// Burst 是一个方法，它返回令牌桶当前的突发值
+// Burst is a method that returns the current burst of the token bucket
+func (l *ScheduledLimiter) Burst() int64 {
+	l.update()
+	return l.limiter.Burst()
+}
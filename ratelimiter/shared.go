@@ -0,0 +1,186 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	// sharedMagic 标识共享内存文件，"RGSH" 的小端序
+	// sharedMagic identifies the shared memory file, "RGSH" in little endian
+	sharedMagic = 0x48534752
+
+	// sharedVersion 是共享内存文件的布局版本
+	// sharedVersion is the layout version of the shared memory file
+	sharedVersion = 1
+
+	// sharedFileSize 是共享内存文件的大小，头部填充到一个缓存行
+	// sharedFileSize is the size of the shared memory file, the header is padded to a cache line
+	sharedFileSize = 64
+)
+
+// sharedHeader 是映射到共享内存文件的状态，所有字段只通过原子操作访问，
+// 因此持有它的进程在任何时刻崩溃都不会留下写了一半的状态
+// sharedHeader is the state mapped to the shared memory file, all fields are accessed only through atomic operations,
+// so a process holding it crashing at any moment never leaves a half-written state
+type sharedHeader struct {
+	// magic 和 version 在其他字段初始化之后最后写入
+	// magic and version are written last after the other fields are initialized
+	magic   uint32
+	version uint32
+
+	// rate 是速率的 IEEE 754 位模式
+	// rate is the IEEE 754 bit pattern of the rate
+	rate uint64
+
+	// burst 是突发值
+	// burst is the burst
+	burst int64
+
+	// tat 是理论到达时间，单位为 Unix 纳秒，令牌桶的全部状态就是这一个字
+	// tat is the theoretical arrival time in Unix nanoseconds, the whole state of the token bucket is this single word
+	tat int64
+
+	_ [sharedFileSize - 32]byte
+}
+
+// SharedLimiter 是同一主机上多个进程共享的令牌桶限流器，状态保存在内存映射文件中，不需要网络服务。
+// 它使用通用信元速率算法 (GCRA)，令牌桶的状态只有一个字，每次预留是一次原子 CAS，不持有任何锁，
+// 文件锁只在打开文件时用于串行化初始化，进程退出时由内核释放
+// SharedLimiter is a token bucket limiter shared by several processes on the same host, its state is kept in a memory-mapped file without a network service.
+// It uses the generic cell rate algorithm (GCRA), the state of the token bucket is a single word and every reservation is one atomic CAS without holding any lock,
+// the file lock is only used to serialize the initialization when opening the file, and is released by the kernel when the process exits
+type SharedLimiter struct {
+	// path 是共享内存文件的路径
+	// path is the path of the shared memory file
+	path string
+
+	// data 是映射的内存
+	// data is the mapped memory
+	data []byte
+
+	// header 指向映射内存中的状态
+	// header points to the state in the mapped memory
+	header *sharedHeader
+}
+
+// NewSharedLimiter 是创建新的共享限流器的函数，它打开或者创建共享内存文件，并把配置的速率和突发值写入文件，
+// 因此最后打开文件的进程决定共享的限制。无效或者不完整的文件会被重新初始化
+// NewSharedLimiter is a function to create a new shared limiter, it opens or creates the shared memory file and writes the configured rate and burst to the file,
+// so the last process opening the file decides the shared limit. An invalid or incomplete file is initialized again
+func NewSharedLimiter(path string, conf *Config) (*SharedLimiter, error) {
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+	conf = isConfigValid(conf)
+
+	data, err := mapSharedFile(path, func(h *sharedHeader) {
+		// 先写入限制，再写入标识，初始化中途崩溃的文件在下次打开时被视为无效
+		// Write the limit before the magic, a file whose initialization crashed halfway is treated as invalid on the next opening
+		if atomic.LoadUint32(&h.magic) != sharedMagic || atomic.LoadUint32(&h.version) != sharedVersion {
+			atomic.StoreUint32(&h.magic, 0)
+			atomic.StoreInt64(&h.tat, 0)
+			storeSharedLimit(h, conf.rate, conf.burst)
+			atomic.StoreUint32(&h.version, sharedVersion)
+			atomic.StoreUint32(&h.magic, sharedMagic)
+			return
+		}
+		storeSharedLimit(h, conf.rate, conf.burst)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SharedLimiter{path: path, data: data, header: (*sharedHeader)(unsafe.Pointer(&data[0]))}, nil
+}
+
+// NewStrictSharedLimiter 是创建新的共享限流器的函数，它在配置无效时返回错误
+// NewStrictSharedLimiter is a function to create a new shared limiter, it returns an error when the configuration is invalid
+func NewStrictSharedLimiter(path string, conf *Config) (*SharedLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewSharedLimiter(path, conf)
+}
+
+// storeSharedLimit 是一个函数，它把速率和突发值写入共享状态
+// storeSharedLimit is a function that writes the rate and burst to the shared state
+func storeSharedLimit(h *sharedHeader, rate float64, burst int64) {
+	atomic.StoreUint64(&h.rate, math.Float64bits(rate))
+	atomic.StoreInt64(&h.burst, burst)
+}
+
+// When 是一个方法，它在共享的令牌桶中预留一个令牌并返回延迟时间。
+// 理论到达时间每次前进一个发射间隔，事件在它之前 burst 个间隔时可用
+// When is a method that reserves a token in the shared token bucket and returns the delay.
+// The theoretical arrival time advances by one emission interval each time, and the event is available burst intervals before it
+func (l *SharedLimiter) When() time.Duration {
+	h := l.header
+	for {
+		interval := float64(time.Second) / math.Float64frombits(atomic.LoadUint64(&h.rate))
+		tolerance := int64(interval * float64(atomic.LoadInt64(&h.burst)))
+
+		now := time.Now().UnixNano()
+		tat := atomic.LoadInt64(&h.tat)
+		start := tat
+		if start < now {
+			start = now
+		}
+
+		// 发射间隔至少为 1 纳秒
+		// The emission interval is at least 1 nanosecond
+		next := start + 1
+		if interval > 1 {
+			next = start + int64(interval)
+		}
+
+		if atomic.CompareAndSwapInt64(&h.tat, tat, next) {
+			if delay := next - tolerance - now; delay > 0 {
+				return time.Duration(delay)
+			}
+			return 0
+		}
+	}
+}
+
+// SetRate 是一个方法，它修改共享的速率，对所有进程生效
+// SetRate is a method that changes the shared rate, it takes effect for all processes
+func (l *SharedLimiter) SetRate(rate float64) {
+	if rate > 0 && !math.IsNaN(rate) && !math.IsInf(rate, 0) {
+		atomic.StoreUint64(&l.header.rate, math.Float64bits(rate))
+	}
+}
+
+// SetBurst 是一个方法，它修改共享的突发值，对所有进程生效
+// SetBurst is a method that changes the shared burst, it takes effect for all processes
+func (l *SharedLimiter) SetBurst(burst int64) {
+	if burst > 0 {
+		atomic.StoreInt64(&l.header.burst, burst)
+	}
+}
+
+// Rate 是一个方法，它返回共享的速率
+// Rate is a method that returns the shared rate
+func (l *SharedLimiter) Rate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&l.header.rate))
+}
+
+// Burst 是一个方法，它返回共享的突发值
+// Burst is a method that returns the shared burst
+func (l *SharedLimiter) Burst() int64 {
+	return atomic.LoadInt64(&l.header.burst)
+}
+
+// Path 是一个方法，它返回共享内存文件的路径
+// Path is a method that returns the path of the shared memory file
+func (l *SharedLimiter) Path() string {
+	return l.path
+}
+
+// Close 是一个方法，它解除文件的映射，文件和其中的状态保留给其他进程，关闭后不能再使用限流器
+// Close is a method that unmaps the file, the file and its state are kept for other processes, the limiter cannot be used after closing
+func (l *SharedLimiter) Close() error {
+	return unmapSharedFile(l.data)
+}
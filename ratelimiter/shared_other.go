@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ratelimiter
+
+// mapSharedFile 是一个函数，当前平台不支持共享内存文件，它总是返回错误
+// mapSharedFile is a function, the shared memory file is not supported on the current platform, it always returns an error
+func mapSharedFile(string, func(h *sharedHeader)) ([]byte, error) {
+	return nil, ErrSharedUnsupported
+}
+
+// unmapSharedFile 是一个函数，当前平台不支持共享内存文件，它什么也不做
+// unmapSharedFile is a function, the shared memory file is not supported on the current platform, it does nothing
+func unmapSharedFile([]byte) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ratelimiter
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapSharedFile 是一个函数，它打开或者创建共享内存文件并映射到内存，在独占文件锁内调用 init 检查和初始化状态
+// mapSharedFile is a function that opens or creates the shared memory file and maps it to memory, init is called within an exclusive file lock to check and initialize the state
+func mapSharedFile(path string, init func(h *sharedHeader)) ([]byte, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// 映射之后文件可以关闭，关闭文件也会释放文件锁
+	// The file can be closed after mapping, closing the file also releases the file lock
+	defer file.Close()
+
+	fd := int(file.Fd())
+	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return nil, &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+	defer func() { _ = syscall.Flock(fd, syscall.LOCK_UN) }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < sharedFileSize {
+		if err = file.Truncate(sharedFileSize); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := syscall.Mmap(fd, 0, sharedFileSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+
+	init((*sharedHeader)(unsafe.Pointer(&data[0])))
+	return data, nil
+}
+
+// unmapSharedFile 是一个函数，它解除共享内存文件的映射
+// unmapSharedFile is a function that unmaps the shared memory file
+func unmapSharedFile(data []byte) error {
+	return syscall.Munmap(data)
+}
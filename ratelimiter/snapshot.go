@@ -0,0 +1,258 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// SnapshotVersion 是当前的快照编码版本
+// SnapshotVersion is the current version of the snapshot encoding
+const SnapshotVersion = 1
+
+const (
+	// SnapshotKindToken 表示令牌桶限流器的快照
+	// SnapshotKindToken represents the snapshot of the token bucket limiter
+	SnapshotKindToken = "token"
+
+	// SnapshotKindNop 表示不执行任何操作的限流器的快照
+	// SnapshotKindNop represents the snapshot of the limiter that does not perform any operations
+	SnapshotKindNop = "nop"
+
+	// SnapshotKindMultiWindow 表示多窗口限流器的快照
+	// SnapshotKindMultiWindow represents the snapshot of the multi-window limiter
+	SnapshotKindMultiWindow = "multiwindow"
+)
+
+// WindowState 是窗口限流器中单个窗口的计数
+// WindowState is the counter of a single window in a window based limiter
+type WindowState struct {
+	// Start 是窗口的开始时间
+	// Start is the start time of the window
+	Start time.Time `json:"start"`
+
+	// Count 是窗口中已经允许的事件数量
+	// Count is the number of events already admitted in the window
+	Count int64 `json:"count"`
+}
+
+// Snapshot 是限流器状态的快照，它有稳定的带版本的二进制和 JSON 编码
+// Snapshot is a snapshot of the limiter state, it has a stable versioned binary and JSON encoding
+type Snapshot struct {
+	// Version 是快照的编码版本
+	// Version is the encoding version of the snapshot
+	Version int `json:"version"`
+
+	// Kind 是限流器的类型，恢复时必须与目标限流器一致
+	// Kind is the type of the limiter, it must match the target limiter when restoring
+	Kind string `json:"kind"`
+
+	// Tokens 是桶中的令牌数量，为负数时表示已经预留但尚未到期的令牌
+	// Tokens is the number of tokens in the bucket, a negative number means tokens that are reserved but not yet due
+	Tokens float64 `json:"tokens"`
+
+	// Updated 是快照的时间
+	// Updated is the time of the snapshot
+	Updated time.Time `json:"updated"`
+
+	// Windows 是窗口限流器的窗口计数
+	// Windows are the window counters of a window based limiter
+	Windows []WindowState `json:"windows,omitempty"`
+}
+
+// Snapshotter 是一个接口，定义了可以保存和恢复状态的限流器
+// Snapshotter is an interface that defines a limiter whose state can be saved and restored
+type Snapshotter interface {
+	// Snapshot 返回限流器当前状态的快照
+	// Snapshot returns a snapshot of the current state of the limiter
+	Snapshot() *Snapshot
+
+	// Restore 从快照恢复限流器的状态，快照之后经过的时间会被计算在内
+	// Restore restores the state of the limiter from the snapshot, the time elapsed since the snapshot is taken into account
+	Restore(s *Snapshot) error
+}
+
+// check 是一个方法，它检查快照的版本和类型是否可以恢复到指定类型的限流器
+// check is a method that checks if the version and type of the snapshot can be restored to the limiter of the specified type
+func (s *Snapshot) check(kind string) error {
+	if s == nil {
+		return ErrInvalidSnapshot
+	}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("%w, got %d", ErrSnapshotVersion, s.Version)
+	}
+	if s.Kind != kind {
+		return fmt.Errorf("%w: cannot restore %q to %q", ErrSnapshotKind, s.Kind, kind)
+	}
+	return nil
+}
+
+// MarshalBinary 是一个方法，它把快照编码为二进制，格式为版本、类型、令牌数量、时间和窗口计数，整数使用大端序
+// MarshalBinary is a method that encodes the snapshot into binary, the format is the version, type, tokens, time and window counters, integers are in big endian
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte(SnapshotVersion)
+	writeUvarint(&buf, uint64(len(s.Kind)))
+	buf.WriteString(s.Kind)
+	_ = binary.Write(&buf, binary.BigEndian, math.Float64bits(s.Tokens))
+	_ = binary.Write(&buf, binary.BigEndian, s.Updated.UnixNano())
+
+	writeUvarint(&buf, uint64(len(s.Windows)))
+	for _, w := range s.Windows {
+		_ = binary.Write(&buf, binary.BigEndian, w.Start.UnixNano())
+		_ = binary.Write(&buf, binary.BigEndian, w.Count)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary 是一个方法，它从二进制解码快照
+// UnmarshalBinary is a method that decodes the snapshot from binary
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if version < 1 || version > SnapshotVersion {
+		return fmt.Errorf("%w, got %d", ErrSnapshotVersion, version)
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return fmt.Errorf("%w: bad kind length", ErrInvalidSnapshot)
+	}
+	kind := make([]byte, size)
+	_, _ = r.Read(kind)
+
+	var bits uint64
+	var updated int64
+	if err = binary.Read(r, binary.BigEndian, &bits); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if err = binary.Read(r, binary.BigEndian, &updated); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	// 每个窗口占 16 字节，先检查长度再分配
+	// Each window takes 16 bytes, check the length before allocating
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()/16) {
+		return fmt.Errorf("%w: bad window count", ErrInvalidSnapshot)
+	}
+	var windows []WindowState
+	for i := uint64(0); i < count; i++ {
+		var start, n int64
+		_ = binary.Read(r, binary.BigEndian, &start)
+		_ = binary.Read(r, binary.BigEndian, &n)
+		windows = append(windows, WindowState{Start: time.Unix(0, start), Count: n})
+	}
+
+	*s = Snapshot{
+		Version: int(version),
+		Kind:    string(kind),
+		Tokens:  math.Float64frombits(bits),
+		Updated: time.Unix(0, updated),
+		Windows: windows,
+	}
+	return nil
+}
+
+// writeUvarint 是一个函数，它把无符号变长整数写入缓冲区
+// writeUvarint is a function that writes an unsigned varint into the buffer
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// Snapshot 是一个方法，它返回令牌桶当前的令牌数量
+// Snapshot is a method that returns the current tokens of the token bucket
+func (l *Limiter) Snapshot() *Snapshot {
+	now := time.Now()
+	return &Snapshot{
+		Version: SnapshotVersion,
+		Kind:    SnapshotKindToken,
+		Tokens:  l.limiter.TokensAt(now),
+		Updated: now.Round(0),
+	}
+}
+
+// Restore 是一个方法，它把令牌桶恢复到快照时的令牌数量，并补充快照之后按速率产生的令牌。
+// 速率和突发值保持当前的配置，令牌数量不超过当前的突发值。它应该在限流器开始使用前调用
+// Restore is a method that restores the token bucket to the tokens at the time of the snapshot, and adds the tokens produced at the rate since the snapshot.
+// The rate and burst keep the current configuration, and the tokens do not exceed the current burst. It should be called before the limiter is used
+func (l *Limiter) Restore(s *Snapshot) error {
+	if err := s.check(SnapshotKindToken); err != nil {
+		return err
+	}
+
+	limit, burst := l.limiter.Limit(), l.limiter.Burst()
+	if limit == rate.Inf || burst <= 0 {
+		return nil
+	}
+
+	// 时钟回拨时把快照时间视为现在
+	// Treat the snapshot time as now if the clock went backwards
+	now := time.Now()
+	at := s.Updated
+	if at.After(now) {
+		at = now
+	}
+	tokens := math.Min(s.Tokens, float64(burst))
+	if math.IsNaN(tokens) {
+		return fmt.Errorf("%w: tokens is NaN", ErrInvalidSnapshot)
+	}
+
+	// 速率为 0 时令牌不会补充，只能恢复整数个令牌
+	// The tokens are not refilled when the rate is 0, only whole tokens can be restored
+	if limit == 0 {
+		if used := burst - int(math.Max(tokens, 0)); used > 0 {
+			l.limiter.ReserveN(now, used)
+		}
+		return nil
+	}
+
+	// 欠下的令牌向上取整，剩下的小数部分由时间补充
+	// Round the owed tokens up, the remaining fraction is refilled by time
+	owed := 0
+	if tokens < 0 {
+		owed = int(math.Ceil(-tokens))
+	}
+	refill := time.Duration((tokens + float64(owed)) / float64(limit) * float64(time.Second))
+
+	// 先把上一次更新时间移到最早，使桶装满，然后在 at-refill 时取空整个桶，到 at 时正好补充到 tokens+owed 个令牌
+	// First move the last update time to the earliest so the bucket is full, then empty the whole bucket at at-refill, so there are exactly tokens+owed tokens at at
+	l.limiter.SetLimitAt(time.Time{}, limit)
+	l.limiter.ReserveN(at.Add(-refill), burst)
+
+	// 按突发值分块预留欠下的令牌
+	// Reserve the owed tokens in chunks of the burst
+	for owed > 0 {
+		n := owed
+		if n > burst {
+			n = burst
+		}
+		l.limiter.ReserveN(at, n)
+		owed -= n
+	}
+
+	return nil
+}
+
+// Snapshot 是一个方法，它返回一个没有状态的快照
+// Snapshot is a method that returns a snapshot without state
+func (l *NopLimiter) Snapshot() *Snapshot {
+	return &Snapshot{Version: SnapshotVersion, Kind: SnapshotKindNop, Updated: time.Now().Round(0)}
+}
+
+// Restore 是一个方法，它只检查快照的版本和类型
+// Restore is a method that only checks the version and type of the snapshot
+func (l *NopLimiter) Restore(s *Snapshot) error {
+	return s.check(SnapshotKindNop)
+}
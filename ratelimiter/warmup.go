@@ -0,0 +1,218 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultColdFactor 是默认的冷启动系数，冷状态下事件间隔是稳定间隔的 3 倍
+// DefaultColdFactor is the default cold factor, the event interval in the cold state is 3 times the stable interval
+const DefaultColdFactor = 3.0
+
+// WarmUpConfig 是预热限流器的配置
+// WarmUpConfig is the configuration of the warm-up limiter
+type WarmUpConfig struct {
+	// rate 是预热完成后的稳定速率
+	// rate is the stable rate after warming up
+	rate float64
+
+	// warmup 是从冷状态加速到稳定速率的时间
+	// warmup is the time to ramp up from the cold state to the stable rate
+	warmup time.Duration
+
+	// coldFactor 是冷状态下的事件间隔与稳定间隔的比值
+	// coldFactor is the ratio of the event interval in the cold state to the stable interval
+	coldFactor float64
+
+	// coldAfter 是从稳定状态空闲到完全回到冷状态的时间
+	// coldAfter is the idle time from the stable state back to the fully cold state
+	coldAfter time.Duration
+}
+
+// NewWarmUpConfig 是创建新的预热限流器配置的函数，它接受稳定速率和预热时间，默认空闲一个预热时间后完全回到冷状态
+// NewWarmUpConfig is a function to create a new warm-up limiter configuration, it accepts the stable rate and the warm-up time, by default it is fully cold again after being idle for one warm-up time
+func NewWarmUpConfig(rate float64, warmup time.Duration) *WarmUpConfig {
+	return &WarmUpConfig{rate: rate, warmup: warmup, coldFactor: DefaultColdFactor, coldAfter: warmup}
+}
+
+// WithColdFactor 它设置冷状态下的事件间隔与稳定间隔的比值，冷状态的速率是 rate / factor
+// WithColdFactor is a method that sets the ratio of the event interval in the cold state to the stable interval, the rate in the cold state is rate / factor
+func (c *WarmUpConfig) WithColdFactor(factor float64) *WarmUpConfig {
+	c.coldFactor = factor
+	return c
+}
+
+// WithColdAfter 它设置从稳定状态空闲到完全回到冷状态的时间，空闲时间较短时部分回到冷状态
+// WithColdAfter is a method that sets the idle time from the stable state back to the fully cold state, it is partially cold again after a shorter idle time
+func (c *WarmUpConfig) WithColdAfter(idle time.Duration) *WarmUpConfig {
+	c.coldAfter = idle
+	return c
+}
+
+// Validate 是一个方法，它严格检查预热限流器配置是否有效
+// Validate is a method that strictly checks if the warm-up limiter configuration is valid
+func (c *WarmUpConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if err := NewConfig().WithRate(c.rate).Validate(); err != nil {
+		return err
+	}
+	if c.warmup <= 0 || c.coldAfter <= 0 || c.coldFactor < 1 || math.IsInf(c.coldFactor, 0) || math.IsNaN(c.coldFactor) {
+		return fmt.Errorf("%w, got warm-up %v, cold factor %v and cold after %v", ErrInvalidWarmUp, c.warmup, c.coldFactor, c.coldAfter)
+	}
+	return nil
+}
+
+// isWarmUpConfigValid 是一个函数，它检查预热限流器配置是否有效，如果无效，它将设置为默认值
+// isWarmUpConfigValid is a function that checks if the warm-up limiter configuration is valid, if not, it sets it to the default values
+func isWarmUpConfigValid(conf *WarmUpConfig) *WarmUpConfig {
+	if conf == nil {
+		conf = NewWarmUpConfig(DefaultLimitRate, time.Second)
+	}
+	if NewConfig().WithRate(conf.rate).Validate() != nil {
+		conf.rate = DefaultLimitRate
+	}
+	if conf.warmup <= 0 {
+		conf.warmup = time.Second
+	}
+	if conf.coldFactor < 1 || math.IsInf(conf.coldFactor, 0) || math.IsNaN(conf.coldFactor) {
+		conf.coldFactor = DefaultColdFactor
+	}
+	if conf.coldAfter <= 0 {
+		conf.coldAfter = conf.warmup
+	}
+	return conf
+}
+
+// WarmUpLimiter 是预热限流器，它与 Guava 的 SmoothWarmingUp 相同，从 rate / coldFactor 的冷速率开始，
+// 在预热时间内平滑地加速到稳定速率，空闲后逐渐回到冷状态。
+// 它使用存储的许可表示冷的程度：许可在空闲时积累，超过阈值的许可比稳定间隔更昂贵，越接近上限越昂贵。
+// 每个事件的代价由下一个事件支付，因此空闲后的第一个事件不会等待
+// WarmUpLimiter is the warm-up limiter, it is the same as SmoothWarmingUp of Guava, it starts from the cold rate of rate / coldFactor,
+// ramps up smoothly to the stable rate within the warm-up time, and becomes cold gradually after being idle.
+// It uses stored permits to represent how cold it is: permits accumulate while idle, permits above the threshold are more expensive than the stable interval, and the closer to the maximum the more expensive.
+// The cost of each event is paid by the next event, so the first event after being idle does not wait
+type WarmUpLimiter struct {
+	// config 是预热限流器的配置
+	// config is the configuration of the warm-up limiter
+	config *WarmUpConfig
+
+	// stable 和 cold 是稳定间隔和冷间隔，单位为纳秒
+	// stable and cold are the stable interval and the cold interval in nanoseconds
+	stable, cold float64
+
+	// threshold 和 maxPermits 是开始变贵的许可数量和许可的上限
+	// threshold and maxPermits are the number of permits at which they start to be expensive and the maximum of permits
+	threshold, maxPermits float64
+
+	// slope 是超过阈值后每个许可增加的间隔
+	// slope is the interval added by each permit above the threshold
+	slope float64
+
+	// coolDown 是空闲时积累一个许可的时间，单位为纳秒
+	// coolDown is the time to accumulate one permit while idle in nanoseconds
+	coolDown float64
+
+	// lock 保护存储的许可和下一个空闲时间
+	// lock protects the stored permits and the next free time
+	lock sync.Mutex
+
+	// stored 是存储的许可
+	// stored is the stored permits
+	stored float64
+
+	// next 是下一个事件可以开始的时间
+	// next is the time the next event can start
+	next time.Time
+}
+
+// NewWarmUpLimiter 是创建新的预热限流器的函数，它从冷状态开始
+// NewWarmUpLimiter is a function to create a new warm-up limiter, it starts in the cold state
+func NewWarmUpLimiter(conf *WarmUpConfig) *WarmUpLimiter {
+	conf = isWarmUpConfigValid(conf)
+
+	l := &WarmUpLimiter{config: conf, next: time.Now()}
+	l.stable = float64(time.Second) / conf.rate
+	l.cold = l.stable * conf.coldFactor
+
+	// 阈值以下的许可在半个预热时间内以稳定间隔消耗，阈值以上的许可在一个预热时间内从冷间隔加速到稳定间隔
+	// Permits below the threshold are consumed at the stable interval within half a warm-up time, permits above it ramp up from the cold interval to the stable interval within one warm-up time
+	warmup := float64(conf.warmup)
+	l.threshold = 0.5 * warmup / l.stable
+	l.maxPermits = l.threshold + 2*warmup/(l.stable+l.cold)
+	l.slope = (l.cold - l.stable) / (l.maxPermits - l.threshold)
+	l.coolDown = float64(conf.coldAfter) / l.maxPermits
+	l.stored = l.maxPermits
+
+	return l
+}
+
+// NewStrictWarmUpLimiter 是创建新的预热限流器的函数，它在配置无效时返回错误
+// NewStrictWarmUpLimiter is a function to create a new warm-up limiter, it returns an error when the configuration is invalid
+func NewStrictWarmUpLimiter(conf *WarmUpConfig) (*WarmUpLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewWarmUpLimiter(conf), nil
+}
+
+// resync 是一个方法，它把空闲时间换算成存储的许可，调用者必须持有锁
+// resync is a method that converts the idle time into stored permits, the caller must hold the lock
+func (l *WarmUpLimiter) resync(now time.Time) {
+	if now.After(l.next) {
+		l.stored = math.Min(l.maxPermits, l.stored+float64(now.Sub(l.next))/l.coolDown)
+		l.next = now
+	}
+}
+
+// interval 是一个方法，它返回存储的许可为 permits 时的事件间隔，单位为纳秒
+// interval is a method that returns the event interval when the stored permits are permits in nanoseconds
+func (l *WarmUpLimiter) interval(permits float64) float64 {
+	if permits <= l.threshold {
+		return l.stable
+	}
+	return l.stable + (permits-l.threshold)*l.slope
+}
+
+// When 是一个方法，它预留一个事件并返回延迟时间
+// When is a method that reserves an event and returns the delay
+func (l *WarmUpLimiter) When() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.resync(now)
+	delay := l.next.Sub(now)
+
+	// 消耗一个存储的许可，代价是间隔函数在这个许可上的积分，阈值以下的部分和没有存储的许可都按稳定间隔
+	// Consume one stored permit, the cost is the integral of the interval function over this permit, the part below the threshold and the missing stored permit both cost the stable interval
+	spend := math.Min(1, l.stored)
+	above := math.Min(spend, math.Max(0, l.stored-l.threshold))
+	cost := l.stable*(1-above) + (l.interval(l.stored)+l.interval(l.stored-above))/2*above
+	l.stored -= spend
+	l.next = l.next.Add(time.Duration(cost))
+
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Rate 是一个方法，它返回预热完成后的稳定速率
+// Rate is a method that returns the stable rate after warming up
+func (l *WarmUpLimiter) Rate() float64 {
+	return l.config.rate
+}
+
+// CurrentRate 是一个方法，它返回当前的速率，它在冷速率和稳定速率之间
+// CurrentRate is a method that returns the current rate, it is between the cold rate and the stable rate
+func (l *WarmUpLimiter) CurrentRate() float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.resync(time.Now())
+	return float64(time.Second) / l.interval(l.stored)
+}
@@ -0,0 +1,422 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CalendarUnit 是日历窗口的单位，日历窗口对齐到配置时区的日历边界
+// CalendarUnit is the unit of a calendar window, a calendar window is aligned to the calendar boundaries of the configured time zone
+type CalendarUnit int8
+
+const (
+	// CalendarNone 表示滚动窗口，任意 period 长度的时间内最多允许 limit 个事件
+	// CalendarNone means a rolling window, at most limit events are admitted within any time of period length
+	CalendarNone CalendarUnit = iota
+
+	// CalendarHour 是从整点开始的小时
+	// CalendarHour is the hour starting on the hour
+	CalendarHour
+
+	// CalendarDay 是从午夜开始的一天
+	// CalendarDay is the day starting at midnight
+	CalendarDay
+
+	// CalendarWeek 是从周一午夜开始的一周
+	// CalendarWeek is the week starting at midnight on Monday
+	CalendarWeek
+
+	// CalendarMonth 是从一日午夜开始的一个月
+	// CalendarMonth is the month starting at midnight on the first day
+	CalendarMonth
+)
+
+// String 是一个方法，它返回日历单位的名称
+// String is a method that returns the name of the calendar unit
+func (u CalendarUnit) String() string {
+	switch u {
+	case CalendarNone:
+		return "rolling"
+	case CalendarHour:
+		return "hour"
+	case CalendarDay:
+		return "day"
+	case CalendarWeek:
+		return "week"
+	case CalendarMonth:
+		return "month"
+	default:
+		return "unknown"
+	}
+}
+
+// bounds 是一个方法，它返回在指定时区中包含 t 的日历窗口的开始和结束时间
+// bounds is a method that returns the start and end of the calendar window containing t in the specified time zone
+func (u CalendarUnit) bounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(loc)
+	year, month, day := t.Date()
+
+	switch u {
+	case CalendarHour:
+		start := time.Date(year, month, day, t.Hour(), 0, 0, 0, loc)
+		return start, start.Add(time.Hour)
+	case CalendarWeek:
+		start := time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 7)
+	case CalendarMonth:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// WindowRule 是多窗口限流器的一条规则，Calendar 为 CalendarNone 时是长度为 Period 的滚动窗口，否则是日历窗口并忽略 Period
+// WindowRule is a rule of the multi-window limiter, it is a rolling window of Period length when Calendar is CalendarNone, otherwise it is a calendar window and Period is ignored
+type WindowRule struct {
+	// Limit 是窗口内最多允许的事件数量
+	// Limit is the maximum number of events admitted within the window
+	Limit int64
+
+	// Period 是滚动窗口的长度
+	// Period is the length of the rolling window
+	Period time.Duration
+
+	// Calendar 是日历窗口的单位
+	// Calendar is the unit of the calendar window
+	Calendar CalendarUnit
+}
+
+// validate 是一个方法，它检查规则是否有效
+// validate is a method that checks if the rule is valid
+func (r WindowRule) validate() error {
+	if r.Limit <= 0 {
+		return fmt.Errorf("%w, got limit %d", ErrInvalidWindow, r.Limit)
+	}
+	if r.Calendar == CalendarNone && r.Period <= 0 {
+		return fmt.Errorf("%w, got period %v", ErrInvalidWindow, r.Period)
+	}
+	if r.Calendar < CalendarNone || r.Calendar > CalendarMonth {
+		return fmt.Errorf("%w, got calendar unit %d", ErrInvalidWindow, r.Calendar)
+	}
+	return nil
+}
+
+// MultiWindowConfig 是多窗口限流器的配置
+// MultiWindowConfig is the configuration of the multi-window limiter
+type MultiWindowConfig struct {
+	// rules 是窗口规则，事件只有在所有窗口都允许时才被放行
+	// rules are the window rules, an event is admitted only when all windows allow it
+	rules []WindowRule
+
+	// location 是日历窗口使用的时区
+	// location is the time zone used by the calendar windows
+	location *time.Location
+}
+
+// NewMultiWindowConfig 是创建新的多窗口限流器配置的函数，它接受窗口规则，默认使用本地时区
+// NewMultiWindowConfig is a function to create a new multi-window limiter configuration, it accepts the window rules, the local time zone is used by default
+func NewMultiWindowConfig(rules ...WindowRule) *MultiWindowConfig {
+	return &MultiWindowConfig{rules: append([]WindowRule(nil), rules...), location: time.Local}
+}
+
+// DefaultMultiWindowConfig 是获取默认多窗口限流器配置的函数，它每秒最多允许 DefaultLimitRate 个事件
+// DefaultMultiWindowConfig is a function to get the default multi-window limiter configuration, it admits at most DefaultLimitRate events per second
+func DefaultMultiWindowConfig() *MultiWindowConfig {
+	return NewMultiWindowConfig().WithWindow(DefaultLimitRate, time.Second)
+}
+
+// WithWindow 它添加一个滚动窗口，任意 period 长度的时间内最多允许 limit 个事件，窗口的内存与 limit 成正比
+// WithWindow is a method that adds a rolling window, at most limit events are admitted within any time of period length, the memory of the window is proportional to limit
+func (c *MultiWindowConfig) WithWindow(limit int64, period time.Duration) *MultiWindowConfig {
+	c.rules = append(c.rules, WindowRule{Limit: limit, Period: period})
+	return c
+}
+
+// WithCalendarWindow 它添加一个日历窗口，每个日历单位内最多允许 limit 个事件，窗口在日历边界重置
+// WithCalendarWindow is a method that adds a calendar window, at most limit events are admitted within each calendar unit, the window resets on the calendar boundary
+func (c *MultiWindowConfig) WithCalendarWindow(limit int64, unit CalendarUnit) *MultiWindowConfig {
+	c.rules = append(c.rules, WindowRule{Limit: limit, Calendar: unit})
+	return c
+}
+
+// WithLocation 它设置日历窗口使用的时区
+// WithLocation is a method that sets the time zone used by the calendar windows
+func (c *MultiWindowConfig) WithLocation(loc *time.Location) *MultiWindowConfig {
+	c.location = loc
+	return c
+}
+
+// Validate 是一个方法，它严格检查多窗口限流器配置是否有效
+// Validate is a method that strictly checks if the multi-window limiter configuration is valid
+func (c *MultiWindowConfig) Validate() error {
+	if c == nil {
+		return ErrConfigIsNil
+	}
+	if len(c.rules) == 0 {
+		return fmt.Errorf("%w, got no rules", ErrInvalidWindow)
+	}
+	for _, rule := range c.rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMultiWindowConfigValid 是一个函数，它检查多窗口限流器配置是否有效，无效的规则被丢弃，没有规则时使用默认配置
+// isMultiWindowConfigValid is a function that checks if the multi-window limiter configuration is valid, invalid rules are dropped and the default configuration is used when there are no rules
+func isMultiWindowConfigValid(conf *MultiWindowConfig) *MultiWindowConfig {
+	if conf == nil {
+		return DefaultMultiWindowConfig()
+	}
+
+	rules := make([]WindowRule, 0, len(conf.rules))
+	for _, rule := range conf.rules {
+		if rule.validate() == nil {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		rules = DefaultMultiWindowConfig().rules
+	}
+	conf.rules = rules
+
+	if conf.location == nil {
+		conf.location = time.Local
+	}
+	return conf
+}
+
+// window 是一个窗口的状态
+// window is the state of a window
+type window struct {
+	// rule 是窗口规则
+	// rule is the window rule
+	rule WindowRule
+
+	// times 是滚动窗口最近 limit 个事件的时间，单位为 Unix 纳秒，它是一个环形缓冲区，head 指向最早的事件
+	// times are the times of the latest limit events of the rolling window in Unix nanoseconds, it is a ring buffer and head points to the earliest event
+	times []int64
+	head  int
+
+	// start、end 和 count 是日历窗口当前的边界和事件数量
+	// start, end and count are the current boundaries and number of events of the calendar window
+	start, end time.Time
+	count      int64
+}
+
+// earliest 是一个方法，它返回不早于 t 且窗口允许一个新事件的最早时间
+// earliest is a method that returns the earliest time no earlier than t at which the window admits a new event
+func (w *window) earliest(t time.Time) time.Time {
+	if w.rule.Calendar == CalendarNone {
+		if int64(len(w.times)) < w.rule.Limit {
+			return t
+		}
+		if at := time.Unix(0, w.times[w.head]).Add(w.rule.Period); at.After(t) {
+			return at
+		}
+		return t
+	}
+
+	if t.Before(w.end) && w.count >= w.rule.Limit {
+		return w.end
+	}
+	return t
+}
+
+// add 是一个方法，它在时间 t 记录一个事件，t 不早于之前记录的事件
+// add is a method that records an event at time t, t is no earlier than the events recorded before
+func (w *window) add(t time.Time, loc *time.Location) {
+	if w.rule.Calendar == CalendarNone {
+		if int64(len(w.times)) < w.rule.Limit {
+			w.times = append(w.times, t.UnixNano())
+			return
+		}
+		w.times[w.head] = t.UnixNano()
+		w.head = (w.head + 1) % len(w.times)
+		return
+	}
+
+	if !t.Before(w.end) {
+		w.start, w.end = w.rule.Calendar.bounds(t, loc)
+		w.count = 0
+	}
+	w.count++
+}
+
+// MultiWindowLimiter 是叠加多个窗口的限流器，例如每秒 10 个、每分钟 600 个并且每天 50000 个。
+// 事件只有在所有窗口都允许时才被放行，延迟时间是各个窗口中最大的延迟
+// MultiWindowLimiter is a limiter stacking several windows, such as 10 per second, 600 per minute and 50000 per day.
+// An event is admitted only when all windows allow it, the delay is the maximum delay across the windows
+type MultiWindowLimiter struct {
+	// config 是多窗口限流器的配置
+	// config is the configuration of the multi-window limiter
+	config *MultiWindowConfig
+
+	// lock 保护窗口的状态
+	// lock protects the state of the windows
+	lock sync.Mutex
+
+	// windows 是每条规则的窗口
+	// windows are the windows of each rule
+	windows []*window
+
+	// last 是最后一个事件的时间，事件按预留的顺序放行
+	// last is the time of the last event, events are admitted in the order of reservation
+	last time.Time
+}
+
+// NewMultiWindowLimiter 是创建新的多窗口限流器的函数
+// NewMultiWindowLimiter is a function to create a new multi-window limiter
+func NewMultiWindowLimiter(conf *MultiWindowConfig) *MultiWindowLimiter {
+	conf = isMultiWindowConfigValid(conf)
+
+	l := &MultiWindowLimiter{config: conf, windows: make([]*window, len(conf.rules))}
+	for i, rule := range conf.rules {
+		l.windows[i] = &window{rule: rule}
+	}
+	return l
+}
+
+// NewStrictMultiWindowLimiter 是创建新的多窗口限流器的函数，它在配置无效时返回错误
+// NewStrictMultiWindowLimiter is a function to create a new multi-window limiter, it returns an error when the configuration is invalid
+func NewStrictMultiWindowLimiter(conf *MultiWindowConfig) (*MultiWindowLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return NewMultiWindowLimiter(conf), nil
+}
+
+// When 是一个方法，它为一个事件预留所有窗口都允许的最早时间，并返回延迟时间
+// When is a method that reserves the earliest time allowed by all windows for an event and returns the delay
+func (l *MultiWindowLimiter) When() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// 窗口状态使用墙上时间，以便与日历边界比较
+	// The window state uses the wall clock so that it can be compared with the calendar boundaries
+	now := time.Now().Round(0)
+	t := now
+	if t.Before(l.last) {
+		t = l.last
+	}
+
+	// 推迟到所有窗口都允许的时间，每一轮至少推迟到一个窗口的边界，直到没有窗口再推迟
+	// Postpone to the time allowed by all windows, every round postpones to at least one window boundary, until no window postpones any more
+	for {
+		next := t
+		for _, w := range l.windows {
+			if at := w.earliest(t); at.After(next) {
+				next = at
+			}
+		}
+		if !next.After(t) {
+			break
+		}
+		t = next
+	}
+
+	for _, w := range l.windows {
+		w.add(t, l.config.location)
+	}
+	l.last = t
+
+	return t.Sub(now)
+}
+
+// Rules 是一个方法，它返回窗口规则
+// Rules is a method that returns the window rules
+func (l *MultiWindowLimiter) Rules() []WindowRule {
+	return append([]WindowRule(nil), l.config.rules...)
+}
+
+// Snapshot 是一个方法，它按规则的顺序返回每个窗口的计数。日历窗口保存当前窗口的开始时间和事件数量，
+// 滚动窗口保存最早的仍在窗口内的事件时间和窗口内的事件数量。预留了未来的事件时，快照时间是最后一个事件的时间
+// Snapshot is a method that returns the counter of each window in the order of the rules. A calendar window saves the start time and the number of events of the current window,
+// a rolling window saves the time of the earliest event still within the window and the number of events within the window. When future events are reserved, the snapshot time is the time of the last event
+func (l *MultiWindowLimiter) Snapshot() *Snapshot {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now().Round(0)
+	if now.Before(l.last) {
+		now = l.last
+	}
+
+	windows := make([]WindowState, len(l.windows))
+	for i, w := range l.windows {
+		if w.rule.Calendar != CalendarNone {
+			windows[i] = WindowState{Start: w.start, Count: w.count}
+			continue
+		}
+
+		// 从最早的事件开始遍历环形缓冲区，跳过已经离开窗口的事件
+		// Walk the ring buffer from the earliest event, skip the events that have left the window
+		since := now.Add(-w.rule.Period).UnixNano()
+		for j := range w.times {
+			at := w.times[(w.head+j)%len(w.times)]
+			if at <= since {
+				continue
+			}
+			if windows[i].Count == 0 {
+				windows[i].Start = time.Unix(0, at)
+			}
+			windows[i].Count++
+		}
+	}
+
+	return &Snapshot{Version: SnapshotVersion, Kind: SnapshotKindMultiWindow, Updated: now, Windows: windows}
+}
+
+// Restore 是一个方法，它把每个窗口恢复到快照时的计数，快照的窗口必须与规则一一对应。
+// 滚动窗口只保存了最早的事件时间，其余的事件视为发生在快照时间，所以恢复的结果不会比原来更宽松。它应该在限流器开始使用前调用
+// Restore is a method that restores each window to the counter at the time of the snapshot, the windows of the snapshot must correspond to the rules one by one.
+// A rolling window only saves the time of the earliest event, the other events are regarded as happening at the snapshot time, so the result is never looser than before. It should be called before the limiter is used
+func (l *MultiWindowLimiter) Restore(s *Snapshot) error {
+	if err := s.check(SnapshotKindMultiWindow); err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(s.Windows) != len(l.windows) {
+		return fmt.Errorf("%w: got %d windows for %d rules", ErrInvalidSnapshot, len(s.Windows), len(l.windows))
+	}
+	for _, state := range s.Windows {
+		if state.Count < 0 {
+			return fmt.Errorf("%w: window count is %d", ErrInvalidSnapshot, state.Count)
+		}
+	}
+
+	for i, w := range l.windows {
+		state := s.Windows[i]
+		if w.rule.Calendar != CalendarNone {
+			w.start, w.end, w.count = time.Time{}, time.Time{}, 0
+			if state.Count > 0 {
+				w.start, w.end = w.rule.Calendar.bounds(state.Start, l.config.location)
+				w.count = state.Count
+			}
+			continue
+		}
+
+		w.times, w.head = w.times[:0], 0
+		start := state.Start
+		if start.After(s.Updated) {
+			start = s.Updated
+		}
+		for j := int64(0); j < state.Count && j < w.rule.Limit; j++ {
+			at := s.Updated
+			if j == 0 {
+				at = start
+			}
+			w.times = append(w.times, at.UnixNano())
+		}
+	}
+	l.last = s.Updated.Round(0)
+
+	return nil
+}
@@ -0,0 +1,434 @@
+package regula
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+)
+
+// DefaultReloadInterval 是默认的配置文件轮询间隔
+// DefaultReloadInterval is the default polling interval of the configuration file
+const DefaultReloadInterval = time.Second * 2
+
+const (
+	// LimiterTypeToken 表示令牌桶速率限制器
+	// LimiterTypeToken represents the token bucket rate limiter
+	LimiterTypeToken = "token"
+
+	// LimiterTypeNop 表示不执行任何操作的速率限制器
+	// LimiterTypeNop represents the rate limiter that does not perform any operations
+	LimiterTypeNop = "nop"
+)
+
+// LimiterSpec 是配置文件中单个速率限制器的描述
+// LimiterSpec is the description of a single rate limiter in the configuration file
+type LimiterSpec struct {
+	// Type 是速率限制器的类型，为空时表示令牌桶速率限制器
+	// Type is the type of the rate limiter, empty means the token bucket rate limiter
+	Type string `json:"type"`
+
+	// Rate 是限制的速率
+	// Rate is the limit rate
+	Rate float64 `json:"rate"`
+
+	// Burst 是限制的突发值
+	// Burst is the limit burst
+	Burst int64 `json:"burst"`
+}
+
+// kind 是一个方法，它返回速率限制器的类型，为空时返回令牌桶类型
+// kind is a method that returns the type of the rate limiter, returns the token bucket type if it is empty
+func (s *LimiterSpec) kind() string {
+	if s.Type == "" {
+		return LimiterTypeToken
+	}
+	return s.Type
+}
+
+// Validate 是一个方法，它检查速率限制器的描述是否有效
+// Validate is a method that checks if the description of the rate limiter is valid
+func (s *LimiterSpec) Validate() error {
+	switch s.kind() {
+	case LimiterTypeToken:
+		return rl.NewConfig().WithRate(s.Rate).WithBurst(s.Burst).Validate()
+	case LimiterTypeNop:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownLimiterType, s.Type)
+	}
+}
+
+// newRateLimiter 是一个方法，它根据描述创建一个新的速率限制器，调用前描述必须已经通过检查
+// newRateLimiter is a method that creates a new rate limiter according to the description, the description must have been validated before calling
+func (s *LimiterSpec) newRateLimiter() RateLimiter {
+	if s.kind() == LimiterTypeNop {
+		return rl.NewNopLimiter()
+	}
+	return rl.NewRateLimiter(rl.NewConfig().WithRate(s.Rate).WithBurst(s.Burst))
+}
+
+// ReloadFile 是配置文件的结构，速率限制器按名称索引
+// ReloadFile is the structure of the configuration file, the rate limiters are indexed by name
+type ReloadFile struct {
+	// Limiters 是按名称索引的速率限制器描述
+	// Limiters are the rate limiter descriptions indexed by name
+	Limiters map[string]*LimiterSpec `json:"limiters"`
+}
+
+// ReloadEvent 是一次配置重新加载的结果
+// ReloadEvent is the result of a configuration reload
+type ReloadEvent struct {
+	// Path 是配置文件的路径
+	// Path is the path of the configuration file
+	Path string
+
+	// Applied 是本次被修改并应用的速率限制器名称，按字母顺序排列
+	// Applied are the names of the rate limiters that were changed and applied this time, in alphabetical order
+	Applied []string
+
+	// Removed 是本次从配置文件中删除的速率限制器名称，按字母顺序排列，已注册的目标保留最后一次应用的限制
+	// Removed are the names of the rate limiters removed from the configuration file this time, in alphabetical order, the registered targets keep the last applied limit
+	Removed []string
+
+	// Err 是本次重新加载的错误，如果解析或检查失败，上一次有效的配置会被保留
+	// Err is the error of this reload, if parsing or validation fails, the last valid configuration is kept
+	Err error
+}
+
+// reloadTarget 是一个接口，定义了可以应用速率限制器描述的目标
+// reloadTarget is an interface that defines a target to which a rate limiter description can be applied
+type reloadTarget interface {
+	// checkLimiterSpec 检查描述能否应用到目标上，不修改目标
+	// checkLimiterSpec checks whether the description can be applied to the target without modifying the target
+	checkLimiterSpec(spec *LimiterSpec) error
+
+	// applyLimiterSpec 把描述应用到目标上
+	// applyLimiterSpec applies the description to the target
+	applyLimiterSpec(spec *LimiterSpec) error
+}
+
+// limiterTarget 是一个包装结构体，它让令牌桶速率限制器可以作为重新加载的目标
+// limiterTarget is a wrapper structure that makes the token bucket rate limiter a reload target
+type limiterTarget struct{ limiter *rl.Limiter }
+
+// applyLimiterSpec 是一个方法，它原地修改令牌桶速率限制器的速率和突发值，独立的速率限制器不能修改类型
+// applyLimiterSpec is a method that modifies the rate and burst of the token bucket rate limiter in place, a standalone rate limiter cannot change its type
+func (t limiterTarget) applyLimiterSpec(spec *LimiterSpec) error {
+	if err := t.checkLimiterSpec(spec); err != nil {
+		return err
+	}
+	t.limiter.SetLimit(spec.Rate, spec.Burst)
+	return nil
+}
+
+// checkLimiterSpec 是一个方法，它检查描述是否是令牌桶速率限制器
+// checkLimiterSpec is a method that checks whether the description is a token bucket rate limiter
+func (t limiterTarget) checkLimiterSpec(spec *LimiterSpec) error {
+	if spec.kind() != LimiterTypeToken {
+		return fmt.Errorf("%w: cannot change a standalone limiter to %q", ErrLimiterTypeMismatch, spec.Type)
+	}
+	return nil
+}
+
+// checkLimiterSpec 是一个方法，流控制器可以替换为任何类型的速率限制器，所以总是返回 nil
+// checkLimiterSpec is a method that always returns nil, because the flow controller can be replaced with a rate limiter of any type
+func (fc *FlowController) checkLimiterSpec(_ *LimiterSpec) error {
+	return nil
+}
+
+// applyLimiterSpec 是一个方法，它把速率限制器描述应用到流控制器上
+// applyLimiterSpec is a method that applies the rate limiter description to the flow controller
+func (fc *FlowController) applyLimiterSpec(spec *LimiterSpec) error {
+	// 如果类型没有变化，原地修改令牌桶，保留桶中已有的令牌
+	// If the type has not changed, modify the token bucket in place and keep the existing tokens in the bucket
+	if limiter, ok := fc.RateLimiter().(*rl.Limiter); ok && spec.kind() == LimiterTypeToken {
+		return limiterTarget{limiter}.applyLimiterSpec(spec)
+	}
+
+	// 否则原子地替换为新的速率限制器
+	// Otherwise replace it atomically with a new rate limiter
+	fc.SetRateLimiter(spec.newRateLimiter())
+	return nil
+}
+
+// ReloaderConfig 是重新加载器的配置
+// ReloaderConfig is the configuration of the reloader
+type ReloaderConfig struct {
+	// interval 是配置文件的轮询间隔
+	// interval is the polling interval of the configuration file
+	interval time.Duration
+
+	// callback 是重新加载的回调函数
+	// callback is the reload callback function
+	callback ReloadCallback
+}
+
+// NewReloaderConfig 是创建新的重新加载器配置的函数
+// NewReloaderConfig is a function to create a new reloader configuration
+func NewReloaderConfig() *ReloaderConfig {
+	return &ReloaderConfig{
+		interval: DefaultReloadInterval,
+		callback: NewEmptyReloadCallback(),
+	}
+}
+
+// DefaultReloaderConfig 是获取默认重新加载器配置的函数
+// DefaultReloaderConfig is a function to get the default reloader configuration
+func DefaultReloaderConfig() *ReloaderConfig {
+	return NewReloaderConfig()
+}
+
+// WithInterval 它设置配置文件的轮询间隔
+// WithInterval is a method that sets the polling interval of the configuration file
+func (c *ReloaderConfig) WithInterval(interval time.Duration) *ReloaderConfig {
+	c.interval = interval
+	return c
+}
+
+// WithCallback 它设置重新加载的回调函数
+// WithCallback is a method that sets the reload callback function
+func (c *ReloaderConfig) WithCallback(cb ReloadCallback) *ReloaderConfig {
+	c.callback = cb
+	return c
+}
+
+// isReloaderConfigValid 是一个函数，它检查重新加载器配置是否有效，如果无效，它将设置为默认值
+// isReloaderConfigValid is a function that checks if the reloader configuration is valid, if not, it sets it to the default values
+func isReloaderConfigValid(conf *ReloaderConfig) *ReloaderConfig {
+	if conf != nil {
+		if conf.interval <= 0 {
+			conf.interval = DefaultReloadInterval
+		}
+		if conf.callback == nil {
+			conf.callback = NewEmptyReloadCallback()
+		}
+	} else {
+		conf = DefaultReloaderConfig()
+	}
+	return conf
+}
+
+// Reloader 是重新加载器，它轮询配置文件并把修改后的速率限制器应用到已注册的流控制器和速率限制器上
+// Reloader is the reloader, it polls the configuration file and applies the changed rate limiters to the registered flow controllers and rate limiters
+type Reloader struct {
+	// path 是配置文件的路径
+	// path is the path of the configuration file
+	path string
+
+	// config 是重新加载器的配置
+	// config is the configuration of the reloader
+	config *ReloaderConfig
+
+	// lock 保护已注册的目标、上一次有效的配置和文件状态
+	// lock protects the registered targets, the last valid configuration and the file status
+	lock sync.Mutex
+
+	// targets 是按名称索引的重新加载目标
+	// targets are the reload targets indexed by name
+	targets map[string][]reloadTarget
+
+	// current 是上一次有效的速率限制器描述
+	// current are the last valid rate limiter descriptions
+	current map[string]LimiterSpec
+
+	// modTime 和 size 是上一次加载时配置文件的状态
+	// modTime and size are the status of the configuration file at the last load
+	modTime time.Time
+	size    int64
+
+	// ctx 和 cancel 用于管理轮询协程的生命周期
+	// ctx and cancel are used to manage the lifecycle of the polling goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg 用于等待轮询协程退出
+	// wg is used to wait for the polling goroutine to exit
+	wg sync.WaitGroup
+
+	// once 用于确保重新加载器只被停止一次
+	// once is used to ensure that the reloader is stopped only once
+	once sync.Once
+}
+
+// NewReloader 是创建新的重新加载器的函数，它会立即加载一次配置文件，然后在后台按间隔轮询
+// NewReloader is a function to create a new reloader, it loads the configuration file once immediately, and then polls it in the background at intervals
+func NewReloader(path string, conf *ReloaderConfig) *Reloader {
+	conf = isReloaderConfigValid(conf)
+
+	r := &Reloader{
+		path:    path,
+		config:  conf,
+		targets: make(map[string][]reloadTarget),
+		current: make(map[string]LimiterSpec),
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	// 立即加载一次配置文件，错误通过回调函数报告
+	// Load the configuration file once immediately, errors are reported through the callback function
+	_ = r.Reload()
+
+	// 启动轮询协程
+	// Start the polling goroutine
+	r.wg.Add(1)
+	go r.poller()
+
+	return r
+}
+
+// Stop 是一个方法，它停止重新加载器的轮询
+// Stop is a method that stops the polling of the reloader
+func (r *Reloader) Stop() {
+	r.once.Do(func() {
+		r.cancel()
+		r.wg.Wait()
+	})
+}
+
+// RegisterFlowController 是一个方法，它把流控制器注册到指定名称的速率限制器描述上，如果该名称已有有效的描述，会立即应用
+// RegisterFlowController is a method that registers the flow controller to the rate limiter description of the specified name, if there is a valid description of the name, it is applied immediately
+func (r *Reloader) RegisterFlowController(name string, fc *FlowController) error {
+	return r.register(name, fc)
+}
+
+// RegisterLimiter 是一个方法，它把令牌桶速率限制器注册到指定名称的速率限制器描述上，如果该名称已有有效的描述，会立即应用
+// RegisterLimiter is a method that registers the token bucket rate limiter to the rate limiter description of the specified name, if there is a valid description of the name, it is applied immediately
+func (r *Reloader) RegisterLimiter(name string, limiter *rl.Limiter) error {
+	return r.register(name, limiterTarget{limiter})
+}
+
+// register 是一个方法，它注册一个重新加载的目标
+// register is a method that registers a reload target
+func (r *Reloader) register(name string, target reloadTarget) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.targets[name] = append(r.targets[name], target)
+
+	if spec, ok := r.current[name]; ok {
+		return target.applyLimiterSpec(&spec)
+	}
+	return nil
+}
+
+// Reload 是一个方法，它立即重新读取配置文件并应用修改，返回值与回调函数中的错误相同
+// Reload is a method that immediately rereads the configuration file and applies the changes, the return value is the same as the error in the callback function
+func (r *Reloader) Reload() error {
+	r.lock.Lock()
+	event := r.reload()
+	r.lock.Unlock()
+
+	r.config.callback.OnReloaded(event)
+	return event.Err
+}
+
+// poller 是一个方法，它按间隔检查配置文件的修改时间和大小，发生变化时重新加载
+// poller is a method that checks the modification time and size of the configuration file at intervals, and reloads it when it changes
+func (r *Reloader) poller() {
+	ticker := time.NewTicker(r.config.interval)
+
+	defer func() {
+		ticker.Stop()
+		r.wg.Done()
+	}()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if r.changed() {
+				_ = r.Reload()
+			}
+		}
+	}
+}
+
+// changed 是一个方法，它通过文件状态判断配置文件是否发生了变化
+// changed is a method that determines whether the configuration file has changed through the file status
+func (r *Reloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+}
+
+// reload 是一个方法，它读取、解析和检查配置文件，全部有效后才应用修改，调用者必须持有锁
+// reload is a method that reads, parses and validates the configuration file, and applies the changes only after all of them are valid, the caller must hold the lock
+func (r *Reloader) reload() *ReloadEvent {
+	event := &ReloadEvent{Path: r.path}
+
+	// 记录文件状态，即使内容无效也不会在下一次轮询中重复报告
+	// Record the file status, even if the content is invalid, it will not be reported repeatedly in the next poll
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime, r.size = info.ModTime(), info.Size()
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		event.Err = err
+		return event
+	}
+
+	var file ReloadFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		event.Err = err
+		return event
+	}
+
+	// 先检查全部描述以及它们能否应用到已注册的目标上，任何一个无效都保留上一次有效的配置
+	// Validate all descriptions and whether they can be applied to the registered targets first, if any one is invalid, keep the last valid configuration
+	for name, spec := range file.Limiters {
+		if spec == nil {
+			event.Err = fmt.Errorf("limiter %q: %w", name, ErrConfigIsNil)
+			return event
+		}
+		if err = spec.Validate(); err != nil {
+			event.Err = fmt.Errorf("limiter %q: %w", name, err)
+			return event
+		}
+		for _, target := range r.targets[name] {
+			if err = target.checkLimiterSpec(spec); err != nil {
+				event.Err = fmt.Errorf("limiter %q: %w", name, err)
+				return event
+			}
+		}
+	}
+
+	// 应用发生变化的描述
+	// Apply the changed descriptions
+	for name, spec := range file.Limiters {
+		if old, ok := r.current[name]; ok && old == *spec {
+			continue
+		}
+		r.current[name] = *spec
+		event.Applied = append(event.Applied, name)
+
+		for _, target := range r.targets[name] {
+			_ = target.applyLimiterSpec(spec)
+		}
+	}
+	sort.Strings(event.Applied)
+
+	// 报告被删除的描述，之后再次出现时会被重新应用
+	// Report the removed descriptions, they are applied again when they appear again later
+	for name := range r.current {
+		if _, ok := file.Limiters[name]; !ok {
+			delete(r.current, name)
+			event.Removed = append(event.Removed, name)
+		}
+	}
+	sort.Strings(event.Removed)
+
+	return event
+}
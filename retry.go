@@ -0,0 +1,238 @@
+package regula
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRetryMaxAttempts 是默认的最大执行次数（包括第一次执行）
+	// DefaultRetryMaxAttempts is the default maximum number of executions (including the first execution)
+	DefaultRetryMaxAttempts = 3
+
+	// DefaultRetryInitialBackoff 是默认的第一次重试前的退避时间
+	// DefaultRetryInitialBackoff is the default backoff time before the first retry
+	DefaultRetryInitialBackoff = time.Millisecond * 100
+
+	// DefaultRetryMaxBackoff 是默认的最大退避时间
+	// DefaultRetryMaxBackoff is the default maximum backoff time
+	DefaultRetryMaxBackoff = time.Second * 10
+
+	// DefaultRetryMultiplier 是默认的退避时间倍数
+	// DefaultRetryMultiplier is the default multiplier of the backoff time
+	DefaultRetryMultiplier = 2.0
+
+	// DefaultRetryJitter 是默认的退避时间抖动比例
+	// DefaultRetryJitter is the default jitter ratio of the backoff time
+	DefaultRetryJitter = 0.2
+)
+
+// RetryableFunc 是一个函数类型，用于判断错误是否可以重试
+// RetryableFunc is a function type used to determine whether an error can be retried
+type RetryableFunc = func(err error) bool
+
+// RetryPolicy 是重试策略的结构体，包含最大执行次数、指数退避和抖动、以及可重试错误的判断函数
+// RetryPolicy is the structure of the retry policy, it contains the maximum number of executions, exponential backoff and jitter, and the function to determine retryable errors
+type RetryPolicy struct {
+	// maxAttempts 是最大执行次数（包括第一次执行）
+	// maxAttempts is the maximum number of executions (including the first execution)
+	maxAttempts int
+
+	// initialBackoff 是第一次重试前的退避时间
+	// initialBackoff is the backoff time before the first retry
+	initialBackoff time.Duration
+
+	// maxBackoff 是最大退避时间
+	// maxBackoff is the maximum backoff time
+	maxBackoff time.Duration
+
+	// multiplier 是每次重试后退避时间的倍数
+	// multiplier is the multiplier of the backoff time after each retry
+	multiplier float64
+
+	// jitter 是退避时间的抖动比例，取值范围为 [0, 1]，实际退避时间在 [backoff*(1-jitter), backoff] 之间随机
+	// jitter is the jitter ratio of the backoff time, in the range of [0, 1], the actual backoff time is random between [backoff*(1-jitter), backoff]
+	jitter float64
+
+	// retryable 是判断错误是否可以重试的函数
+	// retryable is the function to determine whether an error can be retried
+	retryable RetryableFunc
+}
+
+// NewRetryPolicy 是创建新的重试策略的函数，默认所有错误都可以重试
+// NewRetryPolicy is a function to create a new retry policy, by default all errors can be retried
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		maxAttempts:    DefaultRetryMaxAttempts,
+		initialBackoff: DefaultRetryInitialBackoff,
+		maxBackoff:     DefaultRetryMaxBackoff,
+		multiplier:     DefaultRetryMultiplier,
+		jitter:         DefaultRetryJitter,
+		retryable:      retryAll,
+	}
+}
+
+// DefaultRetryPolicy 是获取默认重试策略的函数
+// DefaultRetryPolicy is a function to get the default retry policy
+func DefaultRetryPolicy() *RetryPolicy {
+	return NewRetryPolicy()
+}
+
+// WithMaxAttempts 它设置最大执行次数（包括第一次执行）
+// WithMaxAttempts is a method that sets the maximum number of executions (including the first execution)
+func (p *RetryPolicy) WithMaxAttempts(attempts int) *RetryPolicy {
+	p.maxAttempts = attempts
+	return p
+}
+
+// WithBackoff 它设置第一次重试前的退避时间和最大退避时间
+// WithBackoff is a method that sets the backoff time before the first retry and the maximum backoff time
+func (p *RetryPolicy) WithBackoff(initial, max time.Duration) *RetryPolicy {
+	p.initialBackoff = initial
+	p.maxBackoff = max
+	return p
+}
+
+// WithMultiplier 它设置每次重试后退避时间的倍数
+// WithMultiplier is a method that sets the multiplier of the backoff time after each retry
+func (p *RetryPolicy) WithMultiplier(multiplier float64) *RetryPolicy {
+	p.multiplier = multiplier
+	return p
+}
+
+// WithJitter 它设置退避时间的抖动比例
+// WithJitter is a method that sets the jitter ratio of the backoff time
+func (p *RetryPolicy) WithJitter(jitter float64) *RetryPolicy {
+	p.jitter = jitter
+	return p
+}
+
+// WithRetryable 它设置判断错误是否可以重试的函数
+// WithRetryable is a method that sets the function to determine whether an error can be retried
+func (p *RetryPolicy) WithRetryable(fn RetryableFunc) *RetryPolicy {
+	p.retryable = fn
+	return p
+}
+
+// Validate 是一个方法，它严格检查重试策略是否有效
+// Validate is a method that strictly checks if the retry policy is valid
+func (p *RetryPolicy) Validate() error {
+	if p.maxAttempts <= 0 {
+		return fmt.Errorf("%w: max attempts must be greater than 0, got %d", ErrInvalidRetryPolicy, p.maxAttempts)
+	}
+	if p.initialBackoff < 0 || p.maxBackoff < p.initialBackoff {
+		return fmt.Errorf("%w: backoff must satisfy 0 <= initial <= max, got %v and %v", ErrInvalidRetryPolicy, p.initialBackoff, p.maxBackoff)
+	}
+	if p.multiplier < 1 {
+		return fmt.Errorf("%w: multiplier must be at least 1, got %v", ErrInvalidRetryPolicy, p.multiplier)
+	}
+	if p.jitter < 0 || p.jitter > 1 {
+		return fmt.Errorf("%w: jitter must be in [0, 1], got %v", ErrInvalidRetryPolicy, p.jitter)
+	}
+	if p.retryable == nil {
+		return fmt.Errorf("%w: retryable function is nil", ErrInvalidRetryPolicy)
+	}
+	return nil
+}
+
+// isRetryPolicyValid 是一个函数，它检查重试策略是否有效，如果无效，它将设置为默认值
+// isRetryPolicyValid is a function that checks if the retry policy is valid, if not, it sets it to the default values
+func isRetryPolicyValid(p *RetryPolicy) *RetryPolicy {
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = DefaultRetryMaxAttempts
+	}
+	if p.initialBackoff < 0 {
+		p.initialBackoff = DefaultRetryInitialBackoff
+	}
+	if p.maxBackoff < p.initialBackoff {
+		p.maxBackoff = p.initialBackoff
+	}
+	if p.multiplier < 1 {
+		p.multiplier = DefaultRetryMultiplier
+	}
+	if p.jitter < 0 || p.jitter > 1 {
+		p.jitter = DefaultRetryJitter
+	}
+	if p.retryable == nil {
+		p.retryable = retryAll
+	}
+	return p
+}
+
+// retryAll 是默认的可重试错误判断函数，所有错误都可以重试
+// retryAll is the default function to determine retryable errors, all errors can be retried
+func retryAll(error) bool { return true }
+
+// backoff 是一个方法，它计算第 attempt 次执行失败后的退避时间
+// backoff is a method that calculates the backoff time after the attempt-th execution fails
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	// 指数退避，并限制在最大退避时间内
+	// Exponential backoff, limited to the maximum backoff time
+	backoff := float64(p.initialBackoff) * math.Pow(p.multiplier, float64(attempt-1))
+	if backoff > float64(p.maxBackoff) {
+		backoff = float64(p.maxBackoff)
+	}
+
+	// 加入抖动，避免多个重试同时发生
+	// Add jitter to avoid multiple retries happening at the same time
+	backoff -= backoff * p.jitter * rand.Float64()
+
+	return time.Duration(backoff)
+}
+
+// retry 是一个方法，如果任务的错误可以重试并且还有剩余的执行次数，它在退避时间后把任务重新提交给速率限制器，并返回 true
+// retry is a method that resubmits the task to the rate limiter after the backoff time and returns true if the error of the task can be retried and there are remaining executions
+func (fc *FlowController) retry(t *task, err error) bool {
+	// 检查是否配置了重试策略，以及错误是否可以重试
+	// Check whether the retry policy is configured and whether the error can be retried
+	policy := fc.config.retryPolicy
+	if policy == nil || t.attempts >= policy.maxAttempts || !policy.retryable(err) {
+		return false
+	}
+
+	// 计算退避时间，并调用回调函数，通知将要重试
+	// Calculate the backoff time, and call the callback function to notify that it will retry
+	delay := policy.backoff(t.attempts)
+	fc.metrics.retried.Add(1)
+	fc.onExecRetry(t.msg, t.attempts, delay, err)
+
+	// 任务重新回到等待状态，在关闭时可以被放弃
+	// The task goes back to the pending state, and can be abandoned during shutdown
+	atomic.StoreInt32(&t.state, taskPending)
+
+	// 退避时间结束后，任务重新通过速率限制器，所以重试不会超过配置的速率
+	// After the backoff time, the task goes through the rate limiter again, so retries never exceed the configured rate
+	if err := fc.pipline.SubmitAfterWithFunc(t.readmit(fc), t.msg, delay); err != nil {
+		// 如果重新提交失败且任务没有被放弃，重试结束
+		// If the resubmission fails and the task has not been abandoned, the retry ends
+		return !atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning)
+	}
+
+	return true
+}
+
+// readmit 是一个方法，它返回在退避或推迟时间结束后把任务重新分发给熔断器和速率限制器的处理函数
+// readmit is a method that returns the handle function that dispatches the task to the circuit breaker and the rate limiter again after the backoff or defer time
+func (t *task) readmit(fc *FlowController) MessageHandleFunc {
+	return func(_ any) (any, error) {
+		// 如果任务已被放弃，直接返回
+		// If the task has been abandoned, return directly
+		if atomic.LoadInt32(&t.state) != taskPending {
+			return nil, ErrStopped
+		}
+
+		// 重新分发任务，如果失败并且任务没有被放弃，以失败结束任务
+		// Dispatch the task again, if it fails and the task has not been abandoned, finish the task with failure
+		if err := fc.dispatch(t); err != nil {
+			if atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning) {
+				fc.finish(t, nil, err)
+			}
+			return nil, err
+		}
+
+		return nil, nil
+	}
+}
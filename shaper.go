@@ -0,0 +1,147 @@
+package regula
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultShaperCapacity 是默认的整形器中等待时间槽的最多消息数量
+// DefaultShaperCapacity is the default maximum number of messages waiting for their slots in the shaper
+const DefaultShaperCapacity = 1024
+
+// ShaperConfig 是漏桶整形模式的配置，消息按固定的间隔一条一条地执行，不会突发
+// ShaperConfig is the configuration of the leaky-bucket shaping mode, messages are executed one by one at a fixed interval without bursts
+type ShaperConfig struct {
+	// interval 是相邻两条消息之间的间隔
+	// interval is the spacing between two adjacent messages
+	interval time.Duration
+
+	// capacity 是等待时间槽的最多消息数量，超过后 Do 返回 ErrShaperFull
+	// capacity is the maximum number of messages waiting for their slots, Do returns ErrShaperFull when it is exceeded
+	capacity int
+
+	// jitter 是加在每个时间槽上的最大随机延迟，用于避免多个副本同步地突发
+	// jitter is the maximum random delay added to each slot, used to avoid synchronized bursts across replicas
+	jitter time.Duration
+}
+
+// NewShaperConfig 是创建新的漏桶整形模式配置的函数，它接受相邻两条消息之间的间隔
+// NewShaperConfig is a function to create a new leaky-bucket shaping mode configuration, it accepts the spacing between two adjacent messages
+func NewShaperConfig(interval time.Duration) *ShaperConfig {
+	return &ShaperConfig{interval: interval, capacity: DefaultShaperCapacity}
+}
+
+// WithCapacity 它设置等待时间槽的最多消息数量
+// WithCapacity is a method that sets the maximum number of messages waiting for their slots
+func (c *ShaperConfig) WithCapacity(capacity int) *ShaperConfig {
+	c.capacity = capacity
+	return c
+}
+
+// WithJitter 它设置加在每个时间槽上的最大随机延迟，它必须小于间隔，所以消息的顺序不变，为 0 时不加随机延迟
+// WithJitter is a method that sets the maximum random delay added to each slot, it must be less than the interval so the order of the messages is kept, no random delay is added if it is 0
+func (c *ShaperConfig) WithJitter(jitter time.Duration) *ShaperConfig {
+	c.jitter = jitter
+	return c
+}
+
+// Validate 是一个方法，它严格检查漏桶整形模式配置是否有效
+// Validate is a method that strictly checks if the leaky-bucket shaping mode configuration is valid
+func (c *ShaperConfig) Validate() error {
+	if c.interval <= 0 {
+		return fmt.Errorf("%w: interval must be greater than 0, got %v", ErrInvalidShaper, c.interval)
+	}
+	if c.capacity <= 0 {
+		return fmt.Errorf("%w: capacity must be greater than 0, got %d", ErrInvalidShaper, c.capacity)
+	}
+	if c.jitter < 0 || c.jitter >= c.interval {
+		return fmt.Errorf("%w: jitter must be in [0, %v), got %v", ErrInvalidShaper, c.interval, c.jitter)
+	}
+	return nil
+}
+
+// isShaperConfigValid 是一个函数，它检查漏桶整形模式配置是否有效，如果无效，它将设置为默认值，间隔无效时关闭整形模式
+// isShaperConfigValid is a function that checks if the leaky-bucket shaping mode configuration is valid, if not, it sets it to the default values, the shaping mode is disabled if the interval is invalid
+func isShaperConfigValid(c *ShaperConfig) *ShaperConfig {
+	if c.interval <= 0 {
+		return nil
+	}
+	if c.capacity <= 0 {
+		c.capacity = DefaultShaperCapacity
+	}
+	if c.jitter < 0 || c.jitter >= c.interval {
+		c.jitter = 0
+	}
+	return c
+}
+
+// shaper 是漏桶整形器，它为每条消息分配下一个空闲的时间槽
+// shaper is the leaky-bucket shaper, it assigns the next free slot to each message
+type shaper struct {
+	// config 是漏桶整形模式的配置
+	// config is the configuration of the leaky-bucket shaping mode
+	config *ShaperConfig
+
+	// lock 保护下一个空闲的时间槽
+	// lock protects the next free slot
+	lock sync.Mutex
+
+	// next 是下一个空闲的时间槽
+	// next is the next free slot
+	next time.Time
+}
+
+// newShaper 是创建新的漏桶整形器的函数
+// newShaper is a function to create a new leaky-bucket shaper
+func newShaper(conf *ShaperConfig) *shaper {
+	return &shaper{config: conf}
+}
+
+// schedule 是一个方法，它先检查容量，再通过 reserve 从速率限制器预留一个事件，然后把消息排在速率限制器的延迟之后的下一个空闲时间槽上，
+// 返回时间槽和精确的延迟。容量已满时不消耗令牌。随机延迟不会移动之后的时间槽，所以平均间隔保持不变
+// schedule is a method that checks the capacity first, then reserves an event from the rate limiter through reserve, and places the message on the next free slot after the delay of the rate limiter,
+// it returns the slot and the exact delay. No token is consumed when the capacity is full. The random delay does not move the following slots, so the average spacing stays the same
+func (s *shaper) schedule(reserve func() (time.Duration, error)) (time.Time, time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// 从现在到下一个空闲时间槽之间的时间槽都已被占用
+	// The slots between now and the next free slot are all taken
+	now := time.Now()
+	interval := s.config.interval
+	if waiting := s.next.Sub(now); waiting > 0 {
+		if queued := int((waiting + interval - 1) / interval); queued >= s.config.capacity {
+			return time.Time{}, 0, fmt.Errorf("%w, %d messages are waiting", ErrShaperFull, queued)
+		}
+	}
+
+	delay, err := reserve()
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	slot := now.Add(delay)
+	if slot.Before(s.next) {
+		slot = s.next
+	}
+	s.next = slot.Add(interval)
+
+	delay = slot.Sub(now)
+	if s.config.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.config.jitter)))
+	}
+	return slot, delay, nil
+}
+
+// cancel 是一个方法，它归还提交失败的消息的时间槽。只有最后一个时间槽可以归还，之后已经有消息排定时，这个时间槽留作空隙，间隔不会变小
+// cancel is a method that gives back the slot of a message whose submission failed. Only the last slot can be given back, when messages are already placed after it, the slot is left as a gap and the spacing never shrinks
+func (s *shaper) cancel(slot time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.next.Equal(slot.Add(s.config.interval)) {
+		s.next = slot
+	}
+}
@@ -0,0 +1,359 @@
+package regula
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultFileStoreCompactThreshold 是默认的触发运行时压缩的已删除或者被覆盖的日志行数
+// DefaultFileStoreCompactThreshold is the default number of deleted or overwritten log lines that triggers the compaction at runtime
+const DefaultFileStoreCompactThreshold = 1024
+
+const (
+	// storeOpPut 表示保存或者更新一条记录
+	// storeOpPut represents saving or updating a record
+	storeOpPut = "put"
+
+	// storeOpDelete 表示删除一条记录
+	// storeOpDelete represents deleting a record
+	storeOpDelete = "del"
+)
+
+// StoreRecord 是持久化存储中的一条消息记录
+// StoreRecord is a message record in the durable store
+type StoreRecord struct {
+	// ID 是记录的编号，保存时为 0 表示由存储分配新的编号
+	// ID is the number of the record, 0 when saving means a new number is assigned by the store
+	ID uint64 `json:"id"`
+
+	// Handler 是处理函数的注册名称
+	// Handler is the registered name of the handle function
+	Handler string `json:"handler"`
+
+	// Payload 是编码后的消息
+	// Payload is the encoded message
+	Payload []byte `json:"payload"`
+
+	// Due 是消息计划执行的时间
+	// Due is the time when the message is scheduled to execute
+	Due time.Time `json:"due"`
+}
+
+// FileStoreConfig 是文件存储的配置
+// FileStoreConfig is the configuration of the file store
+type FileStoreConfig struct {
+	// sync 表示每次写入后都同步到磁盘
+	// sync indicates that every write is synchronized to disk
+	sync bool
+
+	// compactThreshold 是触发运行时压缩的无效日志行数，无效的行同时多于有效的行时才压缩
+	// compactThreshold is the number of dead log lines that triggers the compaction at runtime, the log is compacted only when the dead lines also outnumber the live ones
+	compactThreshold int
+}
+
+// NewFileStoreConfig 是创建新的文件存储配置的函数，默认不在每次写入后同步到磁盘
+// NewFileStoreConfig is a function to create a new file store configuration, by default writes are not synchronized to disk after every write
+func NewFileStoreConfig() *FileStoreConfig {
+	return &FileStoreConfig{compactThreshold: DefaultFileStoreCompactThreshold}
+}
+
+// DefaultFileStoreConfig 是获取默认文件存储配置的函数
+// DefaultFileStoreConfig is a function to get the default file store configuration
+func DefaultFileStoreConfig() *FileStoreConfig {
+	return NewFileStoreConfig()
+}
+
+// WithSync 它设置是否在每次写入后同步到磁盘，开启后进程崩溃和断电都不会丢失记录，但写入更慢
+// WithSync is a method that sets whether to synchronize to disk after every write, records are not lost on process crashes or power failures when it is enabled, but writes are slower
+func (c *FileStoreConfig) WithSync(sync bool) *FileStoreConfig {
+	c.sync = sync
+	return c
+}
+
+// WithCompactThreshold 它设置触发运行时压缩的无效日志行数，无效的行是已删除或者被覆盖的记录留下的行
+// WithCompactThreshold is a method that sets the number of dead log lines that triggers the compaction at runtime, dead lines are the lines left by deleted or overwritten records
+func (c *FileStoreConfig) WithCompactThreshold(threshold int) *FileStoreConfig {
+	c.compactThreshold = threshold
+	return c
+}
+
+// isFileStoreConfigValid 是一个函数，它检查文件存储配置是否有效，如果无效，它将设置为默认值
+// isFileStoreConfigValid is a function that checks if the file store configuration is valid, if not, it sets it to the default values
+func isFileStoreConfigValid(conf *FileStoreConfig) *FileStoreConfig {
+	if conf == nil {
+		conf = DefaultFileStoreConfig()
+	}
+	if conf.compactThreshold <= 0 {
+		conf.compactThreshold = DefaultFileStoreCompactThreshold
+	}
+	return conf
+}
+
+// storeEntry 是追加日志中的一行
+// storeEntry is a line in the append-only log
+type storeEntry struct {
+	Op string `json:"op"`
+	*StoreRecord
+}
+
+// FileStore 是基于文件的只追加日志存储，每次保存和删除都追加一行。打开时以及运行中无效的行足够多时，压缩日志只保留未删除的记录
+// FileStore is a file based append-only log store, every save and delete appends a line. The log is compacted to keep only the records that are not deleted when it is opened and when there are enough dead lines at runtime
+type FileStore struct {
+	// config 是文件存储的配置
+	// config is the configuration of the file store
+	config *FileStoreConfig
+
+	// lock 保护文件和记录
+	// lock protects the file and the records
+	lock sync.Mutex
+
+	// path 是追加日志文件的路径
+	// path is the path of the append-only log file
+	path string
+
+	// file 是追加日志文件
+	// file is the append-only log file
+	file *os.File
+
+	// lines 是日志文件中的行数
+	// lines is the number of lines in the log file
+	lines int
+
+	// records 是未删除的记录
+	// records are the records that are not deleted
+	records map[uint64]*StoreRecord
+
+	// nextID 是下一个分配的记录编号
+	// nextID is the next assigned record number
+	nextID uint64
+}
+
+// NewFileStore 是创建新的文件存储的函数，它读取已有的日志，压缩后继续追加
+// NewFileStore is a function to create a new file store, it reads the existing log, compacts it and continues appending
+func NewFileStore(path string, conf *FileStoreConfig) (*FileStore, error) {
+	s := &FileStore{
+		config:  isFileStoreConfigValid(conf),
+		path:    path,
+		records: make(map[uint64]*StoreRecord),
+		nextID:  1,
+	}
+
+	// 读取已有的日志
+	// Read the existing log
+	if err := s.read(path); err != nil {
+		return nil, err
+	}
+
+	// 压缩日志，只保留未删除的记录
+	// Compact the log, keep only the records that are not deleted
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// read 是一个方法，它按顺序重放日志，得到未删除的记录，文件不存在时不执行任何操作
+// read is a method that replays the log in order to get the records that are not deleted, it does nothing if the file does not exist
+func (s *FileStore) read(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry storeEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.StoreRecord == nil {
+			// 最后一行可能因为崩溃只写入了一部分，跳过它
+			// The last line may be only partially written because of a crash, skip it
+			continue
+		}
+
+		switch entry.Op {
+		case storeOpPut:
+			s.records[entry.ID] = entry.StoreRecord
+		case storeOpDelete:
+			delete(s.records, entry.ID)
+		}
+		if entry.ID >= s.nextID {
+			s.nextID = entry.ID + 1
+		}
+	}
+	return scanner.Err()
+}
+
+// compact 是一个方法，它把未删除的记录写入临时文件，然后替换原来的日志，并打开它继续追加。失败时原来的日志保持不变，调用者必须持有锁或者独占存储
+// compact is a method that writes the records that are not deleted into a temporary file, then replaces the original log, and opens it to continue appending. The original log is unchanged when it fails, the caller must hold the lock or own the store exclusively
+func (s *FileStore) compact() error {
+	path := s.path
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, record := range s.sorted() {
+		if err := writeStoreEntry(w, storeOpPut, record); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// 在替换之前打开新的日志，替换后的追加写入新的日志
+	// Open the new log before replacing, appends after the replacement go to the new log
+	file, err := os.OpenFile(tmp.Name(), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		file.Close()
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.lines = len(s.records)
+	return nil
+}
+
+// writeStoreEntry 是一个函数，它把一条日志写成一行 JSON
+// writeStoreEntry is a function that writes a log entry as a line of JSON
+func writeStoreEntry(w io.Writer, op string, record *StoreRecord) error {
+	line, err := json.Marshal(storeEntry{Op: op, StoreRecord: record})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// append 是一个方法，它追加一条日志，并按配置同步到磁盘，调用者必须持有锁
+// append is a method that appends a log entry and synchronizes it to disk according to the configuration, the caller must hold the lock
+func (s *FileStore) append(op string, record *StoreRecord) error {
+	if s.file == nil {
+		return ErrStoreClosed
+	}
+	if err := writeStoreEntry(s.file, op, record); err != nil {
+		return err
+	}
+	s.lines++
+	if s.config.sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// maybeCompact 是一个方法，它在无效的行达到阈值并且多于有效的行时压缩日志，压缩失败时继续追加到原来的日志，调用者必须持有锁
+// maybeCompact is a method that compacts the log when the dead lines reach the threshold and outnumber the live ones, appends continue to the original log when the compaction fails, the caller must hold the lock
+func (s *FileStore) maybeCompact() {
+	dead := s.lines - len(s.records)
+	if dead >= s.config.compactThreshold && dead > len(s.records) {
+		_ = s.compact()
+	}
+}
+
+// Save 是一个方法，它保存或者更新一条记录，编号为 0 时分配新的编号并写回记录
+// Save is a method that saves or updates a record, a new number is assigned and written back to the record if the number is 0
+func (s *FileStore) Save(record *StoreRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record.ID == 0 {
+		record.ID = s.nextID
+		s.nextID++
+	}
+
+	saved := *record
+	if err := s.append(storeOpPut, &saved); err != nil {
+		return err
+	}
+	s.records[saved.ID] = &saved
+	s.maybeCompact()
+	return nil
+}
+
+// Delete 是一个方法，它删除一条记录，记录不存在时不执行任何操作
+// Delete is a method that deletes a record, it does nothing if the record does not exist
+func (s *FileStore) Delete(id uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.records[id]; !ok {
+		return nil
+	}
+	if err := s.append(storeOpDelete, &StoreRecord{ID: id}); err != nil {
+		return err
+	}
+	delete(s.records, id)
+	s.maybeCompact()
+	return nil
+}
+
+// Load 是一个方法，它按编号顺序返回所有未删除的记录
+// Load is a method that returns all records that are not deleted in the order of number
+func (s *FileStore) Load() ([]*StoreRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil, ErrStoreClosed
+	}
+
+	records := s.sorted()
+	for i, record := range records {
+		copied := *record
+		records[i] = &copied
+	}
+	return records, nil
+}
+
+// sorted 是一个方法，它按编号顺序返回未删除的记录，调用者必须持有锁或者独占存储
+// sorted is a method that returns the records that are not deleted in the order of number, the caller must hold the lock or own the store exclusively
+func (s *FileStore) sorted() []*StoreRecord {
+	records := make([]*StoreRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// Close 是一个方法，它关闭日志文件，之后的写入返回 ErrStoreClosed
+// Close is a method that closes the log file, subsequent writes return ErrStoreClosed
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
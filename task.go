@@ -0,0 +1,348 @@
+package regula
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// taskPending 表示任务已被接受，正在排队或延迟等待执行
+	// taskPending indicates that the task has been accepted and is queued or delayed waiting for execution
+	taskPending int32 = iota
+
+	// taskRunning 表示任务正在执行
+	// taskRunning indicates that the task is being executed
+	taskRunning
+
+	// taskAbandoned 表示任务在关闭时被放弃，即使之后被管道调度也不会执行
+	// taskAbandoned indicates that the task was abandoned during shutdown, it will not be executed even if it is scheduled by the pipeline later
+	taskAbandoned
+)
+
+// task 是流控制器内部对一次消息提交的描述
+// task is the internal description of a message submission in the flow controller
+type task struct {
+	// id 是任务的提交序号，用于保持顺序
+	// id is the submission sequence number of the task, used to keep the order
+	id uint64
+
+	// fn 是消息处理函数
+	// fn is the message handle function
+	fn ContextHandleFunc
+
+	// msg 是消息
+	// msg is the message
+	msg any
+
+	// state 是任务的状态
+	// state is the state of the task
+	state int32
+
+	// attempts 是任务已经执行的次数
+	// attempts is the number of times the task has been executed
+	attempts int
+
+	// timeout 是单次提交的超时，为 0 时使用流控制器的超时
+	// timeout is the timeout of a single submission, the timeout of the flow controller is used when it is 0
+	timeout time.Duration
+
+	// future 是任务的异步结果，为 nil 时不保存结果
+	// future is the asynchronous result of the task, the result is not kept when it is nil
+	future *Future
+
+	// members 是批处理模式下批次任务包含的成员任务，普通任务为 nil
+	// members are the member tasks contained in the batch task in the batching mode, it is nil for normal tasks
+	members []*task
+
+	// key 是任务的合并键，keyed 表示任务是该键的领导者
+	// key is the coalescing key of the task, keyed indicates that the task is the leader of the key
+	key   any
+	keyed bool
+
+	// followers 是等待与该任务共享结果的跟随者任务
+	// followers are the follower tasks waiting to share the result of the task
+	followers []*task
+
+	// dedupeKey 是任务的去重键，queued 表示任务按去重键登记为正在等待
+	// dedupeKey is the deduplication key of the task, queued indicates that the task is registered as waiting by the deduplication key
+	dedupeKey any
+	queued    bool
+
+	// replacement 是去重合并后将要执行的处理函数和消息，在任务开始执行时生效
+	// replacement is the handle function and message to be executed after deduplication, it takes effect when the task starts
+	replacement *task
+
+	// priority 是任务的优先级，用于按优先级减载
+	// priority is the priority of the task, used for load shedding by priority
+	priority int
+
+	// slotted 表示任务在管道中排定了尚未到来的执行时间槽
+	// slotted indicates that the task has an execution slot in the pipeline that has not arrived yet
+	slotted atomic.Bool
+
+	// heir 是继承被丢弃的任务的时间槽的任务，fired 表示被丢弃的任务的时间槽已经到来，它们由流控制器的锁保护
+	// heir is the task that inherits the slot of the dropped task, fired indicates that the slot of the dropped task has arrived, they are protected by the lock of the flow controller
+	heir  *task
+	fired bool
+
+	// slot 是漏桶整形模式下任务排定的时间槽，提交失败时归还，没有时间槽时为零值
+	// slot is the slot the task is placed on in the leaky-bucket shaping mode, it is given back when the submission fails, it is the zero value when there is no slot
+	slot time.Time
+
+	// probe 是任务在熔断器半开状态下被放行时得到的探测凭证，不是探测消息时为 0
+	// probe is the probe ticket the task got when it was allowed in the half-open state of the circuit breaker, it is 0 if it is not a probe message
+	probe atomic.Uint64
+
+	// record 是任务在持久化存储中的记录，没有配置持久化存储或者不是按名称提交时为 nil
+	// record is the record of the task in the durable store, it is nil if the durable store is not configured or the task is not submitted by name
+	record *StoreRecord
+}
+
+// newTask 是创建新的任务的函数
+// newTask is a function to create a new task
+func newTask(fn ContextHandleFunc, msg any) *task {
+	return &task{fn: fn, msg: msg}
+}
+
+// withoutContext 是一个函数，它把不带上下文的消息处理函数转换为带上下文的消息处理函数，nil 仍然是 nil
+// withoutContext is a function that converts a message handle function without context to a message handle function with context, nil stays nil
+func withoutContext(fn MessageHandleFunc) ContextHandleFunc {
+	if fn == nil {
+		return nil
+	}
+	return func(_ context.Context, msg any) (any, error) {
+		return fn(msg)
+	}
+}
+
+// handle 是一个方法，它返回提交给管道的消息处理函数
+// handle is a method that returns the message handle function submitted to the pipeline
+func (t *task) handle(fc *FlowController) MessageHandleFunc {
+	return func(_ any) (any, error) {
+		return fc.execute(t)
+	}
+}
+
+// admit 是一个方法，它登记一个新的任务并返回因积压而被丢弃的任务，如果流控制器已停止，返回 ErrStopped
+// admit is a method that registers a new task and returns the task dropped due to the backlog, if the flow controller has been stopped, it returns ErrStopped
+func (fc *FlowController) admit(t *task) (*task, error) {
+	fc.prioritize(t)
+
+	fc.lock.Lock()
+
+	// 如果流控制器已停止，拒绝新的任务
+	// If the flow controller has been stopped, reject the new task
+	if fc.stopped {
+		fc.lock.Unlock()
+		return nil, ErrStopped
+	}
+
+	// 检查积压，积压已满时拒绝新的任务或者丢弃正在等待的任务
+	// Check the backlog, when the backlog is full, reject the new task or drop a waiting task
+	victim, err := fc.shedLocked(t)
+	if err != nil {
+		fc.lock.Unlock()
+		fc.shed(t, false)
+		return nil, err
+	}
+
+	// 登记任务
+	// Register the task
+	fc.seq++
+	t.id = fc.seq
+	fc.tasks[t] = struct{}{}
+	fc.lock.Unlock()
+
+	if victim != nil {
+		fc.shed(victim, true)
+	}
+	return victim, nil
+}
+
+// release 是一个方法，它注销一个任务，如果流控制器已停止且所有任务都已完成，通知等待者
+// release is a method that unregisters a task, if the flow controller has been stopped and all tasks have been completed, notify the waiters
+func (fc *FlowController) release(t *task) {
+	// 任务已完成或者被丢弃，删除它的持久化记录，没有执行的探测消息归还名额
+	// The task has completed or been dropped, delete its durable record, and a probe message that was not executed returns its slot
+	fc.unpersist(t)
+	fc.unprobe(t)
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	delete(fc.tasks, t)
+	fc.dequeueLocked(t)
+	fc.notifyDrained()
+}
+
+// unprobe 是一个方法，如果任务是没有执行的探测消息，它把名额归还给熔断器
+// unprobe is a method that returns the slot to the circuit breaker if the task is a probe message that was not executed
+func (fc *FlowController) unprobe(t *task) {
+	if fc.breaker == nil {
+		return
+	}
+	if probe := t.probe.Swap(0); probe != 0 {
+		fc.breaker.cancel(probe)
+	}
+}
+
+// notifyDrained 是一个方法，它在停止后所有任务完成时关闭 drained 通道，调用者必须持有锁
+// notifyDrained is a method that closes the drained channel when all tasks are completed after stopping, the caller must hold the lock
+func (fc *FlowController) notifyDrained() {
+	if fc.stopped && len(fc.tasks) == 0 {
+		select {
+		case <-fc.drained:
+		default:
+			close(fc.drained)
+		}
+	}
+}
+
+// abandon 是一个方法，它放弃所有尚未开始执行的任务，并按提交顺序返回它们的消息。每次提交只返回它自己原始的消息，
+// 去重合并后的消息不会返回，所以合并在一起的消息不会被返回两次
+// abandon is a method that abandons all tasks that have not yet started, and returns their messages in the order of submission. Each submission only returns its own original message,
+// the message combined by deduplication is not returned, so messages combined together are never returned twice
+func (fc *FlowController) abandon() []any {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	abandoned := make([]*task, 0, len(fc.tasks))
+	for t := range fc.tasks {
+		// 只有仍在等待的任务可以被放弃，正在执行的任务会正常完成
+		// Only tasks that are still waiting can be abandoned, tasks that are being executed will complete normally
+		if atomic.CompareAndSwapInt32(&t.state, taskPending, taskAbandoned) {
+			delete(fc.tasks, t)
+			fc.unprobe(t)
+
+			// 批次任务按成员返回消息，跟随者的消息也一起返回
+			// The batch task returns the messages by member, the messages of the followers are returned as well
+			members := []*task{t}
+			if t.members != nil {
+				members = t.members
+			}
+			for _, m := range members {
+				abandoned = append(abandoned, m)
+				abandoned = append(abandoned, fc.detachLocked(m)...)
+			}
+		}
+	}
+
+	// 暂停期间保留的任务都尚未开始，已全部被放弃
+	// The tasks kept during the pause have not started yet and have all been abandoned
+	fc.held = nil
+
+	sort.Slice(abandoned, func(i, j int) bool { return abandoned[i].id < abandoned[j].id })
+
+	msgs := make([]any, 0, len(abandoned))
+	for _, t := range abandoned {
+		// 保存在持久化存储中的消息由 Replay 在重启后重放，不返回给调用者，避免执行两次
+		// Messages saved in the durable store are replayed by Replay after a restart, they are not returned to the caller to avoid executing them twice
+		if t.record != nil && t.record.ID != 0 {
+			if t.future != nil {
+				t.future.complete(nil, ErrStopped)
+			}
+			continue
+		}
+		msgs = append(msgs, t.msg)
+
+		// 通知等待异步结果的调用者
+		// Notify the callers waiting for the asynchronous result
+		if t.future != nil {
+			t.future.complete(nil, ErrStopped)
+		}
+	}
+	return msgs
+}
+
+// execute 是一个方法，它在管道中执行任务，被放弃的任务不会执行，失败的任务按重试策略重新提交
+// execute is a method that executes the task in the pipeline, abandoned tasks will not be executed, failed tasks are resubmitted according to the retry policy
+func (fc *FlowController) execute(t *task) (any, error) {
+	// 如果任务已被放弃，直接返回
+	// If the task has been abandoned, return directly
+	if !atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning) {
+		// 被丢弃的任务的时间槽交给继承它的任务
+		// The slot of the dropped task is handed over to the task that inherits it
+		if heir := fc.succeed(t); heir != nil {
+			return fc.execute(heir)
+		}
+		return nil, ErrStopped
+	}
+	t.slotted.Store(false)
+
+	// 任务开始执行，不再接受去重合并
+	// The task starts, it no longer accepts deduplication
+	fc.dequeue(t)
+
+	// 执行消息处理函数
+	// Execute the message handle function
+	t.attempts++
+	start := time.Now()
+	result, err := fc.invoke(t)
+
+	// 把执行结果反馈给速率限制器
+	// Feed the execution result back to the rate limiter
+	fc.feedback(err, time.Since(start))
+
+	// 把执行结果记录到熔断器中，重试时任务重新通过熔断器
+	// Record the execution result into the circuit breaker, the task goes through the circuit breaker again when retried
+	if fc.breaker != nil {
+		fc.breaker.record(err, t.probe.Swap(0))
+	}
+
+	// 如果执行失败并且已经安排了重试，任务保持登记状态
+	// If the execution fails and a retry has been scheduled, the task remains registered
+	if err != nil && fc.retry(t, err) {
+		return result, err
+	}
+
+	// 结束任务
+	// Finish the task
+	fc.finish(t, result, err)
+
+	return result, err
+}
+
+// finish 是一个方法，它结束一个任务并设置异步结果，如果任务最终失败，调用回调函数通知
+// finish is a method that finishes a task and sets the asynchronous result, if the task finally fails, call the callback function to notify
+func (fc *FlowController) finish(t *task, result any, err error) {
+	// 注销任务
+	// Unregister the task
+	fc.release(t)
+
+	// 批次任务的结果分发给每个成员
+	// The result of the batch task is distributed to each member
+	if t.members != nil {
+		fc.finishBatch(t, result, err)
+		return
+	}
+
+	fc.complete(t, result, err)
+}
+
+// complete 是一个方法，它统计任务的结果并设置异步结果，如果任务最终失败，调用回调函数通知
+// complete is a method that counts the result of the task and sets the asynchronous result, if the task finally fails, call the callback function to notify
+func (fc *FlowController) complete(t *task, result any, err error) {
+	// 取出跟随者，它们得到相同的结果
+	// Take out the followers, they get the same result
+	followers := fc.detach(t)
+	for _, f := range followers {
+		fc.complete(f, result, err)
+	}
+
+	// 如果任务最终失败，调用回调函数通知
+	// If the task finally fails, call the callback function to notify
+	if err != nil {
+		fc.metrics.failed.Add(1)
+		fc.onExecFailed(t.msg, err)
+	} else {
+		fc.metrics.succeeded.Add(1)
+	}
+
+	// 设置异步结果
+	// Set the asynchronous result
+	if t.future != nil {
+		t.future.complete(result, err)
+	}
+}
@@ -0,0 +1,213 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklogConfig_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewBacklogConfig().WithMaxMessages(10).Validate())
+	assert.NoError(t, regula.NewBacklogConfig().WithMaxDelay(time.Second).Validate())
+	assert.ErrorIs(t, regula.NewBacklogConfig().Validate(), regula.ErrInvalidBacklog)
+	assert.ErrorIs(t, regula.NewBacklogConfig().WithMaxMessages(-1).Validate(), regula.ErrInvalidBacklog)
+	assert.ErrorIs(t, regula.NewBacklogConfig().WithMaxMessages(1).WithPolicy(regula.ShedDropLowestPriority).Validate(), regula.ErrInvalidBacklog)
+	assert.ErrorIs(t, regula.NewBacklogConfig().WithMaxMessages(1).WithEarlyDrop(1).Validate(), regula.ErrInvalidBacklog)
+	assert.Equal(t, "drop-oldest", regula.ShedDropOldest.String())
+}
+
+// newPausedBacklogController returns a paused flow controller, so every accepted message stays in the backlog
+func newPausedBacklogController(backlog *regula.BacklogConfig, cb regula.Callback) *regula.FlowController {
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithBacklog(backlog))
+	fc.Pause()
+	return fc
+}
+
+func TestFlowController_BacklogRejectNewest(t *testing.T) {
+	cb := &dropCallback{}
+	fc := newPausedBacklogController(regula.NewBacklogConfig().WithMaxMessages(2), cb)
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	assert.NoError(t, fc.Do(handle, 1))
+	assert.NoError(t, fc.Do(handle, 2))
+	assert.ErrorIs(t, fc.Do(handle, 3), regula.ErrBacklogFull)
+
+	dropped, reasons := cb.snapshot()
+	assert.Equal(t, []any{3}, dropped)
+	assert.ErrorIs(t, reasons[0], regula.ErrBacklogFull)
+
+	metrics := fc.Metrics()
+	assert.Equal(t, uint64(1), metrics.Shed)
+	assert.Equal(t, uint64(1), metrics.Rejected)
+}
+
+func TestFlowController_BacklogDropOldest(t *testing.T) {
+	cb := &dropCallback{}
+	fc := newPausedBacklogController(regula.NewBacklogConfig().WithMaxMessages(2).WithPolicy(regula.ShedDropOldest), cb)
+	defer fc.Stop()
+
+	rec := &recorder{}
+	first, err := fc.DoWithFuture(rec.handle, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Do(rec.handle, 2))
+	assert.NoError(t, fc.Do(rec.handle, 3))
+
+	<-first.Done()
+	_, err = first.Result()
+	assert.ErrorIs(t, err, regula.ErrBacklogFull)
+
+	fc.Resume()
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+	assert.ElementsMatch(t, []any{2, 3}, rec.executed())
+
+	dropped, _ := cb.snapshot()
+	assert.Equal(t, []any{1}, dropped)
+	assert.Equal(t, uint64(1), fc.Metrics().Dropped)
+}
+
+func TestFlowController_BacklogDropOldestInheritsSlot(t *testing.T) {
+	interval := time.Millisecond * 100
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	backlog := regula.NewBacklogConfig().WithMaxMessages(3).WithPolicy(regula.ShedDropOldest)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithBacklog(backlog))
+	defer fc.Stop()
+
+	// The first message keeps running, the next two wait in the delays of the rate limiter
+	started, release := make(chan struct{}), make(chan struct{})
+	rec := &recorder{}
+	assert.NoError(t, fc.Do(func(msg any) (any, error) {
+		close(started)
+		<-release
+		return msg, nil
+	}, 1))
+	<-started
+	assert.NoError(t, fc.Do(rec.handle, 2))
+	assert.NoError(t, fc.Do(rec.handle, 3))
+
+	// The new message takes over the slot of the dropped one instead of reserving another token
+	assert.NoError(t, fc.Do(rec.handle, 4))
+	assert.Equal(t, uint64(2), fc.Metrics().Limited)
+	assert.Equal(t, interval*3, limiter.When().Round(interval))
+
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, []any{4, 3}, rec.executed())
+	assert.Equal(t, uint64(1), fc.Metrics().Dropped)
+	close(release)
+}
+
+// fillSlottedBacklog runs a blocking first message and places two more on the slots of the rate limiter, 500ms and 1s away
+func fillSlottedBacklog(t *testing.T, fc *regula.FlowController, rec *recorder, fail error) chan struct{} {
+	started, release := make(chan struct{}), make(chan struct{})
+	assert.NoError(t, fc.Do(func(msg any) (any, error) {
+		close(started)
+		<-release
+		return nil, fail
+	}, 1))
+	<-started
+	assert.NoError(t, fc.Do(rec.handle, 2))
+	assert.NoError(t, fc.Do(rec.handle, 3))
+	return release
+}
+
+func TestFlowController_BacklogDropOldestPausedHeir(t *testing.T) {
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(2).WithBurst(1))
+	backlog := regula.NewBacklogConfig().WithMaxMessages(3).WithPolicy(regula.ShedDropOldest)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithBacklog(backlog))
+	defer fc.Stop()
+
+	rec := &recorder{}
+	release := fillSlottedBacklog(t, fc, rec, nil)
+	defer close(release)
+
+	// The new message drops message 2 but is kept by the pause, so it does not run at the slot of message 2
+	fc.Pause()
+	assert.NoError(t, fc.Do(rec.handle, 4))
+	time.Sleep(time.Millisecond * 700)
+	assert.Empty(t, rec.executed())
+
+	fc.Resume()
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second*3, time.Millisecond*10)
+	assert.ElementsMatch(t, []any{3, 4}, rec.executed())
+}
+
+func TestFlowController_BacklogDropOldestOpenCircuitHeir(t *testing.T) {
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(2).WithBurst(1))
+	backlog := regula.NewBacklogConfig().WithMaxMessages(3).WithPolicy(regula.ShedDropOldest)
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(1).WithOpenDuration(time.Second * 2).WithDeferWhenOpen(true)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithBacklog(backlog).WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	// The first message fails and opens the circuit while messages 2 and 3 wait for their slots
+	rec := &recorder{}
+	close(fillSlottedBacklog(t, fc, rec, errors.New("down")))
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+
+	// Message 5 drops message 2 but is deferred by the open circuit, so it does not run at the slot of message 2
+	assert.NoError(t, fc.Do(rec.handle, 4))
+	assert.NoError(t, fc.Do(rec.handle, 5))
+	time.Sleep(time.Millisecond * 700)
+	assert.Empty(t, rec.executed())
+	assert.Equal(t, uint64(1), fc.Metrics().Dropped)
+}
+
+func TestFlowController_BacklogDropLowestPriority(t *testing.T) {
+	cb := &dropCallback{}
+	priority := func(msg any) int { return msg.(int) % 10 }
+	fc := newPausedBacklogController(regula.NewBacklogConfig().WithMaxMessages(2).WithPriority(priority), cb)
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	assert.NoError(t, fc.Do(handle, 5))
+	assert.NoError(t, fc.Do(handle, 3))
+
+	// A more important message replaces the least important one, a less important one is rejected
+	assert.NoError(t, fc.Do(handle, 7))
+	assert.ErrorIs(t, fc.Do(handle, 4), regula.ErrBacklogFull)
+
+	dropped, _ := cb.snapshot()
+	assert.Equal(t, []any{3, 4}, dropped)
+	assert.Equal(t, uint64(2), fc.Metrics().Shed)
+}
+
+func TestFlowController_BacklogEarlyDrop(t *testing.T) {
+	fc := newPausedBacklogController(regula.NewBacklogConfig().WithMaxMessages(100).WithEarlyDrop(0.5), &dropCallback{})
+	defer fc.Stop()
+
+	// Below the threshold nothing is dropped, above it some messages are, and at the limit all of them are
+	handle := func(msg any) (any, error) { return msg, nil }
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+	assert.Equal(t, uint64(0), fc.Metrics().Shed)
+	for i := 0; i < 10000 && fc.Metrics().Submitted < 100; i++ {
+		_ = fc.Do(handle, i)
+	}
+
+	metrics := fc.Metrics()
+	assert.Equal(t, uint64(100), metrics.Submitted)
+	assert.Greater(t, metrics.Shed, uint64(0))
+	assert.ErrorIs(t, fc.Do(handle, 100), regula.ErrBacklogFull)
+}
+
+func TestFlowController_BacklogMaxDelay(t *testing.T) {
+	// One message per 100ms, so the fourth delayed message is beyond the 250ms horizon
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	conf := regula.NewConfig().WithRateLimiter(limiter).WithBacklog(regula.NewBacklogConfig().WithMaxDelay(time.Millisecond * 250))
+	fc := regula.NewFlowController(newTestPipeline(), conf)
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+	assert.ErrorIs(t, fc.Do(handle, 4), regula.ErrBacklogFull)
+
+	// The horizon moves forward with time
+	time.Sleep(time.Millisecond * 200)
+	assert.NoError(t, fc.Do(handle, 5))
+}
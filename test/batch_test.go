@@ -0,0 +1,129 @@
+package test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+type batchCallback struct {
+	testCallback
+	lock    sync.Mutex
+	limited [][]any
+}
+
+func (c *batchCallback) OnExecBatchLimited(msgs []any, delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.limited = append(c.limited, msgs)
+}
+
+type messageCallback struct {
+	lock    sync.Mutex
+	limited []any
+}
+
+func (c *messageCallback) OnExecLimited(msg any, delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.limited = append(c.limited, msg)
+}
+
+// inlinePipeline runs every message immediately in the caller, it is used to measure the overhead of the flow controller
+type inlinePipeline struct{}
+
+func (inlinePipeline) SubmitWithFunc(fn regula.MessageHandleFunc, msg any) error {
+	_, _ = fn(msg)
+	return nil
+}
+
+func (inlinePipeline) SubmitAfterWithFunc(fn regula.MessageHandleFunc, msg any, _ time.Duration) error {
+	_, _ = fn(msg)
+	return nil
+}
+
+func (inlinePipeline) Stop() {}
+
+func TestFlowController_DoBatch(t *testing.T) {
+	cb := &batchCallback{}
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(2))
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithRateLimiter(limiter))
+
+	var count atomic.Int64
+	handle := func(msg any) (any, error) {
+		count.Add(1)
+		return msg, nil
+	}
+
+	errs := fc.DoBatch(handle, []any{0, 1, 2, 3, 4})
+	assert.Len(t, errs, 5)
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	// The two burst tokens run immediately, the others are delayed and reported once
+	assert.Equal(t, [][]any{{2, 3, 4}}, cb.limited)
+	assert.Eventually(t, func() bool { return count.Load() == 5 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, uint64(3), fc.Metrics().Limited)
+
+	fc.Stop()
+	errs = fc.DoBatch(handle, []any{5, 6})
+	assert.ErrorIs(t, errs[0], regula.ErrStopped)
+	assert.ErrorIs(t, errs[1], regula.ErrStopped)
+}
+
+func TestFlowController_DoBatchPerMessageCallback(t *testing.T) {
+	cb := &messageCallback{}
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(2))
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithRateLimiter(limiter))
+	defer fc.Stop()
+
+	// Without BatchCallback every delayed message is reported with its own delay
+	errs := fc.DoBatch(func(msg any) (any, error) { return msg, nil }, []any{0, 1, 2, 3})
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []any{2, 3}, cb.limited)
+}
+
+const benchmarkBatchSize = 1000
+
+func newBenchmarkFlowController() *regula.FlowController {
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1e9).WithBurst(benchmarkBatchSize))
+	return regula.NewFlowController(inlinePipeline{}, regula.NewConfig().WithRateLimiter(limiter))
+}
+
+func BenchmarkFlowController_DoLoop(b *testing.B) {
+	fc := newBenchmarkFlowController()
+	handle := func(msg any) (any, error) { return msg, nil }
+	msgs := make([]any, benchmarkBatchSize)
+	for i := range msgs {
+		msgs[i] = i
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range msgs {
+			_ = fc.Do(handle, msg)
+		}
+	}
+}
+
+func BenchmarkFlowController_DoBatch(b *testing.B) {
+	fc := newBenchmarkFlowController()
+	handle := func(msg any) (any, error) { return msg, nil }
+	msgs := make([]any, benchmarkBatchSize)
+	for i := range msgs {
+		msgs[i] = i
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = fc.DoBatch(handle, msgs)
+	}
+}
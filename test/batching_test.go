@@ -0,0 +1,165 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchingConfig_Validate(t *testing.T) {
+	handle := func(msgs []any) ([]any, error) { return msgs, nil }
+
+	assert.NoError(t, regula.NewBatchingConfig(handle).Validate())
+	assert.ErrorIs(t, regula.NewBatchingConfig(nil).Validate(), regula.ErrInvalidBatching)
+	assert.ErrorIs(t, regula.NewBatchingConfig(handle).WithMaxBatchSize(0).Validate(), regula.ErrInvalidBatching)
+	assert.ErrorIs(t, regula.NewBatchingConfig(handle).WithMaxLinger(0).Validate(), regula.ErrInvalidBatching)
+
+	_, err := regula.NewStrictFlowController(newTestPipeline(), regula.NewConfig().WithBatching(regula.NewBatchingConfig(nil)))
+	assert.ErrorIs(t, err, regula.ErrInvalidBatching)
+}
+
+func TestFlowController_Batching(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]any
+	handle := func(msgs []any) ([]any, error) {
+		lock.Lock()
+		batches = append(batches, msgs)
+		lock.Unlock()
+
+		results := make([]any, len(msgs))
+		for i, msg := range msgs {
+			results[i] = msg.(int) * 10
+		}
+		return results, nil
+	}
+
+	// One token per second after the first one, so only batches are able to pass quickly
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(2))
+	batching := regula.NewBatchingConfig(handle).WithMaxBatchSize(3).WithMaxLinger(time.Millisecond * 50)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithBatching(batching))
+	defer fc.Stop()
+
+	futures := make([]*regula.Future, 5)
+	for i := range futures {
+		f, err := fc.DoWithFuture(nil, i)
+		assert.NoError(t, err)
+		futures[i] = f
+	}
+
+	// The first batch is full and flushed immediately, the rest is flushed after the linger time
+	for i, f := range futures {
+		select {
+		case <-f.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("message %d was not handled", i)
+		}
+		result, err := f.Result()
+		assert.NoError(t, err)
+		assert.Equal(t, i*10, result)
+	}
+	assert.Equal(t, [][]any{{0, 1, 2}, {3, 4}}, batches)
+
+	metrics := fc.Metrics()
+	assert.Equal(t, uint64(5), metrics.Submitted)
+	assert.Equal(t, uint64(5), metrics.Succeeded)
+	assert.Equal(t, uint64(0), metrics.Limited)
+}
+
+func TestFlowController_BatchingErrors(t *testing.T) {
+	errBulk := errors.New("bulk failed")
+	fail := true
+	handle := func(msgs []any) ([]any, error) {
+		if fail {
+			return nil, errBulk
+		}
+		return msgs[:1], nil
+	}
+
+	batching := regula.NewBatchingConfig(handle).WithMaxBatchSize(2)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithBatching(batching))
+	defer fc.Stop()
+
+	// Every member gets the error of the batch handle function
+	f1, _ := fc.DoWithFuture(nil, 1)
+	f2, _ := fc.DoWithFuture(nil, 2)
+	<-f1.Done()
+	<-f2.Done()
+	_, err := f1.Result()
+	assert.ErrorIs(t, err, errBulk)
+	_, err = f2.Result()
+	assert.ErrorIs(t, err, errBulk)
+
+	// A result count that does not match the messages is reported to every member
+	fail = false
+	f3, _ := fc.DoWithFuture(nil, 3)
+	f4, _ := fc.DoWithFuture(nil, 4)
+	<-f3.Done()
+	<-f4.Done()
+	_, err = f3.Result()
+	assert.ErrorIs(t, err, regula.ErrBatchResultMismatch)
+	_, err = f4.Result()
+	assert.ErrorIs(t, err, regula.ErrBatchResultMismatch)
+	assert.Equal(t, uint64(4), fc.Metrics().Failed)
+}
+
+func TestFlowController_BatchingHandler(t *testing.T) {
+	handle := func(msgs []any) ([]any, error) { return msgs, nil }
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithBatching(regula.NewBatchingConfig(handle)))
+	defer fc.Stop()
+
+	// A handle function would never be called, so it is rejected instead of being ignored
+	assert.ErrorIs(t, fc.Do(func(msg any) (any, error) { return msg, nil }, 1), regula.ErrBatchingHandler)
+	_, err := fc.DoWithTimeout(func(_ context.Context, msg any) (any, error) { return msg, nil }, 2, time.Second)
+	assert.ErrorIs(t, err, regula.ErrBatchingHandler)
+	assert.Equal(t, uint64(2), fc.Metrics().Rejected)
+}
+
+func TestFlowController_BatchingShutdownFlush(t *testing.T) {
+	handle := func(msgs []any) ([]any, error) { return msgs, nil }
+
+	// The partial batch is flushed at shutdown instead of waiting for the linger
+	batching := regula.NewBatchingConfig(handle).WithMaxLinger(time.Hour)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithBatching(batching))
+
+	f, err := fc.DoWithFuture(nil, 1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	abandoned, err := fc.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, abandoned)
+
+	result, err := f.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+}
+
+func TestFlowController_BatchingShutdown(t *testing.T) {
+	handle := func(msgs []any) ([]any, error) { return msgs, nil }
+
+	// A paused flow controller keeps the flushed batch, so it is abandoned
+	batching := regula.NewBatchingConfig(handle).WithMaxLinger(time.Hour)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithBatching(batching))
+	fc.Pause()
+
+	f, err := fc.DoWithFuture(nil, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Do(nil, 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	abandoned, err := fc.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []any{1, 2}, abandoned)
+
+	<-f.Done()
+	_, err = f.Result()
+	assert.ErrorIs(t, err, regula.ErrStopped)
+}
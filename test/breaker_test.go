@@ -0,0 +1,173 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/stretchr/testify/assert"
+)
+
+type breakerCallback struct {
+	testCallback
+	lock        sync.Mutex
+	transitions []regula.CircuitState
+}
+
+func (c *breakerCallback) OnCircuitStateChanged(from, to regula.CircuitState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.transitions = append(c.transitions, to)
+}
+
+func (c *breakerCallback) snapshot() []regula.CircuitState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]regula.CircuitState(nil), c.transitions...)
+}
+
+func TestFlowController_CircuitBreakerReject(t *testing.T) {
+	cb := &breakerCallback{}
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(2).WithOpenDuration(time.Millisecond * 200)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	var healthy atomic.Bool
+	handle := func(msg any) (any, error) {
+		if healthy.Load() {
+			return msg, nil
+		}
+		return nil, errors.New("down")
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+	assert.ErrorIs(t, fc.Do(handle, "rejected"), regula.ErrCircuitOpen)
+
+	// After the open duration, a successful probe closes the circuit
+	healthy.Store(true)
+	time.Sleep(time.Millisecond * 250)
+	assert.NoError(t, fc.Do(handle, "probe"))
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitClosed }, time.Second, time.Millisecond*10)
+	assert.Equal(t, []regula.CircuitState{regula.CircuitOpen, regula.CircuitHalfOpen, regula.CircuitClosed}, cb.snapshot())
+}
+
+func TestFlowController_CircuitBreakerErrorRatioDefer(t *testing.T) {
+	bconf := regula.NewCircuitBreakerConfig().
+		WithConsecutiveFailures(0).
+		WithErrorRatio(0.5, 4).
+		WithOpenDuration(time.Millisecond * 200).
+		WithDeferWhenOpen(true)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	var fail atomic.Bool
+	fail.Store(true)
+	var done atomic.Int64
+	handle := func(msg any) (any, error) {
+		if msg.(int)%2 == 1 && fail.Load() {
+			return nil, errors.New("down")
+		}
+		done.Add(1)
+		return msg, nil
+	}
+
+	// Two failures out of four requests reach the error ratio
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+
+	// While open, messages are deferred instead of rejected
+	fail.Store(false)
+	before := done.Load()
+	assert.NoError(t, fc.Do(handle, 5))
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, before, done.Load())
+	assert.Eventually(t, func() bool { return done.Load() == before+1 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, regula.CircuitClosed, fc.CircuitState())
+}
+
+func TestCircuitBreakerConfig_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewCircuitBreakerConfig().Validate())
+	assert.ErrorIs(t, regula.NewCircuitBreakerConfig().WithConsecutiveFailures(0).Validate(), regula.ErrInvalidCircuitBreaker)
+	assert.ErrorIs(t, regula.NewCircuitBreakerConfig().WithErrorRatio(2, 1).Validate(), regula.ErrInvalidCircuitBreaker)
+	assert.ErrorIs(t, regula.NewCircuitBreakerConfig().WithOpenDuration(0).Validate(), regula.ErrInvalidCircuitBreaker)
+}
+
+// switchPipeline rejects every submission while reject is set
+type switchPipeline struct {
+	*testPipeline
+	reject atomic.Bool
+}
+
+var errSwitchRejected = errors.New("rejected by pipeline")
+
+func (p *switchPipeline) SubmitWithFunc(fn regula.MessageHandleFunc, msg any) error {
+	return p.SubmitAfterWithFunc(fn, msg, 0)
+}
+
+func (p *switchPipeline) SubmitAfterWithFunc(fn regula.MessageHandleFunc, msg any, delay time.Duration) error {
+	if p.reject.Load() {
+		return errSwitchRejected
+	}
+	return p.testPipeline.SubmitAfterWithFunc(fn, msg, delay)
+}
+
+func TestFlowController_CircuitBreakerProbeReleased(t *testing.T) {
+	pipeline := &switchPipeline{testPipeline: newTestPipeline()}
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(1).WithOpenDuration(time.Millisecond * 100).WithHalfOpenRequests(1)
+	fc := regula.NewFlowController(pipeline, regula.NewConfig().WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	assert.NoError(t, fc.Do(func(msg any) (any, error) { return nil, errors.New("down") }, 0))
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 150)
+
+	// The probe is rejected after it was allowed by the circuit breaker, so it never runs and its slot is returned
+	pipeline.reject.Store(true)
+	assert.ErrorIs(t, fc.Do(func(msg any) (any, error) { return msg, nil }, 1), errSwitchRejected)
+	assert.Equal(t, regula.CircuitHalfOpen, fc.CircuitState())
+
+	pipeline.reject.Store(false)
+	assert.NoError(t, fc.Do(func(msg any) (any, error) { return msg, nil }, 2))
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitClosed }, time.Second, time.Millisecond*10)
+}
+
+func TestFlowController_CircuitBreakerStaleResult(t *testing.T) {
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(1).WithOpenDuration(time.Millisecond * 100).WithHalfOpenRequests(1)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	// A slow message is allowed while the circuit is closed
+	slow := make(chan struct{})
+	assert.NoError(t, fc.Do(func(msg any) (any, error) {
+		<-slow
+		return msg, nil
+	}, "slow"))
+
+	assert.NoError(t, fc.Do(func(msg any) (any, error) { return nil, errors.New("down") }, "fail"))
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 150)
+
+	probe := make(chan struct{})
+	assert.NoError(t, fc.Do(func(msg any) (any, error) {
+		<-probe
+		return nil, errors.New("still down")
+	}, "probe"))
+	assert.Equal(t, regula.CircuitHalfOpen, fc.CircuitState())
+
+	// The success of the slow message is not a probe result and does not close the circuit
+	close(slow)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, regula.CircuitHalfOpen, fc.CircuitState())
+
+	close(probe)
+	assert.Eventually(t, func() bool { return fc.CircuitState() == regula.CircuitOpen }, time.Second, time.Millisecond*10)
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowController_Coalescing(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	handle := func(msg any) (any, error) {
+		calls.Add(1)
+		<-release
+		return msg.(string) + "!", nil
+	}
+
+	conf := regula.NewConfig().WithCoalescing(func(msg any) any { return msg })
+	fc := regula.NewFlowController(newTestPipeline(), conf)
+	defer fc.Stop()
+
+	// Identical messages share the execution of the first one, a different one runs on its own
+	futures := make([]*regula.Future, 0, 4)
+	for _, msg := range []string{"a", "a", "a", "b"} {
+		f, err := fc.DoWithFuture(handle, msg)
+		assert.NoError(t, err)
+		futures = append(futures, f)
+	}
+	close(release)
+
+	for i, want := range []string{"a!", "a!", "a!", "b!"} {
+		<-futures[i].Done()
+		result, err := futures[i].Result()
+		assert.NoError(t, err)
+		assert.Equal(t, want, result)
+	}
+	assert.Equal(t, int64(2), calls.Load())
+
+	metrics := fc.Metrics()
+	assert.Equal(t, uint64(4), metrics.Submitted)
+	assert.Equal(t, uint64(2), metrics.Coalesced)
+	assert.Equal(t, uint64(4), metrics.Succeeded)
+
+	// Once the leader has completed, the same key runs again
+	f, err := fc.DoWithFuture(handle, "a")
+	assert.NoError(t, err)
+	<-f.Done()
+	assert.Equal(t, int64(3), calls.Load())
+}
+
+func TestFlowController_CoalescingErrors(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+	release := make(chan struct{})
+	handle := func(msg any) (any, error) {
+		<-release
+		return nil, errLookup
+	}
+
+	cb := &testCallback{}
+	conf := regula.NewConfig().WithCallback(cb).WithCoalescing(func(msg any) any { return msg })
+	fc := regula.NewFlowController(newTestPipeline(), conf)
+	defer fc.Stop()
+
+	f1, _ := fc.DoWithFuture(handle, 1)
+	f2, _ := fc.DoWithFuture(handle, 1)
+	close(release)
+
+	<-f1.Done()
+	<-f2.Done()
+	_, err := f1.Result()
+	assert.ErrorIs(t, err, errLookup)
+	_, err = f2.Result()
+	assert.ErrorIs(t, err, errLookup)
+	assert.Equal(t, uint64(2), fc.Metrics().Failed)
+}
+
+func TestFlowController_CoalescingShutdown(t *testing.T) {
+	conf := regula.NewConfig().WithCoalescing(func(msg any) any { return msg })
+	fc := regula.NewFlowController(newTestPipeline(), conf)
+
+	// Paused messages are never executed, so the followers are abandoned with their leader
+	fc.Pause()
+	handle := func(msg any) (any, error) { return msg, nil }
+	f, err := fc.DoWithFuture(handle, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Do(handle, 2))
+	assert.NoError(t, fc.Do(handle, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	abandoned, err := fc.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []any{1, 2, 1}, abandoned)
+
+	<-f.Done()
+	_, err = f.Result()
+	assert.ErrorIs(t, err, regula.ErrStopped)
+}
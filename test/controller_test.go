@@ -1,8 +1,10 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -155,3 +157,79 @@ func TestFlowController_DoUniqQueueParallel(t *testing.T) {
 
 	time.Sleep(time.Second * 2)
 }
+
+func TestFlowController_NewStrictFlowController(t *testing.T) {
+	fc, err := regula.NewStrictFlowController(nil, regula.NewConfig())
+	assert.ErrorIs(t, err, regula.ErrPipelineIsNil)
+	assert.Nil(t, fc)
+
+	kconf := karta.NewConfig().WithWorkerNumber(2)
+	queue := karta.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl := karta.NewPipeline(queue, kconf)
+	defer pl.Stop()
+
+	_, err = regula.NewStrictFlowController(pl, nil)
+	assert.ErrorIs(t, err, regula.ErrConfigIsNil)
+
+	_, err = regula.NewStrictFlowController(pl, regula.NewConfig().WithRateLimiter(nil))
+	assert.ErrorIs(t, err, regula.ErrRateLimiterIsNil)
+
+	_, err = regula.NewStrictFlowController(pl, regula.NewConfig().WithCallback(nil))
+	assert.ErrorIs(t, err, regula.ErrCallbackIsNil)
+
+	fc, err = regula.NewStrictFlowController(pl, regula.NewConfig())
+	assert.NoError(t, err)
+	assert.NotNil(t, fc)
+}
+
+func TestFlowController_ShutdownDrain(t *testing.T) {
+	rl := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(rl))
+
+	var count atomic.Int64
+	for i := 0; i < 5; i++ {
+		err := fc.Do(func(msg any) (any, error) {
+			count.Add(1)
+			return msg, nil
+		}, i)
+		assert.NoError(t, err, "fc.Do should not return error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	abandoned, err := fc.Shutdown(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, abandoned)
+	assert.Equal(t, int64(5), count.Load())
+
+	err = fc.Do(func(msg any) (any, error) { return msg, nil }, "late")
+	assert.ErrorIs(t, err, regula.ErrStopped)
+}
+
+func TestFlowController_ShutdownDeadline(t *testing.T) {
+	rl := rl.NewRateLimiter(rl.NewConfig().WithRate(2).WithBurst(1))
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(rl))
+
+	var count atomic.Int64
+	for i := 0; i < 6; i++ {
+		err := fc.Do(func(msg any) (any, error) {
+			count.Add(1)
+			return msg, nil
+		}, i)
+		assert.NoError(t, err, "fc.Do should not return error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*700)
+	defer cancel()
+
+	abandoned, err := fc.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotEmpty(t, abandoned)
+	assert.Equal(t, int64(6), count.Load()+int64(len(abandoned)))
+	assert.Equal(t, 5, abandoned[len(abandoned)-1], "abandoned messages should keep the submission order")
+
+	// Abandoned messages are never executed, even if they are scheduled later
+	time.Sleep(time.Second)
+	assert.Equal(t, int64(6), count.Load()+int64(len(abandoned)))
+}
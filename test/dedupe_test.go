@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/stretchr/testify/assert"
+)
+
+type dedupeCallback struct {
+	testCallback
+	lock  sync.Mutex
+	pairs [][2]any
+}
+
+func (c *dedupeCallback) OnExecDeduplicated(msg any, kept any) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pairs = append(c.pairs, [2]any{msg, kept})
+}
+
+type job struct {
+	id    string
+	count int
+}
+
+func jobKey(msg any) any { return msg.(job).id }
+
+func TestDedupeConfig_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewDedupeConfig(jobKey).Validate())
+	assert.ErrorIs(t, regula.NewDedupeConfig(nil).Validate(), regula.ErrInvalidDedupe)
+	assert.ErrorIs(t, regula.NewDedupeConfig(jobKey).WithPolicy(regula.DedupeMerge).Validate(), regula.ErrInvalidDedupe)
+	assert.ErrorIs(t, regula.NewDedupeConfig(jobKey).WithPolicy(regula.DedupePolicy(9)).Validate(), regula.ErrInvalidDedupe)
+	assert.Equal(t, "replace-latest", regula.DedupeReplaceLatest.String())
+
+	// Deduplication only works with messages waiting in the rate limiter
+	handle := func(msgs []any) ([]any, error) { return msgs, nil }
+	conf := regula.NewConfig().WithDedupe(regula.NewDedupeConfig(jobKey)).WithBatching(regula.NewBatchingConfig(handle))
+	assert.ErrorIs(t, conf.Validate(), regula.ErrInvalidDedupe)
+	conf = regula.NewConfig().WithDedupe(regula.NewDedupeConfig(jobKey)).WithDebounce(regula.NewDebounceConfig(nil, time.Second))
+	assert.ErrorIs(t, conf.Validate(), regula.ErrInvalidDedupe)
+	conf = regula.NewConfig().WithDedupe(regula.NewDedupeConfig(jobKey)).WithThrottle(regula.NewThrottleConfig(nil, time.Second))
+	assert.ErrorIs(t, conf.Validate(), regula.ErrInvalidDedupe)
+}
+
+func TestFlowController_DedupeShutdown(t *testing.T) {
+	merge := func(waiting, incoming any) any {
+		w, i := waiting.(job), incoming.(job)
+		return job{id: w.id, count: w.count + i.count}
+	}
+
+	policies := map[string]*regula.DedupeConfig{
+		"keep first":     regula.NewDedupeConfig(jobKey),
+		"replace latest": regula.NewDedupeConfig(jobKey).WithPolicy(regula.DedupeReplaceLatest),
+		"merge":          regula.NewDedupeConfig(jobKey).WithMerge(merge),
+	}
+
+	for name, conf := range policies {
+		t.Run(name, func(t *testing.T) {
+			rec := &recorder{}
+			fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithDedupe(conf))
+			fc.Pause()
+
+			futures := make([]*regula.Future, 0, 3)
+			for i := 1; i <= 3; i++ {
+				f, err := fc.DoWithFuture(rec.handle, job{"a", i})
+				assert.NoError(t, err)
+				futures = append(futures, f)
+			}
+
+			// Every submitter gets its own original message back exactly once
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+			defer cancel()
+			abandoned, err := fc.Shutdown(ctx)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, []any{job{"a", 1}, job{"a", 2}, job{"a", 3}}, abandoned)
+			for _, f := range futures {
+				_, err := f.Result()
+				assert.ErrorIs(t, err, regula.ErrStopped)
+			}
+			assert.Empty(t, rec.executed())
+		})
+	}
+}
+
+func TestFlowController_Dedupe(t *testing.T) {
+	merge := func(waiting, incoming any) any {
+		w, i := waiting.(job), incoming.(job)
+		return job{id: w.id, count: w.count + i.count}
+	}
+
+	cases := []struct {
+		name  string
+		conf  *regula.DedupeConfig
+		want  job
+		pairs [][2]any
+	}{
+		{
+			name:  "keep first",
+			conf:  regula.NewDedupeConfig(jobKey),
+			want:  job{"a", 1},
+			pairs: [][2]any{{job{"a", 2}, job{"a", 1}}, {job{"a", 3}, job{"a", 1}}},
+		},
+		{
+			name:  "replace latest",
+			conf:  regula.NewDedupeConfig(jobKey).WithPolicy(regula.DedupeReplaceLatest),
+			want:  job{"a", 3},
+			pairs: [][2]any{{job{"a", 1}, job{"a", 2}}, {job{"a", 2}, job{"a", 3}}},
+		},
+		{
+			name:  "merge",
+			conf:  regula.NewDedupeConfig(jobKey).WithMerge(merge),
+			want:  job{"a", 6},
+			pairs: [][2]any{{job{"a", 2}, job{"a", 3}}, {job{"a", 3}, job{"a", 6}}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cb := &dedupeCallback{}
+			rec := &recorder{}
+			fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithDedupe(c.conf))
+			defer fc.Stop()
+
+			// Paused messages keep waiting, so the duplicates are combined with the first one
+			fc.Pause()
+			futures := make([]*regula.Future, 0, 3)
+			for i := 1; i <= 3; i++ {
+				f, err := fc.DoWithFuture(rec.handle, job{"a", i})
+				assert.NoError(t, err)
+				futures = append(futures, f)
+			}
+			assert.NoError(t, fc.Do(rec.handle, job{"b", 1}))
+			fc.Resume()
+
+			// Every submitter gets the result of the single execution
+			for _, f := range futures {
+				<-f.Done()
+				result, err := f.Result()
+				assert.NoError(t, err)
+				assert.Equal(t, c.want, result)
+			}
+			assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+			assert.ElementsMatch(t, []any{c.want, job{"b", 1}}, rec.executed())
+			assert.Equal(t, c.pairs, cb.pairs)
+			assert.Equal(t, uint64(2), fc.Metrics().Deduplicated)
+			assert.Equal(t, uint64(4), fc.Metrics().Submitted)
+
+			// The same key runs again once the previous message has started
+			f, err := fc.DoWithFuture(rec.handle, job{"a", 9})
+			assert.NoError(t, err)
+			<-f.Done()
+			assert.Equal(t, uint64(2), fc.Metrics().Deduplicated)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/stretchr/testify/assert"
+)
+
+type dropCallback struct {
+	testCallback
+	lock    sync.Mutex
+	dropped []any
+	reasons []error
+}
+
+func (c *dropCallback) OnExecDropped(msg any, reason error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dropped = append(c.dropped, msg)
+	c.reasons = append(c.reasons, reason)
+}
+
+func (c *dropCallback) snapshot() ([]any, []error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]any(nil), c.dropped...), append([]error(nil), c.reasons...)
+}
+
+type recorder struct {
+	lock  sync.Mutex
+	msgs  []any
+	times []time.Time
+}
+
+func (r *recorder) handle(msg any) (any, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.msgs = append(r.msgs, msg)
+	r.times = append(r.times, time.Now())
+	return msg, nil
+}
+
+func (r *recorder) executed() []any {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]any(nil), r.msgs...)
+}
+
+func keyOf(msg any) any { return msg.(string)[:1] }
+
+func TestEventModeConfig_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewDebounceConfig(nil, time.Second).Validate())
+	assert.ErrorIs(t, regula.NewDebounceConfig(nil, 0).Validate(), regula.ErrInvalidDebounce)
+	assert.NoError(t, regula.NewThrottleConfig(nil, time.Second).Validate())
+	assert.ErrorIs(t, regula.NewThrottleConfig(nil, 0).Validate(), regula.ErrInvalidThrottle)
+	assert.ErrorIs(t, regula.NewThrottleConfig(nil, time.Second).WithLeading(false).WithTrailing(false).Validate(), regula.ErrInvalidThrottle)
+
+	conf := regula.NewConfig().WithDebounce(regula.NewDebounceConfig(nil, time.Second)).WithThrottle(regula.NewThrottleConfig(nil, time.Second))
+	assert.ErrorIs(t, conf.Validate(), regula.ErrInvalidThrottle)
+}
+
+func TestFlowController_Debounce(t *testing.T) {
+	cb := &dropCallback{}
+	rec := &recorder{}
+	conf := regula.NewConfig().WithCallback(cb).WithDebounce(regula.NewDebounceConfig(keyOf, time.Millisecond*50))
+	fc := regula.NewFlowController(newTestPipeline(), conf)
+	defer fc.Stop()
+
+	first, err := fc.DoWithFuture(rec.handle, "a1")
+	assert.NoError(t, err)
+	for _, msg := range []string{"a2", "b1", "a3"} {
+		assert.NoError(t, fc.Do(rec.handle, msg))
+	}
+
+	// Only the last message of each key runs after the quiet period
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+	assert.ElementsMatch(t, []any{"b1", "a3"}, rec.executed())
+
+	dropped, reasons := cb.snapshot()
+	assert.Equal(t, []any{"a1", "a2"}, dropped)
+	for _, reason := range reasons {
+		assert.ErrorIs(t, reason, regula.ErrMessageSuperseded)
+	}
+
+	<-first.Done()
+	_, err = first.Result()
+	assert.ErrorIs(t, err, regula.ErrMessageSuperseded)
+	assert.Equal(t, uint64(2), fc.Metrics().Dropped)
+}
+
+func TestFlowController_Throttle(t *testing.T) {
+	interval := time.Millisecond * 100
+
+	t.Run("leading and trailing", func(t *testing.T) {
+		cb := &dropCallback{}
+		rec := &recorder{}
+		conf := regula.NewConfig().WithCallback(cb).WithThrottle(regula.NewThrottleConfig(keyOf, interval))
+		fc := regula.NewFlowController(newTestPipeline(), conf)
+		defer fc.Stop()
+
+		start := time.Now()
+		for _, msg := range []string{"a1", "a2", "a3"} {
+			assert.NoError(t, fc.Do(rec.handle, msg))
+		}
+
+		// The first message runs immediately, the last one runs at the end of the interval
+		assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+		assert.Equal(t, []any{"a1", "a3"}, rec.executed())
+		assert.Less(t, rec.times[0].Sub(start), interval/2)
+		assert.GreaterOrEqual(t, rec.times[1].Sub(start), interval)
+
+		dropped, reasons := cb.snapshot()
+		assert.Equal(t, []any{"a2"}, dropped)
+		assert.ErrorIs(t, reasons[0], regula.ErrMessageSuperseded)
+	})
+
+	t.Run("leading only", func(t *testing.T) {
+		cb := &dropCallback{}
+		rec := &recorder{}
+		conf := regula.NewConfig().WithCallback(cb).WithThrottle(regula.NewThrottleConfig(keyOf, interval).WithTrailing(false))
+		fc := regula.NewFlowController(newTestPipeline(), conf)
+		defer fc.Stop()
+
+		for _, msg := range []string{"a1", "a2", "a3"} {
+			assert.NoError(t, fc.Do(rec.handle, msg))
+		}
+
+		// After the interval, a new message starts a new interval and runs immediately
+		time.Sleep(interval * 2)
+		assert.NoError(t, fc.Do(rec.handle, "a4"))
+		assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+		assert.Equal(t, []any{"a1", "a4"}, rec.executed())
+
+		dropped, reasons := cb.snapshot()
+		assert.Equal(t, []any{"a2", "a3"}, dropped)
+		assert.ErrorIs(t, reasons[0], regula.ErrMessageThrottled)
+	})
+
+	t.Run("trailing only", func(t *testing.T) {
+		rec := &recorder{}
+		conf := regula.NewConfig().WithThrottle(regula.NewThrottleConfig(keyOf, interval).WithLeading(false))
+		fc := regula.NewFlowController(newTestPipeline(), conf)
+		defer fc.Stop()
+
+		start := time.Now()
+		for _, msg := range []string{"a1", "a2", "b1"} {
+			assert.NoError(t, fc.Do(rec.handle, msg))
+		}
+
+		assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+		assert.ElementsMatch(t, []any{"a2", "b1"}, rec.executed())
+		assert.GreaterOrEqual(t, rec.times[0].Sub(start), interval)
+		assert.Equal(t, uint64(1), fc.Metrics().Dropped)
+	})
+}
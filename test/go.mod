@@ -5,6 +5,7 @@ go 1.19
 replace github.com/shengyanli1982/regula => ../
 
 require (
+	github.com/alicebob/miniredis/v2 v2.33.0
 	github.com/shengyanli1982/karta v0.2.4
 	github.com/shengyanli1982/regula v0.0.0-00010101000000-000000000000
 	github.com/shengyanli1982/workqueue/v2 v2.2.4
@@ -12,8 +13,10 @@ require (
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
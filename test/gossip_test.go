@@ -0,0 +1,174 @@
+package test
+
+import (
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula/gossip"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGossipNode(t *testing.T, conf *gossip.Config) (*gossip.Node, *rl.Limiter) {
+	limiter := rl.NewRateLimiter(rl.NewConfig())
+	node, err := gossip.NewNode(limiter, conf.WithInterval(time.Millisecond*20).WithPeerTimeout(time.Millisecond*200))
+	assert.NoError(t, err)
+	return node, limiter
+}
+
+// driveDemand calls When on the node every period until stop is closed
+func driveDemand(wg *sync.WaitGroup, stop chan struct{}, node *gossip.Node, period time.Duration) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				node.When()
+			}
+		}
+	}()
+}
+
+func shareSum(nodes ...*gossip.Node) float64 {
+	sum := 0.0
+	for _, node := range nodes {
+		sum += node.Share()
+	}
+	return sum
+}
+
+func TestGossip_DemandWeightedSplit(t *testing.T) {
+	a, la := newGossipNode(t, gossip.NewConfig(300, 30))
+	b, lb := newGossipNode(t, gossip.NewConfig(300, 30))
+	c, _ := newGossipNode(t, gossip.NewConfig(300, 30))
+	defer a.Stop()
+	defer b.Stop()
+
+	// Peer lists do not need to be symmetric, a node learns the address of every node that talks to it
+	assert.NoError(t, a.AddPeer(b.Addr()))
+	assert.NoError(t, b.AddPeer(c.Addr()))
+	assert.NoError(t, c.AddPeer(a.Addr()))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	defer func() { close(stop); wg.Wait() }()
+	driveDemand(&wg, stop, a, time.Millisecond)
+	driveDemand(&wg, stop, b, time.Millisecond*10)
+
+	// The busy node gets the largest share, the idle node keeps its minimum share, and the rates add up to the global rate
+	assert.Eventually(t, func() bool {
+		return a.Peers() == 2 && b.Peers() == 2 && c.Peers() == 2 &&
+			a.Share() > b.Share() && b.Share() > c.Share() && math.Abs(shareSum(a, b, c)-1) < 0.05
+	}, time.Second*5, time.Millisecond*20)
+	assert.InDelta(t, gossip.DefaultMinShare/3, c.Share(), 0.02)
+	assert.InDelta(t, 300*a.Share(), la.Rate(), 1e-6)
+	assert.True(t, lb.Burst() >= 1)
+
+	// A node that leaves is removed at once, and the remaining nodes take its share
+	c.Stop()
+	assert.Eventually(t, func() bool {
+		return a.Peers() == 1 && b.Peers() == 1 && math.Abs(shareSum(a, b)-1) < 0.05
+	}, time.Second*5, time.Millisecond*20)
+
+	// A node that joins converges as well
+	d, _ := newGossipNode(t, gossip.NewConfig(300, 30).WithPeers(a.Addr()))
+	defer d.Stop()
+	assert.Eventually(t, func() bool {
+		return a.Peers() == 2 && b.Peers() == 2 && d.Peers() == 2 && math.Abs(shareSum(a, b, d)-1) < 0.05
+	}, time.Second*5, time.Millisecond*20)
+}
+
+func TestGossip_BurstSplit(t *testing.T) {
+	// An equal split of 10 across 3 nodes is 3.33 each, one node takes the remainder so the bursts add up to 10
+	a, la := newGossipNode(t, gossip.NewConfig(300, 10).WithMinShare(1))
+	b, lb := newGossipNode(t, gossip.NewConfig(300, 10).WithMinShare(1).WithPeers(a.Addr()))
+	c, lc := newGossipNode(t, gossip.NewConfig(300, 10).WithMinShare(1).WithPeers(a.Addr()))
+	defer a.Stop()
+	defer b.Stop()
+	defer c.Stop()
+
+	assert.Eventually(t, func() bool {
+		return a.Peers() == 2 && b.Peers() == 2 && c.Peers() == 2 && la.Burst()+lb.Burst()+lc.Burst() == 10
+	}, time.Second*5, time.Millisecond*20)
+	for _, limiter := range []*rl.Limiter{la, lb, lc} {
+		assert.Contains(t, []int64{3, 4}, limiter.Burst())
+	}
+
+	// Every node keeps a burst of at least 1, so a global burst smaller than the number of nodes is exceeded
+	d, ld := newGossipNode(t, gossip.NewConfig(300, 1).WithMinShare(1))
+	e, le := newGossipNode(t, gossip.NewConfig(300, 1).WithMinShare(1).WithPeers(d.Addr()))
+	defer d.Stop()
+	defer e.Stop()
+	assert.Eventually(t, func() bool { return d.Peers() == 1 && e.Peers() == 1 }, time.Second*5, time.Millisecond*20)
+	assert.Equal(t, int64(1), ld.Burst())
+	assert.Equal(t, int64(1), le.Burst())
+}
+
+func TestGossip_PeerTimeout(t *testing.T) {
+	a, _ := newGossipNode(t, gossip.NewConfig(100, 10))
+	defer a.Stop()
+
+	// A peer that crashed sends one message and never a leave message
+	conn, err := net.Dial("udp", a.Addr())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte(`{"id":"crashed","demand":50}`))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool { return a.Peers() == 1 && a.Share() < 1 }, time.Second*2, time.Millisecond*10)
+	assert.Eventually(t, func() bool { return a.Peers() == 0 && a.Share() == 1 }, time.Second*2, time.Millisecond*10)
+}
+
+func TestGossip_StartupSplit(t *testing.T) {
+	// Before hearing from anyone, the global limit is split equally among the static peers
+	limiter := rl.NewRateLimiter(rl.NewConfig())
+	node, err := gossip.NewNode(limiter, gossip.NewConfig(90, 9).WithInterval(time.Hour).WithPeers("127.0.0.1:1", "127.0.0.1:2"))
+	assert.NoError(t, err)
+	defer node.Stop()
+
+	assert.InDelta(t, 1.0/3, node.Share(), 1e-9)
+	assert.InDelta(t, 30, limiter.Rate(), 1e-9)
+	assert.Equal(t, int64(3), limiter.Burst())
+}
+
+func TestGossip_Validate(t *testing.T) {
+	assert.NoError(t, gossip.NewConfig(10, 1).Validate())
+	assert.ErrorIs(t, gossip.NewConfig(0, 1).Validate(), gossip.ErrInvalidRate)
+	assert.ErrorIs(t, gossip.NewConfig(10, 0).Validate(), gossip.ErrInvalidRate)
+	assert.ErrorIs(t, gossip.NewConfig(10, 1).WithMinShare(0).Validate(), gossip.ErrInvalidShare)
+
+	_, err := gossip.NewNode(nil, gossip.NewConfig(10, 1))
+	assert.ErrorIs(t, err, gossip.ErrLimiterIsNil)
+	_, err = gossip.NewNode(rl.NewRateLimiter(nil), nil)
+	assert.ErrorIs(t, err, gossip.ErrConfigIsNil)
+}
+
+func TestGossip_Multicast(t *testing.T) {
+	group := "239.255.42.99:17946"
+	limiter := rl.NewRateLimiter(rl.NewConfig())
+	a, err := gossip.NewNode(limiter, gossip.NewConfig(100, 10).WithMulticast(group).WithInterval(time.Millisecond*20))
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer a.Stop()
+
+	// The number of nodes is not known yet, so a node starts at the minimum share instead of the whole limit
+	assert.InDelta(t, gossip.DefaultMinShare, a.Share(), 1e-9)
+	assert.InDelta(t, 100*gossip.DefaultMinShare, limiter.Rate(), 1e-6)
+	b, err := gossip.NewNode(rl.NewRateLimiter(rl.NewConfig()), gossip.NewConfig(100, 10).WithMulticast(group).WithInterval(time.Millisecond*20))
+	assert.NoError(t, err)
+	defer b.Stop()
+
+	// Nodes find each other without a peer list, and raise their shares only after one peer timeout
+	assert.Eventually(t, func() bool { return a.Peers() == 1 && b.Peers() == 1 }, time.Second, time.Millisecond*10)
+	assert.InDelta(t, 100*gossip.DefaultMinShare, limiter.Rate(), 1e-6)
+	assert.Eventually(t, func() bool { return math.Abs(limiter.Rate()-50) < 1e-6 }, time.Second*3, time.Millisecond*10)
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+)
+
+var errTestPipelineClosed = errors.New("test pipeline is closed")
+
+// testPipeline is a minimal timer based pipeline, every message runs in its own goroutine after the delay
+type testPipeline struct {
+	lock   sync.Mutex
+	closed bool
+	timers []*time.Timer
+	wg     sync.WaitGroup
+}
+
+func newTestPipeline() *testPipeline {
+	return &testPipeline{}
+}
+
+func (p *testPipeline) SubmitWithFunc(fn regula.MessageHandleFunc, msg any) error {
+	return p.SubmitAfterWithFunc(fn, msg, 0)
+}
+
+func (p *testPipeline) SubmitAfterWithFunc(fn regula.MessageHandleFunc, msg any, delay time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return errTestPipelineClosed
+	}
+
+	p.wg.Add(1)
+	p.timers = append(p.timers, time.AfterFunc(delay, func() {
+		defer p.wg.Done()
+		_, _ = fn(msg)
+	}))
+
+	return nil
+}
+
+// Stop discards the messages that are still delayed and waits for the running ones
+func (p *testPipeline) Stop() {
+	p.lock.Lock()
+	p.closed = true
+	for _, timer := range p.timers {
+		if timer.Stop() {
+			p.wg.Done()
+		}
+	}
+	p.lock.Unlock()
+
+	p.wg.Wait()
+}
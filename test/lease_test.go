@@ -0,0 +1,186 @@
+package test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/shengyanli1982/regula/lease"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseServer_Grant(t *testing.T) {
+	interval := time.Millisecond * 100
+	server := lease.NewServer(lease.NewServerConfig().WithMaxBatch(3).WithTTL(time.Millisecond * 500))
+	server.SetLimit("api", 10, 1)
+
+	// A lease is capped at the maximum batch, and its tokens are staggered at the global rate
+	l, err := server.Grant("api", 5)
+	assert.NoError(t, err)
+	assert.Len(t, l.Delays, 3)
+	for i, delay := range l.Delays {
+		assert.Equal(t, interval.Milliseconds()*int64(i), (time.Duration(delay) * time.Microsecond).Round(interval).Milliseconds())
+	}
+	assert.Equal(t, int64(500), l.TTL)
+
+	_, err = server.Grant("unknown", 1)
+	assert.ErrorIs(t, err, lease.ErrUnknownLimit)
+	_, err = server.Grant("api", 0)
+	assert.ErrorIs(t, err, lease.ErrInvalidBatchSize)
+
+	// Changing a limit keeps its limiter
+	limiter := server.SetLimit("api", 20, 2)
+	same, ok := server.Limiter("api")
+	assert.True(t, ok)
+	assert.Same(t, limiter, same)
+	assert.Equal(t, lease.Limit{Rate: 20, Burst: 2}, server.Limits()["api"])
+
+	server.RemoveLimit("api")
+	assert.Empty(t, server.Limits())
+}
+
+func TestLeaseClient_SharedLimit(t *testing.T) {
+	interval := time.Millisecond * 100
+	server := lease.NewServer(nil)
+	server.SetLimit("api", 10, 2)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	// Batches of one token, so each When asks the coordinator and the delays of both clients interleave
+	newClient := func() *lease.Client {
+		client, err := lease.NewStrictClient(lease.NewClientConfig(httpServer.URL, "api").WithBatchSize(1))
+		assert.NoError(t, err)
+		return client
+	}
+	a, b := newClient(), newClient()
+	defer a.Stop()
+	defer b.Stop()
+
+	expected := []time.Duration{0, 0, interval, interval * 2, interval * 3}
+	for i, want := range expected {
+		client := a
+		if i%2 == 1 {
+			client = b
+		}
+		assert.Equal(t, want.Milliseconds(), client.When().Round(interval).Milliseconds())
+	}
+	assert.False(t, a.Degraded())
+}
+
+func TestLeaseClient_Prefetch(t *testing.T) {
+	server := lease.NewServer(nil)
+	server.SetLimit("api", 1000, 100)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := lease.NewClient(lease.NewClientConfig(httpServer.URL, "api").WithBatchSize(10))
+	defer client.Stop()
+
+	// The first When fetches a batch, and the next batch is prefetched once it runs low
+	assert.Equal(t, time.Duration(0), client.When())
+	assert.Equal(t, 9, client.Available())
+	for i := 0; i < 5; i++ {
+		client.When()
+	}
+	assert.Eventually(t, func() bool { return client.Available() > 5 }, time.Second, time.Millisecond*10)
+}
+
+func TestLeaseClient_Expire(t *testing.T) {
+	server := lease.NewServer(lease.NewServerConfig().WithTTL(time.Millisecond * 50))
+	server.SetLimit("api", 1000, 100)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := lease.NewClient(lease.NewClientConfig(httpServer.URL, "api").WithBatchSize(10))
+	defer client.Stop()
+
+	// Unused tokens are dropped after the lease expires, and an idle client does not fetch new ones
+	client.When()
+	assert.Equal(t, 9, client.Available())
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, client.Available())
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, 0, client.Available())
+}
+
+func TestLeaseClient_Fallback(t *testing.T) {
+	interval := time.Millisecond * 100
+	server := lease.NewServer(nil)
+	server.SetLimit("api", 100, 1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	httpServer := &http.Server{Handler: server}
+	go func() { _ = httpServer.Serve(listener) }()
+
+	var lock sync.Mutex
+	var errs []error
+	client := lease.NewClient(lease.NewClientConfig("http://"+addr, "api").WithBatchSize(1).
+		WithRetryInterval(time.Millisecond * 100).WithRenewInterval(time.Millisecond * 20).
+		WithFallback(rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))).
+		WithErrorHandler(func(err error) { lock.Lock(); errs = append(errs, err); lock.Unlock() }))
+	defer client.Stop()
+
+	assert.Equal(t, time.Duration(0), client.When())
+
+	// The coordinator goes away, the conservative local rate takes over
+	assert.NoError(t, httpServer.Close())
+	assert.Equal(t, time.Duration(0), client.When())
+	assert.True(t, client.Degraded())
+	assert.Equal(t, interval.Milliseconds(), client.When().Round(interval).Milliseconds())
+
+	// The coordinator comes back and is found again in the background
+	listener, err = net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	httpServer = &http.Server{Handler: server}
+	go func() { _ = httpServer.Serve(listener) }()
+	defer httpServer.Close()
+
+	assert.Eventually(t, func() bool { return !client.Degraded() }, time.Second*2, time.Millisecond*10)
+	lock.Lock()
+	assert.NotEmpty(t, errs)
+	lock.Unlock()
+
+	// An unknown limit degrades with a typed error
+	unknown := lease.NewClient(lease.NewClientConfig("http://"+addr, "missing").WithErrorHandler(func(err error) {
+		assert.ErrorIs(t, err, lease.ErrUnknownLimit)
+	}))
+	defer unknown.Stop()
+	unknown.When()
+	assert.True(t, unknown.Degraded())
+}
+
+func TestLeaseClient_FlowController(t *testing.T) {
+	server := lease.NewServer(nil)
+	server.SetLimit("api", 1000, 10)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := lease.NewClient(lease.NewClientConfig(httpServer.URL, "api"))
+	defer client.Stop()
+
+	rec := &recorder{}
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(client))
+	defer fc.Stop()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, fc.Do(rec.handle, i))
+	}
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 5 }, time.Second, time.Millisecond*10)
+}
+
+func TestLeaseClient_Validate(t *testing.T) {
+	assert.NoError(t, lease.NewClientConfig("http://127.0.0.1:8080", "api").Validate())
+	assert.ErrorIs(t, lease.NewClientConfig("", "api").Validate(), lease.ErrInvalidURL)
+	assert.ErrorIs(t, lease.NewClientConfig("http://127.0.0.1:8080", "").Validate(), lease.ErrInvalidName)
+	assert.ErrorIs(t, lease.NewClientConfig("http://127.0.0.1:8080", "api").WithBatchSize(0).Validate(), lease.ErrInvalidBatchSize)
+
+	_, err := lease.NewStrictClient(nil)
+	assert.ErrorIs(t, err, lease.ErrConfigIsNil)
+}
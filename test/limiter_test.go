@@ -1,6 +1,7 @@
 package test
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -19,3 +20,69 @@ func TestRateLimiter_When(t *testing.T) {
 		assert.Equal(t, rl.When().Round(interval).Milliseconds(), interval.Milliseconds()*int64(i))
 	}
 }
+
+func TestRateLimiter_Validate(t *testing.T) {
+	assert.NoError(t, rl.NewConfig().Validate(), "default config should be valid")
+	assert.ErrorIs(t, rl.NewConfig().WithRate(0).Validate(), rl.ErrInvalidRate)
+	assert.ErrorIs(t, rl.NewConfig().WithRate(-1).Validate(), rl.ErrInvalidRate)
+	assert.ErrorIs(t, rl.NewConfig().WithBurst(0).Validate(), rl.ErrInvalidBurst)
+
+	var conf *rl.Config
+	assert.ErrorIs(t, conf.Validate(), rl.ErrConfigIsNil)
+}
+
+func TestRateLimiter_NewStrictRateLimiter(t *testing.T) {
+	limiter, err := rl.NewStrictRateLimiter(rl.NewConfig().WithRate(-5))
+	assert.ErrorIs(t, err, rl.ErrInvalidRate)
+	assert.Nil(t, limiter)
+
+	limiter, err = rl.NewStrictRateLimiter(rl.NewConfig().WithRate(5).WithBurst(1))
+	assert.NoError(t, err)
+	assert.NotNil(t, limiter)
+}
+
+func TestRateLimiter_WhenN(t *testing.T) {
+	interval := time.Millisecond * 100
+
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	delays := limiter.WhenN(5)
+	for i, delay := range delays {
+		assert.Equal(t, interval.Milliseconds()*int64(i), delay.Round(interval).Milliseconds())
+	}
+	assert.Equal(t, interval.Milliseconds()*5, limiter.When().Round(interval).Milliseconds())
+
+	limiter = rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(5))
+	for _, delay := range limiter.WhenN(5) {
+		assert.Equal(t, time.Duration(0), delay)
+	}
+	delays = limiter.WhenN(3)
+	for i, delay := range delays {
+		assert.Equal(t, interval.Milliseconds()*int64(i+1), delay.Round(interval).Milliseconds())
+	}
+
+	// With a rate of 0 only the burst can be reserved, the other events can never be admitted
+	limiter = rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(2))
+	limiter.SetRate(0)
+	never := time.Duration(math.MaxInt64)
+	assert.Equal(t, []time.Duration{0, 0, never, never}, limiter.WhenN(4))
+}
+
+func TestRateLimiter_SetLimit(t *testing.T) {
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(1))
+
+	// Reservations running concurrently with the change see either the old or the new limit
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			limiter.When()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		limiter.SetLimit(float64(100+i), int64(10+i))
+	}
+	<-done
+
+	assert.Equal(t, float64(199), limiter.Rate())
+	assert.Equal(t, int64(109), limiter.Burst())
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/karta"
+	"github.com/shengyanli1982/regula"
+	wkq "github.com/shengyanli1982/workqueue/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type panicCallback struct {
+	testCallback
+	panics atomic.Int64
+}
+
+func (c *panicCallback) OnExecPanic(msg any, err *regula.PanicError) { c.panics.Add(1) }
+
+func TestFlowController_PanicIsolation(t *testing.T) {
+	cb := &panicCallback{}
+	kconf := karta.NewConfig().WithWorkerNumber(1)
+	queue := karta.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl := karta.NewPipeline(queue, kconf)
+	policy := regula.NewRetryPolicy().WithMaxAttempts(2).WithBackoff(0, 0)
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(2)
+	fconf := regula.NewConfig().WithCallback(cb).WithRetryPolicy(policy).WithCircuitBreaker(bconf)
+	fc := regula.NewFlowController(pl, fconf)
+	defer fc.Stop()
+
+	cause := errors.New("boom")
+	future, err := fc.DoWithFuture(func(msg any) (any, error) {
+		panic(cause)
+	}, "bad")
+	assert.NoError(t, err)
+
+	_, err = future.Result()
+	var pe *regula.PanicError
+	assert.ErrorAs(t, err, &pe)
+	assert.ErrorIs(t, err, cause)
+	assert.NotEmpty(t, pe.Stack)
+
+	// The panic counts as a failure for both retries and circuit breaking
+	assert.Equal(t, int64(2), cb.panics.Load())
+	assert.Equal(t, uint64(2), fc.Metrics().Panicked)
+	assert.Equal(t, regula.CircuitOpen, fc.CircuitState())
+}
+
+func TestFlowController_PanicKeepsWorker(t *testing.T) {
+	kconf := karta.NewConfig().WithWorkerNumber(1)
+	queue := karta.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl := karta.NewPipeline(queue, kconf)
+	fc := regula.NewFlowController(pl, nil)
+	defer fc.Stop()
+
+	assert.NoError(t, fc.Do(func(msg any) (any, error) { panic("bad message") }, "bad"))
+
+	future, err := fc.DoWithFuture(func(msg any) (any, error) { return msg, nil }, "good")
+	assert.NoError(t, err)
+
+	select {
+	case <-future.Done():
+		result, err := future.Result()
+		assert.NoError(t, err)
+		assert.Equal(t, "good", result)
+	case <-time.After(time.Second * 5):
+		t.Fatal("the worker should survive a panicking handler")
+	}
+}
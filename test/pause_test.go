@@ -0,0 +1,56 @@
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+type pauseCallback struct {
+	testCallback
+	paused  atomic.Int64
+	resumed atomic.Int64
+}
+
+func (c *pauseCallback) OnPaused()          { c.paused.Add(1) }
+func (c *pauseCallback) OnResumed(held int) { c.resumed.Add(int64(held)) }
+
+func TestFlowController_PauseResume(t *testing.T) {
+	cb := &pauseCallback{}
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	fconf := regula.NewConfig().WithCallback(cb).WithRateLimiter(limiter).WithPauseCapacity(3)
+	fc := regula.NewFlowController(newTestPipeline(), fconf)
+	defer fc.Stop()
+
+	fc.Pause()
+	fc.Pause()
+	assert.True(t, fc.Paused())
+	assert.Equal(t, int64(1), cb.paused.Load())
+
+	var count atomic.Int64
+	handle := func(msg any) (any, error) {
+		count.Add(1)
+		return msg, nil
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+	assert.ErrorIs(t, fc.Do(handle, 3), regula.ErrPauseCapacityExceeded)
+
+	// Nothing runs and no token is consumed while paused
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, int64(0), count.Load())
+
+	start := time.Now()
+	fc.Resume()
+	assert.False(t, fc.Paused())
+	assert.Equal(t, int64(3), cb.resumed.Load())
+
+	// The first message uses the burst token, the others are released at the configured rate
+	assert.Eventually(t, func() bool { return count.Load() == 3 }, time.Second, time.Millisecond*10)
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*150)
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaLimiter_Exhausted(t *testing.T) {
+	loc := time.FixedZone("UTC-10", -10*60*60)
+	limiter, err := rl.NewStrictQuotaLimiter(rl.NewQuotaConfig(3, rl.CalendarDay).WithName("api").WithLocation(loc))
+	assert.NoError(t, err)
+	defer limiter.Close()
+
+	now := time.Now().In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	assert.True(t, midnight.Equal(limiter.ResetAt()))
+
+	for i := 0; i < 3; i++ {
+		delay, err := limiter.Reserve()
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), delay)
+	}
+	assert.Equal(t, int64(0), limiter.Remaining())
+	assert.Equal(t, int64(3), limiter.Used())
+
+	// The exhausted quota is reported with its reset time and nothing is consumed
+	_, err = limiter.Reserve()
+	assert.ErrorIs(t, err, rl.ErrQuotaExhausted)
+	var quotaErr *rl.QuotaError
+	if assert.True(t, errors.As(err, &quotaErr)) {
+		assert.Equal(t, "api", quotaErr.Name)
+		assert.Equal(t, int64(3), quotaErr.Limit)
+		assert.True(t, midnight.Equal(quotaErr.ResetAt))
+	}
+	assert.Equal(t, int64(3), limiter.Used())
+
+	// When reports the time until the reset
+	assert.InDelta(t, float64(time.Until(midnight)), float64(limiter.When()), float64(time.Second))
+}
+
+func TestQuotaLimiter_RateWithinQuota(t *testing.T) {
+	inner := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))
+	limiter, err := rl.NewQuotaLimiter(rl.NewQuotaConfig(3, rl.CalendarMonth).WithLimiter(inner))
+	assert.NoError(t, err)
+	defer limiter.Close()
+
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.Equal(t, time.Millisecond*100, limiter.When().Round(time.Millisecond*10))
+	assert.Equal(t, int64(1), limiter.Remaining())
+	assert.Equal(t, int64(3), limiter.Limit())
+}
+
+func TestQuotaLimiter_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	store := rl.NewFileQuotaStore(path)
+	conf := func(name string) *rl.QuotaConfig {
+		return rl.NewQuotaConfig(10, rl.CalendarMonth).WithName(name).WithStore(store).WithSaveInterval(time.Millisecond * 20)
+	}
+
+	a, err := rl.NewQuotaLimiter(conf("a"))
+	assert.NoError(t, err)
+	b, err := rl.NewQuotaLimiter(conf("b"))
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		a.When()
+	}
+	b.When()
+
+	// The usage is saved in the background
+	assert.Eventually(t, func() bool {
+		state, ok, err := store.Load("a")
+		return err == nil && ok && state.Count == 4
+	}, time.Second, time.Millisecond*10)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, b.Close())
+
+	// The usage of the current period survives a restart, quotas in the same file are kept apart
+	a, err = rl.NewQuotaLimiter(conf("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), a.Remaining())
+	assert.NoError(t, a.Close())
+	b, err = rl.NewQuotaLimiter(conf("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), b.Remaining())
+	assert.NoError(t, b.Close())
+
+	// The usage of a past period is discarded
+	assert.NoError(t, store.Save("a", rl.WindowState{Start: time.Now().AddDate(0, -2, 0), Count: 10}))
+	a, err = rl.NewQuotaLimiter(conf("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), a.Remaining())
+	assert.NoError(t, a.Close())
+
+	// A corrupted file is reported
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = rl.NewQuotaLimiter(conf("a"))
+	assert.ErrorIs(t, err, rl.ErrInvalidSnapshot)
+}
+
+func TestQuotaLimiter_Config(t *testing.T) {
+	assert.ErrorIs(t, (*rl.QuotaConfig)(nil).Validate(), rl.ErrConfigIsNil)
+	assert.ErrorIs(t, rl.NewQuotaConfig(0, rl.CalendarDay).Validate(), rl.ErrInvalidQuota)
+	assert.ErrorIs(t, rl.NewQuotaConfig(1, rl.CalendarNone).Validate(), rl.ErrInvalidQuota)
+	_, err := rl.NewStrictQuotaLimiter(rl.NewQuotaConfig(1, rl.CalendarUnit(42)))
+	assert.ErrorIs(t, err, rl.ErrInvalidQuota)
+
+	limiter, err := rl.NewQuotaLimiter(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(rl.DefaultLimitRate), limiter.Limit())
+	assert.NoError(t, limiter.Close())
+}
+
+func TestFlowController_QuotaExhausted(t *testing.T) {
+	limiter, err := rl.NewQuotaLimiter(rl.NewQuotaConfig(3, rl.CalendarDay))
+	assert.NoError(t, err)
+	defer limiter.Close()
+
+	pl := newTestPipeline()
+	fc := regula.NewFlowController(pl, regula.NewConfig().WithRateLimiter(limiter))
+	defer fc.Stop()
+
+	var handled atomic.Int32
+	handle := func(msg any) (any, error) {
+		handled.Add(1)
+		return msg, nil
+	}
+
+	assert.NoError(t, fc.Do(handle, 1))
+	assert.NoError(t, fc.Do(handle, 2))
+
+	// The batch admits the last unit of the quota and rejects the rest
+	errs := fc.DoBatch(handle, []any{3, 4})
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], regula.ErrQuotaExhausted)
+
+	// The message is rejected with the reset time instead of being delayed until then
+	err = fc.Do(handle, 5)
+	assert.ErrorIs(t, err, regula.ErrQuotaExhausted)
+	var quotaErr *rl.QuotaError
+	if assert.True(t, errors.As(err, &quotaErr)) {
+		assert.True(t, quotaErr.ResetAt.Equal(limiter.ResetAt()))
+	}
+
+	assert.Eventually(t, func() bool { return handled.Load() == 3 }, time.Second, time.Millisecond*10)
+}
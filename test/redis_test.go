@@ -0,0 +1,180 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisLimiter_SharedTokenBucket(t *testing.T) {
+	interval := time.Millisecond * 100
+	// miniredis runs the Lua scripts that ship with the limiter
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+
+	newLimiter := func() *rl.RedisLimiter {
+		limiter, err := rl.NewStrictRedisLimiter(rl.NewRedisConfig(server.Addr()).WithPassword("secret").WithDB(1).WithKey("api").WithRate(10).WithBurst(2))
+		assert.NoError(t, err)
+		return limiter
+	}
+	a, b := newLimiter(), newLimiter()
+	defer a.Close()
+	defer b.Close()
+
+	// Both replicas draw from the same bucket
+	expected := []time.Duration{0, 0, interval, interval * 2, interval * 3, interval * 4}
+	for i, want := range expected {
+		limiter := a
+		if i%2 == 1 {
+			limiter = b
+		}
+		assert.Equal(t, want.Milliseconds(), limiter.When().Round(interval).Milliseconds())
+	}
+	assert.False(t, a.Degraded())
+	server.Select(1)
+	assert.True(t, server.Exists("api"))
+
+	// Once the script is cached, a call is one EVALSHA plus the four commands the script runs, without a NOSCRIPT retry
+	count := server.CommandCount()
+	a.When()
+	assert.Equal(t, count+5, server.CommandCount())
+}
+
+func TestRedisLimiter_SlidingWindow(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	limiter := rl.NewRedisLimiter(rl.NewRedisConfig(server.Addr()).WithKey("api").WithSlidingWindow(3, time.Second))
+	defer limiter.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.Less(t, limiter.When(), time.Millisecond*10)
+	}
+	assert.Equal(t, time.Second.Milliseconds(), limiter.When().Round(time.Millisecond*100).Milliseconds())
+
+	// Both keys share a hash tag, so they map to the same cluster slot
+	assert.Equal(t, []string{"{api}", "{api}:seq"}, server.Keys())
+}
+
+func TestRedisLimiter_PoolSize(t *testing.T) {
+	// The server accepts connections but never replies, so every connection stays in use
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	var lock sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, conn)
+			lock.Unlock()
+		}
+	}()
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	errs := make(chan error, 4)
+	limiter := rl.NewRedisLimiter(rl.NewRedisConfig(listener.Addr().String()).WithPoolSize(1).
+		WithTimeout(time.Millisecond*50, time.Millisecond*300).
+		WithErrorHandler(func(err error) { errs <- err }))
+	defer limiter.Close()
+
+	// The second call does not open another connection, it gives up after the dial timeout
+	go limiter.When()
+	time.Sleep(time.Millisecond * 50)
+	limiter.When()
+	assert.ErrorIs(t, <-errs, rl.ErrRedisPoolExhausted)
+
+	lock.Lock()
+	assert.Len(t, conns, 1)
+	lock.Unlock()
+}
+
+// TestRedisLimiter_RealServer runs the Lua scripts against the server in REGULA_REDIS_ADDR, it is skipped without one
+func TestRedisLimiter_RealServer(t *testing.T) {
+	addr := os.Getenv("REGULA_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REGULA_REDIS_ADDR is not set")
+	}
+	interval := time.Millisecond * 100
+	key := fmt.Sprintf("regula:test:%d", time.Now().UnixNano())
+
+	bucket, err := rl.NewStrictRedisLimiter(rl.NewRedisConfig(addr).WithKey(key + ":bucket").WithRate(10).WithBurst(2))
+	assert.NoError(t, err)
+	defer bucket.Close()
+	for i, want := range []time.Duration{0, 0, interval, interval * 2} {
+		assert.Equal(t, want.Milliseconds(), bucket.When().Round(interval).Milliseconds(), "event %d", i)
+	}
+	assert.False(t, bucket.Degraded())
+
+	window, err := rl.NewStrictRedisLimiter(rl.NewRedisConfig(addr).WithKey(key+":window").WithSlidingWindow(2, time.Second))
+	assert.NoError(t, err)
+	defer window.Close()
+	assert.Less(t, window.When(), interval)
+	assert.Less(t, window.When(), interval)
+	assert.Equal(t, time.Second.Milliseconds(), window.When().Round(interval).Milliseconds())
+	assert.False(t, window.Degraded())
+}
+
+func TestRedisLimiter_Fallback(t *testing.T) {
+	interval := time.Millisecond * 100
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+
+	var lock sync.Mutex
+	var errs []error
+	limiter := rl.NewRedisLimiter(rl.NewRedisConfig(addr).WithRate(10).WithBurst(1).WithRetryInterval(time.Millisecond * 200).
+		WithFallback(rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(1))).
+		WithErrorHandler(func(err error) { lock.Lock(); errs = append(errs, err); lock.Unlock() }))
+	defer limiter.Close()
+
+	assert.Equal(t, time.Duration(0), limiter.When())
+
+	// The server goes away, the local limiter takes over
+	server.Close()
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.True(t, limiter.Degraded())
+	assert.Equal(t, interval.Milliseconds(), limiter.When().Round(interval).Milliseconds())
+	lock.Lock()
+	assert.Len(t, errs, 1)
+	lock.Unlock()
+
+	// The server is tried again after the retry interval
+	assert.NoError(t, server.Restart())
+	time.Sleep(time.Millisecond * 250)
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.False(t, limiter.Degraded())
+
+	// A wrong password degrades as well
+	server.RequireAuth("secret")
+	bad := rl.NewRedisLimiter(rl.NewRedisConfig(addr).WithPassword("wrong"))
+	defer bad.Close()
+	bad.When()
+	assert.True(t, bad.Degraded())
+}
+
+func TestRedisLimiter_Validate(t *testing.T) {
+	assert.NoError(t, rl.NewRedisConfig("127.0.0.1:6379").Validate())
+	assert.ErrorIs(t, rl.NewRedisConfig("").Validate(), rl.ErrInvalidAddress)
+	assert.ErrorIs(t, rl.NewRedisConfig("127.0.0.1:6379").WithRate(0).Validate(), rl.ErrInvalidRate)
+	assert.ErrorIs(t, rl.NewRedisConfig("127.0.0.1:6379").WithSlidingWindow(0, time.Second).Validate(), rl.ErrInvalidWindow)
+
+	_, err := rl.NewStrictRedisLimiter(nil)
+	assert.ErrorIs(t, err, rl.ErrConfigIsNil)
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/karta"
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	wkq "github.com/shengyanli1982/workqueue/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type testReloadCallback struct {
+	lock   sync.Mutex
+	events []*regula.ReloadEvent
+}
+
+func (c *testReloadCallback) OnReloaded(event *regula.ReloadEvent) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = append(c.events, event)
+}
+
+func (c *testReloadCallback) last() *regula.ReloadEvent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.events) == 0 {
+		return nil
+	}
+	return c.events[len(c.events)-1]
+}
+
+func writeReloadFile(t *testing.T, path, content string) {
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	// Make sure the modification time changes even on coarse-grained file systems
+	now := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(path, now, now))
+}
+
+func TestReloader_Apply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": 10, "burst": 1}}}`)
+
+	cb := &testReloadCallback{}
+	r := regula.NewReloader(path, regula.NewReloaderConfig().WithInterval(time.Millisecond*20).WithCallback(cb))
+	defer r.Stop()
+
+	assert.NoError(t, cb.last().Err)
+	assert.Equal(t, []string{"api"}, cb.last().Applied)
+
+	kconf := karta.NewConfig().WithWorkerNumber(2)
+	queue := karta.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl := karta.NewPipeline(queue, kconf)
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(1))
+	fc := regula.NewFlowController(pl, regula.NewConfig().WithRateLimiter(limiter))
+	defer fc.Stop()
+
+	standalone := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(1))
+
+	assert.NoError(t, r.RegisterFlowController("api", fc))
+	assert.NoError(t, r.RegisterLimiter("api", standalone))
+	assert.Equal(t, float64(10), limiter.Rate())
+	assert.Equal(t, float64(10), standalone.Rate())
+
+	// Change the rate and burst in place
+	writeReloadFile(t, path, `{"limiters": {"api": {"type": "token", "rate": 20, "burst": 4}}}`)
+	assert.Eventually(t, func() bool { return limiter.Rate() == 20 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, int64(4), limiter.Burst())
+	assert.Equal(t, float64(20), standalone.Rate())
+	assert.Same(t, limiter, fc.RateLimiter())
+
+	// Invalid content keeps the last valid configuration
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": -1, "burst": 4}}}`)
+	assert.Eventually(t, func() bool { return cb.last().Err != nil }, time.Second, time.Millisecond*10)
+	assert.ErrorIs(t, cb.last().Err, rl.ErrInvalidRate)
+	assert.Equal(t, float64(20), limiter.Rate())
+
+	writeReloadFile(t, path, `{"limiters": `)
+	assert.Error(t, r.Reload())
+	assert.Equal(t, float64(20), limiter.Rate())
+
+	// The standalone limiter cannot change its type, so nothing is applied, not even to the flow controller
+	writeReloadFile(t, path, `{"limiters": {"api": {"type": "nop"}}}`)
+	assert.ErrorIs(t, r.Reload(), regula.ErrLimiterTypeMismatch)
+	assert.Same(t, limiter, fc.RateLimiter())
+	assert.Equal(t, float64(20), standalone.Rate())
+
+	// A file that every target accepts is applied again
+	writeReloadFile(t, path, `{"limiters": {"api": {"type": "token", "rate": 30, "burst": 4}}}`)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, float64(30), standalone.Rate())
+}
+
+func TestReloader_ChangeType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": 10, "burst": 1}}}`)
+
+	r := regula.NewReloader(path, regula.NewReloaderConfig().WithInterval(time.Hour))
+	defer r.Stop()
+
+	kconf := karta.NewConfig().WithWorkerNumber(2)
+	queue := karta.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl := karta.NewPipeline(queue, kconf)
+	fc := regula.NewFlowController(pl, nil)
+	defer fc.Stop()
+	assert.NoError(t, r.RegisterFlowController("api", fc))
+
+	// A flow controller can change the type of its limiter
+	writeReloadFile(t, path, `{"limiters": {"api": {"type": "nop"}}}`)
+	assert.NoError(t, r.Reload())
+	_, ok := fc.RateLimiter().(*rl.NopLimiter)
+	assert.True(t, ok)
+}
+
+func TestReloader_Removed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": 10, "burst": 1}, "db": {"rate": 5, "burst": 1}}}`)
+
+	cb := &testReloadCallback{}
+	r := regula.NewReloader(path, regula.NewReloaderConfig().WithInterval(time.Hour).WithCallback(cb))
+	defer r.Stop()
+
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(1))
+	assert.NoError(t, r.RegisterLimiter("db", limiter))
+	assert.Equal(t, float64(5), limiter.Rate())
+
+	// The removal is reported and the registered limiter keeps the last applied limit
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": 10, "burst": 1}}}`)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, []string{"db"}, cb.last().Removed)
+	assert.Empty(t, cb.last().Applied)
+	assert.Equal(t, float64(5), limiter.Rate())
+
+	// A limiter that appears again is applied again
+	writeReloadFile(t, path, `{"limiters": {"api": {"rate": 10, "burst": 1}, "db": {"rate": 5, "burst": 1}}}`)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, []string{"db"}, cb.last().Applied)
+	assert.Empty(t, cb.last().Removed)
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestFatal = errors.New("fatal")
+
+type retryCallback struct {
+	testCallback
+	lock     sync.Mutex
+	attempts []int
+	failed   []error
+}
+
+func (c *retryCallback) OnExecRetry(msg any, attempt int, delay time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.attempts = append(c.attempts, attempt)
+}
+
+func (c *retryCallback) OnExecFailed(msg any, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failed = append(c.failed, err)
+}
+
+func (c *retryCallback) snapshot() ([]int, []error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int(nil), c.attempts...), append([]error(nil), c.failed...)
+}
+
+func TestFlowController_RetrySucceeds(t *testing.T) {
+	cb := &retryCallback{}
+	policy := regula.NewRetryPolicy().WithMaxAttempts(3).WithBackoff(time.Millisecond*10, time.Millisecond*50)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithRetryPolicy(policy))
+	defer fc.Stop()
+
+	var calls atomic.Int64
+	err := fc.Do(func(msg any) (any, error) {
+		if calls.Add(1) < 3 {
+			return nil, errors.New("temporary")
+		}
+		return msg, nil
+	}, "test")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+
+	attempts, failed := cb.snapshot()
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Empty(t, failed)
+}
+
+func TestFlowController_RetryExhaustedAndRateLimited(t *testing.T) {
+	cb := &retryCallback{}
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(5).WithBurst(1))
+	policy := regula.NewRetryPolicy().WithMaxAttempts(3).WithBackoff(0, 0)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithRateLimiter(limiter).WithRetryPolicy(policy))
+	defer fc.Stop()
+
+	var lock sync.Mutex
+	var calls []time.Time
+	err := fc.Do(func(msg any) (any, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, time.Now())
+		return nil, errors.New("always")
+	}, "test")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, failed := cb.snapshot()
+		return len(failed) == 1
+	}, time.Second*2, time.Millisecond*10)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, calls, 3)
+
+	// Retries are admitted through the same limiter, so they are spaced by the rate
+	for i := 1; i < len(calls); i++ {
+		assert.GreaterOrEqual(t, calls[i].Sub(calls[i-1]), time.Millisecond*150)
+	}
+}
+
+func TestFlowController_RetryNotRetryable(t *testing.T) {
+	cb := &retryCallback{}
+	policy := regula.NewRetryPolicy().WithRetryable(func(err error) bool { return !errors.Is(err, errTestFatal) })
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).WithRetryPolicy(policy))
+	defer fc.Stop()
+
+	var calls atomic.Int64
+	err := fc.Do(func(msg any) (any, error) {
+		calls.Add(1)
+		return nil, errTestFatal
+	}, "test")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, failed := cb.snapshot()
+		return len(failed) == 1
+	}, time.Second, time.Millisecond*10)
+
+	attempts, failed := cb.snapshot()
+	assert.Empty(t, attempts)
+	assert.ErrorIs(t, failed[0], errTestFatal)
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewRetryPolicy().Validate())
+	assert.ErrorIs(t, regula.NewRetryPolicy().WithMaxAttempts(0).Validate(), regula.ErrInvalidRetryPolicy)
+	assert.ErrorIs(t, regula.NewRetryPolicy().WithJitter(2).Validate(), regula.ErrInvalidRetryPolicy)
+	assert.ErrorIs(t, regula.NewConfig().WithRetryPolicy(regula.NewRetryPolicy().WithMultiplier(0.5)).Validate(), regula.ErrInvalidRetryPolicy)
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeWindow_Contains(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		// 2024-01-01 is a Monday
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	w, err := rl.ParseTimeWindow("Mon-Fri 09:00-17:30")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(at(1, 9, 0)))
+	assert.True(t, w.Contains(at(5, 17, 29)))
+	assert.False(t, w.Contains(at(5, 17, 30)))
+	assert.False(t, w.Contains(at(6, 12, 0)))
+
+	// The part after midnight belongs to the day the range starts
+	w, err = rl.ParseTimeWindow("Fri 22:00-06:00")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(at(5, 23, 0)))
+	assert.True(t, w.Contains(at(6, 5, 59)))
+	assert.False(t, w.Contains(at(5, 5, 0)))
+	assert.False(t, w.Contains(at(6, 22, 0)))
+
+	// Weekday ranges wrap around the weekend, and the time range defaults to the whole day
+	w, err = rl.ParseTimeWindow("Sat-Sun")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(at(6, 0, 0)))
+	assert.True(t, w.Contains(at(7, 23, 59)))
+	assert.False(t, w.Contains(at(8, 0, 0)))
+	w, err = rl.ParseTimeWindow("fri-mon,wed")
+	assert.NoError(t, err)
+	assert.True(t, w.Contains(at(1, 12, 0)))
+	assert.False(t, w.Contains(at(2, 12, 0)))
+	assert.True(t, w.Contains(at(3, 12, 0)))
+
+	// The weekdays default to every day
+	w, err = rl.ParseTimeWindow("12:00:30-24:00")
+	assert.NoError(t, err)
+	assert.False(t, w.Contains(time.Date(2024, 1, 3, 12, 0, 29, 0, time.UTC)))
+	assert.True(t, w.Contains(time.Date(2024, 1, 3, 23, 59, 59, 0, time.UTC)))
+
+	for _, spec := range []string{"", "Mon 09:00", "Xyz 09:00-10:00", "* 25:00-26:00", "* 09:60-10:00", "* 24:00-01:00", "Mon 1-2", "a b c"} {
+		_, err = rl.ParseTimeWindow(spec)
+		assert.ErrorIs(t, err, rl.ErrInvalidSchedule, spec)
+	}
+}
+
+func TestScheduledLimiter_Switch(t *testing.T) {
+	loc := time.FixedZone("UTC+9", 9*60*60)
+	clock := func(t time.Time) string { return t.In(loc).Format("15:04:05") }
+
+	// The night profile starts in one second and lasts for an hour
+	start := time.Now().Add(time.Second).Truncate(time.Second)
+	window := "* " + clock(start) + "-" + clock(start.Add(time.Hour))
+
+	var lock sync.Mutex
+	var changes [][2]string
+	limiter, err := rl.NewStrictScheduledLimiter(rl.NewScheduleConfig(10, 2).
+		WithProfile("night", 100, 20, window).
+		WithLocation(loc).
+		WithTransition(time.Millisecond * 400).
+		WithChangeHandler(func(from, to rl.Profile) {
+			lock.Lock()
+			changes = append(changes, [2]string{from.Name, to.Name})
+			lock.Unlock()
+		}))
+	assert.NoError(t, err)
+
+	assert.Equal(t, rl.Profile{Name: rl.DefaultProfileName, Rate: 10, Burst: 2}, limiter.Active())
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.Greater(t, limiter.When(), time.Duration(0))
+
+	time.Sleep(time.Until(start) + time.Millisecond*50)
+	assert.Equal(t, rl.Profile{Name: "night", Rate: 100, Burst: 20}, limiter.Active())
+	assert.Equal(t, int64(20), limiter.Burst())
+
+	// The rate ramps up to the new profile during the transition
+	rate := limiter.Rate()
+	assert.Greater(t, rate, 10.0)
+	assert.Less(t, rate, 100.0)
+	time.Sleep(time.Millisecond * 400)
+	assert.Equal(t, 100.0, limiter.Rate())
+
+	// The bucket keeps its tokens across the switch, so the larger burst is not available at once
+	delays := limiter.WhenN(20)
+	assert.Greater(t, delays[19], time.Millisecond*50)
+
+	lock.Lock()
+	assert.Equal(t, [][2]string{{rl.DefaultProfileName, "night"}}, changes)
+	lock.Unlock()
+}
+
+func TestScheduledLimiter_Startup(t *testing.T) {
+	// The profile of the current time is in effect from the start without a transition
+	limiter := rl.NewScheduledLimiter(rl.NewScheduleConfig(10, 1).WithProfile("always", 100, 1, "*").WithTransition(time.Second))
+	assert.Equal(t, 100.0, limiter.Rate())
+	assert.Equal(t, "always", limiter.Active().Name)
+}
+
+func TestScheduledLimiter_Config(t *testing.T) {
+	assert.ErrorIs(t, (*rl.ScheduleConfig)(nil).Validate(), rl.ErrConfigIsNil)
+	assert.ErrorIs(t, rl.NewScheduleConfig(0, 1).Validate(), rl.ErrInvalidRate)
+	assert.ErrorIs(t, rl.NewScheduleConfig(1, 1).WithProfile("p", 1, 0, "*").Validate(), rl.ErrInvalidBurst)
+	assert.ErrorIs(t, rl.NewScheduleConfig(1, 1).WithProfile("p", 1, 1).Validate(), rl.ErrInvalidSchedule)
+	assert.ErrorIs(t, rl.NewScheduleConfig(1, 1).WithProfile("p", 1, 1, "Mon 9-10").Validate(), rl.ErrInvalidSchedule)
+	_, err := rl.NewStrictScheduledLimiter(rl.NewScheduleConfig(1, 1).WithProfile("p", 1, 1, "bad"))
+	assert.ErrorIs(t, err, rl.ErrInvalidSchedule)
+
+	// Invalid profiles and time windows are dropped
+	limiter := rl.NewScheduledLimiter(rl.NewScheduleConfig(0, 0).WithProfile("bad", 1, 1, "bad").WithProfile("zero", 0, 1, "*"))
+	assert.Equal(t, rl.Profile{Name: rl.DefaultProfileName, Rate: rl.DefaultLimitRate, Burst: rl.DefaultLimitBurst}, limiter.Active())
+}
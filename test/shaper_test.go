@@ -0,0 +1,203 @@
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+type shaperCallback struct {
+	testCallback
+	lock   sync.Mutex
+	delays map[any]time.Duration
+}
+
+func (c *shaperCallback) OnExecLimited(msg any, delay time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.delays == nil {
+		c.delays = make(map[any]time.Duration)
+	}
+	c.delays[msg] = delay
+}
+
+func (c *shaperCallback) delay(msg any) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.delays[msg]
+}
+
+func TestShaperConfig_Validate(t *testing.T) {
+	assert.NoError(t, regula.NewShaperConfig(time.Second).WithJitter(time.Millisecond).Validate())
+	assert.ErrorIs(t, regula.NewShaperConfig(0).Validate(), regula.ErrInvalidShaper)
+	assert.ErrorIs(t, regula.NewShaperConfig(time.Second).WithCapacity(0).Validate(), regula.ErrInvalidShaper)
+	assert.ErrorIs(t, regula.NewShaperConfig(time.Second).WithJitter(time.Second).Validate(), regula.ErrInvalidShaper)
+	assert.ErrorIs(t, regula.NewShaperConfig(time.Second).WithJitter(-1).Validate(), regula.ErrInvalidShaper)
+	assert.ErrorIs(t, regula.NewConfig().WithShaper(regula.NewShaperConfig(0)).Validate(), regula.ErrInvalidShaper)
+}
+
+func TestFlowController_ShaperSpacing(t *testing.T) {
+	// The token bucket would let the whole burst through at once
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1000).WithBurst(10))
+	cb := &shaperCallback{}
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithCallback(cb).
+		WithShaper(regula.NewShaperConfig(time.Millisecond*30)))
+	defer fc.Stop()
+
+	rec := &recorder{}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, fc.Do(rec.handle, i))
+	}
+
+	// The delays are exact instead of being rounded to the time slice
+	ms := float64(time.Millisecond)
+	for i := 1; i < 5; i++ {
+		assert.InDelta(t, float64(i)*30*ms, float64(cb.delay(i)), 3*ms, "message %d", i)
+	}
+
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 5 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, []any{0, 1, 2, 3, 4}, rec.executed())
+}
+
+func TestFlowController_ShaperFollowsRateLimiter(t *testing.T) {
+	// The rate limiter is slower than the shaper, so the messages follow its delays without rounding
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(20).WithBurst(1))
+	cb := &shaperCallback{}
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithCallback(cb).
+		WithShaper(regula.NewShaperConfig(time.Millisecond*10)))
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+
+	ms := float64(time.Millisecond)
+	assert.InDelta(t, 50*ms, float64(cb.delay(1)), 5*ms)
+	assert.InDelta(t, 100*ms, float64(cb.delay(2)), 5*ms)
+}
+
+func TestFlowController_ShaperFull(t *testing.T) {
+	quota, err := rl.NewQuotaLimiter(rl.NewQuotaConfig(10, rl.CalendarDay))
+	assert.NoError(t, err)
+	defer quota.Close()
+
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(quota).
+		WithShaper(regula.NewShaperConfig(time.Second).WithCapacity(2)))
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	assert.NoError(t, fc.Do(handle, 1))
+	errs := fc.DoBatch(handle, []any{2, 3})
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], regula.ErrShaperFull)
+	assert.ErrorIs(t, fc.Do(handle, 4), regula.ErrShaperFull)
+
+	// Rejected messages do not consume tokens
+	assert.Equal(t, int64(2), quota.Used())
+}
+
+func TestFlowController_ShaperJitter(t *testing.T) {
+	cb := &shaperCallback{}
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithCallback(cb).
+		WithShaper(regula.NewShaperConfig(time.Millisecond*20).WithJitter(time.Millisecond*10)))
+	defer fc.Stop()
+
+	handle := func(msg any) (any, error) { return msg, nil }
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, fc.Do(handle, i))
+	}
+
+	// The jitter stays within its bound and does not move the following slots
+	var jittered bool
+	for i := 1; i < 20; i++ {
+		slot := time.Millisecond * 20 * time.Duration(i)
+		delay := cb.delay(i)
+		assert.Greater(t, delay, slot-time.Millisecond*5, "message %d", i)
+		assert.Less(t, delay, slot+time.Millisecond*10, "message %d", i)
+		jittered = jittered || delay > slot
+	}
+	assert.True(t, jittered)
+}
+
+// heartbeatPipeline runs immediate submissions at once, but releases delayed ones only on a 300ms heartbeat like the delaying queue of workqueue
+type heartbeatPipeline struct {
+	*testPipeline
+}
+
+func (p *heartbeatPipeline) SubmitWithFunc(fn regula.MessageHandleFunc, msg any) error {
+	return p.testPipeline.SubmitAfterWithFunc(fn, msg, 0)
+}
+
+func (p *heartbeatPipeline) SubmitAfterWithFunc(fn regula.MessageHandleFunc, msg any, delay time.Duration) error {
+	heartbeat := time.Millisecond * 300
+	return p.testPipeline.SubmitAfterWithFunc(fn, msg, (delay+heartbeat-1)/heartbeat*heartbeat)
+}
+
+func TestFlowController_ShaperExecutionSpacing(t *testing.T) {
+	fc := regula.NewFlowController(&heartbeatPipeline{newTestPipeline()},
+		regula.NewConfig().WithShaper(regula.NewShaperConfig(time.Millisecond*50)))
+	defer fc.Stop()
+
+	rec := &recorder{}
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, fc.Do(rec.handle, i))
+	}
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 6 }, time.Second, time.Millisecond*10)
+
+	// The messages really execute one interval apart instead of clumping on the heartbeat
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	for i := 1; i < len(rec.times); i++ {
+		gap := rec.times[i].Sub(rec.times[i-1])
+		assert.Greater(t, gap, time.Millisecond*40, "message %d", i)
+		assert.Less(t, gap, time.Millisecond*80, "message %d", i)
+	}
+}
+
+// rejectingPipeline rejects the submission of some messages
+type rejectingPipeline struct {
+	*testPipeline
+	reject map[any]bool
+}
+
+func (p *rejectingPipeline) SubmitWithFunc(fn regula.MessageHandleFunc, msg any) error {
+	return p.SubmitAfterWithFunc(fn, msg, 0)
+}
+
+func (p *rejectingPipeline) SubmitAfterWithFunc(fn regula.MessageHandleFunc, msg any, delay time.Duration) error {
+	if p.reject[msg] {
+		return errTestPipelineClosed
+	}
+	return p.testPipeline.SubmitAfterWithFunc(fn, msg, delay)
+}
+
+func TestFlowController_ShaperGivesBackSlot(t *testing.T) {
+	cb := &shaperCallback{}
+	fc := regula.NewFlowController(&rejectingPipeline{testPipeline: newTestPipeline(), reject: map[any]bool{"bad": true, "late": true}},
+		regula.NewConfig().WithCallback(cb).WithShaper(regula.NewShaperConfig(time.Millisecond*100)))
+	defer fc.Stop()
+
+	// The rejected message gives its slot back, so the next message takes it instead of waiting an interval
+	rec := &recorder{}
+	assert.ErrorIs(t, fc.Do(rec.handle, "bad"), errTestPipelineClosed)
+	assert.NoError(t, fc.Do(rec.handle, "good"))
+	assert.Equal(t, time.Duration(0), cb.delay("good"))
+
+	// A message delayed by the shaper and rejected when its slot arrives is finished with the error
+	future, err := fc.DoWithFuture(rec.handle, "late")
+	assert.NoError(t, err)
+	assert.InDelta(t, float64(time.Millisecond*100), float64(cb.delay("late")), float64(time.Millisecond*5))
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the future of the rejected message was not completed")
+	}
+	_, err = future.Result()
+	assert.ErrorIs(t, err, errTestPipelineClosed)
+	assert.Equal(t, []any{"good"}, rec.executed())
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+const sharedHelperEnv = "REGULA_SHARED_HELPER"
+
+func skipSharedUnsupported(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "netbsd", "openbsd", "dragonfly":
+	default:
+		t.Skip("shared memory file is not supported on " + runtime.GOOS)
+	}
+}
+
+// TestSharedLimiter_Helper is not a test, it is the worker process of TestSharedLimiter_CrossProcess.
+// It reserves tokens from the shared file and prints the scheduled times in Unix nanoseconds
+func TestSharedLimiter_Helper(t *testing.T) {
+	path := os.Getenv(sharedHelperEnv)
+	if path == "" {
+		t.Skip("helper process only")
+	}
+
+	limiter, err := rl.NewSharedLimiter(path, rl.NewConfig().WithRate(50).WithBurst(5))
+	if err != nil {
+		fmt.Println("error", err)
+		os.Exit(1)
+	}
+	for i := 0; i < 20; i++ {
+		now := time.Now()
+		fmt.Println(now.Add(limiter.When()).UnixNano())
+	}
+	_ = limiter.Close()
+	os.Exit(0)
+}
+
+func TestSharedLimiter_CrossProcess(t *testing.T) {
+	skipSharedUnsupported(t)
+	path := filepath.Join(t.TempDir(), "limiter.shm")
+
+	workers := make([]*exec.Cmd, 3)
+	outputs := make([]*bufio.Scanner, len(workers))
+	for i := range workers {
+		workers[i] = exec.Command(os.Args[0], "-test.run=^TestSharedLimiter_Helper$")
+		workers[i].Env = append(os.Environ(), sharedHelperEnv+"="+path)
+		stdout, err := workers[i].StdoutPipe()
+		assert.NoError(t, err)
+		outputs[i] = bufio.NewScanner(stdout)
+		assert.NoError(t, workers[i].Start())
+	}
+
+	var times []int64
+	for i, worker := range workers {
+		for outputs[i].Scan() {
+			at, err := strconv.ParseInt(outputs[i].Text(), 10, 64)
+			if assert.NoError(t, err, outputs[i].Text()) {
+				times = append(times, at)
+			}
+		}
+		assert.NoError(t, worker.Wait())
+	}
+	assert.Len(t, times, 60)
+
+	// No window of any length admits more than burst + rate * length events across all processes
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	for i := range times {
+		for j := i + 1; j < len(times); j++ {
+			allowed := 5 + 50*time.Duration(times[j]-times[i]).Seconds()
+			assert.LessOrEqual(t, float64(j-i+1), allowed+0.5, "events %d to %d", i, j)
+		}
+	}
+	assert.GreaterOrEqual(t, time.Duration(times[len(times)-1]-times[0]), time.Millisecond*1090)
+}
+
+func TestSharedLimiter_SharedBucket(t *testing.T) {
+	skipSharedUnsupported(t)
+	path := filepath.Join(t.TempDir(), "limiter.shm")
+	interval := time.Millisecond * 100
+
+	a, err := rl.NewSharedLimiter(path, rl.NewConfig().WithRate(10).WithBurst(4))
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := rl.NewSharedLimiter(path, rl.NewConfig().WithRate(10).WithBurst(4))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	// Both limiters draw from the same bucket
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, time.Duration(0), a.When())
+		assert.Equal(t, time.Duration(0), b.When())
+	}
+	assert.Equal(t, interval, a.When().Round(time.Millisecond*10))
+	assert.Equal(t, interval*2, b.When().Round(time.Millisecond*10))
+
+	// Limit changes are visible to every process
+	a.SetRate(100)
+	a.SetBurst(8)
+	assert.Equal(t, 100.0, b.Rate())
+	assert.Equal(t, int64(8), b.Burst())
+
+	// Reopening the file keeps the state and applies the new limit
+	c, err := rl.NewSharedLimiter(path, rl.NewConfig().WithRate(20).WithBurst(4))
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 20.0, a.Rate())
+	assert.Greater(t, c.When(), time.Duration(0))
+}
+
+func TestSharedLimiter_InvalidFile(t *testing.T) {
+	skipSharedUnsupported(t)
+	dir := t.TempDir()
+
+	// A garbage file left behind by a crashed process is initialized again
+	path := filepath.Join(dir, "garbage.shm")
+	garbage := make([]byte, 64)
+	for i := range garbage {
+		garbage[i] = 0xff
+	}
+	assert.NoError(t, os.WriteFile(path, garbage, 0o644))
+	limiter, err := rl.NewSharedLimiter(path, rl.NewConfig().WithRate(10).WithBurst(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, limiter.Rate())
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.NoError(t, limiter.Close())
+
+	// A truncated file is extended
+	path = filepath.Join(dir, "short.shm")
+	assert.NoError(t, os.WriteFile(path, []byte{1, 2, 3}, 0o644))
+	limiter, err = rl.NewSharedLimiter(path, rl.NewConfig().WithRate(10).WithBurst(2))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.NoError(t, limiter.Close())
+
+	_, err = rl.NewSharedLimiter("", nil)
+	assert.ErrorIs(t, err, rl.ErrInvalidPath)
+	_, err = rl.NewStrictSharedLimiter(path, rl.NewConfig().WithRate(0))
+	assert.ErrorIs(t, err, rl.ErrInvalidRate)
+	_, err = rl.NewSharedLimiter(filepath.Join(dir, "missing", "limiter.shm"), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_Encoding(t *testing.T) {
+	s := &rl.Snapshot{
+		Version: rl.SnapshotVersion,
+		Kind:    rl.SnapshotKindToken,
+		Tokens:  -2.5,
+		Updated: time.Unix(1700000000, 123),
+		Windows: []rl.WindowState{{Start: time.Unix(1700000000, 0), Count: 42}},
+	}
+
+	data, err := s.MarshalBinary()
+	assert.NoError(t, err)
+	decoded := &rl.Snapshot{}
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, s.Kind, decoded.Kind)
+	assert.Equal(t, s.Tokens, decoded.Tokens)
+	assert.True(t, s.Updated.Equal(decoded.Updated))
+	assert.Equal(t, int64(42), decoded.Windows[0].Count)
+
+	// Truncated data and unknown versions are rejected
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data[:len(data)-3]), rl.ErrInvalidSnapshot)
+	data[0] = rl.SnapshotVersion + 1
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data), rl.ErrSnapshotVersion)
+
+	data, err = json.Marshal(s)
+	assert.NoError(t, err)
+	decoded = &rl.Snapshot{}
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, s.Tokens, decoded.Tokens)
+	assert.True(t, s.Updated.Equal(decoded.Updated))
+}
+
+func TestSnapshot_Restore(t *testing.T) {
+	interval := time.Millisecond * 100
+	conf := func() *rl.Config { return rl.NewConfig().WithRate(10).WithBurst(5) }
+
+	// An empty bucket stays empty after a restart
+	limiter := rl.NewRateLimiter(conf())
+	limiter.WhenN(5)
+	restored := rl.NewRateLimiter(conf())
+	assert.NoError(t, restored.Restore(limiter.Snapshot()))
+	assert.Equal(t, interval.Milliseconds(), restored.When().Round(interval).Milliseconds())
+
+	// Reserved tokens are owed after a restart
+	limiter = rl.NewRateLimiter(conf())
+	limiter.WhenN(8)
+	restored = rl.NewRateLimiter(conf())
+	assert.NoError(t, restored.Restore(limiter.Snapshot()))
+	assert.Equal(t, (interval * 4).Milliseconds(), restored.When().Round(interval).Milliseconds())
+
+	// Tokens produced since the snapshot are added
+	s := limiter.Snapshot()
+	s.Updated = s.Updated.Add(-time.Second)
+	restored = rl.NewRateLimiter(conf())
+	assert.NoError(t, restored.Restore(s))
+	assert.Equal(t, time.Duration(0), restored.When())
+
+	// Kinds must match
+	assert.ErrorIs(t, restored.Restore(rl.NewNopLimiter().Snapshot()), rl.ErrSnapshotKind)
+	assert.ErrorIs(t, rl.NewNopLimiter().Restore(s), rl.ErrSnapshotKind)
+	assert.ErrorIs(t, restored.Restore(nil), rl.ErrInvalidSnapshot)
+}
+
+func TestCheckpointer(t *testing.T) {
+	interval := time.Millisecond * 100
+	path := filepath.Join(t.TempDir(), "limiters.json")
+
+	var saveErrs []error
+	cp, err := rl.NewCheckpointer(path, rl.NewCheckpointConfig().WithInterval(time.Millisecond*20).WithErrorHandler(func(err error) { saveErrs = append(saveErrs, err) }))
+	assert.NoError(t, err)
+
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(5))
+	assert.NoError(t, cp.Register("api", limiter))
+	limiter.WhenN(5)
+
+	// The snapshots are saved in the background
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	assert.NoError(t, cp.Stop())
+	assert.Empty(t, saveErrs)
+
+	// The limiter restarts with an empty bucket
+	cp, err = rl.NewCheckpointer(path, nil)
+	assert.NoError(t, err)
+	defer cp.Stop()
+
+	limiter = rl.NewRateLimiter(rl.NewConfig().WithRate(10).WithBurst(5))
+	assert.NoError(t, cp.Register("api", limiter))
+	assert.Equal(t, interval.Milliseconds(), limiter.When().Round(interval).Milliseconds())
+
+	assert.ErrorIs(t, cp.Register("api", rl.NewNopLimiter()), rl.ErrSnapshotKind)
+
+	// A corrupt file is reported
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = rl.NewCheckpointer(path, nil)
+	assert.ErrorIs(t, err, rl.ErrInvalidSnapshot)
+}
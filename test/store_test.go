@@ -0,0 +1,187 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(msg any) ([]byte, error)  { return []byte(msg.(string)), nil }
+func (stringCodec) Decode(data []byte) (any, error) { return string(data), nil }
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+
+	store, err := regula.NewFileStore(path, nil)
+	assert.NoError(t, err)
+
+	a := &regula.StoreRecord{Handler: "h", Payload: []byte("a")}
+	b := &regula.StoreRecord{Handler: "h", Payload: []byte("b")}
+	assert.NoError(t, store.Save(a))
+	assert.NoError(t, store.Save(b))
+	assert.Equal(t, uint64(1), a.ID)
+	assert.Equal(t, uint64(2), b.ID)
+
+	// Saving again updates the record
+	b.Payload = []byte("b2")
+	assert.NoError(t, store.Save(b))
+	assert.NoError(t, store.Delete(a.ID))
+
+	records, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, []byte("b2"), records[0].Payload)
+	assert.NoError(t, store.Close())
+	assert.ErrorIs(t, store.Save(a), regula.ErrStoreClosed)
+
+	// A partially written last line is skipped, and new numbers continue after the old ones
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, _ = f.WriteString(`{"op":"put","id":3,"hand`)
+	assert.NoError(t, f.Close())
+
+	store, err = regula.NewFileStore(path, regula.NewFileStoreConfig().WithSync(true))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	records, err = store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, uint64(2), records[0].ID)
+
+	c := &regula.StoreRecord{Handler: "h", Payload: []byte("c")}
+	assert.NoError(t, store.Save(c))
+	assert.Equal(t, uint64(3), c.ID)
+}
+
+func TestFileStore_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	store, err := regula.NewFileStore(path, regula.NewFileStoreConfig().WithCompactThreshold(8))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	kept := &regula.StoreRecord{Handler: "h", Payload: []byte("kept")}
+	assert.NoError(t, store.Save(kept))
+
+	// Finished messages leave dead lines behind, they are compacted away at runtime
+	for i := 0; i < 100; i++ {
+		record := &regula.StoreRecord{Handler: "h", Payload: []byte("x")}
+		assert.NoError(t, store.Save(record))
+		assert.NoError(t, store.Delete(record.ID))
+	}
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, strings.Count(string(data), "\n"), 10)
+
+	// Appends after the compaction go to the new log
+	assert.NoError(t, store.Save(&regula.StoreRecord{Handler: "h", Payload: []byte("last")}))
+	assert.NoError(t, store.Close())
+	store, err = regula.NewFileStore(path, nil)
+	assert.NoError(t, err)
+	records, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, []byte("kept"), records[0].Payload)
+	assert.Equal(t, []byte("last"), records[1].Payload)
+}
+
+func TestFlowController_StoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	handle := func(msg any) (any, error) { return msg, nil }
+
+	// One message per second, so most messages are still delayed when the process stops
+	store, err := regula.NewFileStore(path, nil)
+	assert.NoError(t, err)
+	limiter := rl.NewRateLimiter(rl.NewConfig().WithRate(1).WithBurst(1))
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(limiter).WithStore(store, stringCodec{}))
+
+	assert.ErrorIs(t, fc.DoNamed("echo", "x"), regula.ErrHandlerNotRegistered)
+	fc.RegisterHandler("echo", handle)
+	for _, msg := range []string{"a", "b", "c"} {
+		assert.NoError(t, fc.DoNamed("echo", msg))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	abandoned, _ := fc.Shutdown(ctx)
+	assert.Empty(t, abandoned, "stored messages are left to Replay")
+	assert.NoError(t, store.Close())
+
+	// After the restart, the pending messages are replayed once their handler is registered
+	store, err = regula.NewFileStore(path, nil)
+	assert.NoError(t, err)
+	defer store.Close()
+	records, err := store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.True(t, records[1].Due.After(records[0].Due))
+
+	rec := &recorder{}
+	fc = regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithStore(store, stringCodec{}))
+	defer fc.Stop()
+
+	fc.RegisterHandler("echo", rec.handle)
+	replayed, err := fc.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second*3, time.Millisecond*10)
+	assert.ElementsMatch(t, []any{"b", "c"}, rec.executed())
+	assert.Eventually(t, func() bool {
+		records, _ := store.Load()
+		return len(records) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestFlowController_StoreReplayPausedDedupe(t *testing.T) {
+	store, err := regula.NewFileStore(filepath.Join(t.TempDir(), "store.log"), nil)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, store.Save(&regula.StoreRecord{Handler: "echo", Payload: []byte("a"), Due: time.Now()}))
+	assert.NoError(t, store.Save(&regula.StoreRecord{Handler: "echo", Payload: []byte("b"), Due: time.Now().Add(time.Millisecond * 100)}))
+
+	rec := &recorder{}
+	dedupe := regula.NewDedupeConfig(func(msg any) any { return msg })
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithStore(store, stringCodec{}).WithDedupe(dedupe))
+	defer fc.Stop()
+	fc.RegisterHandler("echo", rec.handle)
+
+	// Replayed messages are kept by the pause, even the one that is already due
+	fc.Pause()
+	replayed, err := fc.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	// A new duplicate is merged into the replayed message that is still waiting
+	assert.NoError(t, fc.DoNamed("echo", "a"))
+	time.Sleep(time.Millisecond * 300)
+	assert.Empty(t, rec.executed())
+
+	fc.Resume()
+	assert.Eventually(t, func() bool { return len(rec.executed()) == 2 }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 100)
+	assert.ElementsMatch(t, []any{"a", "b"}, rec.executed())
+}
+
+func TestFlowController_StoreConfig(t *testing.T) {
+	store, err := regula.NewFileStore(filepath.Join(t.TempDir(), "store.log"), nil)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	assert.ErrorIs(t, regula.NewConfig().WithStore(store, nil).Validate(), regula.ErrCodecIsNil)
+
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig())
+	defer fc.Stop()
+	_, err = fc.Replay()
+	assert.ErrorIs(t, err, regula.ErrStoreIsNil)
+}
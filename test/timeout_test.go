@@ -0,0 +1,110 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	"github.com/stretchr/testify/assert"
+)
+
+type feedbackLimiter struct {
+	timeouts atomic.Int64
+}
+
+func (l *feedbackLimiter) When() time.Duration { return 0 }
+
+func (l *feedbackLimiter) OnExecResult(err error, elapsed time.Duration) {
+	if err == regula.ErrHandlerTimeout {
+		l.timeouts.Add(1)
+	}
+}
+
+func TestFlowController_HandlerTimeout(t *testing.T) {
+	limiter := &feedbackLimiter{}
+	fconf := regula.NewConfig().WithRateLimiter(limiter).WithHandlerTimeout(time.Millisecond * 50)
+	fc := regula.NewFlowController(newTestPipeline(), fconf)
+	defer fc.Stop()
+
+	// The controller timeout applies to handlers without context
+	future, err := fc.DoWithFuture(func(msg any) (any, error) {
+		time.Sleep(time.Millisecond * 200)
+		return msg, nil
+	}, "slow")
+	assert.NoError(t, err)
+	_, err = future.Result()
+	assert.ErrorIs(t, err, regula.ErrHandlerTimeout)
+
+	// The per-submission timeout takes precedence and the context carries the deadline
+	var cancelled atomic.Bool
+	future, err = fc.DoWithTimeout(func(ctx context.Context, msg any) (any, error) {
+		<-ctx.Done()
+		cancelled.Store(true)
+		return nil, ctx.Err()
+	}, "context", time.Millisecond*20)
+	assert.NoError(t, err)
+	_, err = future.Result()
+	assert.ErrorIs(t, err, regula.ErrHandlerTimeout)
+	assert.Eventually(t, cancelled.Load, time.Second, time.Millisecond*10)
+
+	future, err = fc.DoWithTimeout(func(ctx context.Context, msg any) (any, error) {
+		return msg, nil
+	}, "fast", time.Second)
+	assert.NoError(t, err)
+	result, err := future.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", result)
+
+	metrics := fc.Metrics()
+	assert.Equal(t, uint64(3), metrics.Submitted)
+	assert.Equal(t, uint64(2), metrics.TimedOut)
+	assert.Equal(t, uint64(2), metrics.Failed)
+	assert.Equal(t, uint64(1), metrics.Succeeded)
+	assert.Equal(t, int64(2), limiter.timeouts.Load())
+}
+
+func TestFlowController_HandlerTimeoutOpensCircuit(t *testing.T) {
+	bconf := regula.NewCircuitBreakerConfig().WithConsecutiveFailures(1)
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithHandlerTimeout(time.Millisecond*20).WithCircuitBreaker(bconf))
+	defer fc.Stop()
+
+	future, err := fc.DoWithFuture(func(msg any) (any, error) {
+		time.Sleep(time.Millisecond * 100)
+		return msg, nil
+	}, "slow")
+	assert.NoError(t, err)
+	_, err = future.Result()
+	assert.ErrorIs(t, err, regula.ErrHandlerTimeout)
+	assert.Equal(t, regula.CircuitOpen, fc.CircuitState())
+}
+
+func TestFlowController_HandlerTimeoutStragglers(t *testing.T) {
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithHandlerTimeout(time.Millisecond*20))
+	defer fc.Stop()
+
+	// The handler ignores its context, so it keeps running after the timeout
+	release := make(chan struct{})
+	future, err := fc.DoWithFuture(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}, "straggler")
+	assert.NoError(t, err)
+	_, err = future.Result()
+	assert.ErrorIs(t, err, regula.ErrHandlerTimeout)
+	assert.Equal(t, int64(1), fc.Metrics().Stragglers)
+
+	// A handler returning the deadline error of its context is a timeout as well
+	future, err = fc.DoWithTimeout(func(ctx context.Context, msg any) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, "honours", time.Millisecond*10)
+	assert.NoError(t, err)
+	_, err = future.Result()
+	assert.ErrorIs(t, err, regula.ErrHandlerTimeout)
+
+	close(release)
+	assert.Eventually(t, func() bool { return fc.Metrics().Stragglers == 0 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, uint64(2), fc.Metrics().TimedOut)
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shengyanli1982/regula"
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmUpLimiter_Ramp(t *testing.T) {
+	// Stable interval 10ms, cold interval 30ms, 10 permits above the threshold and 20 in total
+	limiter := rl.NewWarmUpLimiter(rl.NewWarmUpConfig(100, time.Millisecond*200))
+	assert.InDelta(t, 100.0/3, limiter.CurrentRate(), 0.5)
+	assert.Equal(t, 100.0, limiter.Rate())
+
+	delays := make([]time.Duration, 40)
+	for i := range delays {
+		delays[i] = limiter.When()
+	}
+	assert.Equal(t, time.Duration(0), delays[0])
+
+	// The interval starts close to the cold interval and shrinks to the stable interval
+	ms := float64(time.Millisecond)
+	assert.InDelta(t, 29*ms, float64(delays[1]-delays[0]), ms)
+	for i := 2; i < len(delays); i++ {
+		assert.LessOrEqual(t, delays[i]-delays[i-1], delays[i-1]-delays[i-2]+time.Millisecond, "event %d", i)
+	}
+	assert.InDelta(t, 10*ms, float64(delays[39]-delays[38]), ms)
+
+	// Ramping through the permits above the threshold takes the warm-up time
+	assert.InDelta(t, 200*ms, float64(delays[10]), 3*ms)
+	assert.InDelta(t, 300*ms, float64(delays[20]), 3*ms)
+}
+
+func TestWarmUpLimiter_ColdAfterIdle(t *testing.T) {
+	limiter := rl.NewWarmUpLimiter(rl.NewWarmUpConfig(100, time.Millisecond*200).WithColdAfter(time.Millisecond * 100))
+
+	var last time.Duration
+	for i := 0; i < 30; i++ {
+		last = limiter.When()
+	}
+
+	// Warm right after the backlog is served
+	time.Sleep(last + time.Millisecond*10)
+	assert.Equal(t, 100.0, limiter.CurrentRate())
+
+	// Fully cold again after the idle time, and the first event after being idle does not wait
+	time.Sleep(time.Millisecond * 150)
+	assert.InDelta(t, 100.0/3, limiter.CurrentRate(), 0.5)
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.InDelta(t, float64(time.Millisecond*29), float64(limiter.When()), float64(time.Millisecond))
+}
+
+func TestWarmUpLimiter_Config(t *testing.T) {
+	assert.ErrorIs(t, (*rl.WarmUpConfig)(nil).Validate(), rl.ErrConfigIsNil)
+	assert.ErrorIs(t, rl.NewWarmUpConfig(0, time.Second).Validate(), rl.ErrInvalidRate)
+	assert.ErrorIs(t, rl.NewWarmUpConfig(1, 0).Validate(), rl.ErrInvalidWarmUp)
+	assert.ErrorIs(t, rl.NewWarmUpConfig(1, time.Second).WithColdFactor(0.5).Validate(), rl.ErrInvalidWarmUp)
+	assert.ErrorIs(t, rl.NewWarmUpConfig(1, time.Second).WithColdAfter(-1).Validate(), rl.ErrInvalidWarmUp)
+	_, err := rl.NewStrictWarmUpLimiter(rl.NewWarmUpConfig(1, 0))
+	assert.ErrorIs(t, err, rl.ErrInvalidWarmUp)
+
+	// A cold factor of 1 does not warm up at all
+	limiter := rl.NewWarmUpLimiter(rl.NewWarmUpConfig(10, time.Second).WithColdFactor(1))
+	assert.Equal(t, 10.0, limiter.CurrentRate())
+
+	// The limiter drops into the flow controller
+	fc := regula.NewFlowController(newTestPipeline(), regula.NewConfig().WithRateLimiter(rl.NewWarmUpLimiter(nil)))
+	defer fc.Stop()
+	assert.NoError(t, fc.Do(func(msg any) (any, error) { return msg, nil }, "x"))
+}
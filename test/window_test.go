@@ -0,0 +1,137 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	rl "github.com/shengyanli1982/regula/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiWindowLimiter_Rolling(t *testing.T) {
+	limiter := rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig().
+		WithWindow(3, time.Millisecond*100).
+		WithWindow(5, time.Second))
+
+	// Each event waits for the window that admits it last
+	expected := []time.Duration{0, 0, 0, 100, 100, 1000, 1000, 1000, 1100, 1100}
+	for i, want := range expected {
+		assert.Equal(t, want*time.Millisecond, limiter.When().Round(time.Millisecond*10), "event %d", i)
+	}
+}
+
+func TestMultiWindowLimiter_RollingExpires(t *testing.T) {
+	limiter := rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig(rl.WindowRule{Limit: 2, Period: time.Millisecond * 100}))
+
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.Equal(t, time.Duration(0), limiter.When())
+	assert.Greater(t, limiter.When(), time.Millisecond*50)
+
+	// Events leave the rolling window after the period
+	time.Sleep(time.Millisecond * 250)
+	assert.Equal(t, time.Duration(0), limiter.When())
+}
+
+func TestMultiWindowLimiter_Calendar(t *testing.T) {
+	loc := time.FixedZone("UTC+14", 14*60*60)
+
+	cases := []struct {
+		unit rl.CalendarUnit
+		next func(now time.Time) time.Time
+	}{
+		{rl.CalendarHour, func(now time.Time) time.Time {
+			return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, loc)
+		}},
+		{rl.CalendarDay, func(now time.Time) time.Time {
+			return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+		}},
+		{rl.CalendarWeek, func(now time.Time) time.Time {
+			days := 7 - (int(now.Weekday())+6)%7
+			return time.Date(now.Year(), now.Month(), now.Day()+days, 0, 0, 0, 0, loc)
+		}},
+		{rl.CalendarMonth, func(now time.Time) time.Time {
+			return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, loc)
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.unit.String(), func(t *testing.T) {
+			limiter := rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig().
+				WithWindow(100, time.Second).
+				WithCalendarWindow(2, c.unit).
+				WithLocation(loc))
+
+			assert.Equal(t, time.Duration(0), limiter.When())
+			assert.Equal(t, time.Duration(0), limiter.When())
+
+			// The exhausted window pushes the event to the next calendar boundary in the time zone
+			now := time.Now().In(loc)
+			delay := limiter.When()
+			assert.InDelta(t, float64(c.next(now).Sub(now)), float64(delay), float64(time.Millisecond*50))
+
+			// The next window admits its limit and then pushes to the following boundary
+			assert.InDelta(t, float64(delay), float64(limiter.When()), float64(time.Millisecond*10))
+			assert.Greater(t, limiter.When(), delay)
+		})
+	}
+}
+
+func TestMultiWindowLimiter_Snapshot(t *testing.T) {
+	newLimiter := func() *rl.MultiWindowLimiter {
+		return rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig().
+			WithWindow(3, time.Second).
+			WithCalendarWindow(4, rl.CalendarDay))
+	}
+	limiter := newLimiter()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), limiter.When())
+	}
+
+	// The snapshot survives the binary encoding
+	data, err := limiter.Snapshot().MarshalBinary()
+	assert.NoError(t, err)
+	s := &rl.Snapshot{}
+	assert.NoError(t, s.UnmarshalBinary(data))
+	assert.Equal(t, rl.SnapshotKindMultiWindow, s.Kind)
+	assert.Len(t, s.Windows, 2)
+	assert.Equal(t, int64(3), s.Windows[0].Count)
+	assert.Equal(t, int64(3), s.Windows[1].Count)
+
+	// The restored rolling window is full, so the next event waits for the earliest one to leave
+	restored := newLimiter()
+	assert.NoError(t, restored.Restore(s))
+	assert.Equal(t, time.Second, restored.When().Round(time.Millisecond*100))
+
+	// The restored calendar window has one event left today
+	day := rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig().WithCalendarWindow(4, rl.CalendarDay))
+	assert.NoError(t, day.Restore(&rl.Snapshot{
+		Version: rl.SnapshotVersion,
+		Kind:    rl.SnapshotKindMultiWindow,
+		Updated: time.Now(),
+		Windows: []rl.WindowState{s.Windows[1]},
+	}))
+	assert.Equal(t, time.Duration(0), day.When())
+	assert.Greater(t, day.When(), time.Duration(0))
+
+	// The windows must match the rules
+	assert.ErrorIs(t, day.Restore(s), rl.ErrInvalidSnapshot)
+	assert.ErrorIs(t, day.Restore(rl.NewNopLimiter().Snapshot()), rl.ErrSnapshotKind)
+}
+
+func TestMultiWindowLimiter_Config(t *testing.T) {
+	assert.ErrorIs(t, (*rl.MultiWindowConfig)(nil).Validate(), rl.ErrConfigIsNil)
+	assert.ErrorIs(t, rl.NewMultiWindowConfig().Validate(), rl.ErrInvalidWindow)
+	assert.ErrorIs(t, rl.NewMultiWindowConfig().WithWindow(0, time.Second).Validate(), rl.ErrInvalidWindow)
+	assert.ErrorIs(t, rl.NewMultiWindowConfig().WithWindow(1, 0).Validate(), rl.ErrInvalidWindow)
+	assert.ErrorIs(t, rl.NewMultiWindowConfig().WithCalendarWindow(1, rl.CalendarUnit(42)).Validate(), rl.ErrInvalidWindow)
+	assert.NoError(t, rl.NewMultiWindowConfig().WithCalendarWindow(1, rl.CalendarDay).Validate())
+
+	_, err := rl.NewStrictMultiWindowLimiter(rl.NewMultiWindowConfig())
+	assert.ErrorIs(t, err, rl.ErrInvalidWindow)
+
+	// Invalid rules are dropped and the default is used when none is left
+	limiter := rl.NewMultiWindowLimiter(rl.NewMultiWindowConfig().WithWindow(0, time.Second).WithWindow(3, time.Minute))
+	assert.Equal(t, []rl.WindowRule{{Limit: 3, Period: time.Minute}}, limiter.Rules())
+	limiter = rl.NewMultiWindowLimiter(nil)
+	assert.Equal(t, []rl.WindowRule{{Limit: rl.DefaultLimitRate, Period: time.Second}}, limiter.Rules())
+}
@@ -0,0 +1,111 @@
+package regula
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// invokeRunning 表示处理函数仍在运行
+	// invokeRunning indicates that the handle function is still running
+	invokeRunning int32 = iota
+
+	// invokeReturned 表示处理函数已在超时前返回
+	// invokeReturned indicates that the handle function has returned before the timeout
+	invokeReturned
+
+	// invokeDetached 表示处理函数已超时，它在后台继续运行直到返回
+	// invokeDetached indicates that the handle function has timed out, it keeps running in the background until it returns
+	invokeDetached
+)
+
+// invokeResult 是在独立协程中执行消息处理函数的结果
+// invokeResult is the result of executing the message handle function in a separate goroutine
+type invokeResult struct {
+	result any
+	err    error
+}
+
+// invoke 是一个方法，它执行任务的消息处理函数，处理函数的 panic 会被恢复为 PanicError 并通过回调函数报告
+// invoke is a method that executes the message handle function of the task, the panic of the handle function is recovered as PanicError and reported through the callback function
+func (fc *FlowController) invoke(t *task) (any, error) {
+	result, err := fc.invokeWithTimeout(t)
+
+	// 报告恢复的 panic，它作为失败参与重试和熔断
+	// Report the recovered panic, it takes part in retries and circuit breaking as a failure
+	if pe, ok := err.(*PanicError); ok {
+		fc.metrics.panicked.Add(1)
+		fc.onExecPanic(t.msg, pe)
+	}
+
+	return result, err
+}
+
+// invokeWithTimeout 是一个方法，它执行任务的消息处理函数。如果设置了超时，处理函数在带有截止时间的上下文中运行，
+// 超时后立即返回 ErrHandlerTimeout 并释放管道的工作者。流控制器无法强行停止处理函数，处理函数必须响应上下文，
+// 不响应上下文的处理函数会在后台继续运行直到返回，它们的数量通过 Metrics 的 Stragglers 报告
+// invokeWithTimeout is a method that executes the message handle function of the task. If a timeout is set, the handle function runs with a deadline context,
+// ErrHandlerTimeout is returned immediately after the timeout and the pipeline worker is released. The flow controller cannot stop a handle function by force, handle functions must honour the context,
+// handle functions that ignore the context keep running in the background until they return, their number is reported by Stragglers of Metrics
+func (fc *FlowController) invokeWithTimeout(t *task) (any, error) {
+	// 单次提交的超时优先于流控制器的超时
+	// The timeout of a single submission takes precedence over the timeout of the flow controller
+	timeout := t.timeout
+	if timeout <= 0 {
+		timeout = fc.config.timeout
+	}
+
+	// 没有超时，直接执行
+	// No timeout, execute directly
+	if timeout <= 0 {
+		return safeCall(t.fn, context.Background(), t.msg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 在独立的协程中执行处理函数，通道带缓冲，超时后协程也能正常退出
+	// Execute the handle function in a separate goroutine, the channel is buffered so the goroutine can exit normally after the timeout
+	ch := make(chan invokeResult, 1)
+	state := invokeRunning
+	go func() {
+		result, err := safeCall(t.fn, ctx, t.msg)
+
+		// 如果处理函数已经超时，它不再是后台运行的处理函数
+		// If the handle function has timed out, it is no longer a handle function running in the background
+		if !atomic.CompareAndSwapInt32(&state, invokeRunning, invokeReturned) {
+			fc.metrics.stragglers.Add(-1)
+		}
+		ch <- invokeResult{result: result, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		// 响应上下文的处理函数可能先于截止时间的通知返回上下文的错误，它同样是超时
+		// A handle function that honours the context may return the error of the context before the notification of the deadline, it is a timeout as well
+		if r.err != nil && errors.Is(r.err, context.DeadlineExceeded) && ctx.Err() != nil {
+			fc.metrics.timedOut.Add(1)
+			return nil, ErrHandlerTimeout
+		}
+		return r.result, r.err
+	case <-ctx.Done():
+		fc.metrics.timedOut.Add(1)
+
+		// 处理函数仍在运行，它在后台继续运行直到返回
+		// The handle function is still running, it keeps running in the background until it returns
+		if atomic.CompareAndSwapInt32(&state, invokeRunning, invokeDetached) {
+			fc.metrics.stragglers.Add(1)
+		}
+		return nil, ErrHandlerTimeout
+	}
+}
+
+// feedback 是一个方法，如果当前的速率限制器实现了 ExecFeedback 接口，它把执行结果和耗时反馈给速率限制器
+// feedback is a method that feeds the execution result and elapsed time back to the rate limiter if the current rate limiter implements the ExecFeedback interface
+func (fc *FlowController) feedback(err error, elapsed time.Duration) {
+	if f, ok := fc.RateLimiter().(ExecFeedback); ok {
+		f.OnExecResult(err, elapsed)
+	}
+}